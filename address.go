@@ -412,6 +412,13 @@ type AddressPubKey struct {
 	cksumHasher  base58.CksumHasher
 }
 
+// IsValidPubKey returns whether the passed serialized public key is valid in
+// this chain, which only allows 33-byte keys starting with 0x03.  This is the
+// same rule NewAddressPubKey enforces.
+func IsValidPubKey(serializedPubKey []byte) bool {
+	return len(serializedPubKey) == 33 && serializedPubKey[0] == 0x03
+}
+
 // NewAddressPubKey returns a new AddressPubKey which represents a pay-to-pubkey
 // address.  The serializedPubKey parameter must be a valid pubkey and can be
 // uncompressed, compressed, or hybrid.
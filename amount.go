@@ -8,6 +8,28 @@ import (
 	"errors"
 	"math"
 	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	// ErrAmountSyntax describes an error where a string can not be parsed
+	// as an amount since it is not a decimal number optionally followed by
+	// a known unit.
+	ErrAmountSyntax = errors.New("invalid amount syntax")
+
+	// ErrAmountUnknownUnit describes an error where a string can not be
+	// parsed as an amount since the unit following the number is unknown.
+	ErrAmountUnknownUnit = errors.New("unknown amount unit")
+
+	// ErrAmountOverflow describes an error where a parsed amount does not
+	// fit in an Amount.
+	ErrAmountOverflow = errors.New("amount overflows")
+
+	// ErrAmountTooPrecise describes an error where a parsed amount has more
+	// decimal places than its unit allows, so it can not be represented as
+	// a whole number of satoshi without rounding.
+	ErrAmountTooPrecise = errors.New("amount has too many decimal places")
 )
 
 // AmountUnit describes a method of converting an Amount to something
@@ -102,10 +124,10 @@ func (a Amount) ToBTC() float64 {
 // Format formats a monetary amount counted in bitcoin base units as a
 // string for a given unit.  The conversion will succeed for any unit,
 // however, known units will be formated with an appended label describing
-// the units with SI notation, or "Satoshi" for the base unit.
+// the units with SI notation, or "Satoshi" for the base unit.  The amount is
+// formatted exactly with as few decimal places as needed.  See FormatWith.
 func (a Amount) Format(u AmountUnit) string {
-	units := " " + u.String()
-	return strconv.FormatFloat(a.ToUnit(u), 'f', -int(u+8), 64) + units
+	return a.FormatWith(AmountFormat{Unit: u, Decimals: -1, ShowUnit: true})
 }
 
 // String is the equivalent of calling Format with AmountBTC.
@@ -120,3 +142,252 @@ func (a Amount) String() string {
 func (a Amount) MulF64(f float64) Amount {
 	return round(float64(a) * f)
 }
+
+// amountUnitsByName maps the names and common abbreviations of the known units
+// to their unit.  Names are matched exactly, except for those of the base unit
+// which are matched case insensitively, since the SI prefixes of the other
+// units are case sensitive.
+var amountUnitsByName = map[string]AmountUnit{
+	"MBTC": AmountMegaBTC,
+	"kBTC": AmountKiloBTC,
+	"BTC":  AmountBTC,
+	"mBTC": AmountMilliBTC,
+	"μBTC": AmountMicroBTC, // Greek small letter mu
+	"µBTC": AmountMicroBTC, // Micro sign
+	"uBTC": AmountMicroBTC,
+}
+
+// parseAmountUnit returns the unit named by the passed string.  Besides the
+// names of the known units, the "1eN BTC" form returned by AmountUnit.String
+// for other units is accepted.
+func parseAmountUnit(s string) (AmountUnit, error) {
+	if u, ok := amountUnitsByName[s]; ok {
+		return u, nil
+	}
+	switch strings.ToLower(s) {
+	case "sat", "sats", "satoshi", "satoshis":
+		return AmountSatoshi, nil
+	}
+
+	if strings.HasPrefix(s, "1e") && strings.HasSuffix(s, " BTC") {
+		exp := strings.TrimSuffix(strings.TrimPrefix(s, "1e"), " BTC")
+		u, err := strconv.ParseInt(exp, 10, 32)
+		if err == nil {
+			return AmountUnit(u), nil
+		}
+	}
+	return 0, ErrAmountUnknownUnit
+}
+
+// ParseAmount parses a decimal amount optionally followed by a unit, such as
+// "0.00012345", "1.5 mBTC" or "250 sat", and returns the Amount it denotes.
+// Amounts without a unit are in bitcoin.  Units are named as returned by
+// AmountUnit.String, and "sat", "sats" and "uBTC" are also accepted.
+//
+// Unlike NewAmount, the amount is parsed exactly without going through
+// floating point.  ErrAmountTooPrecise is returned when the amount is not a
+// whole number of satoshi, and ErrAmountOverflow when it does not fit in an
+// Amount.
+func ParseAmount(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+
+	// The number ends at the first character which can't be part of it,
+	// and anything after it names the unit.
+	end := strings.IndexFunc(s, func(r rune) bool {
+		return !unicode.IsDigit(r) && r != '.' && r != '-' && r != '+'
+	})
+	if end == -1 {
+		return ParseAmountInUnit(s, AmountBTC)
+	}
+
+	u, err := parseAmountUnit(strings.TrimSpace(s[end:]))
+	if err != nil {
+		return 0, err
+	}
+	return ParseAmountInUnit(s[:end], u)
+}
+
+// ParseAmountInUnit parses a decimal number, such as "1.5", which denotes an
+// amount in the passed unit and returns the Amount it denotes.  The number may
+// not carry a unit of its own.  See ParseAmount for the errors returned.
+func ParseAmountInUnit(s string, u AmountUnit) (Amount, error) {
+	// Parse the optional sign.
+	negative := false
+	switch {
+	case strings.HasPrefix(s, "-"):
+		negative = true
+		s = s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+
+	// Split the integer and fractional digits, at least one of which must
+	// be present.
+	intPart, fracPart := s, ""
+	if i := strings.IndexByte(s, '.'); i != -1 {
+		intPart, fracPart = s[:i], s[i+1:]
+	}
+	if len(intPart)+len(fracPart) == 0 {
+		return 0, ErrAmountSyntax
+	}
+	for _, digits := range []string{intPart, fracPart} {
+		for i := 0; i < len(digits); i++ {
+			if digits[i] < '0' || digits[i] > '9' {
+				return 0, ErrAmountSyntax
+			}
+		}
+	}
+
+	// The amount in satoshi is the mantissa formed by all of the digits
+	// scaled by a power of ten.  Zeros at either end of the digits don't
+	// change the amount, and trimming them avoids overflowing the mantissa
+	// needlessly.
+	exp := int(u) + 8 - len(fracPart)
+	digits := intPart + fracPart
+	trimmed := strings.TrimRight(digits, "0")
+	exp += len(digits) - len(trimmed)
+	digits = strings.TrimLeft(trimmed, "0")
+	if digits == "" {
+		return 0, nil
+	}
+	if exp < 0 {
+		return 0, ErrAmountTooPrecise
+	}
+
+	// Accumulate the mantissa and scale it, failing on overflow.  The
+	// magnitude of the most negative Amount is one more than that of the
+	// most positive one.
+	max := uint64(math.MaxInt64)
+	if negative {
+		max++
+	}
+	var sat uint64
+	for i := 0; i < len(digits); i++ {
+		digit := uint64(digits[i] - '0')
+		if sat > (max-digit)/10 {
+			return 0, ErrAmountOverflow
+		}
+		sat = sat*10 + digit
+	}
+	for ; exp > 0; exp-- {
+		if sat > max/10 {
+			return 0, ErrAmountOverflow
+		}
+		sat *= 10
+	}
+
+	if negative {
+		return Amount(-sat), nil
+	}
+	return Amount(sat), nil
+}
+
+// AmountFormat describes how FormatWith formats an Amount.  The zero value
+// formats amounts as a whole number of bitcoin without a unit.
+type AmountFormat struct {
+	// Unit is the unit the amount is expressed in.
+	Unit AmountUnit
+
+	// Decimals is the fixed number of decimal places to format.  Amounts
+	// with more decimal places in the unit are rounded half away from
+	// zero.  A negative value formats as many decimal places as needed to
+	// represent the amount exactly, and no more.
+	Decimals int
+
+	// DecimalSeparator separates the integer part from the decimal places.
+	// It defaults to "." when empty.
+	DecimalSeparator string
+
+	// ThousandsSeparator, when not empty, is inserted between every group
+	// of three digits of the integer part.
+	ThousandsSeparator string
+
+	// ShowUnit appends a space and the name of the unit as returned by
+	// AmountUnit.String.
+	ShowUnit bool
+}
+
+// FormatWith formats a monetary amount counted in bitcoin base units as a
+// string as described by the passed format.  The amount is formatted using
+// exact integer arithmetic, so unlike ToUnit it never loses precision.
+func (a Amount) FormatWith(f AmountFormat) string {
+	// Work with the magnitude of the amount, which is the number of
+	// satoshi and is equal to mantissa * 10^-scale in the unit.
+	mantissa := uint64(a)
+	if a < 0 {
+		mantissa = -mantissa
+	}
+	scale := int(f.Unit) + 8
+
+	// Round the mantissa to the requested number of decimal places.
+	if f.Decimals >= 0 && f.Decimals < scale {
+		shift := scale - f.Decimals
+		scale = f.Decimals
+		if shift > 19 {
+			// The factor exceeds twice the largest magnitude, so
+			// everything rounds to zero.
+			mantissa = 0
+		} else {
+			factor := uint64(math.Pow10(shift))
+			rem := mantissa % factor
+			mantissa /= factor
+			if rem >= factor-rem {
+				mantissa++
+			}
+		}
+	}
+
+	// Render the digits, padding with zeros so there is at least one
+	// digit in the integer part when the scale is positive, and appending
+	// zeros when it is negative.
+	digits := strconv.FormatUint(mantissa, 10)
+	if scale < 0 {
+		if mantissa != 0 {
+			digits += strings.Repeat("0", -scale)
+		}
+		scale = 0
+	}
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	intPart := digits[:len(digits)-scale]
+	fracPart := digits[len(digits)-scale:]
+
+	// Trim or pad the decimal places as requested.
+	if f.Decimals < 0 {
+		fracPart = strings.TrimRight(fracPart, "0")
+	} else if len(fracPart) < f.Decimals {
+		fracPart += strings.Repeat("0", f.Decimals-len(fracPart))
+	}
+
+	// Group the digits of the integer part when requested.
+	if f.ThousandsSeparator != "" && len(intPart) > 3 {
+		var b strings.Builder
+		lead := len(intPart) % 3
+		if lead == 0 {
+			lead = 3
+		}
+		b.WriteString(intPart[:lead])
+		for i := lead; i < len(intPart); i += 3 {
+			b.WriteString(f.ThousandsSeparator)
+			b.WriteString(intPart[i : i+3])
+		}
+		intPart = b.String()
+	}
+
+	str := intPart
+	if a < 0 && mantissa != 0 {
+		str = "-" + str
+	}
+	if fracPart != "" {
+		sep := f.DecimalSeparator
+		if sep == "" {
+			sep = "."
+		}
+		str += sep + fracPart
+	}
+	if f.ShowUnit {
+		str += " " + f.Unit.String()
+	}
+	return str
+}
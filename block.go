@@ -8,6 +8,8 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"sync"
+	"sync/atomic"
 
 	"github.com/nbcorg/btcd/chaincfg/chainhash"
 	"github.com/nbcorg/btcd/wire"
@@ -31,6 +33,11 @@ func (e OutOfRangeError) Error() string {
 // manipulation of raw blocks.  It also memoizes hashes for the block and its
 // transactions on their first access so subsequent accesses don't have to
 // repeat the relatively expensive hashing operations.
+//
+// The memoized accessors are safe for concurrent use by multiple goroutines,
+// so a single Block may be handed to several consumers at once.  Once a value
+// has been cached, retrieving it does not take any locks.  Note that the
+// underlying wire.MsgBlock must not be modified once the Block is shared.
 type Block struct {
 	msgBlock                 *wire.MsgBlock  // Underlying MsgBlock
 	serializedBlock          []byte          // Serialized bytes for the block
@@ -38,7 +45,17 @@ type Block struct {
 	blockHash                *chainhash.Hash // Cached block hash
 	blockHeight              int32           // Height in the main block chain
 	transactions             []*Tx           // Transactions
-	txnsGenerated            bool            // ALL wrapped transactions generated
+	txnsGenerated            uint32          // ALL wrapped transactions generated (atomic)
+	serializeSize            int             // Cached serialized size with witness data
+	strippedSize             int             // Cached serialized size w/o witness data
+
+	serializeErr           error      // Error from serializing the block
+	serializeNoWitnessErr  error      // Error from serializing w/o witness data
+	serializeOnce          sync.Once  // Guards serializedBlock
+	serializeNoWitnessOnce sync.Once  // Guards serializedBlockNoWitness
+	hashOnce               sync.Once  // Guards blockHash
+	sizeOnce               sync.Once  // Guards serializeSize and strippedSize
+	txMtx                  sync.Mutex // Protects transactions until all generated
 }
 
 // MsgBlock returns the underlying wire.MsgBlock for the Block.
@@ -50,59 +67,99 @@ func (b *Block) MsgBlock() *wire.MsgBlock {
 // Bytes returns the serialized bytes for the Block.  This is equivalent to
 // calling Serialize on the underlying wire.MsgBlock, however it caches the
 // result so subsequent calls are more efficient.
+//
+// This function is safe for concurrent access.
 func (b *Block) Bytes() ([]byte, error) {
-	// Return the cached serialized bytes if it has already been generated.
-	if len(b.serializedBlock) != 0 {
-		return b.serializedBlock, nil
-	}
+	b.serializeOnce.Do(func() {
+		// Nothing to do if the serialized bytes were provided when the
+		// block was created.
+		if len(b.serializedBlock) != 0 {
+			return
+		}
 
-	// Serialize the MsgBlock.
-	w := bytes.NewBuffer(make([]byte, 0, b.msgBlock.SerializeSize()))
-	err := b.msgBlock.Serialize(w)
-	if err != nil {
-		return nil, err
+		// Serialize the MsgBlock and cache the result.
+		w := bytes.NewBuffer(make([]byte, 0, b.msgBlock.SerializeSize()))
+		b.serializeErr = b.msgBlock.Serialize(w)
+		if b.serializeErr == nil {
+			b.serializedBlock = w.Bytes()
+		}
+	})
+	if b.serializeErr != nil {
+		return nil, b.serializeErr
 	}
-	serializedBlock := w.Bytes()
-
-	// Cache the serialized bytes and return them.
-	b.serializedBlock = serializedBlock
-	return serializedBlock, nil
+	return b.serializedBlock, nil
 }
 
 // BytesNoWitness returns the serialized bytes for the block with transactions
 // encoded without any witness data.
+//
+// This function is safe for concurrent access.
 func (b *Block) BytesNoWitness() ([]byte, error) {
-	// Return the cached serialized bytes if it has already been generated.
-	if len(b.serializedBlockNoWitness) != 0 {
-		return b.serializedBlockNoWitness, nil
-	}
-
-	// Serialize the MsgBlock.
-	var w bytes.Buffer
-	err := b.msgBlock.SerializeNoWitness(&w)
-	if err != nil {
-		return nil, err
+	b.serializeNoWitnessOnce.Do(func() {
+		// Serialize the MsgBlock and cache the result.
+		var w bytes.Buffer
+		b.serializeNoWitnessErr = b.msgBlock.SerializeNoWitness(&w)
+		if b.serializeNoWitnessErr == nil {
+			b.serializedBlockNoWitness = w.Bytes()
+		}
+	})
+	if b.serializeNoWitnessErr != nil {
+		return nil, b.serializeNoWitnessErr
 	}
-	serializedBlock := w.Bytes()
-
-	// Cache the serialized bytes and return them.
-	b.serializedBlockNoWitness = serializedBlock
-	return serializedBlock, nil
+	return b.serializedBlockNoWitness, nil
 }
 
 // Hash returns the block identifier hash for the Block.  This is equivalent to
 // calling BlockHash on the underlying wire.MsgBlock, however it caches the
 // result so subsequent calls are more efficient.
+//
+// This function is safe for concurrent access.
 func (b *Block) Hash() *chainhash.Hash {
-	// Return the cached block hash if it has already been generated.
-	if b.blockHash != nil {
-		return b.blockHash
-	}
+	// Generate and cache the block hash on first access only.
+	b.hashOnce.Do(func() {
+		hash := b.msgBlock.BlockHash()
+		b.blockHash = &hash
+	})
+	return b.blockHash
+}
 
-	// Cache the block hash and return it.
-	hash := b.msgBlock.BlockHash()
-	b.blockHash = &hash
-	return &hash
+// calcSizes generates and caches the serialized sizes of the block with and
+// without witness data on first access only.
+func (b *Block) calcSizes() {
+	b.sizeOnce.Do(func() {
+		b.serializeSize = b.msgBlock.SerializeSize()
+		b.strippedSize = b.msgBlock.SerializeSizeStripped()
+	})
+}
+
+// SerializeSize returns the number of bytes it would take to serialize the
+// block including the witness data of its transactions.  The result is cached
+// so subsequent calls are more efficient.
+//
+// This function is safe for concurrent access.
+func (b *Block) SerializeSize() int {
+	b.calcSizes()
+	return b.serializeSize
+}
+
+// StrippedSize returns the number of bytes it would take to serialize the
+// block without the witness data of its transactions.  The result is cached so
+// subsequent calls are more efficient.
+//
+// This function is safe for concurrent access.
+func (b *Block) StrippedSize() int {
+	b.calcSizes()
+	return b.strippedSize
+}
+
+// Weight returns the weight of the block as defined by BIP 141, which is what
+// the consensus block weight limit applies to.  It equals the weight of the
+// header and transaction count plus the total weight of all transactions.
+//
+// This function is safe for concurrent access.
+func (b *Block) Weight() int64 {
+	b.calcSizes()
+	return int64(b.strippedSize*(WitnessScaleFactor-1) + b.serializeSize)
 }
 
 // Tx returns a wrapped transaction (btcutil.Tx) for the transaction at the
@@ -111,15 +168,27 @@ func (b *Block) Hash() *chainhash.Hash {
 // equivalent to accessing the raw transaction (wire.MsgTx) from the
 // underlying wire.MsgBlock, however the wrapped transaction has some helpful
 // properties such as caching the hash so subsequent calls are more efficient.
+//
+// This function is safe for concurrent access.  It only takes a lock until all
+// of the wrapped transactions have been generated.
 func (b *Block) Tx(txNum int) (*Tx, error) {
 	// Ensure the requested transaction is in range.
 	numTx := uint64(len(b.msgBlock.Transactions))
-	if txNum < 0 || uint64(txNum) > numTx {
+	if txNum < 0 || uint64(txNum) >= numTx {
 		str := fmt.Sprintf("transaction index %d is out of range - max %d",
-			txNum, numTx-1)
+			txNum, int64(numTx)-1)
 		return nil, OutOfRangeError(str)
 	}
 
+	// Return the wrapped transaction without locking if they have ALL
+	// already been generated.
+	if atomic.LoadUint32(&b.txnsGenerated) == 1 {
+		return b.transactions[txNum], nil
+	}
+
+	b.txMtx.Lock()
+	defer b.txMtx.Unlock()
+
 	// Generate slice to hold all of the wrapped transactions if needed.
 	if len(b.transactions) == 0 {
 		b.transactions = make([]*Tx, numTx)
@@ -141,11 +210,23 @@ func (b *Block) Tx(txNum int) (*Tx, error) {
 // transactions in the Block.  This is nearly equivalent to accessing the raw
 // transactions (wire.MsgTx) in the underlying wire.MsgBlock, however it
 // instead provides easy access to wrapped versions (btcutil.Tx) of them.
+//
+// This function is safe for concurrent access.  The returned slice is shared
+// between all callers and must not be modified.
 func (b *Block) Transactions() []*Tx {
 	// Return transactions if they have ALL already been generated.  This
 	// flag is necessary because the wrapped transactions are lazily
 	// generated in a sparse fashion.
-	if b.txnsGenerated {
+	if atomic.LoadUint32(&b.txnsGenerated) == 1 {
+		return b.transactions
+	}
+
+	b.txMtx.Lock()
+	defer b.txMtx.Unlock()
+
+	// Another caller might have generated them while the lock was being
+	// acquired.
+	if b.txnsGenerated == 1 {
 		return b.transactions
 	}
 
@@ -164,10 +245,25 @@ func (b *Block) Transactions() []*Tx {
 		}
 	}
 
-	b.txnsGenerated = true
+	// Publish the fully generated slice.  The atomic store ensures readers
+	// taking the lock-free path above observe all of the writes.
+	atomic.StoreUint32(&b.txnsGenerated, 1)
 	return b.transactions
 }
 
+// CacheTxHashes generates the hash of every transaction in the block across a
+// pool of workers and caches them, so subsequent calls to Hash on the wrapped
+// transactions return immediately.  This is considerably faster than hashing
+// the transactions one at a time on first access for large blocks.
+//
+// This function is safe for concurrent access.
+func (b *Block) CacheTxHashes() {
+	txns := b.Transactions()
+	parallelFor(len(txns), func(i int) {
+		txns[i].Hash()
+	})
+}
+
 // TxHash returns the hash for the requested transaction number in the Block.
 // The supplied index is 0 based.  That is to say, the first transaction in the
 // block is txNum 0.  This is equivalent to calling TxHash on the underlying
@@ -0,0 +1,140 @@
+// Copyright (c) 2013-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcutil
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/nbcorg/btcd/chaincfg/chainhash"
+	"github.com/nbcorg/btcd/wire"
+)
+
+// testMsgBlock returns a block with the passed number of transactions, every
+// other one of which carries witness data.  Each input has a signature script
+// of sigScriptLen bytes.
+func testMsgBlock(numTx, sigScriptLen int) *wire.MsgBlock {
+	var msgBlock wire.MsgBlock
+	msgBlock.Header.Version = 1
+	for i := 0; i < numTx; i++ {
+		tx := wire.NewMsgTx(2)
+		for j := 0; j < 2; j++ {
+			prevOut := wire.OutPoint{Index: uint32(j)}
+			prevOut.Hash[0] = byte(i)
+			prevOut.Hash[1] = byte(i >> 8)
+			txIn := wire.NewTxIn(&prevOut,
+				bytes.Repeat([]byte{byte(j)}, sigScriptLen), nil)
+			if i%2 == 1 {
+				txIn.Witness = wire.TxWitness{
+					bytes.Repeat([]byte{0x30}, 72),
+					bytes.Repeat([]byte{0x03}, 33),
+				}
+			}
+			tx.AddTxIn(txIn)
+		}
+		tx.AddTxOut(wire.NewTxOut(int64(i)*1000,
+			bytes.Repeat([]byte{0x76}, 25)))
+		tx.LockTime = uint32(i)
+		msgBlock.AddTransaction(tx)
+	}
+	return &msgBlock
+}
+
+// TestBlockConcurrentAccess ensures the memoized accessors of a Block and its
+// wrapped transactions may be used from several goroutines at once.  It is
+// meant to be run with the race detector.
+func TestBlockConcurrentAccess(t *testing.T) {
+	msgBlock := testMsgBlock(64, 32)
+	wantBlockHash := msgBlock.BlockHash()
+	wantHashes := make([]chainhash.Hash, len(msgBlock.Transactions))
+	wantWitnessHashes := make([]chainhash.Hash, len(msgBlock.Transactions))
+	for i, tx := range msgBlock.Transactions {
+		wantHashes[i] = tx.TxHash()
+		wantWitnessHashes[i] = tx.WitnessHash()
+	}
+
+	block := NewBlock(msgBlock)
+	numTx := len(msgBlock.Transactions)
+
+	const numGoroutines = 16
+	var wg sync.WaitGroup
+	wg.Add(numGoroutines)
+	for g := 0; g < numGoroutines; g++ {
+		go func(g int) {
+			defer wg.Done()
+
+			if hash := block.Hash(); *hash != wantBlockHash {
+				t.Errorf("Hash: got %v, want %v", hash,
+					wantBlockHash)
+			}
+
+			// Two thirds of the goroutines access single
+			// transactions first, through either Tx or TxHash, so
+			// both the sparse and the full generation of the
+			// wrapped transactions race with each other.
+			for i := 0; i < numTx && g%3 != 2; i++ {
+				txNum := (i + g) % numTx
+				var hash *chainhash.Hash
+				if g%3 == 0 {
+					tx, err := block.Tx(txNum)
+					if err != nil {
+						t.Errorf("Tx(%d): %v", txNum, err)
+						return
+					}
+					hash = tx.Hash()
+				} else {
+					var err error
+					hash, err = block.TxHash(txNum)
+					if err != nil {
+						t.Errorf("TxHash(%d): %v", txNum, err)
+						return
+					}
+				}
+				if *hash != wantHashes[txNum] {
+					t.Errorf("transaction %d: got hash %v, "+
+						"want %v", txNum, hash,
+						wantHashes[txNum])
+				}
+			}
+
+			txns := block.Transactions()
+			if len(txns) != numTx {
+				t.Errorf("Transactions: got %d transactions, "+
+					"want %d", len(txns), numTx)
+				return
+			}
+			for i, tx := range txns {
+				if hash := tx.WitnessHash(); *hash != wantWitnessHashes[i] {
+					t.Errorf("Transactions()[%d].WitnessHash: "+
+						"got %v, want %v", i, hash,
+						wantWitnessHashes[i])
+				}
+				if hash := tx.Hash(); *hash != wantHashes[i] {
+					t.Errorf("Transactions()[%d].Hash: got "+
+						"%v, want %v", i, hash,
+						wantHashes[i])
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// Every caller must have been handed the same wrapped transactions.
+	txns := block.Transactions()
+	for i := 0; i < numTx; i++ {
+		tx, err := block.Tx(i)
+		if err != nil {
+			t.Fatalf("Tx(%d): %v", i, err)
+		}
+		if tx != txns[i] {
+			t.Errorf("Tx(%d) is not the transaction returned by "+
+				"Transactions", i)
+		}
+		if tx.Index() != i {
+			t.Errorf("Tx(%d).Index: got %d", i, tx.Index())
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2013-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcutil
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+
+	"github.com/nbcorg/btcd/chaincfg/chainhash"
+	"github.com/nbcorg/btcd/wire"
+	"github.com/nbcorg/btcutil/chaincfg"
+)
+
+// ProofOfWorkError describes an error due to a block header whose target
+// difficulty is out of range or whose hash does not satisfy the target
+// difficulty it claims.
+type ProofOfWorkError string
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e ProofOfWorkError) Error() string {
+	return string(e)
+}
+
+// BlockHeader defines a bitcoin block header that provides easier and more
+// efficient manipulation of raw block headers.  It is intended for callers
+// which only deal with headers, such as header stores, without the need to
+// hold an entire block.  It also memoizes the hash for the header on its first
+// access so subsequent accesses don't have to repeat the relatively expensive
+// hashing operation.  The memoized hash is safe for concurrent access.
+type BlockHeader struct {
+	msgHeader    *wire.BlockHeader // Underlying wire.BlockHeader
+	headerHash   *chainhash.Hash   // Cached block hash
+	headerHeight int32             // Height in the main block chain
+	hashOnce     sync.Once         // Guards headerHash
+}
+
+// MsgBlockHeader returns the underlying wire.BlockHeader for the BlockHeader.
+func (h *BlockHeader) MsgBlockHeader() *wire.BlockHeader {
+	// Return the cached header.
+	return h.msgHeader
+}
+
+// Hash returns the block identifier hash for the BlockHeader.  This is
+// equivalent to calling BlockHash on the underlying wire.BlockHeader, however
+// it caches the result so subsequent calls are more efficient.
+//
+// This function is safe for concurrent access.
+func (h *BlockHeader) Hash() *chainhash.Hash {
+	// Generate and cache the block hash on first access only.
+	h.hashOnce.Do(func() {
+		hash := h.msgHeader.BlockHash()
+		h.headerHash = &hash
+	})
+	return h.headerHash
+}
+
+// Height returns the saved height of the block header in the block chain.
+// This value will be BlockHeightUnknown if it hasn't already explicitly been
+// set.
+func (h *BlockHeader) Height() int32 {
+	return h.headerHeight
+}
+
+// SetHeight sets the height of the block header in the block chain.
+func (h *BlockHeader) SetHeight(height int32) {
+	h.headerHeight = height
+}
+
+// Bytes returns the serialized bytes for the BlockHeader.  This is equivalent
+// to calling Serialize on the underlying wire.BlockHeader.  The result is not
+// cached since headers are small and it would double the memory used by large
+// header stores.
+func (h *BlockHeader) Bytes() ([]byte, error) {
+	w := bytes.NewBuffer(make([]byte, 0, wire.MaxBlockHeaderPayload))
+	err := h.msgHeader.Serialize(w)
+	if err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
+
+// Target returns the target difficulty encoded by the compact bits of the
+// block header.  See CompactToBig for details.
+func (h *BlockHeader) Target() *big.Int {
+	return CompactToBig(h.msgHeader.Bits)
+}
+
+// Work returns the amount of chainwork the block header contributes to the
+// chain it is part of.  See CalcWork for details.
+func (h *BlockHeader) Work() *big.Int {
+	return CalcWork(h.msgHeader.Bits)
+}
+
+// CheckProofOfWork ensures the block header bits which indicate the target
+// difficulty are in the range allowed by the passed network parameters and
+// that the block hash is less than the target difficulty as claimed.  An
+// error of type ProofOfWorkError is returned when either check fails.
+func (h *BlockHeader) CheckProofOfWork(params *chaincfg.Params) error {
+	// The target difficulty must be larger than zero.
+	target := h.Target()
+	if target.Sign() <= 0 {
+		str := fmt.Sprintf("block target difficulty of %064x is too low",
+			target)
+		return ProofOfWorkError(str)
+	}
+
+	// The target difficulty must be less than the maximum allowed.
+	if target.Cmp(params.PowLimit) > 0 {
+		str := fmt.Sprintf("block target difficulty of %064x is "+
+			"higher than max of %064x", target, params.PowLimit)
+		return ProofOfWorkError(str)
+	}
+
+	// The block hash must be less than the claimed target.
+	hashNum := HashToBig(h.Hash())
+	if hashNum.Cmp(target) > 0 {
+		str := fmt.Sprintf("block hash of %064x is higher than "+
+			"expected max of %064x", hashNum, target)
+		return ProofOfWorkError(str)
+	}
+
+	return nil
+}
+
+// NewBlockHeader returns a new instance of a bitcoin block header given an
+// underlying wire.BlockHeader.  See BlockHeader.
+func NewBlockHeader(msgHeader *wire.BlockHeader) *BlockHeader {
+	return &BlockHeader{
+		msgHeader:    msgHeader,
+		headerHeight: BlockHeightUnknown,
+	}
+}
+
+// NewBlockHeaderFromBytes returns a new instance of a bitcoin block header
+// given the serialized bytes.  See BlockHeader.
+func NewBlockHeaderFromBytes(serializedHeader []byte) (*BlockHeader, error) {
+	br := bytes.NewReader(serializedHeader)
+	return NewBlockHeaderFromReader(br)
+}
+
+// NewBlockHeaderFromReader returns a new instance of a bitcoin block header
+// given a Reader to deserialize the block header.  See BlockHeader.
+func NewBlockHeaderFromReader(r io.Reader) (*BlockHeader, error) {
+	// Deserialize the bytes into a wire.BlockHeader.
+	var msgHeader wire.BlockHeader
+	err := msgHeader.Deserialize(r)
+	if err != nil {
+		return nil, err
+	}
+
+	h := BlockHeader{
+		msgHeader:    &msgHeader,
+		headerHeight: BlockHeightUnknown,
+	}
+	return &h, nil
+}
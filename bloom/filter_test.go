@@ -0,0 +1,61 @@
+// Copyright (c) 2013-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package bloom_test
+
+import (
+	"testing"
+
+	"github.com/nbcorg/btcd/chaincfg/chainhash"
+	"github.com/nbcorg/btcd/wire"
+	"github.com/nbcorg/btcutil/bloom"
+)
+
+// TestFilterDegenerate ensures filters created for zero elements or with a
+// false positive rate of 1 are usable, and that empty filters loaded from a
+// peer match nothing.
+func TestFilterDegenerate(t *testing.T) {
+	tests := []struct {
+		name     string
+		elements uint32
+		fprate   float64
+	}{
+		{"zero elements", 0, 0.01},
+		{"fprate 1", 10, 1},
+		{"fprate above 1", 10, 2},
+		{"zero elements and fprate 1", 0, 1},
+	}
+
+	data := []byte("data")
+	for _, test := range tests {
+		f := bloom.NewFilter(test.elements, 0, test.fprate,
+			wire.BloomUpdateNone)
+		msg := f.MsgFilterLoad()
+		if len(msg.Filter) == 0 || msg.HashFuncs == 0 {
+			t.Errorf("%s: got %d bytes and %d hash funcs, want at "+
+				"least 1 of each", test.name, len(msg.Filter),
+				msg.HashFuncs)
+			continue
+		}
+		f.Add(data)
+		if !f.Matches(data) {
+			t.Errorf("%s: added data does not match", test.name)
+		}
+	}
+
+	empty := []*wire.MsgFilterLoad{
+		wire.NewMsgFilterLoad(nil, 0, 0, wire.BloomUpdateAll),
+		wire.NewMsgFilterLoad([]byte{}, 5, 0, wire.BloomUpdateAll),
+	}
+	for i, msg := range empty {
+		f := bloom.LoadFilter(msg)
+		f.Add(data)
+		if f.Matches(data) {
+			t.Errorf("empty filter %d matches data", i)
+		}
+		if f.MatchesOutPoint(&wire.OutPoint{Hash: chainhash.Hash{1}}) {
+			t.Errorf("empty filter %d matches an outpoint", i)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+// Copyright (c) 2013-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package bloom
+
+import (
+	"github.com/nbcorg/btcd/wire"
+	"github.com/nbcorg/btcutil"
+)
+
+// NewMerkleBlock returns a new *wire.MsgMerkleBlock and an array of the matched
+// transaction index numbers based on the passed block and filter.  Every
+// transaction in the block is run through MatchTxAndUpdate in block order, so
+// the filter is updated as matches are found according to its bloom update
+// flags.
+func NewMerkleBlock(block *btcutil.Block, filter *Filter) (*wire.MsgMerkleBlock, []uint32) {
+	return btcutil.NewMerkleBlockWithMatchFunc(block, filter.MatchTxAndUpdate)
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2014-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package coinset
+
+import (
+	"math"
+	"sort"
+
+	"github.com/nbcorg/btcutil"
+)
+
+// bnbTotalTries is the maximum number of branches the branch and bound
+// selector explores before settling for the best selection found so far.
+const bnbTotalTries = 100000
+
+// BranchAndBoundCoinSelector is a CoinSelector that mirrors the branch and
+// bound selection of the reference implementation.  It performs a depth first
+// search for a selection of coins whose effective value exceeds the target by
+// no more than the cost of change, so no change output is needed, and picks
+// the one which minimizes waste.
+//
+// The waste of a selection is the excess over the target, which is left to
+// the fee, plus the difference between the fee for spending the selected coins
+// now and spending them at LongTermFeeRate.  When the current fee rate is
+// higher than the long term one, selections with fewer inputs are thus
+// preferred, while more inputs are consolidated when it is lower.
+//
+// A selection made by this selector never has change, so MinChangeAmount does
+// not apply.  ErrCoinsNoSelectionAvailable is returned when no changeless
+// selection exists, in which case another selector should be used.
+type BranchAndBoundCoinSelector struct {
+	MaxInputs       int
+	LongTermFeeRate btcutil.FeeRate
+}
+
+// CoinSelect will attempt to select coins using the algorithm described in the
+// BranchAndBoundCoinSelector struct.
+func (s BranchAndBoundCoinSelector) CoinSelect(targetValue btcutil.Amount,
+	feeRate btcutil.FeeRate, changeCost btcutil.Amount,
+	coins []Coin) (*Selection, error) {
+
+	candidates, err := newCandidates(coins, feeRate)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].effectiveValue > candidates[j].effectiveValue
+	})
+
+	// The cost of spending each coin now compared to spending it later.
+	extraFees := make([]btcutil.Amount, len(candidates))
+	var available btcutil.Amount
+	for i, c := range candidates {
+		extraFees[i] = c.fee - s.LongTermFeeRate.FeeForWeight(c.weight)
+		available += c.effectiveValue
+	}
+	if available < targetValue {
+		return nil, ErrCoinsNoSelectionAvailable
+	}
+
+	// The search explores the inclusion branch of each coin before its
+	// omission branch.  The selection holds one entry for each coin
+	// decided on so far.
+	var value, waste btcutil.Amount
+	bestWaste := btcutil.Amount(math.MaxInt64)
+	var selection, best []bool
+	numSelected := 0
+	for try := 0; try < bnbTotalTries; try++ {
+		// Backtrack when the remaining coins can't reach the target,
+		// when the selection overshoots the target by more than the
+		// cost of change, or when the waste can only get worse.
+		backtrack := false
+		switch {
+		case value+available < targetValue ||
+			value > targetValue+changeCost ||
+			(waste > bestWaste && extraFees[0] > 0) ||
+			(s.MaxInputs > 0 && numSelected > s.MaxInputs):
+
+			backtrack = true
+
+		case value >= targetValue:
+			// The selection is a solution, so record it if it
+			// wastes less than the best one so far.
+			excess := value - targetValue
+			if waste+excess <= bestWaste {
+				best = append(best[:0], selection...)
+				bestWaste = waste + excess
+			}
+			backtrack = true
+		}
+
+		// Nothing can beat a selection without any waste.
+		if bestWaste == 0 {
+			break
+		}
+
+		if backtrack {
+			// Walk back past the omitted coins, and then omit the
+			// last included coin instead.
+			for len(selection) > 0 && !selection[len(selection)-1] {
+				selection = selection[:len(selection)-1]
+				available += candidates[len(selection)].effectiveValue
+			}
+			if len(selection) == 0 {
+				break
+			}
+
+			i := len(selection) - 1
+			selection[i] = false
+			numSelected--
+			value -= candidates[i].effectiveValue
+			waste -= extraFees[i]
+			continue
+		}
+
+		// Include the next coin, unless it is equivalent to the
+		// previous coin which was just omitted, since including it
+		// would only explore the same selections again.
+		i := len(selection)
+		c := &candidates[i]
+		available -= c.effectiveValue
+		if i > 0 && !selection[i-1] &&
+			c.effectiveValue == candidates[i-1].effectiveValue &&
+			c.fee == candidates[i-1].fee {
+
+			selection = append(selection, false)
+			continue
+		}
+		selection = append(selection, true)
+		numSelected++
+		value += c.effectiveValue
+		waste += extraFees[i]
+	}
+
+	if best == nil {
+		return nil, ErrCoinsNoSelectionAvailable
+	}
+
+	selected := make([]candidate, 0, len(best))
+	for i, included := range best {
+		if included {
+			selected = append(selected, candidates[i])
+		}
+	}
+
+	// The excess of the selection is within the cost of change, so it never
+	// has any change.
+	return newSelection(selected, targetValue, changeCost, 0), nil
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2014-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package coinset_test
+
+import (
+	"testing"
+
+	"github.com/nbcorg/btcutil"
+	"github.com/nbcorg/btcutil/coinset"
+)
+
+// cent is the unit the selection tests of the reference implementation use.
+const cent = btcutil.Amount(1000000)
+
+// newCoins returns coins of the passed values, whose hashes are derived from
+// the passed index onwards.
+func newCoins(index int64, values ...btcutil.Amount) []coinset.Coin {
+	coins := make([]coinset.Coin, len(values))
+	for i, value := range values {
+		coins[i] = NewCoin(index+int64(i), value, 6)
+	}
+	return coins
+}
+
+// makeHardCase returns coins and a target which make branch and bound
+// selection explore an exponential number of branches, mirroring the
+// make_hard_case helper of the reference implementation.
+func makeHardCase(utxos uint) ([]coinset.Coin, btcutil.Amount) {
+	var coins []coinset.Coin
+	var target btcutil.Amount
+	for i := uint(0); i < utxos; i++ {
+		value := btcutil.Amount(1) << (utxos + i)
+		target += value
+		coins = append(coins, newCoins(int64(2*i), value,
+			value+btcutil.Amount(1)<<(utxos-1-i))...)
+	}
+	return coins, target
+}
+
+// TestBranchAndBoundCoinSelector checks the branch and bound selector against
+// the vectors of the bnb_search_test of the reference implementation.  The
+// fee rate is zero, so the effective value of each coin is its value.
+func TestBranchAndBoundCoinSelector(t *testing.T) {
+	pool := newCoins(0, 1*cent, 2*cent, 3*cent, 4*cent)
+	coin1, coin2, coin4 := pool[0], pool[1], pool[3]
+	coin5 := NewCoin(4, 5*cent, 6)
+
+	tests := []coinSelectTestCase{
+		{
+			name:          "select 1 cent",
+			inputCoins:    pool,
+			targetValue:   1 * cent,
+			changeCost:    cent / 2,
+			expectedCoins: []coinset.Coin{coin1},
+		},
+		{
+			name:          "select 2 cent",
+			inputCoins:    pool,
+			targetValue:   2 * cent,
+			changeCost:    cent / 2,
+			expectedCoins: []coinset.Coin{coin2},
+		},
+		{
+			name:          "select 5 cent",
+			inputCoins:    pool,
+			targetValue:   5 * cent,
+			changeCost:    cent / 2,
+			expectedCoins: []coinset.Coin{coin4, coin1},
+		},
+		{
+			name:          "select 11 cent, not possible",
+			inputCoins:    pool,
+			targetValue:   11 * cent,
+			changeCost:    cent / 2,
+			expectedError: coinset.ErrCoinsNoSelectionAvailable,
+		},
+		{
+			name:          "cost of change too small",
+			inputCoins:    pool,
+			targetValue:   cent / 4,
+			changeCost:    cent / 2,
+			expectedError: coinset.ErrCoinsNoSelectionAvailable,
+		},
+		{
+			name:          "select 10 cent",
+			inputCoins:    append(pool, coin5),
+			targetValue:   10 * cent,
+			changeCost:    cent / 2,
+			expectedCoins: []coinset.Coin{coin5, coin4, coin1},
+		},
+	}
+
+	// Coins of 5 cent and more can't make up 1 cent without change.
+	var larger []btcutil.Amount
+	for i := btcutil.Amount(5); i <= 20; i++ {
+		larger = append(larger, i*cent)
+	}
+	tests = append(tests, coinSelectTestCase{
+		name:          "select 1 cent from larger coins",
+		inputCoins:    newCoins(100, larger...),
+		targetValue:   1 * cent,
+		changeCost:    2 * cent,
+		expectedError: coinset.ErrCoinsNoSelectionAvailable,
+	})
+
+	// Many coins of the same value are skipped without exploring each of
+	// them, so the search does not run out of tries.
+	sameValue := newCoins(200, 7*cent, 7*cent, 7*cent, 7*cent, 2*cent)
+	fives := make([]btcutil.Amount, 50000)
+	for i := range fives {
+		fives[i] = 5 * cent
+	}
+	bailoutPool := append(newCoins(300, fives...), sameValue...)
+	tests = append(tests, coinSelectTestCase{
+		name:          "same value early bailout",
+		inputCoins:    bailoutPool,
+		targetValue:   30 * cent,
+		changeCost:    5000,
+		expectedCoins: sameValue,
+	})
+
+	for i := range tests {
+		tests[i].selector = coinset.BranchAndBoundCoinSelector{}
+	}
+	testCoinSelector(tests, t)
+}
+
+// TestBranchAndBoundExhaustion ensures the branch and bound selector gives up
+// after exploring the maximum number of branches, mirroring the iteration
+// exhaustion test of the reference implementation.
+func TestBranchAndBoundExhaustion(t *testing.T) {
+	selector := coinset.BranchAndBoundCoinSelector{}
+
+	hardCoins, target := makeHardCase(17)
+	_, err := selector.CoinSelect(target, 0, 0, hardCoins)
+	if err != coinset.ErrCoinsNoSelectionAvailable {
+		t.Fatalf("CoinSelect: expected search to be exhausted, got %v",
+			err)
+	}
+
+	hardCoins, target = makeHardCase(14)
+	selection, err := selector.CoinSelect(target, 0, 0, hardCoins)
+	if err != nil {
+		t.Fatalf("CoinSelect: unexpected error: %v", err)
+	}
+	if selection.TotalValue() != target {
+		t.Fatalf("CoinSelect: selected %v, expected %v",
+			selection.TotalValue(), target)
+	}
+}
+
+// TestBranchAndBoundWaste ensures the branch and bound selector prefers fewer
+// inputs when the fee rate is above the long term fee rate, and more inputs
+// when it is below.
+func TestBranchAndBoundWaste(t *testing.T) {
+	feeRate := btcutil.NewFeeRateFromSatPerVByte(10)
+	inputFee := testInputFee(t, feeRate)
+
+	// A single coin and a pair of coins both match the target exactly
+	// after paying for their inputs.
+	single := NewCoin(400, 2*cent+inputFee, 6)
+	pair := newCoins(401, cent+inputFee, cent+inputFee)
+	pool := append([]coinset.Coin{single}, pair...)
+
+	selector := coinset.BranchAndBoundCoinSelector{
+		LongTermFeeRate: btcutil.NewFeeRateFromSatPerVByte(1),
+	}
+	selection, err := selector.CoinSelect(2*cent, feeRate, 0, pool)
+	if err != nil {
+		t.Fatalf("CoinSelect: unexpected error: %v", err)
+	}
+	if !sameCoins(selection.Coins, []coinset.Coin{single}) {
+		t.Fatalf("CoinSelect: expected the single coin at a high fee "+
+			"rate, got %v", coinValues(selection.Coins))
+	}
+
+	selector.LongTermFeeRate = btcutil.NewFeeRateFromSatPerVByte(100)
+	selection, err = selector.CoinSelect(2*cent, feeRate, 0, pool)
+	if err != nil {
+		t.Fatalf("CoinSelect: unexpected error: %v", err)
+	}
+	if !sameCoins(selection.Coins, pair) {
+		t.Fatalf("CoinSelect: expected the pair of coins at a low fee "+
+			"rate, got %v", coinValues(selection.Coins))
+	}
+}
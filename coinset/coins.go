@@ -0,0 +1,356 @@
+// Copyright (c) 2014-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package coinset
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/nbcorg/btcd/chaincfg/chainhash"
+	"github.com/nbcorg/btcd/wire"
+	"github.com/nbcorg/btcutil"
+	"github.com/nbcorg/btcutil/txscript"
+)
+
+// Coin represents a spendable transaction outpoint.
+type Coin interface {
+	Hash() *chainhash.Hash
+	Index() uint32
+	Value() btcutil.Amount
+	PkScript() []byte
+	NumConfs() int64
+}
+
+// SpendSizer is an optional interface a Coin can implement to provide the
+// size of the data needed to spend it.  The size of coins which don't
+// implement it is estimated from their public key script, which isn't possible
+// for coins paying to a script hash since their redeem or witness script is
+// not known.  Such coins are never selected.  See txscript.EstimateSpendSize.
+type SpendSizer interface {
+	SpendSize() (txscript.SpendSize, error)
+}
+
+var (
+	// ErrCoinsNoSelectionAvailable is returned when a CoinSelector
+	// believes there is no possible combination of coins which can meet
+	// the requirements provided to the selector.
+	ErrCoinsNoSelectionAvailable = errors.New("no coin selection possible")
+)
+
+// Selection is the result of a coin selection.
+type Selection struct {
+	// Coins are the selected coins.
+	Coins []Coin
+
+	// InputFee is the fee for spending the selected coins at the fee rate
+	// the selection was made with.
+	InputFee btcutil.Amount
+
+	// Change is the amount to send to a change output, or zero when the
+	// selection should not have a change output.  In that case any excess
+	// over the target is left to the fee.
+	Change btcutil.Amount
+}
+
+// TotalValue returns the total value of the selected coins.
+func (s *Selection) TotalValue() btcutil.Amount {
+	var total btcutil.Amount
+	for _, coin := range s.Coins {
+		total += coin.Value()
+	}
+	return total
+}
+
+// CoinSelector is an interface that wraps the CoinSelect method.
+//
+// CoinSelect will attempt to select a subset of the coins whose value, after
+// paying for their own inputs at feeRate, is at least the target value.  The
+// target value is thus the amount to pay along with the fee for the rest of
+// the transaction, but excluding the fee for the selected inputs.  Coins which
+// cost more to spend than they are worth are never selected.
+//
+// The changeCost is the fee for adding a change output to the transaction.
+// When the selection exceeds the target by more than that, the rest is
+// returned as change, unless it is below the minimum change amount of the
+// selector, in which case it is left to the fee instead.
+//
+// Coins whose spend size can not be estimated from their public key script
+// and which don't implement SpendSizer are never selected either.  An error
+// identifying the coin is returned when a SpendSizer fails.
+//
+// CoinSelect is not guaranteed to return a selection of coins even if the
+// total value of coins given is greater than the target value.  The exact
+// choice of coins in the subset will be implementation specific.
+type CoinSelector interface {
+	CoinSelect(targetValue btcutil.Amount, feeRate btcutil.FeeRate,
+		changeCost btcutil.Amount, coins []Coin) (*Selection, error)
+}
+
+// candidate houses a coin being considered for selection along with the cost
+// of spending it.
+type candidate struct {
+	coin           Coin
+	weight         int64
+	fee            btcutil.Amount
+	effectiveValue btcutil.Amount
+}
+
+// CoinSpendSize returns the size of the data needed to spend the passed coin,
+// which is provided by the coin when it implements SpendSizer and estimated
+// from its public key script otherwise.
+func CoinSpendSize(coin Coin) (txscript.SpendSize, error) {
+	if sizer, ok := coin.(SpendSizer); ok {
+		return sizer.SpendSize()
+	}
+	return txscript.EstimateSpendSize(coin.PkScript(), nil, nil)
+}
+
+// newCandidates returns the candidates for the passed coins at the passed fee
+// rate, skipping coins which cost as much or more to spend than they are
+// worth, and coins whose spend size can't be estimated without a SpendSizer.
+// The candidates are in the same order as the coins.
+func newCandidates(coins []Coin, feeRate btcutil.FeeRate) ([]candidate, error) {
+	candidates := make([]candidate, 0, len(coins))
+	for _, coin := range coins {
+		spendSize, err := CoinSpendSize(coin)
+		if err != nil {
+			if _, ok := coin.(SpendSizer); !ok {
+				continue
+			}
+			return nil, fmt.Errorf("unable to determine the spend "+
+				"size of coin %v:%d: %v", coin.Hash(),
+				coin.Index(), err)
+		}
+
+		weight := spendSize.Weight()
+		fee := feeRate.FeeForWeight(weight)
+		effectiveValue := coin.Value() - fee
+		if effectiveValue <= 0 {
+			continue
+		}
+
+		candidates = append(candidates, candidate{
+			coin:           coin,
+			weight:         weight,
+			fee:            fee,
+			effectiveValue: effectiveValue,
+		})
+	}
+	return candidates, nil
+}
+
+// newSelection returns the selection for the passed candidates.  The excess of
+// their effective value over the target is returned as change when it covers
+// the change cost and leaves at least minChange.
+func newSelection(selected []candidate, targetValue, changeCost,
+	minChange btcutil.Amount) *Selection {
+
+	selection := &Selection{Coins: make([]Coin, len(selected))}
+	var effectiveValue btcutil.Amount
+	for i, c := range selected {
+		selection.Coins[i] = c.coin
+		selection.InputFee += c.fee
+		effectiveValue += c.effectiveValue
+	}
+
+	change := effectiveValue - targetValue - changeCost
+	if change > 0 && change >= minChange {
+		selection.Change = change
+	}
+	return selection
+}
+
+// accumulate selects candidates in the passed order until their effective
+// value reaches the target.
+func accumulate(candidates []candidate, targetValue, changeCost,
+	minChange btcutil.Amount, maxInputs int) (*Selection, error) {
+
+	var total btcutil.Amount
+	for n, c := range candidates {
+		if maxInputs > 0 && n >= maxInputs {
+			break
+		}
+		total += c.effectiveValue
+		if total >= targetValue {
+			return newSelection(candidates[:n+1], targetValue,
+				changeCost, minChange), nil
+		}
+	}
+	return nil, ErrCoinsNoSelectionAvailable
+}
+
+// LargestFirstCoinSelector is a CoinSelector that selects the coins with the
+// highest value after paying for their inputs first, which uses as few of the
+// coins as possible.
+type LargestFirstCoinSelector struct {
+	MaxInputs       int
+	MinChangeAmount btcutil.Amount
+}
+
+// CoinSelect will attempt to select coins using the algorithm described in the
+// LargestFirstCoinSelector struct.
+func (s LargestFirstCoinSelector) CoinSelect(targetValue btcutil.Amount,
+	feeRate btcutil.FeeRate, changeCost btcutil.Amount,
+	coins []Coin) (*Selection, error) {
+
+	candidates, err := newCandidates(coins, feeRate)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].effectiveValue > candidates[j].effectiveValue
+	})
+
+	return accumulate(candidates, targetValue, changeCost,
+		s.MinChangeAmount, s.MaxInputs)
+}
+
+// OldestFirstCoinSelector is a CoinSelector that selects the coins with the
+// most confirmations first.  Coins with the same number of confirmations are
+// selected in order of their value.
+type OldestFirstCoinSelector struct {
+	MaxInputs       int
+	MinChangeAmount btcutil.Amount
+}
+
+// CoinSelect will attempt to select coins using the algorithm described in the
+// OldestFirstCoinSelector struct.
+func (s OldestFirstCoinSelector) CoinSelect(targetValue btcutil.Amount,
+	feeRate btcutil.FeeRate, changeCost btcutil.Amount,
+	coins []Coin) (*Selection, error) {
+
+	candidates, err := newCandidates(coins, feeRate)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		confsI := candidates[i].coin.NumConfs()
+		confsJ := candidates[j].coin.NumConfs()
+		if confsI != confsJ {
+			return confsI > confsJ
+		}
+		return candidates[i].effectiveValue > candidates[j].effectiveValue
+	})
+
+	return accumulate(candidates, targetValue, changeCost,
+		s.MinChangeAmount, s.MaxInputs)
+}
+
+// RandomCoinSelector is a CoinSelector that selects coins in a random order
+// until the target is reached.  Random selection avoids consistently leaking
+// information about the coins of a wallet through its selections, and tends
+// to keep the number of coins of a wallet in check over time.
+//
+// Rand is the source of randomness, which defaults to the shared source of the
+// math/rand package when nil.
+type RandomCoinSelector struct {
+	MaxInputs       int
+	MinChangeAmount btcutil.Amount
+	Rand            *rand.Rand
+}
+
+// CoinSelect will attempt to select coins using the algorithm described in the
+// RandomCoinSelector struct.
+func (s RandomCoinSelector) CoinSelect(targetValue btcutil.Amount,
+	feeRate btcutil.FeeRate, changeCost btcutil.Amount,
+	coins []Coin) (*Selection, error) {
+
+	candidates, err := newCandidates(coins, feeRate)
+	if err != nil {
+		return nil, err
+	}
+	Shuffle(s.Rand, len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+
+	return accumulate(candidates, targetValue, changeCost,
+		s.MinChangeAmount, s.MaxInputs)
+}
+
+// Shuffle pseudo-randomizes the order of n elements using the passed source of
+// randomness, or the shared source of the math/rand package when it is nil.
+func Shuffle(r *rand.Rand, n int, swap func(i, j int)) {
+	if r == nil {
+		rand.Shuffle(n, swap)
+		return
+	}
+	r.Shuffle(n, swap)
+}
+
+// randBool returns a pseudo-random boolean using the passed source of
+// randomness, or the shared source of the math/rand package when it is nil.
+func randBool(r *rand.Rand) bool {
+	if r == nil {
+		return rand.Int63()&1 == 1
+	}
+	return r.Int63()&1 == 1
+}
+
+// SimpleCoin defines a concrete instance of Coin that is backed by a
+// btcutil.Tx, a specific outpoint index, and the number of confirmations
+// that transaction has had.
+type SimpleCoin struct {
+	Tx         *btcutil.Tx
+	TxIndex    uint32
+	TxNumConfs int64
+}
+
+// Ensure that SimpleCoin is a Coin
+var _ Coin = &SimpleCoin{}
+
+// Hash returns the hash value of the transaction on which the Coin is an output
+func (c *SimpleCoin) Hash() *chainhash.Hash {
+	return c.Tx.Hash()
+}
+
+// Index returns the index of the output on the transaction which the Coin represents
+func (c *SimpleCoin) Index() uint32 {
+	return c.TxIndex
+}
+
+// txOut returns the TxOut of the transaction the Coin represents
+func (c *SimpleCoin) txOut() *wire.TxOut {
+	return c.Tx.MsgTx().TxOut[c.TxIndex]
+}
+
+// Value returns the value of the Coin
+func (c *SimpleCoin) Value() btcutil.Amount {
+	return btcutil.Amount(c.txOut().Value)
+}
+
+// PkScript returns the outpoint script of the Coin.
+//
+// This can be used to determine what type of script the Coin uses
+// and extract standard addresses if possible using
+// txscript.ExtractPkScriptAddrs for example.
+func (c *SimpleCoin) PkScript() []byte {
+	return c.txOut().PkScript
+}
+
+// NumConfs returns the number of confirmations that the transaction the Coin references
+// has had.
+func (c *SimpleCoin) NumConfs() int64 {
+	return c.TxNumConfs
+}
+
+// NewMsgTxWithInputCoins takes the passed coins and makes them the inputs to a
+// new wire.MsgTx which is returned.
+func NewMsgTxWithInputCoins(txVersion int32, coins []Coin) *wire.MsgTx {
+	msgTx := wire.NewMsgTx(txVersion)
+	msgTx.TxIn = make([]*wire.TxIn, len(coins))
+	for i, coin := range coins {
+		msgTx.TxIn[i] = &wire.TxIn{
+			PreviousOutPoint: wire.OutPoint{
+				Hash:  *coin.Hash(),
+				Index: coin.Index(),
+			},
+			SignatureScript: nil,
+			Sequence:        wire.MaxTxInSequenceNum,
+		}
+	}
+	return msgTx
+}
@@ -0,0 +1,349 @@
+// Copyright (c) 2014-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package coinset_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/nbcorg/btcd/chaincfg/chainhash"
+	"github.com/nbcorg/btcutil"
+	"github.com/nbcorg/btcutil/coinset"
+	"github.com/nbcorg/btcutil/txscript"
+)
+
+var (
+	// p2wpkhScript is the public key script of the test coins, whose spend
+	// size can be estimated.
+	p2wpkhScript = append([]byte{txscript.OP_0, txscript.OP_DATA_20},
+		bytes.Repeat([]byte{0x01}, 20)...)
+
+	// p2shScript is a public key script whose spend size can not be
+	// estimated without the redeem script.
+	p2shScript = append(append([]byte{txscript.OP_HASH160,
+		txscript.OP_DATA_20}, bytes.Repeat([]byte{0x02}, 20)...),
+		txscript.OP_EQUAL)
+)
+
+type TestCoin struct {
+	TxHash     *chainhash.Hash
+	TxIndex    uint32
+	TxValue    btcutil.Amount
+	TxNumConfs int64
+	TxPkScript []byte
+}
+
+func (c *TestCoin) Hash() *chainhash.Hash { return c.TxHash }
+func (c *TestCoin) Index() uint32         { return c.TxIndex }
+func (c *TestCoin) Value() btcutil.Amount { return c.TxValue }
+func (c *TestCoin) PkScript() []byte      { return c.TxPkScript }
+func (c *TestCoin) NumConfs() int64       { return c.TxNumConfs }
+
+// SizedCoin is a TestCoin which provides its spend size.
+type SizedCoin struct {
+	TestCoin
+	Size txscript.SpendSize
+	Err  error
+}
+
+func (c *SizedCoin) SpendSize() (txscript.SpendSize, error) {
+	return c.Size, c.Err
+}
+
+func NewCoin(index int64, value btcutil.Amount, numConfs int64) coinset.Coin {
+	h := sha256.New()
+	h.Write([]byte(fmt.Sprintf("%d", index)))
+	hash, _ := chainhash.NewHash(h.Sum(nil))
+	c := &TestCoin{
+		TxHash:     hash,
+		TxIndex:    0,
+		TxValue:    value,
+		TxNumConfs: numConfs,
+		TxPkScript: p2wpkhScript,
+	}
+	return coinset.Coin(c)
+}
+
+type coinSelectTestCase struct {
+	name           string
+	selector       coinset.CoinSelector
+	inputCoins     []coinset.Coin
+	targetValue    btcutil.Amount
+	feeRate        btcutil.FeeRate
+	changeCost     btcutil.Amount
+	expectedCoins  []coinset.Coin
+	expectedChange btcutil.Amount
+	expectedError  error
+}
+
+// sameCoins returns whether the passed selections contain the same coins,
+// regardless of their order.
+func sameCoins(a, b []coinset.Coin) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	used := make([]bool, len(b))
+next:
+	for _, coinA := range a {
+		for i, coinB := range b {
+			if !used[i] && coinA == coinB {
+				used[i] = true
+				continue next
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func testCoinSelector(tests []coinSelectTestCase, t *testing.T) {
+	for _, test := range tests {
+		selection, err := test.selector.CoinSelect(test.targetValue,
+			test.feeRate, test.changeCost, test.inputCoins)
+		if err != test.expectedError {
+			t.Errorf("%s: expected error %v, got %v", test.name,
+				test.expectedError, err)
+			continue
+		}
+		if test.expectedError != nil {
+			continue
+		}
+		if !sameCoins(selection.Coins, test.expectedCoins) {
+			t.Errorf("%s: expected coins %v, got %v", test.name,
+				coinValues(test.expectedCoins),
+				coinValues(selection.Coins))
+		}
+		if selection.Change != test.expectedChange {
+			t.Errorf("%s: expected change %v, got %v", test.name,
+				test.expectedChange, selection.Change)
+		}
+	}
+}
+
+// testInputFee returns the fee for spending a test coin at the passed fee
+// rate.
+func testInputFee(t *testing.T, feeRate btcutil.FeeRate) btcutil.Amount {
+	spendSize, err := txscript.EstimateSpendSize(p2wpkhScript, nil, nil)
+	if err != nil {
+		t.Fatalf("EstimateSpendSize: unexpected error: %v", err)
+	}
+	return feeRate.FeeForWeight(spendSize.Weight())
+}
+
+// coinValues returns the values of the passed coins for error messages.
+func coinValues(coins []coinset.Coin) []btcutil.Amount {
+	values := make([]btcutil.Amount, len(coins))
+	for i, coin := range coins {
+		values[i] = coin.Value()
+	}
+	return values
+}
+
+var coins = []coinset.Coin{
+	NewCoin(1, 100000000, 1),
+	NewCoin(2, 10000000, 20),
+	NewCoin(3, 50000000, 0),
+	NewCoin(4, 25000000, 6),
+}
+
+var largestFirstTests = []coinSelectTestCase{
+	{
+		name:          "single largest coin",
+		selector:      coinset.LargestFirstCoinSelector{},
+		inputCoins:    coins,
+		targetValue:   100000000,
+		expectedCoins: []coinset.Coin{coins[0]},
+	},
+	{
+		name:           "two largest coins",
+		selector:       coinset.LargestFirstCoinSelector{},
+		inputCoins:     coins,
+		targetValue:    120000000,
+		expectedCoins:  []coinset.Coin{coins[0], coins[2]},
+		expectedChange: 30000000,
+	},
+	{
+		name:          "max inputs reached",
+		selector:      coinset.LargestFirstCoinSelector{MaxInputs: 1},
+		inputCoins:    coins,
+		targetValue:   120000000,
+		expectedError: coinset.ErrCoinsNoSelectionAvailable,
+	},
+	{
+		name:          "insufficient funds",
+		selector:      coinset.LargestFirstCoinSelector{},
+		inputCoins:    coins,
+		targetValue:   185000001,
+		expectedError: coinset.ErrCoinsNoSelectionAvailable,
+	},
+	{
+		name:          "change below minimum",
+		selector:      coinset.LargestFirstCoinSelector{MinChangeAmount: 1000},
+		inputCoins:    coins,
+		targetValue:   99999500,
+		changeCost:    0,
+		expectedCoins: []coinset.Coin{coins[0]},
+	},
+	{
+		name:           "change after its cost",
+		selector:       coinset.LargestFirstCoinSelector{MinChangeAmount: 1000},
+		inputCoins:     coins,
+		targetValue:    99990000,
+		changeCost:     2000,
+		expectedCoins:  []coinset.Coin{coins[0]},
+		expectedChange: 8000,
+	},
+}
+
+func TestLargestFirstCoinSelector(t *testing.T) {
+	testCoinSelector(largestFirstTests, t)
+}
+
+var oldestFirstTests = []coinSelectTestCase{
+	{
+		name:          "single oldest coin",
+		selector:      coinset.OldestFirstCoinSelector{},
+		inputCoins:    coins,
+		targetValue:   10000000,
+		expectedCoins: []coinset.Coin{coins[1]},
+	},
+	{
+		name:           "two oldest coins",
+		selector:       coinset.OldestFirstCoinSelector{},
+		inputCoins:     coins,
+		targetValue:    30000000,
+		expectedCoins:  []coinset.Coin{coins[1], coins[3]},
+		expectedChange: 5000000,
+	},
+	{
+		name:          "max inputs reached",
+		selector:      coinset.OldestFirstCoinSelector{MaxInputs: 2},
+		inputCoins:    coins,
+		targetValue:   40000000,
+		expectedError: coinset.ErrCoinsNoSelectionAvailable,
+	},
+}
+
+func TestOldestFirstCoinSelector(t *testing.T) {
+	testCoinSelector(oldestFirstTests, t)
+}
+
+func TestRandomCoinSelector(t *testing.T) {
+	selector := coinset.RandomCoinSelector{
+		MaxInputs: 3,
+		Rand:      rand.New(rand.NewSource(1)),
+	}
+	const targetValue = 60000000
+	for i := 0; i < 100; i++ {
+		selection, err := selector.CoinSelect(targetValue, 0, 0, coins)
+		if err != nil {
+			t.Fatalf("CoinSelect: unexpected error: %v", err)
+		}
+		if len(selection.Coins) > selector.MaxInputs {
+			t.Fatalf("CoinSelect: selected %d coins, more than "+
+				"%d", len(selection.Coins), selector.MaxInputs)
+		}
+		total := selection.TotalValue()
+		if total < targetValue {
+			t.Fatalf("CoinSelect: selected %v, less than %v",
+				total, btcutil.Amount(targetValue))
+		}
+		if selection.Change != total-targetValue {
+			t.Fatalf("CoinSelect: change is %v, expected %v",
+				selection.Change, total-targetValue)
+		}
+
+		// The selection stops once the target is reached, so the last
+		// coin must be needed to reach it.
+		last := selection.Coins[len(selection.Coins)-1]
+		if total-last.Value() >= targetValue {
+			t.Fatalf("CoinSelect: selected %v after the target "+
+				"was reached", last.Value())
+		}
+	}
+}
+
+// TestCoinSelectFees ensures the selectors account for the fee of spending
+// each coin, and skip coins which cost more to spend than they are worth.
+func TestCoinSelectFees(t *testing.T) {
+	feeRate := btcutil.NewFeeRateFromSatPerVByte(10)
+	inputFee := testInputFee(t, feeRate)
+
+	dust := NewCoin(10, inputFee, 100)
+	coin := NewCoin(11, 100000+inputFee, 1)
+	selection, err := coinset.OldestFirstCoinSelector{}.CoinSelect(100000,
+		feeRate, 0, []coinset.Coin{dust, coin})
+	if err != nil {
+		t.Fatalf("CoinSelect: unexpected error: %v", err)
+	}
+	if !sameCoins(selection.Coins, []coinset.Coin{coin}) {
+		t.Fatalf("CoinSelect: expected coins %v, got %v",
+			coinValues([]coinset.Coin{coin}),
+			coinValues(selection.Coins))
+	}
+	if selection.InputFee != inputFee || selection.Change != 0 {
+		t.Fatalf("CoinSelect: expected input fee %v and no change, "+
+			"got %v and %v", inputFee, selection.InputFee,
+			selection.Change)
+	}
+}
+
+// TestCoinSelectUnknownSpendSize ensures coins whose spend size can't be
+// estimated are skipped rather than failing the selection, while a failing
+// SpendSizer is reported.
+func TestCoinSelectUnknownSpendSize(t *testing.T) {
+	feeRate := btcutil.NewFeeRateFromSatPerVByte(1)
+	p2shCoin := &TestCoin{
+		TxHash:     &chainhash.Hash{0x01},
+		TxValue:    500000000,
+		TxPkScript: p2shScript,
+	}
+	sizedCoin := &SizedCoin{
+		TestCoin: TestCoin{
+			TxHash:     &chainhash.Hash{0x02},
+			TxValue:    400000000,
+			TxPkScript: p2shScript,
+		},
+		Size: txscript.SpendSize{SigScriptSize: 254},
+	}
+	inputCoins := []coinset.Coin{p2shCoin, sizedCoin, coins[0]}
+
+	selectors := []coinset.CoinSelector{
+		coinset.LargestFirstCoinSelector{},
+		coinset.OldestFirstCoinSelector{},
+		coinset.RandomCoinSelector{Rand: rand.New(rand.NewSource(1))},
+		coinset.KnapsackCoinSelector{Rand: rand.New(rand.NewSource(1))},
+	}
+	for i, selector := range selectors {
+		selection, err := selector.CoinSelect(450000000, feeRate, 0,
+			inputCoins)
+		if err != nil {
+			t.Errorf("#%d: CoinSelect: unexpected error: %v", i, err)
+			continue
+		}
+		if !sameCoins(selection.Coins, []coinset.Coin{sizedCoin, coins[0]}) {
+			t.Errorf("#%d: CoinSelect: unexpected coins %v", i,
+				coinValues(selection.Coins))
+		}
+	}
+
+	sizerErr := errors.New("unknown redeem script")
+	failingCoin := &SizedCoin{TestCoin: sizedCoin.TestCoin, Err: sizerErr}
+	_, err := coinset.LargestFirstCoinSelector{}.CoinSelect(100000000,
+		feeRate, 0, []coinset.Coin{failingCoin, coins[0]})
+	if err == nil {
+		t.Fatal("CoinSelect: expected error for failing SpendSizer")
+	}
+	hash := failingCoin.Hash().String()
+	if !strings.Contains(err.Error(), hash) {
+		t.Fatalf("CoinSelect: error %q does not identify coin %v",
+			err, hash)
+	}
+}
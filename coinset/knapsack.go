@@ -0,0 +1,175 @@
+// Copyright (c) 2014-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package coinset
+
+import (
+	"math/rand"
+	"sort"
+
+	"github.com/nbcorg/btcutil"
+)
+
+// knapsackIterations is the number of random subsets the knapsack selector
+// tries when approximating the best subset of coins.
+const knapsackIterations = 1000
+
+// KnapsackCoinSelector is a CoinSelector that mirrors the knapsack solver the
+// reference implementation used before branch and bound selection.  A single
+// coin matching the target exactly is preferred.  Otherwise the coins smaller
+// than the target plus the cost of change are searched for the random subset
+// that exceeds the target by the least, which is compared against the
+// smallest coin that exceeds the target on its own.
+//
+// Rand is the source of randomness, which defaults to the shared source of the
+// math/rand package when nil.
+type KnapsackCoinSelector struct {
+	MaxInputs       int
+	MinChangeAmount btcutil.Amount
+	Rand            *rand.Rand
+}
+
+// CoinSelect will attempt to select coins using the algorithm described in the
+// KnapsackCoinSelector struct.
+func (s KnapsackCoinSelector) CoinSelect(targetValue btcutil.Amount,
+	feeRate btcutil.FeeRate, changeCost btcutil.Amount,
+	coins []Coin) (*Selection, error) {
+
+	candidates, err := newCandidates(coins, feeRate)
+	if err != nil {
+		return nil, err
+	}
+	Shuffle(s.Rand, len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+
+	finish := func(selected []candidate) (*Selection, error) {
+		if s.MaxInputs > 0 && len(selected) > s.MaxInputs {
+			return nil, ErrCoinsNoSelectionAvailable
+		}
+		return newSelection(selected, targetValue, changeCost,
+			s.MinChangeAmount), nil
+	}
+
+	// Partition the coins into those smaller than the target plus the
+	// cost of change, and keep track of the smallest coin which is larger.
+	// A coin matching the target exactly is used on its own.
+	changeTarget := targetValue + changeCost + s.MinChangeAmount
+	var applicable []candidate
+	var lowestLarger *candidate
+	var totalLower btcutil.Amount
+	for i := range candidates {
+		c := &candidates[i]
+		switch {
+		case c.effectiveValue == targetValue:
+			return finish([]candidate{*c})
+
+		case c.effectiveValue < changeTarget:
+			applicable = append(applicable, *c)
+			totalLower += c.effectiveValue
+
+		case lowestLarger == nil ||
+			c.effectiveValue < lowestLarger.effectiveValue:
+			lowestLarger = c
+		}
+	}
+
+	// Use all of the smaller coins when they match the target exactly, or
+	// the smallest larger coin when they aren't enough.
+	if totalLower == targetValue {
+		return finish(applicable)
+	}
+	if totalLower < targetValue {
+		if lowestLarger == nil {
+			return nil, ErrCoinsNoSelectionAvailable
+		}
+		return finish([]candidate{*lowestLarger})
+	}
+
+	// Search for the subset of the smaller coins that exceeds the target by
+	// the least.  When it doesn't match the target exactly, search again
+	// for a subset that leaves enough change instead.
+	sort.SliceStable(applicable, func(i, j int) bool {
+		return applicable[i].effectiveValue > applicable[j].effectiveValue
+	})
+	best, bestValue := approximateBestSubset(s.Rand, applicable,
+		totalLower, targetValue)
+	if bestValue != targetValue && totalLower >= changeTarget {
+		best, bestValue = approximateBestSubset(s.Rand, applicable,
+			totalLower, changeTarget)
+	}
+
+	// Prefer the smallest larger coin when the subset neither matches the
+	// target nor leaves enough change, or when the coin is no larger than
+	// the subset.
+	if lowestLarger != nil &&
+		((bestValue != targetValue && bestValue < changeTarget) ||
+			lowestLarger.effectiveValue <= bestValue) {
+
+		return finish([]candidate{*lowestLarger})
+	}
+
+	selected := make([]candidate, 0, len(applicable))
+	for i, included := range best {
+		if included {
+			selected = append(selected, applicable[i])
+		}
+	}
+	return finish(selected)
+}
+
+// approximateBestSubset searches random subsets of the passed candidates,
+// which must be sorted by descending effective value, for the one whose total
+// effective value is the smallest that still reaches the target.  It returns
+// which candidates are included in the best subset found along with its total.
+func approximateBestSubset(r *rand.Rand, candidates []candidate,
+	totalValue, targetValue btcutil.Amount) ([]bool, btcutil.Amount) {
+
+	best := make([]bool, len(candidates))
+	for i := range best {
+		best[i] = true
+	}
+	bestValue := totalValue
+
+	included := make([]bool, len(candidates))
+	for rep := 0; rep < knapsackIterations && bestValue != targetValue; rep++ {
+		for i := range included {
+			included[i] = false
+		}
+
+		// The first pass includes coins at random, and the second pass
+		// includes the remaining coins in order until the target is
+		// reached.  Whenever it is reached, the last coin is removed
+		// again to try to get closer to the target with smaller coins.
+		var total btcutil.Amount
+		reachedTarget := false
+		for pass := 0; pass < 2 && !reachedTarget; pass++ {
+			for i := range candidates {
+				var include bool
+				if pass == 0 {
+					include = randBool(r)
+				} else {
+					include = !included[i]
+				}
+				if !include {
+					continue
+				}
+
+				total += candidates[i].effectiveValue
+				included[i] = true
+				if total >= targetValue {
+					reachedTarget = true
+					if total < bestValue {
+						bestValue = total
+						copy(best, included)
+					}
+					total -= candidates[i].effectiveValue
+					included[i] = false
+				}
+			}
+		}
+	}
+
+	return best, bestValue
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2014-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package coinset_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/nbcorg/btcutil"
+	"github.com/nbcorg/btcutil/coinset"
+)
+
+// TestKnapsackCoinSelector checks the knapsack selector against the vectors
+// of the knapsack_solver_test of the reference implementation, which uses a
+// minimum change of 1 cent.  The fee rate and the cost of change are zero, so
+// the effective value of each coin is its value.
+func TestKnapsackCoinSelector(t *testing.T) {
+	selector := coinset.KnapsackCoinSelector{
+		MinChangeAmount: cent,
+		Rand:            rand.New(rand.NewSource(1)),
+	}
+
+	pool := newCoins(0, 6*cent, 7*cent, 8*cent, 20*cent, 30*cent)
+	coin6, coin7, coin8, coin20 := pool[0], pool[1], pool[2], pool[3]
+	coin5 := NewCoin(5, 5*cent, 6)
+	coin18 := NewCoin(6, 18*cent, 6)
+	withCoin5 := append(append([]coinset.Coin{}, pool...), coin5)
+	withCoin18 := append(append([]coinset.Coin{}, withCoin5...), coin18)
+	bigCoins := newCoins(10, 100*cent, 200*cent, 300*cent, 400*cent)
+	withBigCoins := append(append([]coinset.Coin{}, withCoin18...),
+		bigCoins...)
+
+	tests := []coinSelectTestCase{
+		{
+			name:          "empty wallet",
+			targetValue:   1 * cent,
+			expectedError: coinset.ErrCoinsNoSelectionAvailable,
+		},
+		{
+			name:          "can't make 72 cents",
+			inputCoins:    pool,
+			targetValue:   72 * cent,
+			expectedError: coinset.ErrCoinsNoSelectionAvailable,
+		},
+		{
+			name:          "exact match of a single coin",
+			inputCoins:    pool,
+			targetValue:   20 * cent,
+			expectedCoins: []coinset.Coin{coin20},
+		},
+		{
+			name:           "next biggest coin beats 6+7+8",
+			inputCoins:     pool,
+			targetValue:    16 * cent,
+			expectedCoins:  []coinset.Coin{coin20},
+			expectedChange: 4 * cent,
+		},
+		{
+			name:           "5+6+7 beats the next biggest coin",
+			inputCoins:     withCoin5,
+			targetValue:    16 * cent,
+			expectedCoins:  []coinset.Coin{coin5, coin6, coin7},
+			expectedChange: 2 * cent,
+		},
+		{
+			name:           "next biggest coin equals 5+6+7",
+			inputCoins:     withCoin18,
+			targetValue:    16 * cent,
+			expectedCoins:  []coinset.Coin{coin18},
+			expectedChange: 2 * cent,
+		},
+		{
+			name:          "exact match of 5+6",
+			inputCoins:    withCoin18,
+			targetValue:   11 * cent,
+			expectedCoins: []coinset.Coin{coin5, coin6},
+		},
+		{
+			name:           "smallest bigger coin for 95 cents",
+			inputCoins:     withBigCoins,
+			targetValue:    95 * cent,
+			expectedCoins:  []coinset.Coin{bigCoins[0]},
+			expectedChange: 5 * cent,
+		},
+		{
+			name:           "smallest bigger coin for 195 cents",
+			inputCoins:     withBigCoins,
+			targetValue:    195 * cent,
+			expectedCoins:  []coinset.Coin{bigCoins[1]},
+			expectedChange: 5 * cent,
+		},
+		{
+			name:          "exact match of 6+7+8",
+			inputCoins:    pool,
+			targetValue:   21 * cent,
+			expectedCoins: []coinset.Coin{coin6, coin7, coin8},
+		},
+		{
+			name:          "max inputs reached",
+			selector:      coinset.KnapsackCoinSelector{MaxInputs: 2},
+			inputCoins:    pool,
+			targetValue:   21 * cent,
+			expectedError: coinset.ErrCoinsNoSelectionAvailable,
+		},
+	}
+	for i := range tests {
+		if tests[i].selector == nil {
+			tests[i].selector = selector
+		}
+	}
+	testCoinSelector(tests, t)
+}
+
+// TestKnapsackMinChange checks that the knapsack selector finds an exact
+// match among small coins rather than leaving change below the minimum,
+// mirroring the minimum change vectors of the reference implementation.
+func TestKnapsackMinChange(t *testing.T) {
+	selector := coinset.KnapsackCoinSelector{
+		MinChangeAmount: cent,
+		Rand:            rand.New(rand.NewSource(1)),
+	}
+
+	// Try making 1 cent from 0.1 + 0.2 + 0.3 + 0.4 + 0.5 + 1111 cents,
+	// which has several exact matches.
+	smallCoins := newCoins(0, cent/10, 2*cent/10, 3*cent/10, 4*cent/10,
+		5*cent/10)
+	pool := append(smallCoins, NewCoin(5, 1111*cent, 6))
+	for i := 0; i < 100; i++ {
+		selection, err := selector.CoinSelect(cent, 0, 0, pool)
+		if err != nil {
+			t.Fatalf("CoinSelect: unexpected error: %v", err)
+		}
+		if selection.TotalValue() != cent || selection.Change != 0 {
+			t.Fatalf("CoinSelect: selected %v with change %v, "+
+				"expected %v without change",
+				selection.TotalValue(), selection.Change,
+				btcutil.Amount(cent))
+		}
+	}
+
+	// Making 100.01 cents from 0.05, 1 and 100 cents needs all of them,
+	// while 99.9 cents takes the bigger of the two small coins to avoid
+	// change below the minimum.
+	pool = newCoins(10, 5*cent/100, 1*cent, 100*cent)
+	tests := []coinSelectTestCase{
+		{
+			name:           "avoid small change with all coins",
+			selector:       selector,
+			inputCoins:     pool,
+			targetValue:    10001 * cent / 100,
+			expectedCoins:  pool,
+			expectedChange: 104 * cent / 100,
+		},
+		{
+			name:           "avoid small change with bigger coin",
+			selector:       selector,
+			inputCoins:     pool,
+			targetValue:    9990 * cent / 100,
+			expectedCoins:  []coinset.Coin{pool[1], pool[2]},
+			expectedChange: 110 * cent / 100,
+		},
+	}
+	testCoinSelector(tests, t)
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package descriptor
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// checksumLen is the number of characters of a descriptor checksum.
+	checksumLen = 8
+
+	// inputCharset is the set of characters a descriptor can be made of.
+	// The position of a character determines its value in the checksum,
+	// and the characters most commonly used in descriptors come first so
+	// that errors in them are more likely to be detected.
+	inputCharset = "0123456789()[],'/*abcdefgh@:$%{}" +
+		"IJKLMNOPQRSTUVWXYZ&+-.;<=>?!^_|~" +
+		"ijklmnopqrstuvwxyzABCDEFGH`#\"\\ "
+
+	// checksumCharset is the set of characters a checksum is encoded with,
+	// which is the same as the one used by bech32.
+	checksumCharset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+)
+
+// polyMod computes the next value of the checksum polynomial after feeding in
+// the passed 5-bit value.  The generator is the one defined by BIP 380.
+func polyMod(c uint64, val int) uint64 {
+	c0 := c >> 35
+	c = ((c & 0x7ffffffff) << 5) ^ uint64(val)
+	if c0&1 != 0 {
+		c ^= 0xf5dee51989
+	}
+	if c0&2 != 0 {
+		c ^= 0xa9fdca3312
+	}
+	if c0&4 != 0 {
+		c ^= 0x1bab10e32d
+	}
+	if c0&8 != 0 {
+		c ^= 0x3706b1677a
+	}
+	if c0&16 != 0 {
+		c ^= 0x644d626ffd
+	}
+	return c
+}
+
+// Checksum returns the checksum of the passed descriptor, which must not
+// include a checksum itself, as defined by BIP 380.
+func Checksum(desc string) (string, error) {
+	c := uint64(1)
+	class, classCount := 0, 0
+	for i, r := range desc {
+		pos := strings.IndexRune(inputCharset, r)
+		if pos < 0 {
+			str := fmt.Sprintf("invalid character %q at position %d",
+				r, i)
+			return "", ParseError(str)
+		}
+
+		// Every character contributes its low 5 bits on its own, and
+		// the group it belongs to is packed in groups of three.
+		c = polyMod(c, pos&31)
+		class = class*3 + pos>>5
+		classCount++
+		if classCount == 3 {
+			c = polyMod(c, class)
+			class, classCount = 0, 0
+		}
+	}
+	if classCount > 0 {
+		c = polyMod(c, class)
+	}
+	for i := 0; i < checksumLen; i++ {
+		c = polyMod(c, 0)
+	}
+	c ^= 1
+
+	var checksum [checksumLen]byte
+	for i := range checksum {
+		checksum[i] = checksumCharset[(c>>(5*(7-uint(i))))&31]
+	}
+	return string(checksum[:]), nil
+}
+
+// AddChecksum returns the passed descriptor with its checksum appended.
+func AddChecksum(desc string) (string, error) {
+	checksum, err := Checksum(desc)
+	if err != nil {
+		return "", err
+	}
+	return desc + "#" + checksum, nil
+}
+
+// splitChecksum splits the passed descriptor into the descriptor itself and
+// its checksum, and verifies the checksum when there is one.  A checksum is
+// optional unless requireChecksum is true.
+func splitChecksum(desc string, requireChecksum bool) (string, error) {
+	i := strings.LastIndexByte(desc, '#')
+	if i < 0 {
+		if requireChecksum {
+			return "", ParseError("descriptor is missing a checksum")
+		}
+		return desc, nil
+	}
+
+	desc, checksum := desc[:i], desc[i+1:]
+	if len(checksum) != checksumLen {
+		str := fmt.Sprintf("checksum %q does not have %d characters",
+			checksum, checksumLen)
+		return "", ParseError(str)
+	}
+	expected, err := Checksum(desc)
+	if err != nil {
+		return "", err
+	}
+	if checksum != expected {
+		str := fmt.Sprintf("checksum %q does not match the expected "+
+			"checksum %q", checksum, expected)
+		return "", ParseError(str)
+	}
+	return desc, nil
+}
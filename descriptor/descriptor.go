@@ -0,0 +1,449 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package descriptor implements output script descriptors as defined by
+// BIP 380 through BIP 386.
+package descriptor
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nbcorg/btcutil"
+	"github.com/nbcorg/btcutil/chaincfg"
+	"github.com/nbcorg/btcutil/txscript"
+)
+
+// ParseError describes an error due to a descriptor that is malformed or that
+// is not valid in this chain.
+type ParseError string
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e ParseError) Error() string {
+	return string(e)
+}
+
+// DeriveError describes an error due to a key of a descriptor that can not be
+// derived at the requested index.  BIP 32 requires skipping to the next index
+// in that case.
+type DeriveError string
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e DeriveError) Error() string {
+	return string(e)
+}
+
+// EvenKeyError describes an error due to a key derived from an extended public
+// key whose compressed form starts with 0x02.  Only public keys starting with
+// 0x03 are valid in this chain, so a descriptor has no output script at an
+// index where one of its keys derives to such a key.  Unlike the invalid
+// children of BIP 32, which are astronomically unlikely, this happens at about
+// half of the indexes, and it is up to the caller whether to skip them.
+type EvenKeyError string
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e EvenKeyError) Error() string {
+	return string(e)
+}
+
+// ErrNoAddress is returned when deriving the address of a descriptor whose
+// output script has no address form, such as a bare multisig script.
+var ErrNoAddress = errors.New("descriptor output script has no address")
+
+// exprKind identifies the function of a script expression.
+type exprKind int
+
+// These constants define the supported script expressions.
+const (
+	exprPK exprKind = iota
+	exprPKH
+	exprWPKH
+	exprSH
+	exprWSH
+	exprMulti
+	exprSortedMulti
+	exprAddr
+	exprRaw
+)
+
+// exprNames maps the script expressions to their function names.
+var exprNames = map[exprKind]string{
+	exprPK:          "pk",
+	exprPKH:         "pkh",
+	exprWPKH:        "wpkh",
+	exprSH:          "sh",
+	exprWSH:         "wsh",
+	exprMulti:       "multi",
+	exprSortedMulti: "sortedmulti",
+	exprAddr:        "addr",
+	exprRaw:         "raw",
+}
+
+// exprContext describes where a script expression appears, which restricts
+// the expressions it may be.
+type exprContext int
+
+const (
+	ctxTop exprContext = iota
+	ctxSH
+	ctxWSH
+)
+
+const (
+	// maxBareMultiKeys is the maximum number of keys of a multisig script
+	// that is not nested in another script, as defined by BIP 383.
+	maxBareMultiKeys = 3
+
+	// maxSHMultiKeys is the maximum number of keys of a multisig redeem
+	// script, which is limited by the maximum size of a pushed redeem
+	// script.
+	maxSHMultiKeys = 15
+)
+
+// scriptExpr is a parsed script expression.
+type scriptExpr struct {
+	kind      exprKind
+	keys      []*keyExpr
+	threshold int
+	sub       *scriptExpr
+	addr      btcutil.Address
+	raw       []byte
+}
+
+// Descriptor is a parsed output script descriptor, which describes the output
+// scripts of a wallet.  A ranged descriptor describes one output script for
+// every index, while the index is ignored for any other descriptor.
+type Descriptor struct {
+	expr *scriptExpr
+	net  *chaincfg.Params
+}
+
+// splitArgs splits the arguments of a script expression at the commas that
+// are not nested within parentheses.
+func splitArgs(s string) []string {
+	var args []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				args = append(args, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(args, s[start:])
+}
+
+// parseExpr parses a script expression appearing in the passed context.
+func parseExpr(s string, ctx exprContext, net *chaincfg.Params) (*scriptExpr, error) {
+	open := strings.IndexByte(s, '(')
+	if open < 0 || !strings.HasSuffix(s, ")") {
+		str := fmt.Sprintf("invalid script expression %q", s)
+		return nil, ParseError(str)
+	}
+	name, inner := s[:open], s[open+1:len(s)-1]
+
+	var expr scriptExpr
+	found := false
+	for kind, kindName := range exprNames {
+		if kindName == name {
+			expr.kind = kind
+			found = true
+			break
+		}
+	}
+	if !found {
+		str := fmt.Sprintf("unknown script expression %q", name)
+		return nil, ParseError(str)
+	}
+
+	// Determine whether the expression may appear in its context.
+	allowed := true
+	switch expr.kind {
+	case exprSH, exprAddr, exprRaw:
+		allowed = ctx == ctxTop
+	case exprWPKH, exprWSH:
+		allowed = ctx != ctxWSH
+	}
+	if !allowed {
+		str := fmt.Sprintf("%s() is not allowed within %s()", name,
+			map[exprContext]string{ctxSH: "sh", ctxWSH: "wsh"}[ctx])
+		return nil, ParseError(str)
+	}
+
+	switch expr.kind {
+	case exprPK, exprPKH, exprWPKH:
+		key, err := parseKeyExpr(inner, net)
+		if err != nil {
+			return nil, err
+		}
+		expr.keys = []*keyExpr{key}
+
+	case exprSH, exprWSH:
+		subCtx := ctxSH
+		if expr.kind == exprWSH {
+			subCtx = ctxWSH
+		}
+		sub, err := parseExpr(inner, subCtx, net)
+		if err != nil {
+			return nil, err
+		}
+		expr.sub = sub
+
+	case exprMulti, exprSortedMulti:
+		args := splitArgs(inner)
+		threshold, err := strconv.Atoi(args[0])
+		if err != nil || threshold < 1 || threshold > len(args)-1 {
+			str := fmt.Sprintf("invalid threshold %q for %d keys",
+				args[0], len(args)-1)
+			return nil, ParseError(str)
+		}
+		maxKeys := txscript.MaxPubKeysPerMultiSig
+		switch ctx {
+		case ctxTop:
+			maxKeys = maxBareMultiKeys
+		case ctxSH:
+			maxKeys = maxSHMultiKeys
+		}
+		if len(args)-1 > maxKeys {
+			str := fmt.Sprintf("%s() has %d keys, which is more "+
+				"than the maximum of %d", name, len(args)-1,
+				maxKeys)
+			return nil, ParseError(str)
+		}
+		expr.threshold = threshold
+		for _, arg := range args[1:] {
+			key, err := parseKeyExpr(arg, net)
+			if err != nil {
+				return nil, err
+			}
+			expr.keys = append(expr.keys, key)
+		}
+
+	case exprAddr:
+		addr, err := btcutil.DecodeAddress(inner, net)
+		if err != nil {
+			return nil, err
+		}
+		if !addr.IsForNet(net) {
+			str := fmt.Sprintf("address %s is not for %s", inner,
+				net.Name)
+			return nil, ParseError(str)
+		}
+		expr.addr = addr
+
+	case exprRaw:
+		script, err := hex.DecodeString(inner)
+		if err != nil {
+			str := fmt.Sprintf("invalid hex script %q", inner)
+			return nil, ParseError(str)
+		}
+		expr.raw = script
+	}
+
+	return &expr, nil
+}
+
+// Parse parses an output script descriptor for the passed network.  The
+// checksum is optional, but is verified when present.  Extended keys must be
+// for the passed network, and since only public keys are supported, all
+// derivation steps following an extended key must be unhardened.
+func Parse(descriptor string, net *chaincfg.Params) (*Descriptor, error) {
+	desc, err := splitChecksum(descriptor, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure every character is valid, since only the checksum checks
+	// for that otherwise.
+	if _, err := Checksum(desc); err != nil {
+		return nil, err
+	}
+
+	expr, err := parseExpr(desc, ctxTop, net)
+	if err != nil {
+		return nil, err
+	}
+	return &Descriptor{expr: expr, net: net}, nil
+}
+
+// String returns the canonical form of the expression.
+func (e *scriptExpr) String() string {
+	name := exprNames[e.kind]
+	switch e.kind {
+	case exprSH, exprWSH:
+		return name + "(" + e.sub.String() + ")"
+
+	case exprMulti, exprSortedMulti:
+		args := []string{strconv.Itoa(e.threshold)}
+		for _, key := range e.keys {
+			args = append(args, key.String())
+		}
+		return name + "(" + strings.Join(args, ",") + ")"
+
+	case exprAddr:
+		return name + "(" + e.addr.EncodeAddress() + ")"
+
+	case exprRaw:
+		return name + "(" + hex.EncodeToString(e.raw) + ")"
+	}
+	return name + "(" + e.keys[0].String() + ")"
+}
+
+// String returns the canonical form of the descriptor along with its
+// checksum.
+func (d *Descriptor) String() string {
+	desc := d.expr.String()
+	checksum, err := Checksum(desc)
+	if err != nil {
+		// A parsed descriptor only consists of valid characters.
+		return desc
+	}
+	return desc + "#" + checksum
+}
+
+// isRange returns whether any key of the expression is ranged.
+func (e *scriptExpr) isRange() bool {
+	if e.sub != nil {
+		return e.sub.isRange()
+	}
+	for _, key := range e.keys {
+		if key.ranged {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRange returns whether the descriptor is ranged, which means it describes
+// a different output script at every index.
+func (d *Descriptor) IsRange() bool {
+	return d.expr.isRange()
+}
+
+// KeyOrigins returns the origins of the keys of the descriptor, with a nil entry
+// for every key without a known origin.
+func (d *Descriptor) KeyOrigins() []*KeyOrigin {
+	var origins []*KeyOrigin
+	for e := d.expr; e != nil; e = e.sub {
+		for _, key := range e.keys {
+			origins = append(origins, key.origin)
+		}
+	}
+	return origins
+}
+
+// script returns the output script of the expression at the passed index.
+func (e *scriptExpr) script(index uint32, net *chaincfg.Params) ([]byte, error) {
+	addr, err := e.address(index, net)
+	switch {
+	case err == nil:
+		return txscript.PayToAddrScript(addr)
+	case err != ErrNoAddress:
+		return nil, err
+	}
+
+	switch e.kind {
+	case exprPK:
+		pubKey, err := e.keys[0].derive(index)
+		if err != nil {
+			return nil, err
+		}
+		addr, err := btcutil.NewAddressPubKey(pubKey, net)
+		if err != nil {
+			return nil, err
+		}
+		return txscript.PayToAddrScript(addr)
+
+	case exprMulti, exprSortedMulti:
+		pubKeys := make([][]byte, len(e.keys))
+		for i, key := range e.keys {
+			pubKeys[i], err = key.derive(index)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if e.kind == exprSortedMulti {
+			sort.Slice(pubKeys, func(i, j int) bool {
+				return bytes.Compare(pubKeys[i], pubKeys[j]) < 0
+			})
+		}
+		addrs := make([]*btcutil.AddressPubKey, len(pubKeys))
+		for i, pubKey := range pubKeys {
+			addrs[i], err = btcutil.NewAddressPubKey(pubKey, net)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return txscript.MultiSigScript(addrs, e.threshold)
+
+	case exprRaw:
+		return e.raw, nil
+	}
+	return nil, ErrNoAddress
+}
+
+// address returns the address of the output script of the expression at the
+// passed index, or ErrNoAddress when it has none.
+func (e *scriptExpr) address(index uint32, net *chaincfg.Params) (btcutil.Address, error) {
+	switch e.kind {
+	case exprPKH, exprWPKH:
+		pubKey, err := e.keys[0].derive(index)
+		if err != nil {
+			return nil, err
+		}
+		pubKeyHash := btcutil.Hash160(pubKey)
+		if e.kind == exprPKH {
+			return btcutil.NewAddressPubKeyHash(pubKeyHash, net)
+		}
+		return btcutil.NewAddressWitnessPubKeyHash(pubKeyHash, net)
+
+	case exprSH:
+		script, err := e.sub.script(index, net)
+		if err != nil {
+			return nil, err
+		}
+		return btcutil.NewAddressScriptHash(script, net)
+
+	case exprWSH:
+		script, err := e.sub.script(index, net)
+		if err != nil {
+			return nil, err
+		}
+		scriptHash := sha256.Sum256(script)
+		return btcutil.NewAddressWitnessScriptHash(scriptHash[:], net)
+
+	case exprAddr:
+		return e.addr, nil
+	}
+	return nil, ErrNoAddress
+}
+
+// PkScript returns the output script the descriptor describes at the passed
+// index, which is ignored unless the descriptor is ranged.  A DeriveError is
+// returned when a key can not be derived at the index, and an EvenKeyError
+// when a derived key is not valid in this chain.
+func (d *Descriptor) PkScript(index uint32) ([]byte, error) {
+	return d.expr.script(index, d.net)
+}
+
+// Address returns the address of the output script the descriptor describes
+// at the passed index, which is ignored unless the descriptor is ranged.
+// ErrNoAddress is returned for output scripts without an address form, which
+// are the scripts of pk(), multi(), sortedmulti() and raw() descriptors, and
+// other errors are returned as for PkScript.
+func (d *Descriptor) Address(index uint32) (btcutil.Address, error) {
+	return d.expr.address(index, d.net)
+}
@@ -0,0 +1,426 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package descriptor
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"github.com/nbcorg/btcutil"
+	"github.com/nbcorg/btcutil/chaincfg"
+	"github.com/nbcorg/btcutil/txscript"
+)
+
+const (
+	// masterXpub is the master extended public key of BIP 32 test vector
+	// 2.
+	masterXpub = "xpub661MyMwAqRbcFW31YEwpkMuc5THy2PSt5bDMsktWQcFF8syAmRUapSCGu8ED9W6oDMSgv6Zz8idoc4a6mr8BDzTJY47LJhkJ8UB7WEGuduB"
+
+	// hardenedXpub is the extended public key at m/0' of BIP 32 test
+	// vector 1, whose child at /1 is xpub1Key.
+	hardenedXpub = "xpub68Gmy5EdvgibQVfPdqkBBCHxA5htiqg55crXYuXoQRKfDBFA1WEjWgP6LHhwBZeNK1VTsfTFUHCdrfp1bgwQ9xv5ski8PX9rL2dZXvgGDnw"
+	xpub1Key     = "03501e454bf00751f24b1b489aa925215d66af2234e3891c3b21a52bedb3cd711c"
+
+	// key2 and key3 are the keys of masterXpub at m/0/2 and m/0/3.
+	key2 = "03cb465b7d2632350cc13956a808b11ebc4302327c1ba55982a51b779fbe0f1516"
+	key3 = "0341f3dac1554e01da5b32c8da7557c5864a56cec5d40c95489907870a2244aab4"
+)
+
+// masterChildKeys are the keys of masterXpub at m/0/0 through m/0/7.
+var masterChildKeys = []string{
+	"0205c8897fd0ff5644adba4545a84020cd6aa94d90e1e0a56bb4b8eb7522e3ef8c",
+	"02d27a781fd1b3ec5ba5017ca55b9b900fde598459a0204597b37e6c66a0e35c98",
+	key2,
+	key3,
+	"03c03fb2a8b16108a6852af44b3304d5368f9411d31adc2f44b2dc5f2174294b16",
+	"03ecd17b9d0cfe18ae10c82d4883229464d1b9f9d55e44db92218df5aaec69b93b",
+	"03461fc9ebd7f0c8c5284d762b0871e46265ca1a55f8942e0aeb53dd4fb15e7966",
+	"027486e3bf04f24d4e39441cec606522eaea2650c13ef37470f3203acee5111102",
+}
+
+// testNet returns the main network parameters, registering them first since
+// decoding addresses requires it.
+func testNet() *chaincfg.Params {
+	if !chaincfg.IsRegistered(&chaincfg.MainNetParams) {
+		chaincfg.RegisterBitcoinParams()
+	}
+	return &chaincfg.MainNetParams
+}
+
+// hexToBytes converts the passed hex string into bytes and will panic if there
+// is an error.  This is only provided for the hard-coded constants so errors in
+// the source code can be detected.  It will only (and must only) be called with
+// hard-coded values.
+func hexToBytes(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic("invalid hex in source file: " + s)
+	}
+	return b
+}
+
+// mustScript returns the script built by the passed builder and will panic if
+// there is an error.
+func mustScript(builder *txscript.ScriptBuilder) []byte {
+	script, err := builder.Script()
+	if err != nil {
+		panic(err)
+	}
+	return script
+}
+
+// p2pkhScript returns the pay-to-pubkey-hash script of the passed key.
+func p2pkhScript(key string) []byte {
+	return mustScript(txscript.NewScriptBuilder().AddOp(txscript.OP_DUP).
+		AddOp(txscript.OP_HASH160).
+		AddData(btcutil.Hash160(hexToBytes(key))).
+		AddOp(txscript.OP_EQUALVERIFY).AddOp(txscript.OP_CHECKSIG))
+}
+
+// p2wpkhScript returns the pay-to-witness-pubkey-hash script of the passed
+// key.
+func p2wpkhScript(key string) []byte {
+	return mustScript(txscript.NewScriptBuilder().AddOp(txscript.OP_0).
+		AddData(btcutil.Hash160(hexToBytes(key))))
+}
+
+// p2shScript returns the pay-to-script-hash script of the passed script.
+func p2shScript(script []byte) []byte {
+	return mustScript(txscript.NewScriptBuilder().
+		AddOp(txscript.OP_HASH160).AddData(btcutil.Hash160(script)).
+		AddOp(txscript.OP_EQUAL))
+}
+
+// p2wshScript returns the pay-to-witness-script-hash script of the passed
+// script.
+func p2wshScript(script []byte) []byte {
+	hash := sha256.Sum256(script)
+	return mustScript(txscript.NewScriptBuilder().AddOp(txscript.OP_0).
+		AddData(hash[:]))
+}
+
+// multiScript returns the multisig script of the passed keys in their order.
+func multiScript(threshold int64, keys ...string) []byte {
+	builder := txscript.NewScriptBuilder().AddInt64(threshold)
+	for _, key := range keys {
+		builder.AddData(hexToBytes(key))
+	}
+	return mustScript(builder.AddInt64(int64(len(keys))).
+		AddOp(txscript.OP_CHECKMULTISIG))
+}
+
+// TestChecksum ensures descriptor checksums match the test vectors of BIP 380
+// and BIP 381 and of descriptors of every expression type.
+func TestChecksum(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		desc     string
+		checksum string
+	}{
+		{"raw(deadbeef)", "89f8spxm"},
+		{"pk(0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798)", "gn28ywm7"},
+		{"pk(" + key2 + ")", "gdsq4zeq"},
+		{"pkh([deadbeef/44'/0'/0']" + key2 + ")", "prv57sj6"},
+		{"wpkh(" + masterXpub + "/0/*)", "fmv2q5y2"},
+		{"sh(wpkh(" + key3 + "))", "330w5g3v"},
+		{"sh(wsh(multi(1," + key2 + "," + key3 + ")))", "2cg2zxx2"},
+		{"wsh(sortedmulti(2," + key3 + "," + hardenedXpub + "/1))", "d6smlggz"},
+		{"multi(1," + key2 + "," + key3 + ")", "r5ulptwu"},
+		{"pkh(" + hardenedXpub + "/1)", "kd8wch8l"},
+	}
+
+	for _, test := range tests {
+		checksum, err := Checksum(test.desc)
+		if err != nil {
+			t.Errorf("Checksum(%q): unexpected error: %v", test.desc,
+				err)
+			continue
+		}
+		if checksum != test.checksum {
+			t.Errorf("Checksum(%q): got %s, want %s", test.desc,
+				checksum, test.checksum)
+		}
+	}
+
+	if _, err := Checksum("raw(dead\x01beef)"); err == nil {
+		t.Errorf("Checksum: accepted an invalid character")
+	} else if _, ok := err.(ParseError); !ok {
+		t.Errorf("Checksum: got error %T, want ParseError", err)
+	}
+}
+
+// TestParse ensures descriptors of every expression type parse to their
+// canonical form and describe the expected output scripts.
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	net := testNet()
+	addr, err := btcutil.NewAddressWitnessPubKeyHash(
+		btcutil.Hash160(hexToBytes(key2)), net)
+	if err != nil {
+		t.Fatalf("NewAddressWitnessPubKeyHash: unexpected error: %v", err)
+	}
+	addrChecksum, err := Checksum("addr(" + addr.EncodeAddress() + ")")
+	if err != nil {
+		t.Fatalf("Checksum: unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		in        string
+		canonical string
+		pkScript  []byte
+		hasAddr   bool
+		origins   []*KeyOrigin
+	}{
+		{
+			name:      "pk",
+			in:        "pk(" + key2 + ")#gdsq4zeq",
+			canonical: "pk(" + key2 + ")#gdsq4zeq",
+			pkScript: mustScript(txscript.NewScriptBuilder().
+				AddData(hexToBytes(key2)).
+				AddOp(txscript.OP_CHECKSIG)),
+			origins: []*KeyOrigin{nil},
+		},
+		{
+			name:      "pkh with origin",
+			in:        "pkh([deadbeef/44h/0'/0h]" + key2 + ")",
+			canonical: "pkh([deadbeef/44'/0'/0']" + key2 + ")#prv57sj6",
+			pkScript:  p2pkhScript(key2),
+			hasAddr:   true,
+			origins: []*KeyOrigin{{
+				Fingerprint: 0xdeadbeef,
+				Path:        []uint32{0x8000002c, 0x80000000, 0x80000000},
+			}},
+		},
+		{
+			name:      "pkh of extended key",
+			in:        "pkh(" + hardenedXpub + "/1)",
+			canonical: "pkh(" + hardenedXpub + "/1)#kd8wch8l",
+			pkScript:  p2pkhScript(xpub1Key),
+			hasAddr:   true,
+			origins:   []*KeyOrigin{nil},
+		},
+		{
+			name:      "sh(wpkh)",
+			in:        "sh(wpkh(" + key3 + "))#330w5g3v",
+			canonical: "sh(wpkh(" + key3 + "))#330w5g3v",
+			pkScript:  p2shScript(p2wpkhScript(key3)),
+			hasAddr:   true,
+			origins:   []*KeyOrigin{nil},
+		},
+		{
+			name:      "sh(wsh(multi))",
+			in:        "sh(wsh(multi(1," + key2 + "," + key3 + ")))",
+			canonical: "sh(wsh(multi(1," + key2 + "," + key3 + ")))#2cg2zxx2",
+			pkScript: p2shScript(p2wshScript(multiScript(1, key2,
+				key3))),
+			hasAddr: true,
+			origins: []*KeyOrigin{nil, nil},
+		},
+		{
+			name:      "wsh(sortedmulti)",
+			in:        "wsh(sortedmulti(2," + key3 + "," + hardenedXpub + "/1))",
+			canonical: "wsh(sortedmulti(2," + key3 + "," + hardenedXpub + "/1))#d6smlggz",
+			pkScript:  p2wshScript(multiScript(2, key3, xpub1Key)),
+			hasAddr:   true,
+			origins:   []*KeyOrigin{nil, nil},
+		},
+		{
+			name:      "bare multi",
+			in:        "multi(1," + key2 + "," + key3 + ")",
+			canonical: "multi(1," + key2 + "," + key3 + ")#r5ulptwu",
+			pkScript:  multiScript(1, key2, key3),
+			origins:   []*KeyOrigin{nil, nil},
+		},
+		{
+			name:      "addr",
+			in:        "addr(" + addr.EncodeAddress() + ")",
+			canonical: "addr(" + addr.EncodeAddress() + ")#" + addrChecksum,
+			pkScript:  p2wpkhScript(key2),
+			hasAddr:   true,
+		},
+		{
+			name:      "raw",
+			in:        "raw(deadbeef)#89f8spxm",
+			canonical: "raw(deadbeef)#89f8spxm",
+			pkScript:  hexToBytes("deadbeef"),
+		},
+	}
+
+	for _, test := range tests {
+		desc, err := Parse(test.in, net)
+		if err != nil {
+			t.Errorf("%s: Parse: unexpected error: %v", test.name, err)
+			continue
+		}
+		if got := desc.String(); got != test.canonical {
+			t.Errorf("%s: String: got %s, want %s", test.name, got,
+				test.canonical)
+		}
+		if desc.IsRange() {
+			t.Errorf("%s: IsRange: got true, want false", test.name)
+		}
+		if got := desc.KeyOrigins(); !reflect.DeepEqual(got, test.origins) {
+			t.Errorf("%s: KeyOrigins: got %v, want %v", test.name,
+				got, test.origins)
+		}
+
+		// The index is ignored for descriptors which are not ranged.
+		for _, index := range []uint32{0, 7} {
+			pkScript, err := desc.PkScript(index)
+			if err != nil {
+				t.Errorf("%s: PkScript(%d): unexpected error: %v",
+					test.name, index, err)
+				continue
+			}
+			if !bytes.Equal(pkScript, test.pkScript) {
+				t.Errorf("%s: PkScript(%d): got %x, want %x",
+					test.name, index, pkScript, test.pkScript)
+			}
+		}
+
+		addr, err := desc.Address(0)
+		if !test.hasAddr {
+			if err != ErrNoAddress {
+				t.Errorf("%s: Address: got %v, %v, want %v",
+					test.name, addr, err, ErrNoAddress)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Address: unexpected error: %v", test.name,
+				err)
+			continue
+		}
+		pkScript, err := txscript.PayToAddrScript(addr)
+		if err != nil || !bytes.Equal(pkScript, test.pkScript) {
+			t.Errorf("%s: Address: got %v paying to %x, want %x",
+				test.name, addr, pkScript, test.pkScript)
+		}
+	}
+}
+
+// TestParseInvalid ensures malformed descriptors and descriptors which are not
+// valid in this chain are rejected with a ParseError.
+func TestParseInvalid(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{"wrong checksum", "raw(deadbeef)#89f8spxx"},
+		{"short checksum", "raw(deadbeef)#89f8spx"},
+		{"unknown expression", "foo(" + key2 + ")"},
+		{"wpkh in wsh", "wsh(wpkh(" + key2 + "))"},
+		{"sh in sh", "sh(sh(pk(" + key2 + ")))"},
+		{"raw in wsh", "wsh(raw(00))"},
+		{"addr in sh", "sh(addr(" + key2 + "))"},
+		{"even key", "pk(02" + key2[2:] + ")"},
+		{"uncompressed key", "pk(04" + key2[2:] + key2[2:] + ")"},
+		{"hardened step", "pk(" + hardenedXpub + "/1')"},
+		{"hardened range", "wpkh(" + masterXpub + "/0/*')"},
+		{"threshold too high", "multi(3," + key2 + "," + key3 + ")"},
+		{"zero threshold", "multi(0," + key2 + ")"},
+		{"too many bare keys", "multi(1," + key2 + "," + key3 + "," +
+			key2 + "," + key3 + ")"},
+		{"bad fingerprint", "pk([dead]" + key2 + ")"},
+		{"unclosed origin", "pk([deadbeef" + key2 + ")"},
+		{"bad raw hex", "raw(xyz)"},
+		{"bare extended key", masterXpub},
+	}
+
+	net := testNet()
+	for _, test := range tests {
+		desc, err := Parse(test.in, net)
+		if err == nil {
+			t.Errorf("%s: Parse: got %v, want error", test.name, desc)
+			continue
+		}
+		if _, ok := err.(ParseError); !ok {
+			t.Errorf("%s: Parse: got error %T (%v), want ParseError",
+				test.name, err, err)
+		}
+	}
+}
+
+// TestRangedDerivation ensures ranged descriptors derive the keys of BIP 32 at
+// every index, and fail with an EvenKeyError where the derived key is not
+// valid in this chain.
+func TestRangedDerivation(t *testing.T) {
+	t.Parallel()
+
+	net := testNet()
+	desc, err := Parse("wpkh("+masterXpub+"/0/*)#fmv2q5y2", net)
+	if err != nil {
+		t.Fatalf("Parse: unexpected error: %v", err)
+	}
+	if !desc.IsRange() {
+		t.Fatalf("IsRange: got false, want true")
+	}
+
+	for i, key := range masterChildKeys {
+		index := uint32(i)
+		pkScript, err := desc.PkScript(index)
+		addr, addrErr := desc.Address(index)
+		if key[:2] == "02" {
+			if _, ok := err.(EvenKeyError); !ok {
+				t.Errorf("PkScript(%d): got %x, %v, want "+
+					"EvenKeyError", index, pkScript, err)
+			}
+			if _, ok := addrErr.(EvenKeyError); !ok {
+				t.Errorf("Address(%d): got %v, %v, want "+
+					"EvenKeyError", index, addr, addrErr)
+			}
+			continue
+		}
+		if err != nil || addrErr != nil {
+			t.Errorf("index %d: unexpected errors: %v, %v", index,
+				err, addrErr)
+			continue
+		}
+		want := p2wpkhScript(key)
+		if !bytes.Equal(pkScript, want) {
+			t.Errorf("PkScript(%d): got %x, want %x", index,
+				pkScript, want)
+		}
+		if got, _ := txscript.PayToAddrScript(addr); !bytes.Equal(got, want) {
+			t.Errorf("Address(%d): got %v, want address of %x",
+				index, addr, want)
+		}
+	}
+
+	// Keys derived at a fixed path are checked just the same.
+	desc, err = Parse("pk("+masterXpub+"/0)", net)
+	if err != nil {
+		t.Fatalf("Parse: unexpected error: %v", err)
+	}
+	if _, err := desc.PkScript(0); err == nil {
+		t.Errorf("PkScript: accepted the even key of m/0")
+	} else if _, ok := err.(EvenKeyError); !ok {
+		t.Errorf("PkScript: got error %T (%v), want EvenKeyError", err,
+			err)
+	}
+
+	// A multisig script has an output script only at the indexes where
+	// all of its keys are valid.
+	desc, err = Parse("sh(multi(1,"+key2+","+masterXpub+"/0/*))", net)
+	if err != nil {
+		t.Fatalf("Parse: unexpected error: %v", err)
+	}
+	if _, err := desc.PkScript(1); err == nil {
+		t.Errorf("PkScript(1): accepted the even key of m/0/1")
+	}
+	pkScript, err := desc.PkScript(4)
+	want := p2shScript(multiScript(1, key2, masterChildKeys[4]))
+	if err != nil || !bytes.Equal(pkScript, want) {
+		t.Errorf("PkScript(4): got %x, %v, want %x", pkScript, err, want)
+	}
+}
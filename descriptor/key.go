@@ -0,0 +1,286 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package descriptor
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/nbcorg/btcd/btcec"
+	"github.com/nbcorg/btcutil"
+	"github.com/nbcorg/btcutil/base58"
+	"github.com/nbcorg/btcutil/chaincfg"
+)
+
+const (
+	// pubKeyLen is the length of a serialized public key.
+	pubKeyLen = 33
+
+	// extendedKeyLen is the length of a serialized extended key without
+	// its version, which is the depth (1 byte), the fingerprint of the
+	// parent key (4 bytes), the child number (4 bytes), the chain code (32
+	// bytes) and the key itself.
+	extendedKeyLen = 1 + 4 + 4 + 32 + pubKeyLen
+
+	// hardenedKeyStart is the index at which hardened child keys start.
+	hardenedKeyStart = 0x80000000
+)
+
+// KeyOrigin describes where a key comes from, which is the fingerprint of the
+// master key it is derived from and the derivation path from that master key.
+type KeyOrigin struct {
+	Fingerprint uint32
+	Path        []uint32
+}
+
+// keyExpr is a parsed key expression, which is either a public key or an
+// extended public key along with a derivation path from it.
+type keyExpr struct {
+	origin *KeyOrigin
+
+	// pubKey is the public key for a key expression without an extended
+	// key.
+	pubKey []byte
+
+	// The fields below are set for extended keys.  The path is applied to
+	// the extended key, followed by the index of the derived descriptor
+	// when the key is ranged.
+	xpub      string
+	chainCode []byte
+	path      []uint32
+	ranged    bool
+}
+
+// formatPath returns the textual form of the passed derivation path, where
+// each step is prefixed by a slash and hardened steps end in an apostrophe.
+func formatPath(path []uint32) string {
+	var b strings.Builder
+	for _, step := range path {
+		b.WriteByte('/')
+		if step >= hardenedKeyStart {
+			b.WriteString(strconv.FormatUint(uint64(step-hardenedKeyStart), 10))
+			b.WriteByte('\'')
+			continue
+		}
+		b.WriteString(strconv.FormatUint(uint64(step), 10))
+	}
+	return b.String()
+}
+
+// parsePath parses the steps of a derivation path, none of which may be empty.
+// Both an apostrophe and an h mark a hardened step.
+func parsePath(steps []string) ([]uint32, error) {
+	path := make([]uint32, 0, len(steps))
+	for _, step := range steps {
+		hardened := false
+		if strings.HasSuffix(step, "'") || strings.HasSuffix(step, "h") {
+			hardened = true
+			step = step[:len(step)-1]
+		}
+		index, err := strconv.ParseUint(step, 10, 32)
+		if err != nil || index >= hardenedKeyStart {
+			str := fmt.Sprintf("invalid derivation path step %q", step)
+			return nil, ParseError(str)
+		}
+		if hardened {
+			index += hardenedKeyStart
+		}
+		path = append(path, uint32(index))
+	}
+	return path, nil
+}
+
+// String returns the key expression in its canonical textual form.
+func (k *keyExpr) String() string {
+	var b strings.Builder
+	if k.origin != nil {
+		fmt.Fprintf(&b, "[%08x%s]", k.origin.Fingerprint,
+			formatPath(k.origin.Path))
+	}
+	if k.xpub == "" {
+		b.WriteString(hex.EncodeToString(k.pubKey))
+		return b.String()
+	}
+	b.WriteString(k.xpub)
+	b.WriteString(formatPath(k.path))
+	if k.ranged {
+		b.WriteString("/*")
+	}
+	return b.String()
+}
+
+// validatePubKey returns a ParseError unless the passed public key is valid in
+// this chain.  See btcutil.IsValidPubKey.
+func validatePubKey(pubKey []byte) error {
+	if !btcutil.IsValidPubKey(pubKey) {
+		str := fmt.Sprintf("invalid public key %x", pubKey)
+		return ParseError(str)
+	}
+	return nil
+}
+
+// parseKeyExpr parses a key expression, which is an optional key origin in
+// brackets followed by either a hex-encoded public key or an extended public
+// key for the passed network with an optional derivation path.
+func parseKeyExpr(s string, net *chaincfg.Params) (*keyExpr, error) {
+	k := &keyExpr{}
+	if strings.HasPrefix(s, "[") {
+		end := strings.IndexByte(s, ']')
+		if end < 0 {
+			str := fmt.Sprintf("key origin of %q is missing a "+
+				"closing bracket", s)
+			return nil, ParseError(str)
+		}
+		steps := strings.Split(s[1:end], "/")
+		fingerprint, err := hex.DecodeString(steps[0])
+		if err != nil || len(fingerprint) != 4 {
+			str := fmt.Sprintf("invalid key origin fingerprint %q",
+				steps[0])
+			return nil, ParseError(str)
+		}
+		path, err := parsePath(steps[1:])
+		if err != nil {
+			return nil, err
+		}
+		k.origin = &KeyOrigin{
+			Fingerprint: binary.BigEndian.Uint32(fingerprint),
+			Path:        path,
+		}
+		s = s[end+1:]
+	}
+
+	// A plain public key is hex-encoded, which no extended key is.
+	if pubKey, err := hex.DecodeString(s); err == nil {
+		if err := validatePubKey(pubKey); err != nil {
+			return nil, err
+		}
+		k.pubKey = pubKey
+		return k, nil
+	}
+
+	steps := strings.Split(s, "/")
+	payload, version, err := base58.CheckDecode(steps[0], 4,
+		net.Base58CksumHasher)
+	if err != nil || len(payload) != extendedKeyLen {
+		str := fmt.Sprintf("invalid key %q", steps[0])
+		return nil, ParseError(str)
+	}
+	switch {
+	case bytes.Equal(version, net.HDPublicKeyID[:]):
+	case bytes.Equal(version, net.HDPrivateKeyID[:]):
+		return nil, ParseError("extended private keys are not supported")
+	default:
+		str := fmt.Sprintf("extended key %q is not for %s", steps[0],
+			net.Name)
+		return nil, ParseError(str)
+	}
+	k.xpub = steps[0]
+	k.chainCode = payload[9:41]
+	k.pubKey = payload[41:]
+	if _, err := btcec.ParsePubKey(k.pubKey, btcec.S256()); err != nil {
+		str := fmt.Sprintf("extended key %q has an invalid public key",
+			steps[0])
+		return nil, ParseError(str)
+	}
+
+	steps = steps[1:]
+	if n := len(steps); n > 0 {
+		switch steps[n-1] {
+		case "*":
+			k.ranged = true
+			steps = steps[:n-1]
+		case "*'", "*h":
+			return nil, ParseError("hardened derivation requires " +
+				"an extended private key")
+		}
+	}
+	k.path, err = parsePath(steps)
+	if err != nil {
+		return nil, err
+	}
+	for _, step := range k.path {
+		if step >= hardenedKeyStart {
+			return nil, ParseError("hardened derivation requires " +
+				"an extended private key")
+		}
+	}
+	return k, nil
+}
+
+// deriveChild returns the public key and chain code of the non-hardened child
+// at the passed index of the extended public key with the passed public key
+// and chain code, as defined by BIP 32.
+func deriveChild(pubKey, chainCode []byte, index uint32) ([]byte, []byte, error) {
+	curve := btcec.S256()
+	parent, err := btcec.ParsePubKey(pubKey, curve)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var indexBytes [4]byte
+	binary.BigEndian.PutUint32(indexBytes[:], index)
+	mac := hmac.New(sha512.New, chainCode)
+	mac.Write(pubKey)
+	mac.Write(indexBytes[:])
+	ilr := mac.Sum(nil)
+
+	// The child is invalid when the left half is not a valid private key
+	// or when the resulting point is at infinity, which is astronomically
+	// unlikely.
+	il := new(big.Int).SetBytes(ilr[:32])
+	if il.Sign() == 0 || il.Cmp(curve.N) >= 0 {
+		return nil, nil, DeriveError(fmt.Sprintf("child %d is invalid",
+			index))
+	}
+	ilx, ily := curve.ScalarBaseMult(ilr[:32])
+	x, y := curve.Add(ilx, ily, parent.X, parent.Y)
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return nil, nil, DeriveError(fmt.Sprintf("child %d is invalid",
+			index))
+	}
+
+	child := btcec.PublicKey{Curve: curve, X: x, Y: y}
+	return child.SerializeCompressed(), ilr[32:], nil
+}
+
+// derive returns the public key the key expression refers to at the passed
+// index, which is ignored unless the key is ranged.  A DeriveError is returned
+// when the derivation is invalid according to BIP 32, and an EvenKeyError when
+// the derived key starts with 0x02 and is therefore not valid in this chain.
+func (k *keyExpr) derive(index uint32) ([]byte, error) {
+	if k.xpub == "" {
+		return k.pubKey, nil
+	}
+
+	path := k.path
+	if k.ranged {
+		path = append(path[:len(path):len(path)], index)
+	}
+	pubKey, chainCode := k.pubKey, k.chainCode
+	for _, step := range path {
+		var err error
+		pubKey, chainCode, err = deriveChild(pubKey, chainCode, step)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The derivation itself is unchanged from BIP 32, so about half of
+	// the derived keys start with 0x02, which are not valid in this chain.
+	if !btcutil.IsValidPubKey(pubKey) {
+		str := fmt.Sprintf("derived public key %x of %s%s starts "+
+			"with 0x02, which is not valid in this chain", pubKey,
+			k.xpub, formatPath(path))
+		return nil, EvenKeyError(str)
+	}
+	return pubKey, nil
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcutil
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// FeeError describes an error due to a transaction whose fee can not be
+// calculated from the supplied input amounts.
+type FeeError string
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e FeeError) Error() string {
+	return string(e)
+}
+
+// FeeRate represents a transaction fee rate in satoshi per 1000 virtual bytes
+// (sat/kvB).  Virtual bytes are defined by BIP 141, see Tx.VSize.  Since a
+// kilo-virtual-byte is the finest unit commonly used to express fee rates, all
+// of the conversions below are exact integer operations unless stated
+// otherwise.
+//
+// A fee rate in BTC/kB, as reported by the RPC interface of the reference
+// implementation, is numerically the same as a fee rate in sat/kvB once
+// converted to an Amount, so FeeRate(amount) converts one to the other.
+type FeeRate int64
+
+// NewFeeRateFromSatPerVByte returns the fee rate for the passed number of
+// satoshi per virtual byte.
+func NewFeeRateFromSatPerVByte(satPerVByte int64) FeeRate {
+	return FeeRate(satPerVByte * 1000)
+}
+
+// NewFeeRateFromSatPerKVByte returns the fee rate for the passed amount per
+// 1000 virtual bytes.
+func NewFeeRateFromSatPerKVByte(satPerKVByte Amount) FeeRate {
+	return FeeRate(satPerKVByte)
+}
+
+// NewFeeRateFromSatPerKWeight returns the fee rate for the passed amount per
+// 1000 weight units.  One virtual byte is WitnessScaleFactor weight units.
+func NewFeeRateFromSatPerKWeight(satPerKWeight Amount) FeeRate {
+	return FeeRate(satPerKWeight * WitnessScaleFactor)
+}
+
+// NewFeeRateFromBTCPerKB returns the fee rate for the passed floating point
+// amount of bitcoin per kilobyte, rounded to the nearest satoshi per
+// kilo-virtual-byte.  This is intended for converting fee estimates received
+// over RPC, and errors when f is not a valid amount.  See NewAmount.
+func NewFeeRateFromBTCPerKB(f float64) (FeeRate, error) {
+	amt, err := NewAmount(f)
+	if err != nil {
+		return 0, err
+	}
+	return FeeRate(amt), nil
+}
+
+// NewFeeRate returns the effective fee rate of paying the passed fee for the
+// passed virtual size.  The result is rounded down to a whole satoshi per
+// kilo-virtual-byte so that paying the returned rate for the same size never
+// yields a higher fee than the one passed.  A zero rate is returned for
+// negative fees and for sizes that are not positive.
+func NewFeeRate(fee Amount, vsize int64) FeeRate {
+	if vsize <= 0 {
+		return 0
+	}
+	return FeeRate(mulDiv(int64(fee), 1000, vsize, false))
+}
+
+// SatPerKVByte returns the fee rate as an amount per 1000 virtual bytes.
+func (r FeeRate) SatPerKVByte() Amount {
+	return Amount(r)
+}
+
+// SatPerVByte returns the fee rate in satoshi per virtual byte.  The result is
+// a floating point value since rates are not restricted to whole satoshi per
+// virtual byte, so it is intended for display only.
+func (r FeeRate) SatPerVByte() float64 {
+	return float64(r) / 1000
+}
+
+// SatPerKWeight returns the fee rate as an amount per 1000 weight units,
+// rounded down to a whole satoshi.
+func (r FeeRate) SatPerKWeight() Amount {
+	return Amount(r / WitnessScaleFactor)
+}
+
+// BTCPerKB returns the fee rate in bitcoin per kilobyte as expected by the
+// RPC interface of the reference implementation.
+func (r FeeRate) BTCPerKB() float64 {
+	return Amount(r).ToBTC()
+}
+
+// FeeForVSize returns the fee required to pay the fee rate for a transaction
+// of the passed virtual size.  The fee is rounded up to a whole satoshi so the
+// resulting effective fee rate is never below r.
+func (r FeeRate) FeeForVSize(vsize int64) Amount {
+	return Amount(mulDiv(int64(r), vsize, 1000, true))
+}
+
+// FeeForWeight returns the fee required to pay the fee rate for a transaction
+// of the passed weight.  Unlike calling FeeForVSize with the virtual size, the
+// weight is not rounded up to whole virtual bytes first.  The fee is rounded up
+// to a whole satoshi.
+func (r FeeRate) FeeForWeight(weight int64) Amount {
+	return Amount(mulDiv(int64(r), weight, 1000*WitnessScaleFactor, true))
+}
+
+// FeeForTx returns the fee required to pay the fee rate for the passed
+// transaction based on its virtual size.
+func (r FeeRate) FeeForTx(tx *Tx) Amount {
+	return r.FeeForVSize(tx.VSize())
+}
+
+// String returns the fee rate formatted in satoshi per virtual byte, such as
+// "1.5 sat/vB".  The formatting is exact and does not go through floating
+// point.
+func (r FeeRate) String() string {
+	sign := ""
+	abs := uint64(r)
+	if r < 0 {
+		sign = "-"
+		abs = -abs
+	}
+
+	str := sign + strconv.FormatUint(abs/1000, 10)
+	if frac := abs % 1000; frac != 0 {
+		str += "." + strings.TrimRight(fmt.Sprintf("%03d", frac), "0")
+	}
+	return str + " sat/vB"
+}
+
+// mulDiv returns a*b/c for non-negative a and b and a positive c without
+// overflowing the intermediate product.  The quotient is rounded up when
+// roundUp is true and down otherwise, and saturates at math.MaxInt64.
+// Negative operands yield zero.
+func mulDiv(a, b, c int64, roundUp bool) int64 {
+	if a <= 0 || b <= 0 || c <= 0 {
+		return 0
+	}
+
+	hi, lo := bits.Mul64(uint64(a), uint64(b))
+	if hi >= uint64(c) {
+		return math.MaxInt64
+	}
+	quo, rem := bits.Div64(hi, lo, uint64(c))
+	if roundUp && rem != 0 {
+		quo++
+	}
+	if quo > math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return int64(quo)
+}
+
+// CalcTxFee returns the fee paid by the passed transaction along with its
+// effective fee rate, given the amounts of the outputs spent by each of its
+// inputs in input order.  The fee rate is based on the virtual size of the
+// transaction and is rounded down.  See NewFeeRate.
+//
+// An error of type FeeError is returned when the number of input amounts does
+// not match the number of inputs, when any input or output amount is outside
+// the valid range, or when the outputs spend more than the inputs provide.
+func CalcTxFee(tx *Tx, inputAmounts []Amount) (Amount, FeeRate, error) {
+	msgTx := tx.MsgTx()
+	if len(inputAmounts) != len(msgTx.TxIn) {
+		str := fmt.Sprintf("transaction has %d inputs but %d input "+
+			"amounts were provided", len(msgTx.TxIn),
+			len(inputAmounts))
+		return 0, 0, FeeError(str)
+	}
+
+	// Both totals are kept within range while summing them, so they can't
+	// overflow.
+	var totalIn Amount
+	for i, amt := range inputAmounts {
+		if amt < 0 || amt > MaxSatoshi {
+			str := fmt.Sprintf("input %d amount of %v is outside "+
+				"of the valid range", i, amt)
+			return 0, 0, FeeError(str)
+		}
+		totalIn += amt
+		if totalIn > MaxSatoshi {
+			str := fmt.Sprintf("total input amount of %v exceeds "+
+				"the max of %v", totalIn, Amount(MaxSatoshi))
+			return 0, 0, FeeError(str)
+		}
+	}
+
+	var totalOut Amount
+	for i, txOut := range msgTx.TxOut {
+		amt := Amount(txOut.Value)
+		if amt < 0 || amt > MaxSatoshi {
+			str := fmt.Sprintf("output %d amount of %v is outside "+
+				"of the valid range", i, amt)
+			return 0, 0, FeeError(str)
+		}
+		totalOut += amt
+		if totalOut > MaxSatoshi {
+			str := fmt.Sprintf("total output amount of %v exceeds "+
+				"the max of %v", totalOut, Amount(MaxSatoshi))
+			return 0, 0, FeeError(str)
+		}
+	}
+
+	if totalOut > totalIn {
+		str := fmt.Sprintf("total output amount of %v exceeds the "+
+			"total input amount of %v", totalOut, totalIn)
+		return 0, 0, FeeError(str)
+	}
+
+	fee := totalIn - totalOut
+	return fee, NewFeeRate(fee, tx.VSize()), nil
+}
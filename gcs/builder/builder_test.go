@@ -0,0 +1,55 @@
+// Copyright (c) 2017 The btcsuite developers
+// Copyright (c) 2017 The Lightning Network Developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package builder_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/nbcorg/btcutil/gcs"
+	"github.com/nbcorg/btcutil/gcs/builder"
+)
+
+// TestBuilderSetM ensures an out of range modulus value is reported as such.
+func TestBuilderSetM(t *testing.T) {
+	var key [gcs.KeySize]byte
+	b := builder.WithKeyPM(key, builder.DefaultP, uint64(math.MaxUint32)+1)
+	if _, err := b.Build(); err != gcs.ErrMTooBig {
+		t.Fatalf("Build: got error %v, want %v", err, gcs.ErrMTooBig)
+	}
+
+	b = builder.WithKeyPM(key, builder.DefaultP, math.MaxUint32)
+	if _, err := b.AddEntry([]byte("entry")).Build(); err != nil {
+		t.Fatalf("Build: unexpected error: %v", err)
+	}
+}
+
+// TestBuilderZeroValue ensures entries may be added to a zero value builder.
+func TestBuilderZeroValue(t *testing.T) {
+	entries := [][]byte{[]byte("one"), []byte("two"), []byte("one")}
+
+	var b builder.GCSBuilder
+	filter, err := b.SetP(builder.DefaultP).SetM(builder.DefaultM).
+		AddEntries(entries).Build()
+	if err != nil {
+		t.Fatalf("Build: unexpected error: %v", err)
+	}
+	if filter.N() != 2 {
+		t.Errorf("N: got %d, want 2", filter.N())
+	}
+
+	key, err := b.Key()
+	if err != nil {
+		t.Fatalf("Key: unexpected error: %v", err)
+	}
+	for _, entry := range entries {
+		match, err := filter.Match(key, entry)
+		if err != nil || !match {
+			t.Errorf("Match(%s): got %v, %v, want true", entry, match,
+				err)
+		}
+	}
+}
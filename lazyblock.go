@@ -0,0 +1,554 @@
+// Copyright (c) 2013-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcutil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/nbcorg/btcd/chaincfg/chainhash"
+	"github.com/nbcorg/btcd/wire"
+)
+
+const (
+	// maxWitnessItemsPerInput is the maximum number of witness items a
+	// single transaction input may carry.  It mirrors the limit enforced
+	// when decoding a wire.MsgTx so a lazily parsed block never accepts a
+	// transaction that can't be decoded later on.
+	maxWitnessItemsPerInput = 500000
+
+	// maxWitnessItemSize is the maximum allowed size for an item within an
+	// input's witness data.  It mirrors the limit enforced when decoding a
+	// wire.MsgTx.
+	maxWitnessItemSize = 11000
+)
+
+// BlockParseError describes an error due to serialized block bytes which are
+// truncated or do not follow the wire encoding of a block.
+type BlockParseError string
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e BlockParseError) Error() string {
+	return string(e)
+}
+
+// txScanner walks the transactions of a serialized block without decoding
+// them.  It only validates the encoding and records where each transaction
+// and its witness data starts, which is all that is needed to slice the
+// transaction out of the block and to hash it.
+type txScanner struct {
+	buf []byte
+	pos int
+}
+
+// skip advances the scanner past the next n bytes.
+func (s *txScanner) skip(n uint64) error {
+	if n > uint64(len(s.buf)-s.pos) {
+		str := fmt.Sprintf("unexpected end of block at offset %d "+
+			"reading %d bytes", s.pos, n)
+		return BlockParseError(str)
+	}
+	s.pos += int(n)
+	return nil
+}
+
+// readByte returns the next byte and advances the scanner past it.
+func (s *txScanner) readByte() (byte, error) {
+	if err := s.skip(1); err != nil {
+		return 0, err
+	}
+	return s.buf[s.pos-1], nil
+}
+
+// readVarInt decodes the variable length integer at the current position and
+// advances the scanner past it.  Just like wire.ReadVarInt, integers which are
+// not canonically encoded are rejected.
+func (s *txScanner) readVarInt() (uint64, error) {
+	start := s.pos
+	discriminant, err := s.readByte()
+	if err != nil {
+		return 0, err
+	}
+
+	var rv, min uint64
+	switch discriminant {
+	case 0xff:
+		if err := s.skip(8); err != nil {
+			return 0, err
+		}
+		rv = binary.LittleEndian.Uint64(s.buf[s.pos-8:])
+		min = 0x100000000
+
+	case 0xfe:
+		if err := s.skip(4); err != nil {
+			return 0, err
+		}
+		rv = uint64(binary.LittleEndian.Uint32(s.buf[s.pos-4:]))
+		min = 0x10000
+
+	case 0xfd:
+		if err := s.skip(2); err != nil {
+			return 0, err
+		}
+		rv = uint64(binary.LittleEndian.Uint16(s.buf[s.pos-2:]))
+		min = 0xfd
+
+	default:
+		return uint64(discriminant), nil
+	}
+
+	if rv < min {
+		str := fmt.Sprintf("non-canonical varint at offset %d - "+
+			"discriminant %x must encode a value greater than %x",
+			start, discriminant, min)
+		return 0, BlockParseError(str)
+	}
+	return rv, nil
+}
+
+// skipVarBytes advances the scanner past a length prefixed byte string.  An
+// error is returned when the length exceeds maxAllowed.
+func (s *txScanner) skipVarBytes(maxAllowed uint64, fieldName string) error {
+	count, err := s.readVarInt()
+	if err != nil {
+		return err
+	}
+	if count > maxAllowed {
+		str := fmt.Sprintf("%s is larger than the max allowed size "+
+			"[count %d, max %d]", fieldName, count, maxAllowed)
+		return BlockParseError(str)
+	}
+	return s.skip(count)
+}
+
+// scanTx advances the scanner past the transaction at the current position.
+// It returns the location of the transaction within the block along with the
+// offset of its witness data relative to the start of the transaction, or zero
+// when the transaction does not use the witness encoding.
+func (s *txScanner) scanTx() (wire.TxLoc, int, error) {
+	start := s.pos
+
+	// Version.
+	if err := s.skip(4); err != nil {
+		return wire.TxLoc{}, 0, err
+	}
+
+	// A zero input count followed by a flag byte of 0x01 marks the
+	// witness encoding.
+	count, err := s.readVarInt()
+	if err != nil {
+		return wire.TxLoc{}, 0, err
+	}
+	hasWitness := false
+	if count == 0 {
+		flag, err := s.readByte()
+		if err != nil {
+			return wire.TxLoc{}, 0, err
+		}
+		if flag != 0x01 {
+			str := fmt.Sprintf("witness tx at offset %d but flag "+
+				"byte is %x", start, flag)
+			return wire.TxLoc{}, 0, BlockParseError(str)
+		}
+		hasWitness = true
+
+		count, err = s.readVarInt()
+		if err != nil {
+			return wire.TxLoc{}, 0, err
+		}
+	}
+
+	// Inputs, each of which is an outpoint, a signature script and a
+	// sequence number.
+	numTxIn := count
+	for i := uint64(0); i < numTxIn; i++ {
+		if err := s.skip(chainhash.HashSize + 4); err != nil {
+			return wire.TxLoc{}, 0, err
+		}
+		err := s.skipVarBytes(wire.MaxMessagePayload,
+			"transaction input signature script")
+		if err != nil {
+			return wire.TxLoc{}, 0, err
+		}
+		if err := s.skip(4); err != nil {
+			return wire.TxLoc{}, 0, err
+		}
+	}
+
+	// Outputs, each of which is a value and a public key script.
+	count, err = s.readVarInt()
+	if err != nil {
+		return wire.TxLoc{}, 0, err
+	}
+	for i := uint64(0); i < count; i++ {
+		if err := s.skip(8); err != nil {
+			return wire.TxLoc{}, 0, err
+		}
+		err := s.skipVarBytes(wire.MaxMessagePayload,
+			"transaction output public key script")
+		if err != nil {
+			return wire.TxLoc{}, 0, err
+		}
+	}
+
+	// Witness data, which is a stack of items for every input.
+	var witnessStart int
+	if hasWitness {
+		witnessStart = s.pos - start
+		for i := uint64(0); i < numTxIn; i++ {
+			witCount, err := s.readVarInt()
+			if err != nil {
+				return wire.TxLoc{}, 0, err
+			}
+			if witCount > maxWitnessItemsPerInput {
+				str := fmt.Sprintf("too many witness items to "+
+					"fit into max message size [count %d, "+
+					"max %d]", witCount,
+					maxWitnessItemsPerInput)
+				return wire.TxLoc{}, 0, BlockParseError(str)
+			}
+			for j := uint64(0); j < witCount; j++ {
+				err := s.skipVarBytes(maxWitnessItemSize,
+					"script witness item")
+				if err != nil {
+					return wire.TxLoc{}, 0, err
+				}
+			}
+		}
+	}
+
+	// Lock time.
+	if err := s.skip(4); err != nil {
+		return wire.TxLoc{}, 0, err
+	}
+
+	txLoc := wire.TxLoc{TxStart: start, TxLen: s.pos - start}
+	return txLoc, witnessStart, nil
+}
+
+// parallelFor invokes fn for every index in [0, n) across a pool of workers
+// sized to the number of usable CPUs, and returns once all invocations have
+// completed.  Each worker handles a contiguous range of indices.
+func parallelFor(n int, fn func(i int)) {
+	workers := runtime.GOMAXPROCS(0)
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			fn(i)
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
+	chunk := (n + workers - 1) / workers
+	for start := 0; start < n; start += chunk {
+		end := start + chunk
+		if end > n {
+			end = n
+		}
+
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				fn(i)
+			}
+		}(start, end)
+	}
+	wg.Wait()
+}
+
+// LazyBlock defines a bitcoin block which is parsed lazily from its
+// serialized bytes.  Creating one only decodes the header and records the
+// location of each transaction, so transactions are sliced out of the
+// retained bytes without copying and only decoded when they are requested.
+// This makes it well suited for callers such as indexers which handle large
+// blocks but only need the transaction hashes and a subset of the
+// transactions.
+//
+// All methods are safe for concurrent access.  The serialized bytes the block
+// was created from must not be modified afterwards.
+type LazyBlock struct {
+	serializedBlock []byte           // Serialized bytes for the block
+	header          wire.BlockHeader // Decoded block header
+	txLocs          []wire.TxLoc     // Location of each transaction
+	witnessStarts   []int            // Offset of witness data in each tx
+	blockHash       *chainhash.Hash  // Cached block hash
+	blockHeight     int32            // Height in the main block chain
+	transactions    []*Tx            // Transactions decoded so far
+	txHashes        []chainhash.Hash // Cached transaction hashes
+
+	hashOnce     sync.Once  // Guards blockHash
+	txHashesOnce sync.Once  // Guards txHashes
+	txMtx        sync.Mutex // Protects transactions
+}
+
+// Bytes returns the serialized bytes the LazyBlock was created from.
+func (b *LazyBlock) Bytes() []byte {
+	return b.serializedBlock
+}
+
+// Header returns the decoded header of the LazyBlock.
+func (b *LazyBlock) Header() *wire.BlockHeader {
+	return &b.header
+}
+
+// Hash returns the block identifier hash for the LazyBlock.  The result is
+// cached so subsequent calls are more efficient.
+func (b *LazyBlock) Hash() *chainhash.Hash {
+	b.hashOnce.Do(func() {
+		hash := b.header.BlockHash()
+		b.blockHash = &hash
+	})
+	return b.blockHash
+}
+
+// Height returns the saved height of the block in the block chain.  This value
+// will be BlockHeightUnknown if it hasn't already explicitly been set.
+func (b *LazyBlock) Height() int32 {
+	return b.blockHeight
+}
+
+// SetHeight sets the height of the block in the block chain.
+func (b *LazyBlock) SetHeight(height int32) {
+	b.blockHeight = height
+}
+
+// NumTx returns the number of transactions in the LazyBlock.
+func (b *LazyBlock) NumTx() int {
+	return len(b.txLocs)
+}
+
+// TxLoc returns the offsets and lengths of each transaction in the serialized
+// bytes of the LazyBlock.  The returned slice must not be modified.
+func (b *LazyBlock) TxLoc() []wire.TxLoc {
+	return b.txLocs
+}
+
+// checkTxNum returns an OutOfRangeError when the passed transaction index is
+// not within the block.
+func (b *LazyBlock) checkTxNum(txNum int) error {
+	if txNum < 0 || txNum >= len(b.txLocs) {
+		str := fmt.Sprintf("transaction index %d is out of range - max %d",
+			txNum, len(b.txLocs)-1)
+		return OutOfRangeError(str)
+	}
+	return nil
+}
+
+// TxBytes returns the serialized bytes of the transaction at the specified
+// index in the LazyBlock.  The returned slice references the bytes of the
+// block rather than a copy of them, so it must not be modified.
+func (b *LazyBlock) TxBytes(txNum int) ([]byte, error) {
+	if err := b.checkTxNum(txNum); err != nil {
+		return nil, err
+	}
+	loc := b.txLocs[txNum]
+	return b.serializedBlock[loc.TxStart : loc.TxStart+loc.TxLen], nil
+}
+
+// calcTxHash returns the hash of the transaction at the specified index.  The
+// hash commits to the transaction without its witness data, so for witness
+// transactions the marker, flag and witness bytes are skipped while hashing
+// the serialized bytes in place.
+func (b *LazyBlock) calcTxHash(txNum int) chainhash.Hash {
+	loc := b.txLocs[txNum]
+	txBytes := b.serializedBlock[loc.TxStart : loc.TxStart+loc.TxLen]
+	witnessStart := b.witnessStarts[txNum]
+	if witnessStart == 0 {
+		return chainhash.DoubleHashH(txBytes)
+	}
+
+	// The version is followed by the two byte marker and flag, and the
+	// lock time follows the witness data.
+	h := sha256.New()
+	h.Write(txBytes[:4])
+	h.Write(txBytes[6:witnessStart])
+	h.Write(txBytes[len(txBytes)-4:])
+	var first [sha256.Size]byte
+	return chainhash.Hash(sha256.Sum256(h.Sum(first[:0])))
+}
+
+// TxHashes returns the hashes of all transactions in the LazyBlock.  The
+// hashes are generated directly from the serialized bytes across a pool of
+// workers on the first call and cached.  The returned slice must not be
+// modified.
+//
+// The hashes are generated straight from the serialized bytes, so this is
+// considerably cheaper than decoding every transaction in order to hash it.
+func (b *LazyBlock) TxHashes() []chainhash.Hash {
+	b.txHashesOnce.Do(func() {
+		hashes := make([]chainhash.Hash, len(b.txLocs))
+		parallelFor(len(hashes), func(i int) {
+			hashes[i] = b.calcTxHash(i)
+		})
+		b.txMtx.Lock()
+		b.txHashes = hashes
+		b.txMtx.Unlock()
+	})
+	return b.txHashes
+}
+
+// TxHash returns the hash for the transaction at the specified index in the
+// LazyBlock.  The hash is taken from the cache populated by TxHashes if it has
+// been called, otherwise only the requested transaction is hashed.
+func (b *LazyBlock) TxHash(txNum int) (*chainhash.Hash, error) {
+	if err := b.checkTxNum(txNum); err != nil {
+		return nil, err
+	}
+	hashes := b.cachedTxHashes()
+	if hashes != nil {
+		return &hashes[txNum], nil
+	}
+	hash := b.calcTxHash(txNum)
+	return &hash, nil
+}
+
+// cachedTxHashes returns the transaction hashes cached by TxHashes, or nil
+// when they haven't been generated yet.  It does not wait on a concurrent call
+// to TxHashes.
+func (b *LazyBlock) cachedTxHashes() []chainhash.Hash {
+	b.txMtx.Lock()
+	hashes := b.txHashes
+	b.txMtx.Unlock()
+	return hashes
+}
+
+// newTx decodes the transaction at the specified index and wraps it.  The
+// hash is seeded from the cached transaction hashes when available.
+func (b *LazyBlock) newTx(txNum int, hashes []chainhash.Hash) (*Tx, error) {
+	txBytes, err := b.TxBytes(txNum)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := NewTxFromBytes(txBytes)
+	if err != nil {
+		return nil, err
+	}
+	tx.SetIndex(txNum)
+	if hashes != nil {
+		tx.setHash(&hashes[txNum])
+	}
+	return tx, nil
+}
+
+// Tx returns a wrapped transaction (btcutil.Tx) for the transaction at the
+// specified index in the LazyBlock.  The transaction is decoded from the
+// serialized bytes on first access and cached.
+func (b *LazyBlock) Tx(txNum int) (*Tx, error) {
+	if err := b.checkTxNum(txNum); err != nil {
+		return nil, err
+	}
+
+	b.txMtx.Lock()
+	defer b.txMtx.Unlock()
+
+	if b.transactions == nil {
+		b.transactions = make([]*Tx, len(b.txLocs))
+	}
+	if tx := b.transactions[txNum]; tx != nil {
+		return tx, nil
+	}
+
+	tx, err := b.newTx(txNum, b.txHashes)
+	if err != nil {
+		return nil, err
+	}
+	b.transactions[txNum] = tx
+	return tx, nil
+}
+
+// Block decodes all of the transactions in the LazyBlock across a pool of
+// workers and returns a fully populated Block which shares the serialized
+// bytes.  Any transaction hashes which have already been generated are carried
+// over to the wrapped transactions of the returned block.
+func (b *LazyBlock) Block() (*Block, error) {
+	b.txMtx.Lock()
+	decoded := make([]*Tx, len(b.txLocs))
+	copy(decoded, b.transactions)
+	hashes := b.txHashes
+	b.txMtx.Unlock()
+
+	errs := make([]error, len(decoded))
+	parallelFor(len(decoded), func(i int) {
+		if decoded[i] == nil {
+			decoded[i], errs[i] = b.newTx(i, hashes)
+		}
+	})
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	msgBlock := wire.MsgBlock{
+		Header:       b.header,
+		Transactions: make([]*wire.MsgTx, len(decoded)),
+	}
+	for i, tx := range decoded {
+		msgBlock.Transactions[i] = tx.MsgTx()
+	}
+
+	block := NewBlockFromBlockAndBytes(&msgBlock, b.serializedBlock)
+	block.SetHeight(b.blockHeight)
+	block.transactions = decoded
+	block.txnsGenerated = 1
+	return block, nil
+}
+
+// NewLazyBlockFromBytes returns a new instance of a lazily parsed bitcoin
+// block given the serialized bytes.  Only the header is decoded, while the
+// transactions are scanned to validate their encoding and record their
+// locations.  The passed bytes are retained by the block rather than copied.
+// See LazyBlock.
+func NewLazyBlockFromBytes(serializedBlock []byte) (*LazyBlock, error) {
+	b := LazyBlock{
+		serializedBlock: serializedBlock,
+		blockHeight:     BlockHeightUnknown,
+	}
+	err := b.header.Deserialize(bytes.NewReader(serializedBlock))
+	if err != nil {
+		return nil, err
+	}
+
+	s := txScanner{buf: serializedBlock, pos: wire.MaxBlockHeaderPayload}
+	txCount, err := s.readVarInt()
+	if err != nil {
+		return nil, err
+	}
+
+	// Every transaction takes up at least ten bytes, so a count which can't
+	// possibly fit into the remaining bytes is rejected before allocating
+	// anything for it.
+	const minTxPayload = 10
+	if txCount > uint64(len(serializedBlock)-s.pos)/minTxPayload {
+		str := fmt.Sprintf("block claims %d transactions which can not "+
+			"fit in the remaining %d bytes", txCount,
+			len(serializedBlock)-s.pos)
+		return nil, BlockParseError(str)
+	}
+
+	b.txLocs = make([]wire.TxLoc, txCount)
+	b.witnessStarts = make([]int, txCount)
+	for i := range b.txLocs {
+		b.txLocs[i], b.witnessStarts[i], err = s.scanTx()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s.pos != len(serializedBlock) {
+		str := fmt.Sprintf("block has %d trailing bytes after its "+
+			"transactions", len(serializedBlock)-s.pos)
+		return nil, BlockParseError(str)
+	}
+
+	return &b, nil
+}
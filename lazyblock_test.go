@@ -0,0 +1,166 @@
+// Copyright (c) 2013-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcutil
+
+import (
+	"bytes"
+	"testing"
+)
+
+// serializeTestBlock returns the serialized bytes of a block built by
+// testMsgBlock with the passed parameters.
+func serializeTestBlock(tb testing.TB, numTx, sigScriptLen int) []byte {
+	var buf bytes.Buffer
+	if err := testMsgBlock(numTx, sigScriptLen).Serialize(&buf); err != nil {
+		tb.Fatalf("Serialize: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// TestLazyBlockTxLoc ensures the transaction locations recorded when scanning
+// a block match the ones found by fully decoding it, and that each of them
+// slices out exactly the serialized transaction.
+func TestLazyBlockTxLoc(t *testing.T) {
+	serialized := serializeTestBlock(t, 20, 107)
+	block, err := NewBlockFromBytes(serialized)
+	if err != nil {
+		t.Fatalf("NewBlockFromBytes: %v", err)
+	}
+	lazyBlock, err := NewLazyBlockFromBytes(serialized)
+	if err != nil {
+		t.Fatalf("NewLazyBlockFromBytes: %v", err)
+	}
+
+	wantLocs, err := block.TxLoc()
+	if err != nil {
+		t.Fatalf("TxLoc: %v", err)
+	}
+	gotLocs := lazyBlock.TxLoc()
+	if len(gotLocs) != len(wantLocs) {
+		t.Fatalf("TxLoc: got %d locations, want %d", len(gotLocs),
+			len(wantLocs))
+	}
+	for i, tx := range block.MsgBlock().Transactions {
+		if gotLocs[i] != wantLocs[i] {
+			t.Errorf("TxLoc[%d]: got %+v, want %+v", i, gotLocs[i],
+				wantLocs[i])
+			continue
+		}
+
+		var want bytes.Buffer
+		if err := tx.Serialize(&want); err != nil {
+			t.Fatalf("Serialize: %v", err)
+		}
+		got, err := lazyBlock.TxBytes(i)
+		if err != nil {
+			t.Fatalf("TxBytes(%d): %v", i, err)
+		}
+		if !bytes.Equal(got, want.Bytes()) {
+			t.Errorf("TxBytes(%d): got %x, want %x", i, got,
+				want.Bytes())
+		}
+	}
+}
+
+// TestLazyBlockTxHash ensures hashing the serialized transactions in place,
+// which skips the marker, flag and witness data of witness transactions,
+// produces the same hashes as MsgTx.TxHash for both witness and non-witness
+// transactions.
+func TestLazyBlockTxHash(t *testing.T) {
+	serialized := serializeTestBlock(t, 20, 107)
+	block, err := NewBlockFromBytes(serialized)
+	if err != nil {
+		t.Fatalf("NewBlockFromBytes: %v", err)
+	}
+	lazyBlock, err := NewLazyBlockFromBytes(serialized)
+	if err != nil {
+		t.Fatalf("NewLazyBlockFromBytes: %v", err)
+	}
+
+	var numWitness int
+	for i, tx := range block.MsgBlock().Transactions {
+		if tx.HasWitness() {
+			numWitness++
+			if lazyBlock.witnessStarts[i] == 0 {
+				t.Errorf("transaction %d: witness data not "+
+					"found", i)
+			}
+		} else if lazyBlock.witnessStarts[i] != 0 {
+			t.Errorf("transaction %d: unexpected witness data at "+
+				"%d", i, lazyBlock.witnessStarts[i])
+		}
+
+		want := tx.TxHash()
+		if got := lazyBlock.calcTxHash(i); got != want {
+			t.Errorf("calcTxHash(%d): got %v, want %v", i, got,
+				want)
+		}
+		got, err := lazyBlock.TxHash(i)
+		if err != nil {
+			t.Fatalf("TxHash(%d): %v", i, err)
+		}
+		if *got != want {
+			t.Errorf("TxHash(%d): got %v, want %v", i, got, want)
+		}
+	}
+	if numWitness == 0 || numWitness == len(block.MsgBlock().Transactions) {
+		t.Fatalf("test block has %d witness transactions out of %d",
+			numWitness, len(block.MsgBlock().Transactions))
+	}
+
+	hashes := lazyBlock.TxHashes()
+	for i, tx := range block.Transactions() {
+		if hashes[i] != *tx.Hash() {
+			t.Errorf("TxHashes[%d]: got %v, want %v", i, hashes[i],
+				tx.Hash())
+		}
+	}
+}
+
+// benchBlock is a serialized block of roughly 5MB used by the benchmarks.
+var benchBlock []byte
+
+// benchBlockBytes returns the serialized block used by the benchmarks,
+// generating it on first use.
+func benchBlockBytes(b *testing.B) []byte {
+	if benchBlock == nil {
+		benchBlock = serializeTestBlock(b, 8000, 200)
+	}
+	return benchBlock
+}
+
+// BenchmarkBlockTxHashes benchmarks decoding a multi-megabyte block and
+// hashing each of its transactions through the wrapped transactions.
+func BenchmarkBlockTxHashes(b *testing.B) {
+	serialized := benchBlockBytes(b)
+	b.SetBytes(int64(len(serialized)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		block, err := NewBlockFromBytes(serialized)
+		if err != nil {
+			b.Fatalf("NewBlockFromBytes: %v", err)
+		}
+		for _, tx := range block.Transactions() {
+			tx.Hash()
+		}
+	}
+}
+
+// BenchmarkLazyBlockTxHashes benchmarks scanning a multi-megabyte block and
+// hashing its transactions in place with LazyBlock.TxHashes.
+func BenchmarkLazyBlockTxHashes(b *testing.B) {
+	serialized := benchBlockBytes(b)
+	b.SetBytes(int64(len(serialized)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lazyBlock, err := NewLazyBlockFromBytes(serialized)
+		if err != nil {
+			b.Fatalf("NewLazyBlockFromBytes: %v", err)
+		}
+		lazyBlock.TxHashes()
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcutil
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/nbcorg/btcd/wire"
+)
+
+const (
+	// lockTimeThreshold is the number below which a lock time is
+	// interpreted as a block height, and at or above which it is
+	// interpreted as a unix timestamp.  It mirrors
+	// txscript.LockTimeThreshold, which can not be imported here.
+	lockTimeThreshold = 5e8 // Tue Nov 5 00:53:20 1985 UTC
+
+	// MedianTimeBlocks is the number of previous blocks which are used to
+	// calculate the median time past of a block.
+	MedianTimeBlocks = 11
+)
+
+// SequenceLockError describes an error due to a transaction whose sequence
+// lock can not be calculated from the supplied input confirmations.
+type SequenceLockError string
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e SequenceLockError) Error() string {
+	return string(e)
+}
+
+// HeaderLookup provides the headers of the blocks of the main chain.
+type HeaderLookup interface {
+	// HeaderByHeight returns the header of the main chain block at the
+	// passed height.
+	HeaderByHeight(height int32) (*BlockHeader, error)
+}
+
+// CalcPastMedianTime returns the median time past of the main chain block at
+// the passed height, which is the median of the timestamps of the block and
+// the MedianTimeBlocks-1 blocks preceding it.  Fewer timestamps are used for
+// the first blocks of the chain.
+//
+// The median time past of the current tip is the time the lock times of the
+// transactions of the next block are checked against.  See
+// IsFinalizedTransaction.
+func CalcPastMedianTime(lookup HeaderLookup, height int32) (time.Time, error) {
+	if height < 0 {
+		str := fmt.Sprintf("invalid block height %d", height)
+		return time.Time{}, OutOfRangeError(str)
+	}
+
+	timestamps := make([]int64, 0, MedianTimeBlocks)
+	for i := height; i >= 0 && len(timestamps) < MedianTimeBlocks; i-- {
+		header, err := lookup.HeaderByHeight(i)
+		if err != nil {
+			return time.Time{}, err
+		}
+		timestamps = append(timestamps, header.MsgBlockHeader().Timestamp.Unix())
+	}
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i] < timestamps[j]
+	})
+
+	// With an even number of timestamps, which only happens for the first
+	// blocks of the chain, the later of the two middle ones is used as the
+	// reference implementation does.
+	return time.Unix(timestamps[len(timestamps)/2], 0), nil
+}
+
+// IsFinalizedTransaction returns whether the passed transaction is final and
+// may be included in a block at the passed height, whose preceding block has
+// the passed median time past as defined by BIP 113.
+//
+// A transaction is final when its lock time is zero, when its lock time is
+// below the block height or median time past it is interpreted as, or when
+// every one of its inputs has the maximum sequence number.
+func IsFinalizedTransaction(msgTx *wire.MsgTx, blockHeight int32, medianTime time.Time) bool {
+	lockTime := msgTx.LockTime
+	if lockTime == 0 {
+		return true
+	}
+
+	blockTimeOrHeight := int64(blockHeight)
+	if lockTime >= lockTimeThreshold {
+		blockTimeOrHeight = medianTime.Unix()
+	}
+	if int64(lockTime) < blockTimeOrHeight {
+		return true
+	}
+
+	for _, txIn := range msgTx.TxIn {
+		if txIn.Sequence != wire.MaxTxInSequenceNum {
+			return false
+		}
+	}
+	return true
+}
+
+// InputConfirmation describes the block which confirmed the output spent by a
+// transaction input.  Outputs which are not confirmed yet are treated as if
+// they were confirmed by the block that would include the transaction.
+type InputConfirmation struct {
+	// Height is the height of the block which confirmed the output.
+	Height int32
+
+	// MedianTime is the median time past of the block preceding the one
+	// which confirmed the output.  Relative lock times in seconds count
+	// from it as defined by BIP 68.
+	MedianTime time.Time
+}
+
+// SequenceLock describes the earliest block that may include a transaction
+// given the relative lock times of its inputs as defined by BIP 68.  The zero
+// value does not constrain the block at all.
+type SequenceLock struct {
+	// MinHeight is the smallest height of a block which may include the
+	// transaction.
+	MinHeight int32
+
+	// MinTime is the earliest median time past of the block preceding a
+	// block which may include the transaction, or the zero time when none
+	// of the inputs has a relative lock time in seconds.
+	MinTime time.Time
+}
+
+// CalcSequenceLock returns the sequence lock of the passed transaction given
+// the confirmations of the outputs spent by its inputs, which must be passed
+// in the order of the inputs.  Relative lock times are only enforced for
+// transactions with version 2 or higher, so the zero SequenceLock is returned
+// for older ones.  An error of type SequenceLockError is returned when the
+// number of confirmations does not match the number of inputs.
+func CalcSequenceLock(msgTx *wire.MsgTx, confs []InputConfirmation) (*SequenceLock, error) {
+	if len(confs) != len(msgTx.TxIn) {
+		str := fmt.Sprintf("transaction has %d inputs but %d input "+
+			"confirmations were supplied", len(msgTx.TxIn), len(confs))
+		return nil, SequenceLockError(str)
+	}
+
+	lock := &SequenceLock{}
+	if msgTx.Version < 2 {
+		return lock, nil
+	}
+
+	for i, txIn := range msgTx.TxIn {
+		sequence := txIn.Sequence
+		if sequence&wire.SequenceLockTimeDisabled != 0 {
+			continue
+		}
+
+		relativeLock := int64(sequence & wire.SequenceLockTimeMask)
+		if sequence&wire.SequenceLockTimeIsSeconds != 0 {
+			seconds := relativeLock << wire.SequenceLockTimeGranularity
+			minTime := confs[i].MedianTime.Add(time.Duration(seconds) *
+				time.Second)
+			if minTime.After(lock.MinTime) {
+				lock.MinTime = minTime
+			}
+			continue
+		}
+
+		if minHeight := confs[i].Height + int32(relativeLock); minHeight > lock.MinHeight {
+			lock.MinHeight = minHeight
+		}
+	}
+
+	return lock, nil
+}
+
+// IsActive returns whether the sequence lock still prevents the transaction
+// from being included in a block at the passed height, whose preceding block
+// has the passed median time past.
+//
+// A pre-signed transaction is ready to be broadcast once it is final, see
+// IsFinalizedTransaction, and its sequence lock is no longer active for the
+// next block.
+func (l *SequenceLock) IsActive(blockHeight int32, medianTime time.Time) bool {
+	return blockHeight < l.MinHeight || medianTime.Before(l.MinTime)
+}
@@ -0,0 +1,285 @@
+// Copyright (c) 2013-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcutil
+
+import (
+	"fmt"
+
+	"github.com/nbcorg/btcd/chaincfg/chainhash"
+	"github.com/nbcorg/btcd/wire"
+)
+
+// MerkleBlockError describes an error due to a merkle block whose partial
+// merkle tree is malformed or does not commit to the merkle root in its
+// header.
+type MerkleBlockError string
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e MerkleBlockError) Error() string {
+	return string(e)
+}
+
+// partialMerkleTree is used to house intermediate information needed to
+// generate or verify the partial merkle tree of a wire.MsgMerkleBlock.
+type partialMerkleTree struct {
+	numTx       uint32
+	allHashes   []*chainhash.Hash
+	finalHashes []*chainhash.Hash
+	matchedBits []byte
+	bits        []byte
+
+	// The following fields are only used while verifying a partial
+	// merkle tree.
+	bitsUsed       uint32
+	hashesUsed     uint32
+	bad            bool
+	matchedHashes  []*chainhash.Hash
+	matchedIndices []uint32
+}
+
+// calcTreeWidth calculates and returns the the number of nodes (width) or a
+// merkle tree at the given depth-first height.
+func (m *partialMerkleTree) calcTreeWidth(height uint32) uint32 {
+	return (m.numTx + (1 << height) - 1) >> height
+}
+
+// calcTreeHeight returns the number of merkle branches (height) of the tree.
+func (m *partialMerkleTree) calcTreeHeight() uint32 {
+	height := uint32(0)
+	for m.calcTreeWidth(height) > 1 {
+		height++
+	}
+	return height
+}
+
+// calcHash returns the hash for a sub-tree given a depth-first height and
+// node position.
+func (m *partialMerkleTree) calcHash(height, pos uint32) *chainhash.Hash {
+	if height == 0 {
+		return m.allHashes[pos]
+	}
+
+	var right *chainhash.Hash
+	left := m.calcHash(height-1, pos*2)
+	if pos*2+1 < m.calcTreeWidth(height-1) {
+		right = m.calcHash(height-1, pos*2+1)
+	} else {
+		right = left
+	}
+	return HashMerkleBranches(left, right)
+}
+
+// traverseAndBuild builds a partial merkle tree using a recursive depth-first
+// approach.  As it calculates the hashes, it also saves whether or not each
+// node is a parent node and a list of final hashes to be included in the
+// merkle block.
+func (m *partialMerkleTree) traverseAndBuild(height, pos uint32) {
+	// Determine whether this node is a parent of a matched node.
+	var isParent byte
+	for i := pos << height; i < (pos+1)<<height && i < m.numTx; i++ {
+		isParent |= m.matchedBits[i]
+	}
+	m.bits = append(m.bits, isParent)
+
+	// When the node is a leaf node or not a parent of a matched node,
+	// append the hash to the list that will be part of the final merkle
+	// block.
+	if height == 0 || isParent == 0x00 {
+		m.finalHashes = append(m.finalHashes, m.calcHash(height, pos))
+		return
+	}
+
+	// At this point, the node is an internal node and it is the parent of
+	// of an included leaf node.
+
+	// Descend into the left child and process its sub-tree.
+	m.traverseAndBuild(height-1, pos*2)
+
+	// Descend into the right child and process its sub-tree if
+	// there is one.
+	if pos*2+1 < m.calcTreeWidth(height-1) {
+		m.traverseAndBuild(height-1, pos*2+1)
+	}
+}
+
+// traverseAndExtract walks a serialized partial merkle tree using the same
+// recursive depth-first approach as traverseAndBuild, consuming flag bits and
+// hashes as it goes.  It returns the hash of the sub-tree at the given
+// depth-first height and node position while recording the hashes and
+// positions of all matched leaves.  The bad flag is set when the tree runs out
+// of bits or hashes, or when it contains identical left and right siblings,
+// which would allow the same root to be committed to by distinct trees.
+func (m *partialMerkleTree) traverseAndExtract(height, pos uint32,
+	hashes []*chainhash.Hash, flags []byte) *chainhash.Hash {
+
+	if m.bitsUsed >= uint32(len(flags))*8 {
+		// Overflowed the bits array.
+		m.bad = true
+		return &chainhash.Hash{}
+	}
+	isParent := (flags[m.bitsUsed/8] >> (m.bitsUsed % 8)) & 0x01
+	m.bitsUsed++
+
+	// When the node is a leaf node or not a parent of a matched node, the
+	// hash is taken straight from the list of hashes.
+	if height == 0 || isParent == 0x00 {
+		if m.hashesUsed >= uint32(len(hashes)) {
+			// Overflowed the hash array.
+			m.bad = true
+			return &chainhash.Hash{}
+		}
+		hash := hashes[m.hashesUsed]
+		m.hashesUsed++
+
+		// Leaf nodes with the flag set are the matched transactions.
+		if height == 0 && isParent != 0x00 {
+			m.matchedHashes = append(m.matchedHashes, hash)
+			m.matchedIndices = append(m.matchedIndices, pos)
+		}
+		return hash
+	}
+
+	// At this point, the node is an internal node whose hash must be
+	// calculated from its children.  Descend into the left child and then
+	// the right child if there is one.
+	left := m.traverseAndExtract(height-1, pos*2, hashes, flags)
+	right := left
+	if pos*2+1 < m.calcTreeWidth(height-1) {
+		right = m.traverseAndExtract(height-1, pos*2+1, hashes, flags)
+		if *right == *left {
+			// The left and right branches should never be
+			// identical, as the transaction hashes covered by
+			// them must each be unique.
+			m.bad = true
+		}
+	}
+	return HashMerkleBranches(left, right)
+}
+
+// NewMerkleBlockWithMatchFunc returns a new *wire.MsgMerkleBlock and an array
+// of the matched transaction index numbers based on the passed block.  The
+// match function is invoked for every transaction in the block, in block
+// order, and the transactions it returns true for are the ones the partial
+// merkle tree proves inclusion of.
+func NewMerkleBlockWithMatchFunc(block *Block, match func(tx *Tx) bool) (*wire.MsgMerkleBlock, []uint32) {
+	transactions := block.Transactions()
+	numTx := uint32(len(transactions))
+	mBlock := partialMerkleTree{
+		numTx:       numTx,
+		allHashes:   make([]*chainhash.Hash, 0, numTx),
+		matchedBits: make([]byte, 0, numTx),
+	}
+
+	// Find and keep track of any transactions that match.
+	var matchedIndices []uint32
+	for txIndex, tx := range transactions {
+		if match(tx) {
+			mBlock.matchedBits = append(mBlock.matchedBits, 0x01)
+			matchedIndices = append(matchedIndices, uint32(txIndex))
+		} else {
+			mBlock.matchedBits = append(mBlock.matchedBits, 0x00)
+		}
+		mBlock.allHashes = append(mBlock.allHashes, tx.Hash())
+	}
+
+	// Build the depth-first partial merkle tree.
+	mBlock.traverseAndBuild(mBlock.calcTreeHeight(), 0)
+
+	// Create and return the merkle block.
+	msgMerkleBlock := wire.MsgMerkleBlock{
+		Header:       block.MsgBlock().Header,
+		Transactions: mBlock.numTx,
+		Hashes:       make([]*chainhash.Hash, 0, len(mBlock.finalHashes)),
+		Flags:        make([]byte, (len(mBlock.bits)+7)/8),
+	}
+	for _, hash := range mBlock.finalHashes {
+		msgMerkleBlock.AddTxHash(hash)
+	}
+	for i := uint32(0); i < uint32(len(mBlock.bits)); i++ {
+		msgMerkleBlock.Flags[i/8] |= mBlock.bits[i] << (i % 8)
+	}
+	return &msgMerkleBlock, matchedIndices
+}
+
+// NewMerkleBlockWithMatches returns a new *wire.MsgMerkleBlock proving the
+// inclusion of the transactions with the passed hashes in the passed block,
+// along with an array of the matched transaction index numbers.  Hashes which
+// do not belong to any transaction in the block are ignored.
+func NewMerkleBlockWithMatches(block *Block, matches []*chainhash.Hash) (*wire.MsgMerkleBlock, []uint32) {
+	matchSet := make(map[chainhash.Hash]struct{}, len(matches))
+	for _, hash := range matches {
+		matchSet[*hash] = struct{}{}
+	}
+
+	return NewMerkleBlockWithMatchFunc(block, func(tx *Tx) bool {
+		_, ok := matchSet[*tx.Hash()]
+		return ok
+	})
+}
+
+// VerifyMerkleBlock checks the partial merkle tree of the passed merkle block
+// against the merkle root committed to by its header.  It returns the hashes
+// of the matched transactions along with their index numbers within the
+// block.  An error of type MerkleBlockError is returned when the proof is
+// malformed, including when it carries unused flag bits or hashes, or when it
+// does not commit to the merkle root in the header.
+func VerifyMerkleBlock(msg *wire.MsgMerkleBlock) ([]*chainhash.Hash, []uint32, error) {
+	// An empty set will not work.
+	if msg.Transactions == 0 {
+		str := "merkle block contains no transactions"
+		return nil, nil, MerkleBlockError(str)
+	}
+
+	// There can never be more hashes provided than one for every
+	// transaction.
+	if uint32(len(msg.Hashes)) > msg.Transactions {
+		str := fmt.Sprintf("merkle block provides %d hashes for %d "+
+			"transactions", len(msg.Hashes), msg.Transactions)
+		return nil, nil, MerkleBlockError(str)
+	}
+
+	// There must be at least one bit per node in the partial tree, and at
+	// least one node per hash.
+	if len(msg.Flags)*8 < len(msg.Hashes) {
+		str := fmt.Sprintf("merkle block provides %d flag bits for "+
+			"%d hashes", len(msg.Flags)*8, len(msg.Hashes))
+		return nil, nil, MerkleBlockError(str)
+	}
+
+	// Traverse the partial tree, calculating the merkle root along the way.
+	mBlock := partialMerkleTree{numTx: msg.Transactions}
+	root := mBlock.traverseAndExtract(mBlock.calcTreeHeight(), 0,
+		msg.Hashes, msg.Flags)
+	if mBlock.bad {
+		str := "merkle block contains a malformed partial merkle tree"
+		return nil, nil, MerkleBlockError(str)
+	}
+
+	// Verify that all bits were consumed, except for the padding caused by
+	// serializing it as a byte sequence.
+	if (mBlock.bitsUsed+7)/8 != uint32(len(msg.Flags)) {
+		str := fmt.Sprintf("merkle block partial tree used %d flag "+
+			"bits of the %d provided", mBlock.bitsUsed,
+			len(msg.Flags)*8)
+		return nil, nil, MerkleBlockError(str)
+	}
+
+	// Verify that all hashes were consumed.
+	if mBlock.hashesUsed != uint32(len(msg.Hashes)) {
+		str := fmt.Sprintf("merkle block partial tree used %d hashes "+
+			"of the %d provided", mBlock.hashesUsed, len(msg.Hashes))
+		return nil, nil, MerkleBlockError(str)
+	}
+
+	// Finally, the calculated root must match the one in the header.
+	if !root.IsEqual(&msg.Header.MerkleRoot) {
+		str := fmt.Sprintf("merkle block partial tree root %v does "+
+			"not match header merkle root %v", root,
+			msg.Header.MerkleRoot)
+		return nil, nil, MerkleBlockError(str)
+	}
+
+	return mBlock.matchedHashes, mBlock.matchedIndices, nil
+}
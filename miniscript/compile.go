@@ -0,0 +1,179 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package miniscript
+
+import (
+	"github.com/nbcorg/btcutil"
+	"github.com/nbcorg/btcutil/txscript"
+)
+
+// scriptOp is a single operation of a compiled script, which is either an
+// opcode, a data push or a number push.
+type scriptOp struct {
+	opcode byte
+	data   []byte
+	num    int64
+	isData bool
+	isNum  bool
+}
+
+// op returns an opcode operation.
+func op(opcode byte) scriptOp {
+	return scriptOp{opcode: opcode}
+}
+
+// pushData returns a canonical data push operation.
+func pushData(data []byte) scriptOp {
+	return scriptOp{data: data, isData: true}
+}
+
+// pushNum returns a canonical number push operation.
+func pushNum(num int64) scriptOp {
+	return scriptOp{num: num, isNum: true}
+}
+
+// verifyOps maps the opcodes that have a verifying variant to it, which the
+// v: wrapper uses instead of appending OP_VERIFY.
+var verifyOps = map[byte]byte{
+	txscript.OP_EQUAL:         txscript.OP_EQUALVERIFY,
+	txscript.OP_NUMEQUAL:      txscript.OP_NUMEQUALVERIFY,
+	txscript.OP_CHECKSIG:      txscript.OP_CHECKSIGVERIFY,
+	txscript.OP_CHECKMULTISIG: txscript.OP_CHECKMULTISIGVERIFY,
+}
+
+// hashOps maps the hash fragments to the opcode which hashes their preimage.
+var hashOps = map[fragment]byte{
+	fragSha256:    txscript.OP_SHA256,
+	fragHash256:   txscript.OP_HASH256,
+	fragRipemd160: txscript.OP_RIPEMD160,
+	fragHash160:   txscript.OP_HASH160,
+}
+
+// ops returns the operations of the script the node compiles to.
+func (n *Node) ops() []scriptOp {
+	// subOps returns the operations of the i-th subexpression.
+	subOps := func(i int) []scriptOp {
+		return n.subs[i].ops()
+	}
+
+	// concat joins the passed groups of operations.
+	concat := func(groups ...[]scriptOp) []scriptOp {
+		var ops []scriptOp
+		for _, group := range groups {
+			ops = append(ops, group...)
+		}
+		return ops
+	}
+
+	// ops is a shorthand for a group of operations.
+	ops := func(ops ...scriptOp) []scriptOp {
+		return ops
+	}
+
+	switch n.frag {
+	case fragJust0:
+		return ops(op(txscript.OP_0))
+	case fragJust1:
+		return ops(op(txscript.OP_1))
+	case fragPkK:
+		return ops(pushData(n.keys[0]))
+	case fragPkH:
+		return ops(op(txscript.OP_DUP), op(txscript.OP_HASH160),
+			pushData(btcutil.Hash160(n.keys[0])),
+			op(txscript.OP_EQUALVERIFY))
+	case fragOlder:
+		return ops(pushNum(n.k), op(txscript.OP_CHECKSEQUENCEVERIFY))
+	case fragAfter:
+		return ops(pushNum(n.k), op(txscript.OP_CHECKLOCKTIMEVERIFY))
+	case fragSha256, fragHash256, fragRipemd160, fragHash160:
+		return ops(op(txscript.OP_SIZE), pushNum(32),
+			op(txscript.OP_EQUALVERIFY), op(hashOps[n.frag]),
+			pushData(n.data), op(txscript.OP_EQUAL))
+
+	case fragAndOr:
+		return concat(subOps(0), ops(op(txscript.OP_NOTIF)), subOps(2),
+			ops(op(txscript.OP_ELSE)), subOps(1),
+			ops(op(txscript.OP_ENDIF)))
+	case fragAndV:
+		return concat(subOps(0), subOps(1))
+	case fragAndB:
+		return concat(subOps(0), subOps(1), ops(op(txscript.OP_BOOLAND)))
+	case fragOrB:
+		return concat(subOps(0), subOps(1), ops(op(txscript.OP_BOOLOR)))
+	case fragOrC:
+		return concat(subOps(0), ops(op(txscript.OP_NOTIF)), subOps(1),
+			ops(op(txscript.OP_ENDIF)))
+	case fragOrD:
+		return concat(subOps(0), ops(op(txscript.OP_IFDUP),
+			op(txscript.OP_NOTIF)), subOps(1),
+			ops(op(txscript.OP_ENDIF)))
+	case fragOrI:
+		return concat(ops(op(txscript.OP_IF)), subOps(0),
+			ops(op(txscript.OP_ELSE)), subOps(1),
+			ops(op(txscript.OP_ENDIF)))
+
+	case fragThresh:
+		script := subOps(0)
+		for i := 1; i < len(n.subs); i++ {
+			script = concat(script, subOps(i), ops(op(txscript.OP_ADD)))
+		}
+		return concat(script, ops(pushNum(n.k), op(txscript.OP_EQUAL)))
+
+	case fragMulti:
+		script := ops(pushNum(n.k))
+		for _, pubKey := range n.keys {
+			script = append(script, pushData(pubKey))
+		}
+		return append(script, pushNum(int64(len(n.keys))),
+			op(txscript.OP_CHECKMULTISIG))
+
+	case fragWrapA:
+		return concat(ops(op(txscript.OP_TOALTSTACK)), subOps(0),
+			ops(op(txscript.OP_FROMALTSTACK)))
+	case fragWrapS:
+		return concat(ops(op(txscript.OP_SWAP)), subOps(0))
+	case fragWrapC:
+		return concat(subOps(0), ops(op(txscript.OP_CHECKSIG)))
+	case fragWrapD:
+		return concat(ops(op(txscript.OP_DUP), op(txscript.OP_IF)),
+			subOps(0), ops(op(txscript.OP_ENDIF)))
+	case fragWrapJ:
+		return concat(ops(op(txscript.OP_SIZE), op(txscript.OP_0NOTEQUAL),
+			op(txscript.OP_IF)), subOps(0), ops(op(txscript.OP_ENDIF)))
+	case fragWrapN:
+		return concat(subOps(0), ops(op(txscript.OP_0NOTEQUAL)))
+
+	case fragWrapV:
+		script := subOps(0)
+		last := script[len(script)-1]
+		if verifyOp, ok := verifyOps[last.opcode]; ok &&
+			!last.isData && !last.isNum {
+
+			script[len(script)-1] = op(verifyOp)
+			return script
+		}
+		return append(script, op(txscript.OP_VERIFY))
+	}
+
+	return nil
+}
+
+// Script returns the script the node compiles to, which is the witness script
+// of the pay-to-witness-script-hash output it describes.  An error is
+// returned when the script exceeds the limits of the script engine.
+func (n *Node) Script() ([]byte, error) {
+	builder := txscript.NewScriptBuilder()
+	for _, op := range n.ops() {
+		switch {
+		case op.isData:
+			builder.AddData(op.data)
+		case op.isNum:
+			builder.AddInt64(op.num)
+		default:
+			builder.AddOp(op.opcode)
+		}
+	}
+	return builder.Script()
+}
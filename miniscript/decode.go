@@ -0,0 +1,573 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package miniscript
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/nbcorg/btcutil"
+	"github.com/nbcorg/btcutil/txscript"
+)
+
+// token is a single opcode of a script along with the data it pushes.
+type token struct {
+	opcode byte
+	data   []byte
+}
+
+// isPush returns whether the token pushes data of the passed length.
+func (t token) isPush(dataLen int) bool {
+	return t.opcode > txscript.OP_0 && t.opcode <= txscript.OP_PUSHDATA4 &&
+		len(t.data) == dataLen
+}
+
+// number returns the positive number the token pushes, if any.  Only numbers
+// that fit in 4 bytes are recognized since miniscript has no larger ones.
+func (t token) number() (int64, bool) {
+	if t.opcode >= txscript.OP_1 && t.opcode <= txscript.OP_16 {
+		return int64(t.opcode - (txscript.OP_1 - 1)), true
+	}
+	if t.opcode == txscript.OP_0 || t.opcode > txscript.OP_PUSHDATA4 ||
+		len(t.data) == 0 || len(t.data) > 4 {
+
+		return 0, false
+	}
+
+	// Numbers are encoded as little endian with a sign bit, so positive
+	// numbers never have the high bit of their last byte set.
+	if t.data[len(t.data)-1]&0x80 != 0 {
+		return 0, false
+	}
+	var buf [4]byte
+	copy(buf[:], t.data)
+	n := int64(binary.LittleEndian.Uint32(buf[:]))
+	return n, n > 0
+}
+
+// splitVerifyOps maps the verifying opcodes to the opcodes they combine with
+// OP_VERIFY.  Scripts are decoded with these opcodes split in two so the v:
+// wrapper is recognized the same way for all expressions.
+var splitVerifyOps = map[byte]byte{
+	txscript.OP_EQUALVERIFY:         txscript.OP_EQUAL,
+	txscript.OP_NUMEQUALVERIFY:      txscript.OP_NUMEQUAL,
+	txscript.OP_CHECKSIGVERIFY:      txscript.OP_CHECKSIG,
+	txscript.OP_CHECKMULTISIGVERIFY: txscript.OP_CHECKMULTISIG,
+}
+
+// tokenize splits a script into its tokens, with the verifying opcodes split
+// into the opcode they verify followed by OP_VERIFY.
+func tokenize(script []byte) ([]token, error) {
+	var toks []token
+	tokenizer := txscript.MakeScriptTokenizer(script)
+	for tokenizer.Next() {
+		opcode := tokenizer.Opcode()
+		if verified, ok := splitVerifyOps[opcode]; ok {
+			toks = append(toks, token{opcode: verified},
+				token{opcode: txscript.OP_VERIFY})
+			continue
+		}
+		toks = append(toks, token{opcode: opcode, data: tokenizer.Data()})
+	}
+	if err := tokenizer.Err(); err != nil {
+		str := fmt.Sprintf("script %x does not parse: %v", script, err)
+		return nil, ParseError(str)
+	}
+	return toks, nil
+}
+
+// decodeCtx identifies what the decoder expects next while decoding a script
+// from its end.
+type decodeCtx int
+
+const (
+	// Contexts which decode expressions.
+	ctxSingleBKV decodeCtx = iota
+	ctxBKV
+	ctxW
+	ctxMaybeAndV
+	ctxEndif
+	ctxEndifNotif
+	ctxEndifElse
+	ctxThreshW
+
+	// Contexts which build a node from the nodes decoded before.
+	ctxAndV
+	ctxAndB
+	ctxOrB
+	ctxOrC
+	ctxOrD
+	ctxOrI
+	ctxAndOr
+	ctxThreshE
+	ctxSwap
+	ctxAlt
+	ctxCheck
+	ctxDupIf
+	ctxVerify
+	ctxNonZero
+	ctxZeroNotEqual
+)
+
+// combineFrags maps the steps which build a node from the nodes decoded
+// before to the fragment of the node they build, except for andor() and
+// thresh() which are built separately.
+var combineFrags = map[decodeCtx]fragment{
+	ctxAndV:         fragAndV,
+	ctxAndB:         fragAndB,
+	ctxOrB:          fragOrB,
+	ctxOrC:          fragOrC,
+	ctxOrD:          fragOrD,
+	ctxOrI:          fragOrI,
+	ctxSwap:         fragWrapS,
+	ctxAlt:          fragWrapA,
+	ctxCheck:        fragWrapC,
+	ctxDupIf:        fragWrapD,
+	ctxVerify:       fragWrapV,
+	ctxNonZero:      fragWrapJ,
+	ctxZeroNotEqual: fragWrapN,
+}
+
+// combineArgs maps the steps which build a node from the nodes decoded before
+// to the number of nodes they need, except for thresh() whose number of
+// subexpressions varies.
+var combineArgs = map[decodeCtx]int{
+	ctxAndV:         2,
+	ctxAndB:         2,
+	ctxOrB:          2,
+	ctxOrC:          2,
+	ctxOrD:          2,
+	ctxOrI:          2,
+	ctxAndOr:        3,
+	ctxSwap:         1,
+	ctxAlt:          1,
+	ctxCheck:        1,
+	ctxDupIf:        1,
+	ctxVerify:       1,
+	ctxNonZero:      1,
+	ctxZeroNotEqual: 1,
+}
+
+// decodeStep is a pending step of the decoder.  The threshold steps count the
+// subexpressions decoded so far in n and carry the threshold in k.
+type decodeStep struct {
+	ctx  decodeCtx
+	n, k int64
+}
+
+// decodeNode decodes the tokens of a script into a node without type checking
+// it.
+//
+// Miniscript is decoded from the end of the script, since every fragment is
+// identified by its last opcodes, using an explicit stack of pending steps in
+// place of recursion.  Decoded nodes are kept on a second stack until the
+// step that combines them into their parent runs.
+func decodeNode(toks []token) (*Node, error) {
+	// Reverse the tokens so the decoder can walk them forward.
+	rev := make([]token, len(toks))
+	for i, tok := range toks {
+		rev[len(toks)-1-i] = tok
+	}
+
+	// at returns whether the token at offset i from the current position
+	// exists and has the passed opcode.
+	pos := 0
+	at := func(i int, opcode byte) bool {
+		return pos+i < len(rev) && rev[pos+i].opcode == opcode
+	}
+	errInvalid := func() error {
+		str := fmt.Sprintf("script is not a miniscript: unexpected "+
+			"opcode 0x%02x", rev[pos-1].opcode)
+		return ParseError(str)
+	}
+
+	var built []*Node
+	pop := func() *Node {
+		node := built[len(built)-1]
+		built = built[:len(built)-1]
+		return node
+	}
+	push := func(node *Node) {
+		built = append(built, node)
+	}
+
+	todo := []decodeStep{{ctx: ctxBKV}}
+	for len(todo) > 0 {
+		step := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		next := func(ctxs ...decodeCtx) {
+			for _, ctx := range ctxs {
+				todo = append(todo, decodeStep{ctx: ctx})
+			}
+		}
+
+		// The combining steps need the nodes they combine.
+		need := int64(combineArgs[step.ctx])
+		if step.ctx == ctxThreshE {
+			need = step.n
+		}
+		if int64(len(built)) < need {
+			return nil, ParseError("script is not a miniscript")
+		}
+
+		// The decoding steps need a token.
+		if step.ctx < ctxAndV && step.ctx != ctxMaybeAndV &&
+			pos >= len(rev) {
+
+			return nil, ParseError("script is not a miniscript: " +
+				"unexpected end of script")
+		}
+
+		switch step.ctx {
+		case ctxBKV:
+			next(ctxMaybeAndV, ctxSingleBKV)
+
+		case ctxMaybeAndV:
+			// An and_v() continues with its first argument unless
+			// the next opcode can't end an expression.  Each and_v()
+			// is built before the next one is decoded, so a chain of
+			// them nests to the right.
+			if pos < len(rev) && !at(0, txscript.OP_IF) &&
+				!at(0, txscript.OP_ELSE) && !at(0, txscript.OP_NOTIF) &&
+				!at(0, txscript.OP_TOALTSTACK) &&
+				!at(0, txscript.OP_SWAP) {
+
+				next(ctxMaybeAndV, ctxAndV, ctxSingleBKV)
+			}
+
+		case ctxW:
+			if at(0, txscript.OP_FROMALTSTACK) {
+				pos++
+				next(ctxAlt, ctxBKV)
+			} else {
+				next(ctxSwap, ctxSingleBKV)
+			}
+
+		case ctxSingleBKV:
+			tok := rev[pos]
+			var n int64
+			switch {
+			case tok.opcode == txscript.OP_0:
+				pos++
+				push(&Node{frag: fragJust0})
+
+			case tok.opcode == txscript.OP_1:
+				pos++
+				push(&Node{frag: fragJust1})
+
+			case tok.isPush(pubKeyLen):
+				pos++
+				push(&Node{frag: fragPkK, keys: [][]byte{tok.data}})
+
+			case at(0, txscript.OP_VERIFY) && at(1, txscript.OP_EQUAL) &&
+				pos+2 < len(rev) && rev[pos+2].isPush(20) &&
+				at(3, txscript.OP_HASH160) && at(4, txscript.OP_DUP):
+
+				// The key of a pk_h() is only known by its hash,
+				// so it is stored in the data of the node until
+				// the key is resolved.
+				push(&Node{frag: fragPkH, data: rev[pos+2].data})
+				pos += 5
+
+			case at(0, txscript.OP_CHECKSEQUENCEVERIFY) &&
+				pos+1 < len(rev):
+
+				var ok bool
+				if n, ok = rev[pos+1].number(); !ok {
+					pos++
+					return nil, errInvalid()
+				}
+				push(&Node{frag: fragOlder, k: n})
+				pos += 2
+
+			case at(0, txscript.OP_CHECKLOCKTIMEVERIFY) &&
+				pos+1 < len(rev):
+
+				var ok bool
+				if n, ok = rev[pos+1].number(); !ok {
+					pos++
+					return nil, errInvalid()
+				}
+				push(&Node{frag: fragAfter, k: n})
+				pos += 2
+
+			case at(0, txscript.OP_EQUAL) && pos+6 < len(rev) &&
+				at(3, txscript.OP_VERIFY) && at(4, txscript.OP_EQUAL) &&
+				at(6, txscript.OP_SIZE) && isNumber(rev[pos+5], 32):
+
+				var frag fragment
+				switch rev[pos+2].opcode {
+				case txscript.OP_SHA256:
+					frag = fragSha256
+				case txscript.OP_HASH256:
+					frag = fragHash256
+				case txscript.OP_RIPEMD160:
+					frag = fragRipemd160
+				case txscript.OP_HASH160:
+					frag = fragHash160
+				default:
+					pos += 3
+					return nil, errInvalid()
+				}
+				if !rev[pos+1].isPush(hashLen(frag)) {
+					pos += 2
+					return nil, errInvalid()
+				}
+				push(&Node{frag: frag, data: rev[pos+1].data})
+				pos += 7
+
+			case at(0, txscript.OP_CHECKMULTISIG):
+				numKeys, ok := int64(0), pos+1 < len(rev)
+				if ok {
+					numKeys, ok = rev[pos+1].number()
+				}
+				if !ok || numKeys > maxMultiKeys ||
+					pos+2+int(numKeys) >= len(rev) {
+
+					pos++
+					return nil, errInvalid()
+				}
+				keys := make([][]byte, numKeys)
+				for i := range keys {
+					tok := rev[pos+2+i]
+					if !tok.isPush(pubKeyLen) {
+						pos += 2 + i
+						return nil, errInvalid()
+					}
+					keys[len(keys)-1-i] = tok.data
+				}
+				k, ok := rev[pos+2+int(numKeys)].number()
+				if !ok || k > numKeys {
+					pos += 2 + int(numKeys)
+					return nil, errInvalid()
+				}
+				push(&Node{frag: fragMulti, k: k, keys: keys})
+				pos += 3 + int(numKeys)
+
+			case at(0, txscript.OP_EQUAL) && pos+1 < len(rev) &&
+				isThreshold(rev[pos+1]):
+
+				n, _ = rev[pos+1].number()
+				pos += 2
+				todo = append(todo, decodeStep{ctx: ctxThreshW, k: n})
+
+			case at(0, txscript.OP_VERIFY):
+				pos++
+				next(ctxVerify, ctxSingleBKV)
+
+			case at(0, txscript.OP_0NOTEQUAL):
+				pos++
+				next(ctxZeroNotEqual, ctxSingleBKV)
+
+			case at(0, txscript.OP_CHECKSIG):
+				pos++
+				next(ctxCheck, ctxSingleBKV)
+
+			case at(0, txscript.OP_BOOLAND):
+				pos++
+				next(ctxAndB, ctxSingleBKV, ctxW)
+
+			case at(0, txscript.OP_BOOLOR):
+				pos++
+				next(ctxOrB, ctxSingleBKV, ctxW)
+
+			case at(0, txscript.OP_ENDIF):
+				pos++
+				next(ctxEndif, ctxBKV)
+
+			default:
+				pos++
+				return nil, errInvalid()
+			}
+
+		case ctxEndif:
+			switch {
+			case at(0, txscript.OP_ELSE):
+				pos++
+				next(ctxEndifElse, ctxBKV)
+			case at(0, txscript.OP_IF) && at(1, txscript.OP_DUP):
+				pos += 2
+				next(ctxDupIf)
+			case at(0, txscript.OP_IF) && at(1, txscript.OP_0NOTEQUAL) &&
+				at(2, txscript.OP_SIZE):
+
+				pos += 3
+				next(ctxNonZero)
+			case at(0, txscript.OP_NOTIF):
+				pos++
+				next(ctxEndifNotif)
+			default:
+				pos++
+				return nil, errInvalid()
+			}
+
+		case ctxEndifNotif:
+			if at(0, txscript.OP_IFDUP) {
+				pos++
+				next(ctxOrD, ctxSingleBKV)
+			} else {
+				next(ctxOrC, ctxSingleBKV)
+			}
+
+		case ctxEndifElse:
+			switch {
+			case at(0, txscript.OP_IF):
+				pos++
+				next(ctxOrI)
+			case at(0, txscript.OP_NOTIF):
+				pos++
+				next(ctxAndOr, ctxSingleBKV)
+			default:
+				pos++
+				return nil, errInvalid()
+			}
+
+		case ctxThreshW:
+			// Every subexpression but the first is followed by an
+			// OP_ADD.
+			if at(0, txscript.OP_ADD) {
+				pos++
+				todo = append(todo, decodeStep{ctx: ctxThreshW,
+					n: step.n + 1, k: step.k})
+				next(ctxW)
+			} else {
+				todo = append(todo, decodeStep{ctx: ctxThreshE,
+					n: step.n + 1, k: step.k})
+				next(ctxSingleBKV)
+			}
+
+		case ctxThreshE:
+			if step.k > step.n {
+				return nil, ParseError("script is not a miniscript: " +
+					"threshold exceeds the number of subexpressions")
+			}
+			subs := make([]*Node, step.n)
+			for i := range subs {
+				subs[i] = pop()
+			}
+			push(&Node{frag: fragThresh, k: step.k, subs: subs})
+
+		case ctxAndOr:
+			// The subexpressions were decoded from the end, so the
+			// first one is on top.
+			x, z, y := pop(), pop(), pop()
+			push(&Node{frag: fragAndOr, subs: []*Node{x, y, z}})
+
+		case ctxAndV, ctxAndB, ctxOrB, ctxOrC, ctxOrD, ctxOrI:
+			x, y := pop(), pop()
+			push(&Node{frag: combineFrags[step.ctx],
+				subs: []*Node{x, y}})
+
+		case ctxSwap, ctxAlt:
+			// The s: and a: wrappers end with the opcode which
+			// precedes their subexpression.
+			opcode := byte(txscript.OP_SWAP)
+			if step.ctx == ctxAlt {
+				opcode = txscript.OP_TOALTSTACK
+			}
+			if !at(0, opcode) {
+				return nil, ParseError("script is not a miniscript: " +
+					"unexpected start of a wrapped expression")
+			}
+			pos++
+			push(wrap(combineFrags[step.ctx], pop()))
+
+		default:
+			push(wrap(combineFrags[step.ctx], pop()))
+		}
+	}
+
+	if pos != len(rev) || len(built) != 1 {
+		return nil, ParseError("script is not a miniscript: unexpected " +
+			"opcodes at the start of the script")
+	}
+	return built[0], nil
+}
+
+// isNumber returns whether the token pushes the passed number.
+func isNumber(tok token, num int64) bool {
+	n, ok := tok.number()
+	return ok && n == num
+}
+
+// isThreshold returns whether the token pushes a number that may be the
+// threshold of a thresh() fragment.
+func isThreshold(tok token) bool {
+	_, ok := tok.number()
+	return ok
+}
+
+// resolveKeyHashes replaces the key hashes of the pk_h() fragments of the node
+// with the matching keys, which must be among the passed keys.
+func (n *Node) resolveKeyHashes(keys map[string][]byte) error {
+	if n.frag == fragPkH && n.keys == nil {
+		pubKey, ok := keys[string(n.data)]
+		if !ok {
+			str := fmt.Sprintf("no public key for key hash %x", n.data)
+			return ParseError(str)
+		}
+		n.keys, n.data = [][]byte{pubKey}, nil
+	}
+	for _, sub := range n.subs {
+		if err := sub.resolveKeyHashes(keys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DecodeScript decodes a witness script into the miniscript expression that
+// compiles to it.  A ParseError is returned for scripts that are not the
+// canonical encoding of a miniscript expression and a TypeError for scripts
+// that encode an expression which does not pass the type system.
+//
+// The keys of pk_h() and pkh() fragments only appear in the script as their
+// hash, so those keys must be passed in pkhKeys.  Any other keys are ignored.
+func DecodeScript(script []byte, pkhKeys [][]byte) (*Node, error) {
+	toks, err := tokenize(script)
+	if err != nil {
+		return nil, err
+	}
+	node, err := decodeNode(toks)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string][]byte, len(pkhKeys))
+	for _, pubKey := range pkhKeys {
+		keys[string(btcutil.Hash160(pubKey))] = pubKey
+	}
+	if err := node.resolveKeyHashes(keys); err != nil {
+		return nil, err
+	}
+	for _, pubKey := range node.Keys() {
+		if err := checkPubKey(pubKey); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := node.typeCheck(); err != nil {
+		return nil, err
+	}
+	if node.typ.Base != BaseB {
+		str := fmt.Sprintf("script decodes to %v of type %v instead "+
+			"of B", node, node.typ)
+		return nil, TypeError(str)
+	}
+
+	// Scripts only decode to an expression when the expression compiles
+	// to the exact same script, which rules out non-canonical pushes.
+	compiled, err := node.Script()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(compiled, script) {
+		str := fmt.Sprintf("script %x is not the canonical encoding of "+
+			"%v", script, node)
+		return nil, ParseError(str)
+	}
+	return node, nil
+}
@@ -0,0 +1,539 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package miniscript implements miniscript for pay-to-witness-script-hash
+// scripts as defined by BIP 379.
+package miniscript
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nbcorg/btcutil"
+	"github.com/nbcorg/btcutil/chaincfg"
+)
+
+// ParseError describes an error due to a miniscript expression or script that
+// is malformed.
+type ParseError string
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e ParseError) Error() string {
+	return string(e)
+}
+
+// TypeError describes an error due to a miniscript expression or script that
+// is well-formed but does not pass the type system, which means it can not be
+// satisfied or dissatisfied the way its fragments require.
+type TypeError string
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e TypeError) Error() string {
+	return string(e)
+}
+
+// fragment identifies the kind of a miniscript node.
+type fragment int
+
+// These constants define the miniscript fragments.  The aliases t:, l:, u:,
+// pk(), pkh() and and_n() are represented by the fragments they expand to.
+const (
+	fragJust0 fragment = iota
+	fragJust1
+	fragPkK
+	fragPkH
+	fragOlder
+	fragAfter
+	fragSha256
+	fragHash256
+	fragRipemd160
+	fragHash160
+	fragAndOr
+	fragAndV
+	fragAndB
+	fragOrB
+	fragOrC
+	fragOrD
+	fragOrI
+	fragThresh
+	fragMulti
+	fragWrapA
+	fragWrapS
+	fragWrapC
+	fragWrapD
+	fragWrapV
+	fragWrapJ
+	fragWrapN
+)
+
+// fragNames maps the fragments that take arguments to their names.
+var fragNames = map[fragment]string{
+	fragPkK:       "pk_k",
+	fragPkH:       "pk_h",
+	fragOlder:     "older",
+	fragAfter:     "after",
+	fragSha256:    "sha256",
+	fragHash256:   "hash256",
+	fragRipemd160: "ripemd160",
+	fragHash160:   "hash160",
+	fragAndOr:     "andor",
+	fragAndV:      "and_v",
+	fragAndB:      "and_b",
+	fragOrB:       "or_b",
+	fragOrC:       "or_c",
+	fragOrD:       "or_d",
+	fragOrI:       "or_i",
+	fragThresh:    "thresh",
+	fragMulti:     "multi",
+}
+
+// wrapperFrags maps the wrapper letters to the fragments they denote.  The
+// t:, l: and u: wrappers are handled separately since they are aliases.
+var wrapperFrags = map[byte]fragment{
+	'a': fragWrapA,
+	's': fragWrapS,
+	'c': fragWrapC,
+	'd': fragWrapD,
+	'v': fragWrapV,
+	'j': fragWrapJ,
+	'n': fragWrapN,
+}
+
+const (
+	// pubKeyLen is the length of a serialized public key.
+	pubKeyLen = 33
+
+	// maxMultiKeys is the maximum number of keys of a multi() fragment,
+	// which is the limit OP_CHECKMULTISIG imposes.
+	maxMultiKeys = 20
+
+	// maxLockTime is the largest argument of older() and after(), which
+	// must fit in a positive 4-byte script number.
+	maxLockTime = 1<<31 - 1
+)
+
+// Node is a miniscript expression.  Every node returned by the package has
+// passed the type system, so it is guaranteed to be sound.
+type Node struct {
+	frag fragment
+	k    int64
+	keys [][]byte
+	data []byte
+	subs []*Node
+	typ  Type
+}
+
+// hashLen returns the length of the hash argument of a hash fragment.
+func hashLen(frag fragment) int {
+	if frag == fragSha256 || frag == fragHash256 {
+		return sha256.Size
+	}
+	return 20
+}
+
+// checkPubKey returns a ParseError when the passed public key is not valid in
+// this chain.  See btcutil.IsValidPubKey.
+func checkPubKey(pubKey []byte) error {
+	if !btcutil.IsValidPubKey(pubKey) {
+		str := fmt.Sprintf("invalid public key %x", pubKey)
+		return ParseError(str)
+	}
+	return nil
+}
+
+// splitArgs splits the arguments of a fragment at the commas that are not
+// nested within parentheses.
+func splitArgs(s string) []string {
+	var args []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				args = append(args, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(args, s[start:])
+}
+
+// parseNumber parses a decimal argument and checks it is within [min, max].
+func parseNumber(s string, min, max int64) (int64, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < min || n > max {
+		str := fmt.Sprintf("invalid number %q, must be between %d and %d",
+			s, min, max)
+		return 0, ParseError(str)
+	}
+	return n, nil
+}
+
+// parseKey parses a hex-encoded public key argument.
+func parseKey(s string) ([]byte, error) {
+	pubKey, err := hex.DecodeString(s)
+	if err != nil {
+		str := fmt.Sprintf("invalid public key %q", s)
+		return nil, ParseError(str)
+	}
+	if err := checkPubKey(pubKey); err != nil {
+		return nil, err
+	}
+	return pubKey, nil
+}
+
+// wrap returns a node applying the passed wrapper fragment to sub.
+func wrap(frag fragment, sub *Node) *Node {
+	return &Node{frag: frag, subs: []*Node{sub}}
+}
+
+// parseNode parses a miniscript expression without type checking it.
+func parseNode(s string) (*Node, error) {
+	// The wrappers are the letters before a colon, which apply from right
+	// to left.
+	if colon := strings.IndexByte(s, ':'); colon >= 0 &&
+		!strings.ContainsRune(s[:colon], '(') {
+
+		wrappers := s[:colon]
+		node, err := parseNode(s[colon+1:])
+		if err != nil {
+			return nil, err
+		}
+		if len(wrappers) == 0 {
+			str := fmt.Sprintf("missing wrappers in %q", s)
+			return nil, ParseError(str)
+		}
+		for i := len(wrappers) - 1; i >= 0; i-- {
+			switch w := wrappers[i]; w {
+			case 't':
+				node = &Node{frag: fragAndV, subs: []*Node{node,
+					{frag: fragJust1}}}
+			case 'l':
+				node = &Node{frag: fragOrI, subs: []*Node{
+					{frag: fragJust0}, node}}
+			case 'u':
+				node = &Node{frag: fragOrI, subs: []*Node{node,
+					{frag: fragJust0}}}
+			default:
+				frag, ok := wrapperFrags[w]
+				if !ok {
+					str := fmt.Sprintf("unknown wrapper %q",
+						string(w))
+					return nil, ParseError(str)
+				}
+				node = wrap(frag, node)
+			}
+		}
+		return node, nil
+	}
+
+	switch s {
+	case "0":
+		return &Node{frag: fragJust0}, nil
+	case "1":
+		return &Node{frag: fragJust1}, nil
+	}
+
+	open := strings.IndexByte(s, '(')
+	if open < 0 || !strings.HasSuffix(s, ")") {
+		str := fmt.Sprintf("invalid miniscript expression %q", s)
+		return nil, ParseError(str)
+	}
+	name, args := s[:open], splitArgs(s[open+1:len(s)-1])
+
+	// checkArgs returns an error unless the number of arguments is within
+	// [min, max], where a negative max means there is no upper limit.
+	checkArgs := func(min, max int) error {
+		if len(args) < min || (max >= 0 && len(args) > max) {
+			str := fmt.Sprintf("wrong number of arguments for %s() "+
+				"in %q", name, s)
+			return ParseError(str)
+		}
+		return nil
+	}
+
+	// parseSubs parses all arguments as subexpressions.
+	parseSubs := func(args []string) ([]*Node, error) {
+		subs := make([]*Node, len(args))
+		for i, arg := range args {
+			sub, err := parseNode(arg)
+			if err != nil {
+				return nil, err
+			}
+			subs[i] = sub
+		}
+		return subs, nil
+	}
+
+	switch name {
+	case "pk", "pkh", "pk_k", "pk_h":
+		if err := checkArgs(1, 1); err != nil {
+			return nil, err
+		}
+		pubKey, err := parseKey(args[0])
+		if err != nil {
+			return nil, err
+		}
+		frag := fragPkK
+		if name == "pkh" || name == "pk_h" {
+			frag = fragPkH
+		}
+		node := &Node{frag: frag, keys: [][]byte{pubKey}}
+		if name == "pk" || name == "pkh" {
+			node = wrap(fragWrapC, node)
+		}
+		return node, nil
+
+	case "older", "after":
+		if err := checkArgs(1, 1); err != nil {
+			return nil, err
+		}
+		n, err := parseNumber(args[0], 1, maxLockTime)
+		if err != nil {
+			return nil, err
+		}
+		frag := fragOlder
+		if name == "after" {
+			frag = fragAfter
+		}
+		return &Node{frag: frag, k: n}, nil
+
+	case "sha256", "hash256", "ripemd160", "hash160":
+		if err := checkArgs(1, 1); err != nil {
+			return nil, err
+		}
+		var frag fragment
+		switch name {
+		case "sha256":
+			frag = fragSha256
+		case "hash256":
+			frag = fragHash256
+		case "ripemd160":
+			frag = fragRipemd160
+		default:
+			frag = fragHash160
+		}
+		hash, err := hex.DecodeString(args[0])
+		if err != nil || len(hash) != hashLen(frag) {
+			str := fmt.Sprintf("invalid %d-byte hash %q for %s()",
+				hashLen(frag), args[0], name)
+			return nil, ParseError(str)
+		}
+		return &Node{frag: frag, data: hash}, nil
+
+	case "andor", "and_n":
+		if name == "andor" {
+			if err := checkArgs(3, 3); err != nil {
+				return nil, err
+			}
+		} else {
+			if err := checkArgs(2, 2); err != nil {
+				return nil, err
+			}
+			args = append(args, "0")
+		}
+		subs, err := parseSubs(args)
+		if err != nil {
+			return nil, err
+		}
+		return &Node{frag: fragAndOr, subs: subs}, nil
+
+	case "and_v", "and_b", "or_b", "or_c", "or_d", "or_i":
+		if err := checkArgs(2, 2); err != nil {
+			return nil, err
+		}
+		subs, err := parseSubs(args)
+		if err != nil {
+			return nil, err
+		}
+		var frag fragment
+		for f, fragName := range fragNames {
+			if fragName == name {
+				frag = f
+			}
+		}
+		return &Node{frag: frag, subs: subs}, nil
+
+	case "thresh":
+		if err := checkArgs(2, -1); err != nil {
+			return nil, err
+		}
+		subs, err := parseSubs(args[1:])
+		if err != nil {
+			return nil, err
+		}
+		k, err := parseNumber(args[0], 1, int64(len(subs)))
+		if err != nil {
+			return nil, err
+		}
+		return &Node{frag: fragThresh, k: k, subs: subs}, nil
+
+	case "multi":
+		if err := checkArgs(2, maxMultiKeys+1); err != nil {
+			return nil, err
+		}
+		keys := make([][]byte, len(args)-1)
+		for i, arg := range args[1:] {
+			pubKey, err := parseKey(arg)
+			if err != nil {
+				return nil, err
+			}
+			keys[i] = pubKey
+		}
+		k, err := parseNumber(args[0], 1, int64(len(keys)))
+		if err != nil {
+			return nil, err
+		}
+		return &Node{frag: fragMulti, k: k, keys: keys}, nil
+	}
+
+	str := fmt.Sprintf("unknown fragment %q", name)
+	return nil, ParseError(str)
+}
+
+// Parse parses a miniscript expression and checks that it is a valid top-level
+// expression, which must have the B type.  A ParseError is returned for
+// expressions that are malformed and a TypeError for expressions that do not
+// pass the type system.
+//
+// Both the canonical names of the fragments and their aliases are accepted,
+// such as pk() for c:pk_k() and t:X for and_v(X,1).  Public keys are hex
+// encoded and must be valid in this chain.
+func Parse(s string) (*Node, error) {
+	node, err := parseNode(s)
+	if err != nil {
+		return nil, err
+	}
+	if err := node.typeCheck(); err != nil {
+		return nil, err
+	}
+	if node.typ.Base != BaseB {
+		str := fmt.Sprintf("top-level expression %q has type %v instead "+
+			"of B", s, node.typ)
+		return nil, TypeError(str)
+	}
+	return node, nil
+}
+
+// String returns the expression of the node using the aliases wherever they
+// apply, so parsing the result yields an identical node.
+func (n *Node) String() string {
+	// wrapped joins a wrapper letter with the expression of the wrapped
+	// node, merging it with any wrappers that node already has.
+	wrapped := func(w string, sub *Node) string {
+		s := sub.String()
+		colon := strings.IndexByte(s, ':')
+		if colon >= 0 && !strings.ContainsRune(s[:colon], '(') {
+			return w + s
+		}
+		return w + ":" + s
+	}
+
+	switch n.frag {
+	case fragJust0:
+		return "0"
+	case fragJust1:
+		return "1"
+	case fragPkK, fragPkH:
+		return fragNames[n.frag] + "(" + hex.EncodeToString(n.keys[0]) + ")"
+	case fragOlder, fragAfter:
+		return fragNames[n.frag] + "(" + strconv.FormatInt(n.k, 10) + ")"
+	case fragSha256, fragHash256, fragRipemd160, fragHash160:
+		return fragNames[n.frag] + "(" + hex.EncodeToString(n.data) + ")"
+
+	case fragAndOr:
+		if n.subs[2].frag == fragJust0 {
+			return "and_n(" + n.subs[0].String() + "," +
+				n.subs[1].String() + ")"
+		}
+
+	case fragAndV:
+		if n.subs[1].frag == fragJust1 {
+			return wrapped("t", n.subs[0])
+		}
+
+	case fragOrI:
+		if n.subs[0].frag == fragJust0 {
+			return wrapped("l", n.subs[1])
+		}
+		if n.subs[1].frag == fragJust0 {
+			return wrapped("u", n.subs[0])
+		}
+
+	case fragThresh, fragMulti:
+		args := []string{strconv.FormatInt(n.k, 10)}
+		for _, sub := range n.subs {
+			args = append(args, sub.String())
+		}
+		for _, pubKey := range n.keys {
+			args = append(args, hex.EncodeToString(pubKey))
+		}
+		return fragNames[n.frag] + "(" + strings.Join(args, ",") + ")"
+
+	case fragWrapC:
+		switch n.subs[0].frag {
+		case fragPkK:
+			return "pk(" + hex.EncodeToString(n.subs[0].keys[0]) + ")"
+		case fragPkH:
+			return "pkh(" + hex.EncodeToString(n.subs[0].keys[0]) + ")"
+		}
+		return wrapped("c", n.subs[0])
+	}
+
+	for w, frag := range wrapperFrags {
+		if n.frag == frag {
+			return wrapped(string(w), n.subs[0])
+		}
+	}
+
+	args := make([]string, len(n.subs))
+	for i, sub := range n.subs {
+		args[i] = sub.String()
+	}
+	return fragNames[n.frag] + "(" + strings.Join(args, ",") + ")"
+}
+
+// Type returns the type of the node.
+func (n *Node) Type() Type {
+	return n.typ
+}
+
+// Keys returns the public keys the node refers to in the order they appear
+// in the expression, including duplicates.
+func (n *Node) Keys() [][]byte {
+	keys := append([][]byte(nil), n.keys...)
+	for _, sub := range n.subs {
+		keys = append(keys, sub.Keys()...)
+	}
+	return keys
+}
+
+// WitnessScriptHash returns the witness program of the pay-to-witness-script-
+// hash output paying to the script of the node, which is the SHA-256 hash of
+// the script.
+func (n *Node) WitnessScriptHash() ([]byte, error) {
+	script, err := n.Script()
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(script)
+	return hash[:], nil
+}
+
+// Address returns the pay-to-witness-script-hash address paying to the script
+// of the node on the passed network.
+func (n *Node) Address(net *chaincfg.Params) (*btcutil.AddressWitnessScriptHash, error) {
+	hash, err := n.WitnessScriptHash()
+	if err != nil {
+		return nil, err
+	}
+	return btcutil.NewAddressWitnessScriptHash(hash, net)
+}
@@ -0,0 +1,472 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package miniscript
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/nbcorg/btcd/btcec"
+	"github.com/nbcorg/btcd/chaincfg/chainhash"
+	"github.com/nbcorg/btcd/wire"
+	"github.com/nbcorg/btcutil"
+	"github.com/nbcorg/btcutil/chaincfg"
+	"github.com/nbcorg/btcutil/txscript"
+)
+
+// testKey returns a private key derived from the passed seed whose compressed
+// public key has the 0x03 prefix valid public keys require.
+func testKey(seed byte) *btcec.PrivateKey {
+	for i := byte(0); ; i++ {
+		b := sha256.Sum256([]byte{seed, i})
+		key, pubKey := btcec.PrivKeyFromBytes(btcec.S256(), b[:])
+		if btcutil.IsValidPubKey(pubKey.SerializeCompressed()) {
+			return key
+		}
+	}
+}
+
+var (
+	// testKeys are the keys the placeholders A to D of the test
+	// expressions stand for.
+	testKeys = []*btcec.PrivateKey{testKey(1), testKey(2), testKey(3),
+		testKey(4)}
+
+	// testPreimage is the preimage of the hashes in the test expressions.
+	testPreimage = bytes.Repeat([]byte{0x07}, 32)
+	sha256Hash   = sha256.Sum256(testPreimage)
+	hash160Hash  = btcutil.Hash160(testPreimage)
+)
+
+// pubKeys returns the serialized public keys of the test keys.
+func pubKeys() [][]byte {
+	var pubKeys [][]byte
+	for _, key := range testKeys {
+		pubKeys = append(pubKeys, key.PubKey().SerializeCompressed())
+	}
+	return pubKeys
+}
+
+// expand replaces the placeholders A to D in the passed expression with the
+// test keys, and H32 and H20 with the hashes of the test preimage.
+func expand(s string) string {
+	var oldnew []string
+	for i, pubKey := range pubKeys() {
+		oldnew = append(oldnew, string('A'+rune(i)), hex.EncodeToString(pubKey))
+	}
+	oldnew = append(oldnew, "H32", hex.EncodeToString(sha256Hash[:]),
+		"H20", hex.EncodeToString(hash160Hash))
+	return strings.NewReplacer(oldnew...).Replace(s)
+}
+
+// TestRoundTrip ensures expressions print back to themselves, and that their
+// scripts decode back to the same expressions.
+func TestRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		expr    string
+		typ     string
+		maxSize int
+		minSigs int
+	}{
+		{"pk(A)", "Bondu", 110, 1},
+		{"pkh(A)", "Bndu", 134, 1},
+		{"and_v(v:pk(A),pk(B))", "Bnu", 218, 2},
+		{"or_d(pk(A),pkh(B))", "Bdu", 173, 1},
+		{"and_v(v:pk(A),or_d(pk(B),older(144)))", "Bn", 225, 1},
+		{"thresh(2,pk(A),s:pk(B),a:pk(C))", "Bdu", 261, 2},
+		{"andor(pk(A),older(10),pk(B))", "Bd", 151, 1},
+		{"and_n(pk(A),sha256(H32))", "Bdu", 186, 1},
+		{"or_b(pk(A),s:pk(B))", "Bdu", 148, 1},
+		{"or_i(pk(A),pk(B))", "Bdu", 150, 1},
+		{"t:or_c(pk(A),v:pkh(B))", "Bu", 173, 1},
+		{"multi(2,A,B,C)", "Bndu", 254, 2},
+		{"and_v(v:multi(2,A,B,C),after(500000))", "Bn", 259, 2},
+		{"thresh(3,pk(A),s:pk(B),s:pk(C),sln:older(12960))", "Bdu", 345, 2},
+		{"or_d(multi(1,A,B),and_v(v:pk(C),older(4032)))", "B", 190, 1},
+		{"and_b(pk(A),a:hash160(H20))", "Bndu", 173, 1},
+		{"j:pk(A)", "Bondu", 114, 1},
+		{"n:pk(A)", "Bondu", 111, 1},
+		{"dv:older(1)", "Bond", 10, 0},
+		{"andor(pk(A),and_v(v:hash256(H32),pk(B)),and_v(v:ripemd160(H20),pk(C)))",
+			"Bu", 355, 1},
+		{"and_v(v:pk(A),and_v(v:pk(B),and_v(v:pk(C),pk(D))))", "Bnu", 434, 4},
+	}
+
+	for _, test := range tests {
+		expr := expand(test.expr)
+		node, err := Parse(expr)
+		if err != nil {
+			t.Errorf("Parse(%s): unexpected error: %v", test.expr, err)
+			continue
+		}
+		if got := node.String(); got != expr {
+			t.Errorf("String(%s): got %s", test.expr, got)
+		}
+		if got := node.Type().String(); got != test.typ {
+			t.Errorf("Type(%s): got %s, want %s", test.expr, got,
+				test.typ)
+		}
+		size, err := node.MaxWitnessSize()
+		if err != nil || size != test.maxSize {
+			t.Errorf("MaxWitnessSize(%s): got %d, %v, want %d",
+				test.expr, size, err, test.maxSize)
+		}
+		sigs, err := node.MinSignatures()
+		if err != nil || sigs != test.minSigs {
+			t.Errorf("MinSignatures(%s): got %d, %v, want %d",
+				test.expr, sigs, err, test.minSigs)
+		}
+
+		script, err := node.Script()
+		if err != nil {
+			t.Errorf("Script(%s): unexpected error: %v", test.expr,
+				err)
+			continue
+		}
+		decoded, err := DecodeScript(script, pubKeys())
+		if err != nil {
+			t.Errorf("DecodeScript(%s): unexpected error: %v",
+				test.expr, err)
+			continue
+		}
+		if got := decoded.String(); got != expr {
+			t.Errorf("DecodeScript(%s): got %s", test.expr, got)
+		}
+	}
+}
+
+// TestScript ensures expressions compile to the expected scripts.
+func TestScript(t *testing.T) {
+	t.Parallel()
+
+	keyA, keyB := hex.EncodeToString(pubKeys()[0]),
+		hex.EncodeToString(pubKeys()[1])
+	hashB := hex.EncodeToString(btcutil.Hash160(pubKeys()[1]))
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"and_v(v:pk(A),pkh(B))", keyA + " OP_CHECKSIGVERIFY OP_DUP " +
+			"OP_HASH160 " + hashB + " OP_EQUALVERIFY OP_CHECKSIG"},
+		{"sha256(H32)", "OP_SIZE 20 OP_EQUALVERIFY OP_SHA256 " +
+			hex.EncodeToString(sha256Hash[:]) + " OP_EQUAL"},
+		{"or_i(pk(A),pk(B))", "OP_IF " + keyA + " OP_CHECKSIG OP_ELSE " +
+			keyB + " OP_CHECKSIG OP_ENDIF"},
+		{"multi(1,A,B)", "1 " + keyA + " " + keyB + " 2 " +
+			"OP_CHECKMULTISIG"},
+		{"and_v(v:pk(A),older(144))", keyA + " OP_CHECKSIGVERIFY " +
+			"9000 OP_CHECKSEQUENCEVERIFY"},
+	}
+
+	for _, test := range tests {
+		node, err := Parse(expand(test.expr))
+		if err != nil {
+			t.Errorf("Parse(%s): unexpected error: %v", test.expr, err)
+			continue
+		}
+		script, err := node.Script()
+		if err != nil {
+			t.Errorf("Script(%s): unexpected error: %v", test.expr,
+				err)
+			continue
+		}
+		got, err := txscript.DisasmString(script)
+		if err != nil || got != test.want {
+			t.Errorf("Script(%s): got %s, %v, want %s", test.expr,
+				got, err, test.want)
+		}
+	}
+}
+
+// TestParseInvalid ensures malformed expressions are rejected with a
+// ParseError and expressions which do not pass the type system with a
+// TypeError.
+func TestParseInvalid(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		expr      string
+		typeError bool
+	}{
+		{"pk(02" + strings.Repeat("11", 32) + ")", false},
+		{"pk(A", false},
+		{"foo(A)", false},
+		{"x:pk(A)", false},
+		{"sha256(ab)", false},
+		{"multi(0,A)", false},
+		{"older(0)", false},
+		{"thresh(3,pk(A),s:pk(B))", false},
+		{"v:pk(A)", true},
+		{"and_v(pk(A),pk(B))", true},
+		{"or_b(pk(A),pk(B))", true},
+	}
+
+	for _, test := range tests {
+		_, err := Parse(expand(test.expr))
+		switch err.(type) {
+		case ParseError:
+			if test.typeError {
+				t.Errorf("Parse(%s): got ParseError %v, want "+
+					"TypeError", test.expr, err)
+			}
+		case TypeError:
+			if !test.typeError {
+				t.Errorf("Parse(%s): got TypeError %v, want "+
+					"ParseError", test.expr, err)
+			}
+		default:
+			t.Errorf("Parse(%s): got error %v", test.expr, err)
+		}
+	}
+}
+
+// TestDecodeInvalid ensures scripts which are not the canonical encoding of a
+// valid expression are rejected.
+func TestDecodeInvalid(t *testing.T) {
+	t.Parallel()
+
+	pkh, err := Parse(expand("pkh(A)"))
+	if err != nil {
+		t.Fatalf("Parse: unexpected error: %v", err)
+	}
+	pkhScript, err := pkh.Script()
+	if err != nil {
+		t.Fatalf("Script: unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		script  []byte
+		pkhKeys [][]byte
+	}{
+		{"empty", nil, nil},
+		{"no expression", []byte{txscript.OP_DUP}, nil},
+		{"two expressions", []byte{txscript.OP_1, txscript.OP_1}, nil},
+		{"non-canonical push", []byte{txscript.OP_PUSHDATA1, 0x01,
+			0x01}, nil},
+		{"unknown key hash", pkhScript, nil},
+		{"even key", append(append([]byte{txscript.OP_DATA_33, 0x02},
+			bytes.Repeat([]byte{0x11}, 32)...), txscript.OP_CHECKSIG),
+			nil},
+	}
+
+	for _, test := range tests {
+		if node, err := DecodeScript(test.script, test.pkhKeys); err ==
+			nil {
+
+			t.Errorf("DecodeScript(%s): decoded to %v", test.name,
+				node)
+		}
+	}
+}
+
+// TestDecodeRandom ensures every random script which decodes does so to an
+// expression that parses back.
+func TestDecodeRandom(t *testing.T) {
+	t.Parallel()
+
+	ops := []byte{txscript.OP_0, txscript.OP_1, txscript.OP_2,
+		txscript.OP_IF, txscript.OP_NOTIF, txscript.OP_ELSE,
+		txscript.OP_ENDIF, txscript.OP_SWAP, txscript.OP_TOALTSTACK,
+		txscript.OP_FROMALTSTACK, txscript.OP_CHECKSIG,
+		txscript.OP_CHECKSIGVERIFY, txscript.OP_VERIFY,
+		txscript.OP_EQUAL, txscript.OP_ADD, txscript.OP_BOOLAND,
+		txscript.OP_BOOLOR, txscript.OP_IFDUP, txscript.OP_DUP,
+		txscript.OP_SIZE, txscript.OP_0NOTEQUAL,
+		txscript.OP_CHECKMULTISIG, txscript.OP_CHECKSEQUENCEVERIFY,
+		txscript.OP_DATA_33}
+	pubKey := pubKeys()[0]
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 50000; i++ {
+		var script []byte
+		for j := rng.Intn(12); j >= 0; j-- {
+			op := ops[rng.Intn(len(ops))]
+			script = append(script, op)
+			if op == txscript.OP_DATA_33 {
+				script = append(script, pubKey...)
+			}
+		}
+		node, err := DecodeScript(script, nil)
+		if err != nil {
+			continue
+		}
+		if _, err := Parse(node.String()); err != nil {
+			t.Fatalf("DecodeScript(%x): decoded to %v which does "+
+				"not parse: %v", script, node, err)
+		}
+	}
+}
+
+// testSatisfier signs the input of a transaction with the keys it has and
+// provides the test preimage when it has it.
+type testSatisfier struct {
+	tx         *wire.MsgTx
+	script     []byte
+	amount     int64
+	keys       []*btcec.PrivateKey
+	preimage   bool
+	olderAfter bool
+}
+
+// Signature returns the signature of the input by the passed key, if the
+// satisfier has it.
+func (s *testSatisfier) Signature(pubKey []byte) ([]byte, bool) {
+	for _, key := range s.keys {
+		if !bytes.Equal(key.PubKey().SerializeCompressed(), pubKey) {
+			continue
+		}
+		sig, err := txscript.RawTxInWitnessSignature(s.tx, nil, 0,
+			s.amount, s.script, txscript.SigHashAll, key)
+		return sig, err == nil
+	}
+	return nil, false
+}
+
+// Preimage returns the test preimage for either of its hashes, if the
+// satisfier has it.
+func (s *testSatisfier) Preimage(hash []byte) ([]byte, bool) {
+	if !s.preimage || (!bytes.Equal(hash, sha256Hash[:]) &&
+		!bytes.Equal(hash, hash160Hash)) {
+
+		return nil, false
+	}
+	return testPreimage, true
+}
+
+// CheckOlder returns whether timelocks are satisfied.
+func (s *testSatisfier) CheckOlder(uint32) bool {
+	return s.olderAfter
+}
+
+// CheckAfter returns whether timelocks are satisfied.
+func (s *testSatisfier) CheckAfter(uint32) bool {
+	return s.olderAfter
+}
+
+// TestSatisfy ensures the witnesses built by Satisfy spend their scripts.
+func TestSatisfy(t *testing.T) {
+	t.Parallel()
+
+	a, b, c, d := testKeys[0], testKeys[1], testKeys[2], testKeys[3]
+	tests := []struct {
+		expr       string
+		keys       []*btcec.PrivateKey
+		preimage   bool
+		olderAfter bool
+		items      int
+	}{
+		{"pk(A)", []*btcec.PrivateKey{a}, false, false, 2},
+		{"pk(A)", nil, false, false, -1},
+		{"pkh(A)", []*btcec.PrivateKey{a}, false, false, 3},
+		{"or_d(pk(A),and_v(v:pk(B),older(144)))",
+			[]*btcec.PrivateKey{b}, false, false, -1},
+		{"or_d(pk(A),and_v(v:pk(B),older(144)))",
+			[]*btcec.PrivateKey{b}, false, true, 3},
+		{"or_d(pk(A),and_v(v:pk(B),older(144)))",
+			[]*btcec.PrivateKey{a, b}, false, true, 2},
+		{"multi(2,A,B,C)", []*btcec.PrivateKey{c, a}, false, false, 4},
+		{"multi(2,A,B,C)", []*btcec.PrivateKey{c}, false, false, -1},
+		{"thresh(2,pk(A),s:pk(D),a:sha256(H32))",
+			[]*btcec.PrivateKey{a}, true, false, 4},
+		{"thresh(2,pk(A),s:pk(D),a:sha256(H32))",
+			[]*btcec.PrivateKey{a, d}, false, false, 4},
+		{"or_i(pk(A),hash160(H20))", nil, true, false, 3},
+		{"and_v(v:multi(2,A,B,C),after(500000))",
+			[]*btcec.PrivateKey{a, b}, false, true, 4},
+		{"andor(pk(A),older(10),pk(B))", []*btcec.PrivateKey{b},
+			false, false, 3},
+	}
+
+	for _, test := range tests {
+		node, err := Parse(expand(test.expr))
+		if err != nil {
+			t.Errorf("Parse(%s): unexpected error: %v", test.expr, err)
+			continue
+		}
+		script, err := node.Script()
+		if err != nil {
+			t.Errorf("Script(%s): unexpected error: %v", test.expr,
+				err)
+			continue
+		}
+		pkScript, err := txscript.PayToAddrScript(mustAddress(t, node))
+		if err != nil {
+			t.Fatalf("PayToAddrScript: unexpected error: %v", err)
+		}
+
+		const amount = 100000
+		tx := wire.NewMsgTx(2)
+		tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Hash: chainhash.Hash{1}},
+			nil, nil))
+		tx.AddTxOut(wire.NewTxOut(amount-1000, pkScript))
+		if test.olderAfter {
+			tx.TxIn[0].Sequence = 144
+			tx.LockTime = 500000
+		}
+
+		witness, err := node.Satisfy(&testSatisfier{
+			tx:         tx,
+			script:     script,
+			amount:     amount,
+			keys:       test.keys,
+			preimage:   test.preimage,
+			olderAfter: test.olderAfter,
+		})
+		if test.items < 0 {
+			if err != ErrUnsatisfiable {
+				t.Errorf("Satisfy(%s): got error %v, want %v",
+					test.expr, err, ErrUnsatisfiable)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Satisfy(%s): unexpected error: %v", test.expr,
+				err)
+			continue
+		}
+		if len(witness) != test.items {
+			t.Errorf("Satisfy(%s): got %d witness items, want %d",
+				test.expr, len(witness), test.items)
+		}
+		if size, _ := node.MaxWitnessSize(); witness.SerializeSize() >
+			size {
+
+			t.Errorf("Satisfy(%s): witness of %d bytes exceeds "+
+				"MaxWitnessSize %d", test.expr,
+				witness.SerializeSize(), size)
+		}
+
+		tx.TxIn[0].Witness = witness
+		vm, err := txscript.NewEngine(pkScript, tx, 0,
+			txscript.StandardVerifyFlags, amount,
+			txscript.NewTxSigChecker(nil, nil))
+		if err != nil {
+			t.Errorf("Satisfy(%s): NewEngine: unexpected error: %v",
+				test.expr, err)
+			continue
+		}
+		if err := vm.Execute(); err != nil {
+			t.Errorf("Satisfy(%s): witness does not spend the "+
+				"script: %v", test.expr, err)
+		}
+	}
+}
+
+// mustAddress returns the pay-to-witness-script-hash address of the passed
+// node.
+func mustAddress(t *testing.T, node *Node) btcutil.Address {
+	addr, err := node.Address(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("Address(%v): unexpected error: %v", node, err)
+	}
+	return addr
+}
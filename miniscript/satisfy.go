@@ -0,0 +1,350 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package miniscript
+
+import (
+	"errors"
+
+	"github.com/nbcorg/btcd/wire"
+)
+
+const (
+	// maxSigLen is the maximum length of a DER encoded ECDSA signature
+	// with a low S value including the trailing hash type byte.
+	maxSigLen = 72
+
+	// preimageLen is the length of the preimage of every hash fragment,
+	// which its script checks with OP_SIZE.
+	preimageLen = 32
+)
+
+// ErrUnsatisfiable is returned when an expression can not be satisfied, either
+// at all or with the signatures, preimages and timelocks that are available.
+var ErrUnsatisfiable = errors.New("miniscript expression can not be satisfied")
+
+// Satisfier provides what is needed to satisfy a miniscript expression.
+type Satisfier interface {
+	// Signature returns the signature, including its hash type byte, of
+	// the spending transaction by the passed public key, if available.
+	Signature(pubKey []byte) ([]byte, bool)
+
+	// Preimage returns the 32-byte preimage of the passed hash of a hash
+	// fragment, if available.
+	Preimage(hash []byte) ([]byte, bool)
+
+	// CheckOlder returns whether the relative timelock of an older()
+	// fragment is satisfied by the sequence of the spending input.
+	CheckOlder(sequence uint32) bool
+
+	// CheckAfter returns whether the absolute timelock of an after()
+	// fragment is satisfied by the lock time of the spending transaction.
+	CheckAfter(lockTime uint32) bool
+}
+
+// witnessStack is a candidate set of witness stack items in the order they
+// appear in the witness, which is from the bottom of the stack to the top.
+// The zero value is an unavailable stack.
+type witnessStack struct {
+	ok    bool
+	items [][]byte
+	sigs  int
+}
+
+// size returns the number of bytes the stack items take up when serialized
+// in a witness, excluding the number of items.
+func (s witnessStack) size() int {
+	size := 0
+	for _, item := range s.items {
+		size += wire.VarIntSerializeSize(uint64(len(item))) + len(item)
+	}
+	return size
+}
+
+// stackOf returns an available stack made of the passed items.
+func stackOf(items ...[]byte) witnessStack {
+	return witnessStack{ok: true, items: items}
+}
+
+// then returns the stack with the items of next placed on top of it, which is
+// unavailable when either of them is.
+func (s witnessStack) then(next witnessStack) witnessStack {
+	if !s.ok || !next.ok {
+		return witnessStack{}
+	}
+	items := make([][]byte, 0, len(s.items)+len(next.items))
+	items = append(append(items, s.items...), next.items...)
+	return witnessStack{ok: true, items: items, sigs: s.sigs + next.sigs}
+}
+
+// chooser picks one of two alternative stacks.  Both are passed in order of
+// preference, and either may be unavailable.
+type chooser func(a, b witnessStack) witnessStack
+
+// available returns the only available stack of the two, if any, and whether
+// the choice was made.
+func available(a, b witnessStack) (witnessStack, bool) {
+	switch {
+	case !a.ok:
+		return b, true
+	case !b.ok:
+		return a, true
+	}
+	return witnessStack{}, false
+}
+
+// chooseSmallest prefers the stack which takes up the fewest bytes.
+func chooseSmallest(a, b witnessStack) witnessStack {
+	if s, ok := available(a, b); ok {
+		return s
+	}
+	if b.size() < a.size() {
+		return b
+	}
+	return a
+}
+
+// chooseLargest prefers the stack which takes up the most bytes.
+func chooseLargest(a, b witnessStack) witnessStack {
+	if s, ok := available(a, b); ok {
+		return s
+	}
+	if b.size() > a.size() {
+		return b
+	}
+	return a
+}
+
+// chooseFewestSigs prefers the stack with the fewest signatures and then the
+// one which takes up the fewest bytes.
+func chooseFewestSigs(a, b witnessStack) witnessStack {
+	if s, ok := available(a, b); ok {
+		return s
+	}
+	if b.sigs != a.sigs {
+		if b.sigs < a.sigs {
+			return b
+		}
+		return a
+	}
+	return chooseSmallest(a, b)
+}
+
+// satisfy returns the satisfaction and the dissatisfaction of the node with
+// what the satisfier provides, picking between alternatives with choose.
+func (n *Node) satisfy(s Satisfier, choose chooser) (witnessStack, witnessStack) {
+	var (
+		none  = stackOf()
+		empty = stackOf([]byte{})
+		one   = stackOf([]byte{1})
+	)
+
+	// sig returns a stack with the signature by the passed key.
+	sig := func(pubKey []byte) witnessStack {
+		sig, ok := s.Signature(pubKey)
+		if !ok {
+			return witnessStack{}
+		}
+		return witnessStack{ok: true, items: [][]byte{sig}, sigs: 1}
+	}
+
+	var subSat, subDsat []witnessStack
+	for _, sub := range n.subs {
+		sat, dsat := sub.satisfy(s, choose)
+		subSat = append(subSat, sat)
+		subDsat = append(subDsat, dsat)
+	}
+
+	switch n.frag {
+	case fragJust0:
+		return witnessStack{}, none
+	case fragJust1:
+		return none, witnessStack{}
+	case fragPkK:
+		return sig(n.keys[0]), empty
+	case fragPkH:
+		key := stackOf(n.keys[0])
+		return sig(n.keys[0]).then(key), empty.then(key)
+
+	case fragOlder:
+		if s.CheckOlder(uint32(n.k)) {
+			return none, witnessStack{}
+		}
+		return witnessStack{}, witnessStack{}
+	case fragAfter:
+		if s.CheckAfter(uint32(n.k)) {
+			return none, witnessStack{}
+		}
+		return witnessStack{}, witnessStack{}
+
+	case fragSha256, fragHash256, fragRipemd160, fragHash160:
+		// Any value of the right size other than the preimage
+		// dissatisfies a hash fragment.
+		dsat := stackOf(make([]byte, preimageLen))
+		preimage, ok := s.Preimage(n.data)
+		if !ok || len(preimage) != preimageLen {
+			return witnessStack{}, dsat
+		}
+		return stackOf(preimage), dsat
+
+	case fragMulti:
+		// The signatures are consumed in the order of their keys after
+		// an extra empty item due to an off-by-one bug in
+		// OP_CHECKMULTISIG.
+		sat := empty
+		for _, pubKey := range n.keys {
+			if sat.sigs == int(n.k) {
+				break
+			}
+			if keySig := sig(pubKey); keySig.ok {
+				sat = sat.then(keySig)
+			}
+		}
+		if sat.sigs < int(n.k) {
+			sat = witnessStack{}
+		}
+		dsat := empty
+		for i := int64(0); i < n.k; i++ {
+			dsat = dsat.then(empty)
+		}
+		return sat, dsat
+
+	case fragAndOr:
+		return choose(subSat[1].then(subSat[0]),
+				subSat[2].then(subDsat[0])),
+			subDsat[2].then(subDsat[0])
+
+	case fragAndV:
+		return subSat[1].then(subSat[0]), subDsat[1].then(subSat[0])
+
+	case fragAndB:
+		return subSat[1].then(subSat[0]), subDsat[1].then(subDsat[0])
+
+	case fragOrB:
+		return choose(subDsat[1].then(subSat[0]),
+				subSat[1].then(subDsat[0])),
+			subDsat[1].then(subDsat[0])
+
+	case fragOrC:
+		return choose(subSat[0], subSat[1].then(subDsat[0])),
+			witnessStack{}
+
+	case fragOrD:
+		return choose(subSat[0], subSat[1].then(subDsat[0])),
+			subDsat[1].then(subDsat[0])
+
+	case fragOrI:
+		return choose(subSat[0].then(one), subSat[1].then(empty)),
+			choose(subDsat[0].then(one), subDsat[1].then(empty))
+
+	case fragThresh:
+		// sats[j] is the best stack satisfying exactly j of the
+		// subexpressions seen so far.  The subexpressions are seen
+		// from the last to the first since the first one consumes the
+		// items on top of the stack.
+		sats := []witnessStack{none}
+		for i := len(n.subs) - 1; i >= 0; i-- {
+			next := make([]witnessStack, len(sats)+1)
+			for j := range next {
+				var dsat, sat witnessStack
+				if j < len(sats) {
+					dsat = sats[j].then(subDsat[i])
+				}
+				if j > 0 {
+					sat = sats[j-1].then(subSat[i])
+				}
+				next[j] = choose(dsat, sat)
+			}
+			sats = next
+		}
+		return sats[n.k], sats[0]
+
+	case fragWrapA, fragWrapS, fragWrapC, fragWrapN:
+		return subSat[0], subDsat[0]
+	case fragWrapD:
+		return subSat[0].then(one), empty
+	case fragWrapV:
+		return subSat[0], witnessStack{}
+	case fragWrapJ:
+		return subSat[0], empty
+	}
+
+	return witnessStack{}, witnessStack{}
+}
+
+// Satisfy returns the witness spending the pay-to-witness-script-hash output
+// of the node with the signatures, preimages and timelocks the satisfier
+// provides.  The witness is made of the smallest set of stack items that
+// satisfies the node followed by its script.  ErrUnsatisfiable is returned
+// when the satisfier does not provide enough to satisfy the node.
+//
+// Satisfactions are chosen by their size alone, so when a node may be
+// satisfied in several ways, a third party may be able to replace the
+// witness with a different valid one.
+func (n *Node) Satisfy(s Satisfier) (wire.TxWitness, error) {
+	script, err := n.Script()
+	if err != nil {
+		return nil, err
+	}
+	sat, _ := n.satisfy(s, chooseSmallest)
+	if !sat.ok {
+		return nil, ErrUnsatisfiable
+	}
+	return wire.TxWitness(append(sat.items, script)), nil
+}
+
+// anySatisfier provides placeholder signatures and preimages of the maximum
+// size for every key and hash, and satisfies every timelock.  It is used to
+// analyze all of the ways a node may be satisfied.
+type anySatisfier struct{}
+
+// Signature returns a placeholder signature of the maximum size.
+func (anySatisfier) Signature([]byte) ([]byte, bool) {
+	return make([]byte, maxSigLen), true
+}
+
+// Preimage returns a placeholder preimage.
+func (anySatisfier) Preimage([]byte) ([]byte, bool) {
+	return make([]byte, preimageLen), true
+}
+
+// CheckOlder always returns true.
+func (anySatisfier) CheckOlder(uint32) bool {
+	return true
+}
+
+// CheckAfter always returns true.
+func (anySatisfier) CheckAfter(uint32) bool {
+	return true
+}
+
+// MaxWitnessSize returns the worst-case size in bytes of the witness which
+// satisfies the node, including the number of witness items and the script.
+// Signatures are assumed to have their maximum length, so the result may be
+// used as the WitnessSize of a txscript.SpendSize when estimating fees.
+// ErrUnsatisfiable is returned when the node can not be satisfied at all.
+func (n *Node) MaxWitnessSize() (int, error) {
+	script, err := n.Script()
+	if err != nil {
+		return 0, err
+	}
+	sat, _ := n.satisfy(anySatisfier{}, chooseLargest)
+	if !sat.ok {
+		return 0, ErrUnsatisfiable
+	}
+	return wire.VarIntSerializeSize(uint64(len(sat.items)+1)) + sat.size() +
+		wire.VarIntSerializeSize(uint64(len(script))) + len(script), nil
+}
+
+// MinSignatures returns the smallest number of signatures any satisfaction of
+// the node requires, which is zero when the node can be satisfied without
+// signatures, such as with a preimage or after a timelock alone.
+// ErrUnsatisfiable is returned when the node can not be satisfied at all.
+func (n *Node) MinSignatures() (int, error) {
+	sat, _ := n.satisfy(anySatisfier{}, chooseFewestSigs)
+	if !sat.ok {
+		return 0, ErrUnsatisfiable
+	}
+	return sat.sigs, nil
+}
@@ -0,0 +1,337 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package miniscript
+
+import (
+	"fmt"
+)
+
+// BaseType is the basic type of a miniscript expression, which describes what
+// it expects on the stack and what it leaves there.
+type BaseType int
+
+// These constants define the basic types of miniscript expressions.
+const (
+	// BaseB expressions consume their inputs and push a nonzero value when
+	// satisfied or an exact zero when dissatisfied.
+	BaseB BaseType = iota
+
+	// BaseV expressions consume their inputs and push nothing, aborting
+	// the script unless they are satisfied.
+	BaseV
+
+	// BaseK expressions consume their inputs and push a public key whose
+	// signature must be checked to satisfy them.
+	BaseK
+
+	// BaseW expressions are like BaseB expressions but take their inputs
+	// from one element below the top of the stack.
+	BaseW
+)
+
+// baseTypeStrings maps the basic types to their names.
+var baseTypeStrings = map[BaseType]string{
+	BaseB: "B",
+	BaseV: "V",
+	BaseK: "K",
+	BaseW: "W",
+}
+
+// String returns the BaseType as its single letter name.
+func (t BaseType) String() string {
+	if s, ok := baseTypeStrings[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("Unknown BaseType (%d)", int(t))
+}
+
+// Properties is a set of type properties of a miniscript expression.
+type Properties uint8
+
+// These constants define the type properties of miniscript expressions.
+const (
+	// PropZ means the expression always consumes exactly 0 stack elements.
+	PropZ Properties = 1 << iota
+
+	// PropO means the expression always consumes exactly 1 stack element.
+	PropO
+
+	// PropN means the expression never consumes a zero-length top stack
+	// element when satisfied.
+	PropN
+
+	// PropD means the expression has a dissatisfaction which does not
+	// abort the script.
+	PropD
+
+	// PropU means the expression pushes exactly 1 when satisfied.
+	PropU
+)
+
+// propLetters lists the letters of the type properties in display order.
+var propLetters = []struct {
+	prop   Properties
+	letter string
+}{
+	{PropZ, "z"},
+	{PropO, "o"},
+	{PropN, "n"},
+	{PropD, "d"},
+	{PropU, "u"},
+}
+
+// Type is the type of a miniscript expression.
+type Type struct {
+	Base  BaseType
+	Props Properties
+}
+
+// Has returns whether the type has all of the passed properties.
+func (t Type) Has(props Properties) bool {
+	return t.Props&props == props
+}
+
+// String returns the type as its basic type followed by the letters of its
+// properties, such as "Bdu".
+func (t Type) String() string {
+	s := t.Base.String()
+	for _, p := range propLetters {
+		if t.Has(p.prop) {
+			s += p.letter
+		}
+	}
+	return s
+}
+
+// when returns props if cond is true and no properties otherwise.
+func when(cond bool, props Properties) Properties {
+	if cond {
+		return props
+	}
+	return 0
+}
+
+// typeCheck computes the types of the node and all of its subexpressions,
+// returning a TypeError when any of them breaks the rules of the type system.
+func (n *Node) typeCheck() error {
+	for _, sub := range n.subs {
+		if err := sub.typeCheck(); err != nil {
+			return err
+		}
+	}
+
+	// require returns an error unless the i-th subexpression has the passed
+	// basic type and properties.
+	require := func(i int, base BaseType, props Properties) error {
+		sub := n.subs[i].typ
+		if sub.Base != base || !sub.Has(props) {
+			want := Type{Base: base, Props: props}
+			str := fmt.Sprintf("%v: argument %d has type %v, which "+
+				"is not %v", n, i+1, sub, want)
+			return TypeError(str)
+		}
+		return nil
+	}
+
+	// requireBKV returns an error unless the i-th subexpression has the
+	// passed basic type, which must be B, K or V.
+	requireBKV := func(i int, base BaseType) error {
+		if base != BaseB && base != BaseK && base != BaseV {
+			str := fmt.Sprintf("%v: argument %d has type %v, which "+
+				"is not B, K or V", n, i+1, n.subs[i].typ)
+			return TypeError(str)
+		}
+		return require(i, base, 0)
+	}
+
+	var x, y, z Type
+	if len(n.subs) > 0 {
+		x = n.subs[0].typ
+	}
+	if len(n.subs) > 1 {
+		y = n.subs[1].typ
+	}
+	if len(n.subs) > 2 {
+		z = n.subs[2].typ
+	}
+
+	var t Type
+	switch n.frag {
+	case fragJust0:
+		t = Type{BaseB, PropZ | PropU | PropD}
+	case fragJust1:
+		t = Type{BaseB, PropZ | PropU}
+	case fragPkK:
+		t = Type{BaseK, PropO | PropN | PropD | PropU}
+	case fragPkH:
+		t = Type{BaseK, PropN | PropD | PropU}
+	case fragOlder, fragAfter:
+		t = Type{BaseB, PropZ}
+	case fragSha256, fragHash256, fragRipemd160, fragHash160:
+		t = Type{BaseB, PropO | PropN | PropD | PropU}
+	case fragMulti:
+		t = Type{BaseB, PropN | PropD | PropU}
+
+	case fragAndOr:
+		if err := require(0, BaseB, PropD|PropU); err != nil {
+			return err
+		}
+		if err := requireBKV(1, y.Base); err != nil {
+			return err
+		}
+		if err := require(2, y.Base, 0); err != nil {
+			return err
+		}
+		t = Type{y.Base,
+			when(x.Has(PropZ) && y.Has(PropZ) && z.Has(PropZ), PropZ) |
+				when(x.Has(PropZ) && y.Has(PropO) && z.Has(PropO) ||
+					x.Has(PropO) && y.Has(PropZ) && z.Has(PropZ), PropO) |
+				when(y.Has(PropU) && z.Has(PropU), PropU) |
+				when(z.Has(PropD), PropD)}
+
+	case fragAndV:
+		if err := require(0, BaseV, 0); err != nil {
+			return err
+		}
+		if err := requireBKV(1, y.Base); err != nil {
+			return err
+		}
+		t = Type{y.Base,
+			when(x.Has(PropZ) && y.Has(PropZ), PropZ) |
+				when(x.Has(PropZ) && y.Has(PropO) ||
+					x.Has(PropO) && y.Has(PropZ), PropO) |
+				when(x.Has(PropN) || x.Has(PropZ) && y.Has(PropN), PropN) |
+				when(y.Has(PropU), PropU)}
+
+	case fragAndB:
+		if err := require(0, BaseB, 0); err != nil {
+			return err
+		}
+		if err := require(1, BaseW, 0); err != nil {
+			return err
+		}
+		t = Type{BaseB,
+			when(x.Has(PropZ) && y.Has(PropZ), PropZ) |
+				when(x.Has(PropZ) && y.Has(PropO) ||
+					x.Has(PropO) && y.Has(PropZ), PropO) |
+				when(x.Has(PropN) || x.Has(PropZ) && y.Has(PropN), PropN) |
+				when(x.Has(PropD) && y.Has(PropD), PropD) | PropU}
+
+	case fragOrB:
+		if err := require(0, BaseB, PropD); err != nil {
+			return err
+		}
+		if err := require(1, BaseW, PropD); err != nil {
+			return err
+		}
+		t = Type{BaseB,
+			when(x.Has(PropZ) && y.Has(PropZ), PropZ) |
+				when(x.Has(PropZ) && y.Has(PropO) ||
+					x.Has(PropO) && y.Has(PropZ), PropO) |
+				PropD | PropU}
+
+	case fragOrC:
+		if err := require(0, BaseB, PropD|PropU); err != nil {
+			return err
+		}
+		if err := require(1, BaseV, 0); err != nil {
+			return err
+		}
+		t = Type{BaseV,
+			when(x.Has(PropZ) && y.Has(PropZ), PropZ) |
+				when(x.Has(PropO) && y.Has(PropZ), PropO)}
+
+	case fragOrD:
+		if err := require(0, BaseB, PropD|PropU); err != nil {
+			return err
+		}
+		if err := require(1, BaseB, 0); err != nil {
+			return err
+		}
+		t = Type{BaseB,
+			when(x.Has(PropZ) && y.Has(PropZ), PropZ) |
+				when(x.Has(PropO) && y.Has(PropZ), PropO) |
+				when(y.Has(PropD), PropD) |
+				when(y.Has(PropU), PropU)}
+
+	case fragOrI:
+		if err := requireBKV(0, x.Base); err != nil {
+			return err
+		}
+		if err := require(1, x.Base, 0); err != nil {
+			return err
+		}
+		t = Type{x.Base,
+			when(x.Has(PropZ) && y.Has(PropZ), PropO) |
+				when(x.Has(PropU) && y.Has(PropU), PropU) |
+				when(x.Has(PropD) || y.Has(PropD), PropD)}
+
+	case fragThresh:
+		numZ, numO := 0, 0
+		for i, sub := range n.subs {
+			base := BaseW
+			if i == 0 {
+				base = BaseB
+			}
+			if err := require(i, base, PropD|PropU); err != nil {
+				return err
+			}
+			if sub.typ.Has(PropZ) {
+				numZ++
+			} else if sub.typ.Has(PropO) {
+				numO++
+			}
+		}
+		t = Type{BaseB,
+			when(numZ == len(n.subs), PropZ) |
+				when(numZ == len(n.subs)-1 && numO == 1, PropO) |
+				PropD | PropU}
+
+	case fragWrapA:
+		if err := require(0, BaseB, 0); err != nil {
+			return err
+		}
+		t = Type{BaseW, x.Props & (PropD | PropU)}
+
+	case fragWrapS:
+		if err := require(0, BaseB, PropO); err != nil {
+			return err
+		}
+		t = Type{BaseW, x.Props & (PropD | PropU)}
+
+	case fragWrapC:
+		if err := require(0, BaseK, 0); err != nil {
+			return err
+		}
+		t = Type{BaseB, x.Props&(PropO|PropN|PropD) | PropU}
+
+	case fragWrapD:
+		if err := require(0, BaseV, PropZ); err != nil {
+			return err
+		}
+		t = Type{BaseB, PropO | PropN | PropD}
+
+	case fragWrapV:
+		if err := require(0, BaseB, 0); err != nil {
+			return err
+		}
+		t = Type{BaseV, x.Props & (PropZ | PropO | PropN)}
+
+	case fragWrapJ:
+		if err := require(0, BaseB, PropN); err != nil {
+			return err
+		}
+		t = Type{BaseB, x.Props&(PropO|PropU) | PropN | PropD}
+
+	case fragWrapN:
+		if err := require(0, BaseB, 0); err != nil {
+			return err
+		}
+		t = Type{BaseB, x.Props&(PropZ|PropO|PropN|PropD) | PropU}
+	}
+
+	n.typ = t
+	return nil
+}
@@ -0,0 +1,175 @@
+// Copyright (c) 2018 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package psbt
+
+// The Combiner merges the key-value pairs of several PSBTs for the same
+// unsigned transaction into a single one, for example to gather the partial
+// signatures produced by several signers.
+
+import (
+	"bytes"
+)
+
+// hasPartialSig returns whether there is a partial signature for the passed
+// public key among the passed partial signatures.
+func hasPartialSig(partialSigs []*PartialSig, pubKey []byte) bool {
+	for _, ps := range partialSigs {
+		if bytes.Equal(ps.PubKey, pubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeBip32Derivations returns the passed derivations along with the ones
+// from other for public keys that are not yet present.
+func mergeBip32Derivations(derivations,
+	other []*Bip32Derivation) []*Bip32Derivation {
+
+	for _, od := range other {
+		found := false
+		for _, d := range derivations {
+			if bytes.Equal(d.PubKey, od.PubKey) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			derivations = append(derivations, od)
+		}
+	}
+	return derivations
+}
+
+// mergeUnknowns returns the passed unknowns along with the ones from other
+// for keys that are not yet present.
+func mergeUnknowns(unknowns, other []*Unknown) []*Unknown {
+	for _, ou := range other {
+		found := false
+		for _, u := range unknowns {
+			if bytes.Equal(u.Key, ou.Key) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			unknowns = append(unknowns, ou)
+		}
+	}
+	return unknowns
+}
+
+// merge adds the key-value pairs of other that are missing from pi.
+func (pi *PInput) merge(other *PInput) {
+	if pi.NonWitnessUtxo == nil {
+		pi.NonWitnessUtxo = other.NonWitnessUtxo
+	}
+	if pi.WitnessUtxo == nil {
+		pi.WitnessUtxo = other.WitnessUtxo
+	}
+	for _, ps := range other.PartialSigs {
+		if !hasPartialSig(pi.PartialSigs, ps.PubKey) {
+			pi.PartialSigs = append(pi.PartialSigs, ps)
+		}
+	}
+	if pi.SighashType == 0 {
+		pi.SighashType = other.SighashType
+	}
+	if pi.RedeemScript == nil {
+		pi.RedeemScript = other.RedeemScript
+	}
+	if pi.WitnessScript == nil {
+		pi.WitnessScript = other.WitnessScript
+	}
+	pi.Bip32Derivation = mergeBip32Derivations(
+		pi.Bip32Derivation, other.Bip32Derivation,
+	)
+	pi.Unknowns = mergeUnknowns(pi.Unknowns, other.Unknowns)
+
+	// A finalized input only keeps its utxo and final fields, so the final
+	// fields of either input replace everything else.
+	if pi.FinalScriptSig == nil && pi.FinalScriptWitness == nil &&
+		(other.FinalScriptSig != nil || other.FinalScriptWitness != nil) {
+
+		pi.FinalScriptSig = other.FinalScriptSig
+		pi.FinalScriptWitness = other.FinalScriptWitness
+	}
+	if pi.FinalScriptSig != nil || pi.FinalScriptWitness != nil {
+		pi.PartialSigs = nil
+		pi.SighashType = 0
+		pi.RedeemScript = nil
+		pi.WitnessScript = nil
+		pi.Bip32Derivation = nil
+	}
+}
+
+// merge adds the key-value pairs of other that are missing from po.
+func (po *POutput) merge(other *POutput) {
+	if po.RedeemScript == nil {
+		po.RedeemScript = other.RedeemScript
+	}
+	if po.WitnessScript == nil {
+		po.WitnessScript = other.WitnessScript
+	}
+	po.Bip32Derivation = mergeBip32Derivations(
+		po.Bip32Derivation, other.Bip32Derivation,
+	)
+}
+
+// Combine returns a new PSBT holding the union of the key-value pairs of the
+// passed PSBTs, which serves the role of the Combiner.  When several PSBTs
+// have a value for the same key, the value of the first one is kept.  The
+// passed PSBTs are not modified, although the returned one shares the values
+// of their key-value pairs.
+//
+// ErrIncompatiblePackets is returned when the PSBTs are not all for the same
+// unsigned transaction.
+func Combine(packets ...*Packet) (*Packet, error) {
+	if len(packets) == 0 {
+		return nil, ErrInvalidPsbtFormat
+	}
+
+	first := packets[0]
+	txHash := first.UnsignedTx.TxHash()
+	combined := &Packet{
+		UnsignedTx: first.UnsignedTx.Copy(),
+		Inputs:     make([]PInput, len(first.Inputs)),
+		Outputs:    make([]POutput, len(first.Outputs)),
+	}
+	for _, p := range packets {
+		// The unsigned transaction has no signature scripts or
+		// witnesses, so its hash commits to all of it.
+		if p.UnsignedTx.TxHash() != txHash ||
+			len(p.Inputs) != len(combined.Inputs) ||
+			len(p.Outputs) != len(combined.Outputs) {
+
+			return nil, ErrIncompatiblePackets
+		}
+
+		for i := range p.Inputs {
+			combined.Inputs[i].merge(&p.Inputs[i])
+		}
+		for i := range p.Outputs {
+			combined.Outputs[i].merge(&p.Outputs[i])
+		}
+		for _, u := range p.Unknowns {
+			found := false
+			for _, cu := range combined.Unknowns {
+				if bytes.Equal(cu.Key, u.Key) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				combined.Unknowns = append(combined.Unknowns, u)
+			}
+		}
+	}
+
+	if err := combined.SanityCheck(); err != nil {
+		return nil, err
+	}
+	return combined, nil
+}
@@ -0,0 +1,308 @@
+// Copyright (c) 2018 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package psbt
+
+// The Finalizer requires provision of a single PSBT input in which all
+// necessary signatures are encoded, and uses it to construct valid final
+// sigScript and scriptWitness fields.  The script that has to be satisfied is
+// identified with txscript.GetScriptClass, and pay-to-pubkey,
+// pay-to-pubkey-hash and multisig scripts are supported, whether they are
+// used directly, as a redeem script or as a witness script.
+
+import (
+	"bytes"
+
+	"github.com/nbcorg/btcutil"
+	"github.com/nbcorg/btcutil/txscript"
+)
+
+// isFinalized considers this input finalized if it contains at least one of
+// the FinalScriptSig or FinalScriptWitness are filled (which only occurs in a
+// successful call to Finalize*).
+func isFinalized(p *Packet, inIndex int) bool {
+	input := p.Inputs[inIndex]
+	return input.FinalScriptSig != nil || input.FinalScriptWitness != nil
+}
+
+// prevOutScript returns the public key script of the output spent by the
+// input at index inIndex, taken from whichever utxo field the input has.
+func prevOutScript(p *Packet, inIndex int) ([]byte, error) {
+	pInput := &p.Inputs[inIndex]
+	switch {
+	case pInput.WitnessUtxo != nil:
+		return pInput.WitnessUtxo.PkScript, nil
+
+	case pInput.NonWitnessUtxo != nil:
+		outIndex := p.UnsignedTx.TxIn[inIndex].PreviousOutPoint.Index
+		if int(outIndex) >= len(pInput.NonWitnessUtxo.TxOut) {
+			return nil, ErrInvalidPrevOutNonWitnessTransaction
+		}
+		return pInput.NonWitnessUtxo.TxOut[outIndex].PkScript, nil
+	}
+
+	return nil, ErrNotFinalizable
+}
+
+// satisfyScript returns the stack items which satisfy the passed script using
+// the passed partial signatures, in the order they are pushed.  Only
+// pay-to-pubkey, pay-to-pubkey-hash and multisig scripts are supported, and
+// ErrNotFinalizable is returned when there are not enough signatures.
+func satisfyScript(script []byte, partialSigs []*PartialSig) ([][]byte, error) {
+	// findSig returns the signature for the passed public key, if there
+	// is one.
+	findSig := func(pubKey []byte) []byte {
+		for _, ps := range partialSigs {
+			if bytes.Equal(ps.PubKey, pubKey) {
+				return ps.Signature
+			}
+		}
+		return nil
+	}
+
+	switch txscript.GetScriptClass(script) {
+	case txscript.PubKeyTy:
+		pushes, err := txscript.PushedData(script)
+		if err != nil {
+			return nil, err
+		}
+		sig := findSig(pushes[0])
+		if sig == nil {
+			return nil, ErrNotFinalizable
+		}
+		return [][]byte{sig}, nil
+
+	case txscript.PubKeyHashTy:
+		pushes, err := txscript.PushedData(script)
+		if err != nil {
+			return nil, err
+		}
+		for _, ps := range partialSigs {
+			if bytes.Equal(btcutil.Hash160(ps.PubKey), pushes[0]) {
+				return [][]byte{ps.Signature, ps.PubKey}, nil
+			}
+		}
+		return nil, ErrNotFinalizable
+
+	case txscript.MultiSigTy:
+		_, numSigs, err := txscript.CalcMultiSigStats(script)
+		if err != nil {
+			return nil, err
+		}
+		pubKeys, err := txscript.PushedData(script)
+		if err != nil {
+			return nil, err
+		}
+
+		// The signatures have to be in the same order as the public
+		// keys in the script, and OP_CHECKMULTISIG consumes an extra
+		// empty item due to an off-by-one bug.
+		items := [][]byte{nil}
+		for _, pubKey := range pubKeys {
+			if len(items) == numSigs+1 {
+				break
+			}
+			if sig := findSig(pubKey); sig != nil {
+				items = append(items, sig)
+			}
+		}
+		if len(items) != numSigs+1 {
+			return nil, ErrNotFinalizable
+		}
+		return items, nil
+	}
+
+	return nil, ErrUnsupportedScriptType
+}
+
+// finalScripts returns the final sigScript and serialized scriptWitness of
+// the input at index inIndex, either of which is nil when the input does not
+// need it.
+func finalScripts(p *Packet, inIndex int) ([]byte, []byte, error) {
+	pInput := &p.Inputs[inIndex]
+	pkScript, err := prevOutScript(p, inIndex)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// A pay-to-script-hash output is spent with a sigScript ending in a
+	// push of the redeem script, which is then satisfied instead of the
+	// public key script itself.
+	script := pkScript
+	var redeemScriptPush []byte
+	if txscript.IsPayToScriptHash(pkScript) {
+		if pInput.RedeemScript == nil {
+			return nil, nil, ErrNotFinalizable
+		}
+		redeemScriptPush, err = txscript.NewScriptBuilder().
+			AddData(pInput.RedeemScript).Script()
+		if err != nil {
+			return nil, nil, err
+		}
+		script = pInput.RedeemScript
+	} else if pInput.RedeemScript != nil {
+		return nil, nil, ErrNotFinalizable
+	}
+
+	switch txscript.GetScriptClass(script) {
+	// Witness programs are satisfied by their witness, and only the
+	// redeem script is pushed by the sigScript when they are nested.
+	case txscript.WitnessV0PubKeyHashTy:
+		if pInput.WitnessScript != nil {
+			return nil, nil, ErrNotFinalizable
+		}
+		for _, ps := range pInput.PartialSigs {
+			pubKeyHash := btcutil.Hash160(ps.PubKey)
+			if bytes.Equal(pubKeyHash, script[2:]) {
+				witness, err := writePKHWitness(
+					ps.Signature, ps.PubKey,
+				)
+				if err != nil {
+					return nil, nil, err
+				}
+				return redeemScriptPush, witness, nil
+			}
+		}
+		return nil, nil, ErrNotFinalizable
+
+	case txscript.WitnessV0ScriptHashTy:
+		if pInput.WitnessScript == nil {
+			return nil, nil, ErrNotFinalizable
+		}
+		items, err := satisfyScript(
+			pInput.WitnessScript, pInput.PartialSigs,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		items = append(items, pInput.WitnessScript)
+
+		var buf bytes.Buffer
+		if err := writeTxWitness(&buf, items); err != nil {
+			return nil, nil, err
+		}
+		return redeemScriptPush, buf.Bytes(), nil
+	}
+
+	// Everything else is satisfied by the sigScript alone.
+	if pInput.WitnessScript != nil {
+		return nil, nil, ErrNotFinalizable
+	}
+	items, err := satisfyScript(script, pInput.PartialSigs)
+	if err != nil {
+		return nil, nil, err
+	}
+	builder := txscript.NewScriptBuilder()
+	for _, item := range items {
+		if item == nil {
+			builder.AddOp(txscript.OP_0)
+			continue
+		}
+		builder.AddData(item)
+	}
+	sigScript, err := builder.Script()
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(sigScript, redeemScriptPush...), nil, nil
+}
+
+// isFinalizable checks whether the structure of the entry for the input of the
+// psbt.Packet at index inIndex contains sufficient information to finalize
+// this input.
+func isFinalizable(p *Packet, inIndex int) bool {
+	// The input cannot be finalized without any signatures
+	if len(p.Inputs[inIndex].PartialSigs) == 0 {
+		return false
+	}
+
+	_, _, err := finalScripts(p, inIndex)
+	return err == nil
+}
+
+// MaybeFinalize attempts to finalize the input at index inIndex in the PSBT p,
+// returning true with no error if it succeeds, OR if the input has already
+// been finalized.
+func MaybeFinalize(p *Packet, inIndex int) (bool, error) {
+	if isFinalized(p, inIndex) {
+		return true, nil
+	}
+
+	if !isFinalizable(p, inIndex) {
+		return false, ErrNotFinalizable
+	}
+
+	if err := Finalize(p, inIndex); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// MaybeFinalizeAll attempts to finalize all inputs of the psbt.Packet that are
+// not already finalized, and returns an error if it fails to do so.
+func MaybeFinalizeAll(p *Packet) error {
+	for i := range p.UnsignedTx.TxIn {
+		success, err := MaybeFinalize(p, i)
+		if err != nil {
+			return err
+		}
+		if !success {
+			return ErrNotFinalizable
+		}
+	}
+
+	return nil
+}
+
+// Finalize assumes that the provided psbt.Packet struct has all partial
+// signatures and redeem scripts/witness scripts already prepared for the
+// specified input, and so removes all temporary data and replaces them with
+// completed sigScript and witness fields, which are stored in key-types 07 and
+// 08. The witness/non-witness utxo fields in the inputs (key-types 00 and 01)
+// are left intact as they may be needed for validation.  If there is any
+// invalid or incomplete data, an error is returned.
+func Finalize(p *Packet, inIndex int) error {
+	if inIndex < 0 || inIndex >= len(p.Inputs) {
+		return ErrInvalidPsbtFormat
+	}
+
+	// If this input has already been finalized, then we'll return an error
+	// as we can't proceed.
+	if isFinalized(p, inIndex) {
+		return ErrInputAlreadyFinalized
+	}
+
+	// Every signature must use the sighash type required by the input.
+	pInput := &p.Inputs[inIndex]
+	for _, ps := range pInput.PartialSigs {
+		if !checkSigHashFlags(ps.Signature, pInput) {
+			return ErrInvalidSigHashFlags
+		}
+	}
+
+	sigScript, witness, err := finalScripts(p, inIndex)
+	if err != nil {
+		return err
+	}
+
+	// Remove all fields other than the utxo fields (00 and 01) and the
+	// final scriptSig and scriptWitness fields (07 and 08), then overwrite
+	// the entry in the input list at the correct index.
+	newInput := NewPsbtInput(pInput.NonWitnessUtxo, pInput.WitnessUtxo)
+	if witness != nil && newInput.WitnessUtxo == nil {
+		// Only the spent output is kept for witness inputs, since the
+		// sanity checks don't allow a witness without it.
+		outIndex := p.UnsignedTx.TxIn[inIndex].PreviousOutPoint.Index
+		newInput.WitnessUtxo = pInput.NonWitnessUtxo.TxOut[outIndex]
+		newInput.NonWitnessUtxo = nil
+	}
+	newInput.FinalScriptSig = sigScript
+	newInput.FinalScriptWitness = witness
+	p.Inputs[inIndex] = *newInput
+
+	// Before returning we sanity check the PSBT to ensure we don't extract
+	// an invalid transaction or produce an invalid intermediate state.
+	return p.SanityCheck()
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2018 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package psbt
+
+import (
+	"bytes"
+
+	"github.com/nbcorg/btcutil"
+	"github.com/nbcorg/btcutil/txscript"
+)
+
+// PartialSig encapsulate a (public key, signature) pair, note that the fields
+// are stored as byte slices; compliance with the serialization rules of this
+// chain is enforced with .checkValid()
+type PartialSig struct {
+	PubKey    []byte
+	Signature []byte
+}
+
+// PartialSigSorter implements sort.Interface for PartialSig.
+type PartialSigSorter []*PartialSig
+
+func (s PartialSigSorter) Len() int { return len(s) }
+
+func (s PartialSigSorter) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+func (s PartialSigSorter) Less(i, j int) bool {
+	return bytes.Compare(s[i].PubKey, s[j].PubKey) < 0
+}
+
+// validateSignature checks that the passed byte slice looks like a signature
+// as it would be pushed to the stack, which is the signature itself followed
+// by a sighash flag byte that is defined.  It does *not* of course validate
+// the signature against any message or public key.
+func validateSignature(sig []byte) bool {
+	if len(sig) < 2 {
+		return false
+	}
+
+	hashType := txscript.SigHashType(sig[len(sig)-1])
+	switch hashType &^ txscript.SigHashAnyOneCanPay {
+	case txscript.SigHashAll, txscript.SigHashNone, txscript.SigHashSingle:
+		return true
+	}
+	return false
+}
+
+// checkValid checks that both the pbukey and sig are valid. See the methods
+// (PartialSig, btcutil.IsValidPubKey, validateSignature) for more details.
+func (ps *PartialSig) checkValid() bool {
+	return btcutil.IsValidPubKey(ps.PubKey) && validateSignature(ps.Signature)
+}
@@ -0,0 +1,371 @@
+// Copyright (c) 2018 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package psbt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+
+	"github.com/nbcorg/btcd/btcec"
+	"github.com/nbcorg/btcd/chaincfg/chainhash"
+	"github.com/nbcorg/btcd/wire"
+	"github.com/nbcorg/btcutil"
+	"github.com/nbcorg/btcutil/chaincfg"
+	"github.com/nbcorg/btcutil/txscript"
+)
+
+// testKey returns a private key derived from the passed seed whose compressed
+// public key has the 0x03 prefix valid public keys require.
+func testKey(seed byte) *btcec.PrivateKey {
+	for i := byte(0); ; i++ {
+		b := sha256.Sum256([]byte{seed, i})
+		key, pubKey := btcec.PrivKeyFromBytes(btcec.S256(), b[:])
+		if btcutil.IsValidPubKey(pubKey.SerializeCompressed()) {
+			return key
+		}
+	}
+}
+
+// pubKey returns the compressed public key of the passed private key.
+func pubKey(key *btcec.PrivateKey) []byte {
+	return key.PubKey().SerializeCompressed()
+}
+
+// mustScript returns the script built by the passed builder and will panic if
+// there is an error.
+func mustScript(builder *txscript.ScriptBuilder) []byte {
+	script, err := builder.Script()
+	if err != nil {
+		panic(err)
+	}
+	return script
+}
+
+// testSpend describes the outputs spent by the transaction of the tests along
+// with the keys which sign them.
+type testSpend struct {
+	keys        []*btcec.PrivateKey
+	multiSig    []byte
+	p2wsh       []byte
+	p2wpkh      []byte
+	p2shP2wpkh  []byte
+	p2pkh       []byte
+	prevTx      *wire.MsgTx
+	witnessOuts []*wire.TxOut
+}
+
+// newTestSpend returns the outputs spent by the transaction of the tests,
+// which are a 2-of-3 multisig pay-to-witness-script-hash output, a
+// pay-to-witness-pubkey-hash output, the same nested in a pay-to-script-hash
+// output, and a pay-to-pubkey-hash output of a previous transaction.
+func newTestSpend(t *testing.T) *testSpend {
+	s := &testSpend{}
+	for i := byte(0); i < 6; i++ {
+		s.keys = append(s.keys, testKey(i))
+	}
+
+	var addrs []*btcutil.AddressPubKey
+	for _, key := range s.keys[:3] {
+		addr, err := btcutil.NewAddressPubKey(pubKey(key),
+			&chaincfg.MainNetParams)
+		if err != nil {
+			t.Fatalf("NewAddressPubKey: unexpected error: %v", err)
+		}
+		addrs = append(addrs, addr)
+	}
+	var err error
+	s.multiSig, err = txscript.MultiSigScript(addrs, 2)
+	if err != nil {
+		t.Fatalf("MultiSigScript: unexpected error: %v", err)
+	}
+	scriptHash := sha256.Sum256(s.multiSig)
+	s.p2wsh = mustScript(txscript.NewScriptBuilder().
+		AddOp(txscript.OP_0).AddData(scriptHash[:]))
+	s.p2wpkh = mustScript(txscript.NewScriptBuilder().
+		AddOp(txscript.OP_0).AddData(btcutil.Hash160(pubKey(s.keys[3]))))
+	p2shWitness := mustScript(txscript.NewScriptBuilder().
+		AddOp(txscript.OP_0).AddData(btcutil.Hash160(pubKey(s.keys[4]))))
+	s.p2shP2wpkh = mustScript(txscript.NewScriptBuilder().
+		AddOp(txscript.OP_HASH160).
+		AddData(btcutil.Hash160(p2shWitness)).AddOp(txscript.OP_EQUAL))
+	s.p2pkh = mustScript(txscript.NewScriptBuilder().
+		AddOp(txscript.OP_DUP).AddOp(txscript.OP_HASH160).
+		AddData(btcutil.Hash160(pubKey(s.keys[5]))).
+		AddOp(txscript.OP_EQUALVERIFY).AddOp(txscript.OP_CHECKSIG))
+
+	s.prevTx = wire.NewMsgTx(1)
+	s.prevTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, nil))
+	s.prevTx.AddTxOut(wire.NewTxOut(5000, s.p2pkh))
+	s.witnessOuts = []*wire.TxOut{
+		wire.NewTxOut(100000, s.p2wsh),
+		wire.NewTxOut(20000, s.p2wpkh),
+		wire.NewTxOut(30000, s.p2shP2wpkh),
+	}
+	return s
+}
+
+// newPacket returns an updated packet spending the outputs of the passed
+// spend.
+func (s *testSpend) newPacket(t *testing.T) *Packet {
+	prevHash := s.prevTx.TxHash()
+	inputs := []*wire.OutPoint{
+		{Hash: chainhash.Hash{0x01}, Index: 0},
+		{Hash: chainhash.Hash{0x01}, Index: 1},
+		{Hash: chainhash.Hash{0x01}, Index: 2},
+		{Hash: prevHash, Index: 0},
+	}
+	outputs := []*wire.TxOut{wire.NewTxOut(150000, s.p2wpkh)}
+	p, err := New(inputs, outputs, 2, 0, []uint32{0, 0, 0, 0})
+	if err != nil {
+		t.Fatalf("New: unexpected error: %v", err)
+	}
+
+	u, err := NewUpdater(p)
+	if err != nil {
+		t.Fatalf("NewUpdater: unexpected error: %v", err)
+	}
+	for i, out := range s.witnessOuts {
+		if err := u.AddInWitnessUtxo(out, i); err != nil {
+			t.Fatalf("AddInWitnessUtxo(%d): unexpected error: %v", i,
+				err)
+		}
+	}
+	if err := u.AddInNonWitnessUtxo(s.prevTx, 3); err != nil {
+		t.Fatalf("AddInNonWitnessUtxo: unexpected error: %v", err)
+	}
+	if err := u.AddInWitnessScript(s.multiSig, 0); err != nil {
+		t.Fatalf("AddInWitnessScript: unexpected error: %v", err)
+	}
+	p2shWitness := mustScript(txscript.NewScriptBuilder().
+		AddOp(txscript.OP_0).AddData(btcutil.Hash160(pubKey(s.keys[4]))))
+	if err := u.AddInRedeemScript(p2shWitness, 2); err != nil {
+		t.Fatalf("AddInRedeemScript: unexpected error: %v", err)
+	}
+	err = u.AddInBip32Derivation(0xdeadbeef, []uint32{0x8000002c, 1},
+		pubKey(s.keys[0]), 0)
+	if err != nil {
+		t.Fatalf("AddInBip32Derivation: unexpected error: %v", err)
+	}
+	return p
+}
+
+// sign adds the signature of input idx of the passed packet by the passed key.
+func (s *testSpend) sign(t *testing.T, p *Packet, idx int,
+	key *btcec.PrivateKey) {
+
+	tx := p.UnsignedTx
+	var sig []byte
+	var err error
+	switch idx {
+	case 0:
+		sig, err = txscript.RawTxInWitnessSignature(tx, nil, idx,
+			s.witnessOuts[0].Value, s.multiSig, txscript.SigHashAll,
+			key)
+	case 1, 2:
+		// The script code of a pay-to-witness-pubkey-hash input is
+		// the pay-to-pubkey-hash script of its key.
+		scriptCode := mustScript(txscript.NewScriptBuilder().
+			AddOp(txscript.OP_DUP).AddOp(txscript.OP_HASH160).
+			AddData(btcutil.Hash160(pubKey(key))).
+			AddOp(txscript.OP_EQUALVERIFY).
+			AddOp(txscript.OP_CHECKSIG))
+		sig, err = txscript.RawTxInWitnessSignature(tx, nil, idx,
+			s.witnessOuts[idx].Value, scriptCode,
+			txscript.SigHashAll, key)
+	default:
+		sig, err = txscript.RawTxInSignature(tx, idx, s.p2pkh,
+			txscript.SigHashAll, key)
+	}
+	if err != nil {
+		t.Fatalf("input %d: signing failed: %v", idx, err)
+	}
+
+	u, err := NewUpdater(p)
+	if err != nil {
+		t.Fatalf("NewUpdater: unexpected error: %v", err)
+	}
+	outcome, err := u.Sign(idx, sig, pubKey(key), nil, nil)
+	if err != nil || outcome != SignSuccesful {
+		t.Fatalf("Sign(%d): got %v, %v, want %v", idx, outcome, err,
+			SignSuccesful)
+	}
+}
+
+// TestSignCombineFinalize ensures a packet signed by two signers combines,
+// finalizes and extracts to a transaction whose scripts execute successfully.
+func TestSignCombineFinalize(t *testing.T) {
+	t.Parallel()
+
+	s := newTestSpend(t)
+	p := s.newPacket(t)
+	b64, err := p.B64Encode()
+	if err != nil {
+		t.Fatalf("B64Encode: unexpected error: %v", err)
+	}
+
+	// Every signer works on its own copy of the packet.
+	decode := func() *Packet {
+		p, err := NewFromRawBytes(bytes.NewReader([]byte(b64)), true)
+		if err != nil {
+			t.Fatalf("NewFromRawBytes: unexpected error: %v", err)
+		}
+		return p
+	}
+	p1, p2 := decode(), decode()
+	s.sign(t, p1, 0, s.keys[2])
+	s.sign(t, p1, 1, s.keys[3])
+	s.sign(t, p2, 0, s.keys[0])
+	s.sign(t, p2, 2, s.keys[4])
+	s.sign(t, p2, 3, s.keys[5])
+
+	// Neither packet alone is complete.
+	if err := MaybeFinalizeAll(p1); err == nil {
+		t.Fatalf("MaybeFinalizeAll: finalized an incomplete packet")
+	}
+	if _, err := Extract(p2); err != ErrIncompletePSBT {
+		t.Fatalf("Extract: got error %v, want %v", err,
+			ErrIncompletePSBT)
+	}
+
+	combined, err := Combine(p1, p2)
+	if err != nil {
+		t.Fatalf("Combine: unexpected error: %v", err)
+	}
+	if err := MaybeFinalizeAll(combined); err != nil {
+		t.Fatalf("MaybeFinalizeAll: unexpected error: %v", err)
+	}
+	if !combined.IsComplete() {
+		t.Fatalf("IsComplete: got false after finalizing")
+	}
+
+	// The finalized packet survives a binary round trip.
+	var buf bytes.Buffer
+	if err := combined.Serialize(&buf); err != nil {
+		t.Fatalf("Serialize: unexpected error: %v", err)
+	}
+	final, err := NewFromRawBytes(&buf, false)
+	if err != nil {
+		t.Fatalf("NewFromRawBytes: unexpected error: %v", err)
+	}
+	tx, err := Extract(final)
+	if err != nil {
+		t.Fatalf("Extract: unexpected error: %v", err)
+	}
+
+	prevOuts := append(s.witnessOuts[:3:3], s.prevTx.TxOut[0])
+	sigHashes := txscript.NewTxSigHashes(tx)
+	for i, prevOut := range prevOuts {
+		vm, err := txscript.NewEngine(prevOut.PkScript, tx, i,
+			txscript.StandardVerifyFlags, prevOut.Value,
+			txscript.NewTxSigChecker(nil, sigHashes))
+		if err != nil {
+			t.Fatalf("input %d: NewEngine: unexpected error: %v", i,
+				err)
+		}
+		if err := vm.Execute(); err != nil {
+			t.Errorf("input %d: Execute: unexpected error: %v", i,
+				err)
+		}
+	}
+}
+
+// TestSignInvalid ensures signatures which do not belong to an input are
+// rejected.
+func TestSignInvalid(t *testing.T) {
+	t.Parallel()
+
+	s := newTestSpend(t)
+	p := s.newPacket(t)
+	u, err := NewUpdater(p)
+	if err != nil {
+		t.Fatalf("NewUpdater: unexpected error: %v", err)
+	}
+	sig := append(bytes.Repeat([]byte{0x30}, 70), byte(txscript.SigHashAll))
+
+	// The key of the pay-to-witness-pubkey-hash input is another one.
+	_, err = u.Sign(1, sig, pubKey(s.keys[0]), nil, nil)
+	if err != ErrInvalidSignatureForInput {
+		t.Errorf("Sign: got error %v for the wrong key, want %v", err,
+			ErrInvalidSignatureForInput)
+	}
+
+	// Public keys with an even prefix are not valid in this chain.
+	evenKey := append([]byte{0x02}, pubKey(s.keys[3])[1:]...)
+	if _, err := u.Sign(1, sig, evenKey, nil, nil); err == nil {
+		t.Errorf("Sign: accepted an even public key")
+	}
+
+	// A witness script must match the output.
+	_, err = u.Sign(1, sig, pubKey(s.keys[3]), nil, s.multiSig)
+	if err != ErrInvalidSignatureForInput {
+		t.Errorf("Sign: got error %v for the wrong witness script, "+
+			"want %v", err, ErrInvalidSignatureForInput)
+	}
+}
+
+// TestDecodeInvalid ensures malformed packets are rejected.
+func TestDecodeInvalid(t *testing.T) {
+	t.Parallel()
+
+	s := newTestSpend(t)
+	var buf bytes.Buffer
+	if err := s.newPacket(t).Serialize(&buf); err != nil {
+		t.Fatalf("Serialize: unexpected error: %v", err)
+	}
+	valid := buf.Bytes()
+
+	badMagic := append([]byte{}, valid...)
+	badMagic[0] = 'q'
+	if _, err := NewFromRawBytes(bytes.NewReader(badMagic), false); err !=
+		ErrInvalidMagicBytes {
+
+		t.Errorf("NewFromRawBytes: got error %v for bad magic bytes, "+
+			"want %v", err, ErrInvalidMagicBytes)
+	}
+
+	truncated := valid[:len(valid)-10]
+	if _, err := NewFromRawBytes(bytes.NewReader(truncated), false); err ==
+		nil {
+
+		t.Errorf("NewFromRawBytes: accepted a truncated packet")
+	}
+
+	b64 := base64.StdEncoding.EncodeToString(valid)
+	if _, err := NewFromRawBytes(bytes.NewReader([]byte(b64[1:])),
+		true); err == nil {
+
+		t.Errorf("NewFromRawBytes: accepted invalid base64")
+	}
+
+	// The unsigned transaction of a packet must not be signed.
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, []byte{txscript.OP_TRUE},
+		nil))
+	tx.AddTxOut(wire.NewTxOut(1000, s.p2wpkh))
+	if _, err := NewFromUnsignedTx(tx); err != ErrInvalidRawTxSigned {
+		t.Errorf("NewFromUnsignedTx: got error %v, want %v", err,
+			ErrInvalidRawTxSigned)
+	}
+}
+
+// TestCombineIncompatible ensures packets of different transactions can not
+// be combined.
+func TestCombineIncompatible(t *testing.T) {
+	t.Parallel()
+
+	s := newTestSpend(t)
+	p := s.newPacket(t)
+	other, err := New([]*wire.OutPoint{{Index: 9}},
+		[]*wire.TxOut{wire.NewTxOut(1, s.p2wpkh)}, 2, 0, []uint32{0})
+	if err != nil {
+		t.Fatalf("New: unexpected error: %v", err)
+	}
+	if _, err := Combine(p, other); err != ErrIncompatiblePackets {
+		t.Fatalf("Combine: got error %v, want %v", err,
+			ErrIncompatiblePackets)
+	}
+}
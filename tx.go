@@ -7,6 +7,7 @@ package btcutil
 import (
 	"bytes"
 	"io"
+	"sync"
 
 	"github.com/nbcorg/btcd/chaincfg/chainhash"
 	"github.com/nbcorg/btcd/wire"
@@ -17,16 +18,32 @@ import (
 // yet.
 const TxIndexUnknown = -1
 
+// WitnessScaleFactor determines the level of "discount" witness data receives
+// compared to "base" data.  A scale factor of 4 denotes that witness data is
+// 1/4 as cheap as regular non-witness data.  See BIP 141.
+const WitnessScaleFactor = 4
+
 // Tx defines a bitcoin transaction that provides easier and more efficient
 // manipulation of raw transactions.  It also memoizes the hash for the
 // transaction on its first access so subsequent accesses don't have to repeat
 // the relatively expensive hashing operations.
+//
+// The memoized accessors are safe for concurrent use by multiple goroutines.
+// Once a value has been cached, retrieving it does not take any locks.  Note
+// that the underlying wire.MsgTx must not be modified once the Tx is shared.
 type Tx struct {
 	msgTx         *wire.MsgTx     // Underlying MsgTx
 	txHash        *chainhash.Hash // Cached transaction hash
 	txHashWitness *chainhash.Hash // Cached transaction witness hash
-	txHasWitness  *bool           // If the transaction has witness data
+	txHasWitness  bool            // If the transaction has witness data
 	txIndex       int             // Position within a block or TxIndexUnknown
+	serializeSize int             // Cached serialized size with witness data
+	strippedSize  int             // Cached serialized size w/o witness data
+
+	hashOnce        sync.Once // Guards txHash
+	witnessHashOnce sync.Once // Guards txHashWitness
+	hasWitnessOnce  sync.Once // Guards txHasWitness
+	sizeOnce        sync.Once // Guards serializeSize and strippedSize
 }
 
 // MsgTx returns the underlying wire.MsgTx for the transaction.
@@ -38,45 +55,112 @@ func (t *Tx) MsgTx() *wire.MsgTx {
 // Hash returns the hash of the transaction.  This is equivalent to
 // calling TxHash on the underlying wire.MsgTx, however it caches the
 // result so subsequent calls are more efficient.
+//
+// This function is safe for concurrent access.
 func (t *Tx) Hash() *chainhash.Hash {
-	// Return the cached hash if it has already been generated.
-	if t.txHash != nil {
-		return t.txHash
-	}
-
-	// Cache the hash and return it.
-	hash := t.msgTx.TxHash()
-	t.txHash = &hash
-	return &hash
+	// Generate and cache the hash on first access only.
+	t.hashOnce.Do(func() {
+		hash := t.msgTx.TxHash()
+		t.txHash = &hash
+	})
+	return t.txHash
 }
 
 // WitnessHash returns the witness hash (wtxid) of the transaction.  This is
 // equivalent to calling WitnessHash on the underlying wire.MsgTx, however it
 // caches the result so subsequent calls are more efficient.
+//
+// This function is safe for concurrent access.
 func (t *Tx) WitnessHash() *chainhash.Hash {
-	// Return the cached hash if it has already been generated.
-	if t.txHashWitness != nil {
-		return t.txHashWitness
-	}
+	// Generate and cache the hash on first access only.
+	t.witnessHashOnce.Do(func() {
+		hash := t.msgTx.WitnessHash()
+		t.txHashWitness = &hash
+	})
+	return t.txHashWitness
+}
 
-	// Cache the hash and return it.
-	hash := t.msgTx.WitnessHash()
-	t.txHashWitness = &hash
-	return &hash
+// setHash seeds the cached hash of the transaction with a hash which has
+// already been generated elsewhere.  It has no effect once the hash has been
+// cached.
+func (t *Tx) setHash(hash *chainhash.Hash) {
+	t.hashOnce.Do(func() {
+		txHash := *hash
+		t.txHash = &txHash
+	})
 }
 
 // HasWitness returns false if none of the inputs within the transaction
 // contain witness data, true false otherwise. This equivalent to calling
 // HasWitness on the underlying wire.MsgTx, however it caches the result so
 // subsequent calls are more efficient.
+//
+// This function is safe for concurrent access.
 func (t *Tx) HasWitness() bool {
-	if t.txHashWitness != nil {
-		return *t.txHasWitness
-	}
+	t.hasWitnessOnce.Do(func() {
+		t.txHasWitness = t.msgTx.HasWitness()
+	})
+	return t.txHasWitness
+}
+
+// calcSizes generates and caches the serialized sizes of the transaction with
+// and without witness data on first access only.
+func (t *Tx) calcSizes() {
+	t.sizeOnce.Do(func() {
+		t.serializeSize = t.msgTx.SerializeSize()
+		t.strippedSize = t.msgTx.SerializeSizeStripped()
+	})
+}
+
+// SerializeSize returns the number of bytes it would take to serialize the
+// transaction including any witness data.  This is equivalent to calling
+// SerializeSize on the underlying wire.MsgTx, however it caches the result so
+// subsequent calls are more efficient.
+//
+// This function is safe for concurrent access.
+func (t *Tx) SerializeSize() int {
+	t.calcSizes()
+	return t.serializeSize
+}
+
+// StrippedSize returns the number of bytes it would take to serialize the
+// transaction without any witness data.  This is equivalent to calling
+// SerializeSizeStripped on the underlying wire.MsgTx, however it caches the
+// result so subsequent calls are more efficient.
+//
+// This function is safe for concurrent access.
+func (t *Tx) StrippedSize() int {
+	t.calcSizes()
+	return t.strippedSize
+}
+
+// WitnessSize returns the number of bytes the witness data of the transaction
+// takes up when serialized, including the marker and flag bytes.  It is zero
+// for transactions without witness data.
+//
+// This function is safe for concurrent access.
+func (t *Tx) WitnessSize() int {
+	t.calcSizes()
+	return t.serializeSize - t.strippedSize
+}
+
+// Weight returns the weight of the transaction as defined by BIP 141.  The
+// weight is the stripped size scaled by WitnessScaleFactor with the witness
+// data counted only once, which gives witness data its discount.
+//
+// This function is safe for concurrent access.
+func (t *Tx) Weight() int64 {
+	t.calcSizes()
+	return int64(t.strippedSize*(WitnessScaleFactor-1) + t.serializeSize)
+}
 
-	hasWitness := t.msgTx.HasWitness()
-	t.txHasWitness = &hasWitness
-	return hasWitness
+// VSize returns the virtual size of the transaction as defined by BIP 141,
+// which is its weight divided by WitnessScaleFactor rounded up.  This is the
+// size fee rates are applied to.
+//
+// This function is safe for concurrent access.
+func (t *Tx) VSize() int64 {
+	return (t.Weight() + WitnessScaleFactor - 1) / WitnessScaleFactor
 }
 
 // Index returns the saved index of the transaction within a block.  This value
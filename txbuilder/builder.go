@@ -0,0 +1,334 @@
+// Copyright (c) 2015-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txbuilder
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+
+	"github.com/nbcorg/btcd/wire"
+	"github.com/nbcorg/btcutil"
+	"github.com/nbcorg/btcutil/coinset"
+	"github.com/nbcorg/btcutil/txscript"
+)
+
+// maxSelectionAttempts is the maximum number of times coins are selected
+// while building a transaction.  A selection is only retried when the exact
+// size of the transaction turns out to need a slightly higher fee than was
+// accounted for up front, so a second attempt practically always succeeds.
+const maxSelectionAttempts = 3
+
+var (
+	// ErrNoOutputs is returned when building a transaction without any
+	// outputs.
+	ErrNoOutputs = errors.New("transaction has no outputs")
+
+	// ErrNoChangeAddress is returned when building a transaction without
+	// a change address.
+	ErrNoChangeAddress = errors.New("no change address provided")
+
+	// ErrInsufficientFunds is returned when the passed coins are not enough
+	// to pay for the outputs and the fee of a transaction.
+	ErrInsufficientFunds = errors.New("insufficient funds available to " +
+		"construct transaction")
+)
+
+// Ordering describes how the inputs and outputs of a built transaction are
+// ordered.
+type Ordering int
+
+// These constants define the supported orderings.
+const (
+	// OrderRandom shuffles the inputs and outputs, so the position of the
+	// change output does not reveal it.  This is the default.
+	OrderRandom Ordering = iota
+
+	// OrderBIP69 sorts the inputs and outputs as described by BIP 69
+	// using the txsort package.
+	OrderBIP69
+
+	// OrderNone keeps the inputs in the order of the selected coins and
+	// the outputs in the order they were passed, followed by the change
+	// output.
+	OrderNone
+)
+
+// Output describes a payment of an amount to an address.
+type Output struct {
+	Address btcutil.Address
+	Amount  btcutil.Amount
+}
+
+// AuthoredTx describes an unsigned transaction built by a Builder along with
+// the details of the coins it spends, which are needed to sign it.
+type AuthoredTx struct {
+	// Tx is the unsigned transaction.
+	Tx *wire.MsgTx
+
+	// Coins are the coins spent by the transaction in input order.
+	Coins []coinset.Coin
+
+	// PrevScripts are the public key scripts of the spent coins in input
+	// order.
+	PrevScripts [][]byte
+
+	// PrevInputValues are the values of the spent coins in input order.
+	PrevInputValues []btcutil.Amount
+
+	// TotalInput is the total value of the spent coins.
+	TotalInput btcutil.Amount
+
+	// Fee is the fee paid by the transaction.
+	Fee btcutil.Amount
+
+	// ChangeIndex is the index of the change output, or -1 when the
+	// transaction has no change output.
+	ChangeIndex int
+}
+
+// Builder builds unsigned transactions paying to a set of outputs from a set
+// of coins.  It selects which coins to spend, estimates the size of the signed
+// transaction to pay the fee rate exactly, and adds a change output unless the
+// change would be dust.
+type Builder struct {
+	// FeeRate is the fee rate the signed transaction pays.
+	FeeRate btcutil.FeeRate
+
+	// ChangeAddress is the address any change is paid to.  It is
+	// required even when the transaction ends up without change.
+	ChangeAddress btcutil.Address
+
+	// Selector selects the coins to spend.  By default, a changeless
+	// selection is searched for with branch and bound selection first,
+	// and knapsack selection is used when there is none.
+	Selector coinset.CoinSelector
+
+	// DustRelayFeeRate is the fee rate which determines whether outputs
+	// are dust.  It defaults to txscript.DefaultDustRelayFeeRate.
+	DustRelayFeeRate btcutil.FeeRate
+
+	// Ordering is how the inputs and outputs are ordered.
+	Ordering Ordering
+
+	// Version is the version of the transaction, which defaults to
+	// wire.TxVersion.
+	Version int32
+
+	// LockTime is the lock time of the transaction.  When it is not zero,
+	// the sequence numbers of the inputs are set to enable it.
+	LockTime uint32
+
+	// Rand is the source of randomness used for random ordering and by the
+	// default selector, which defaults to the shared source of the
+	// math/rand package when nil.
+	Rand *rand.Rand
+}
+
+// selectCoins selects coins with the configured selector, or the default one
+// when there is none.
+func (b *Builder) selectCoins(targetValue, changeCost, minChange btcutil.Amount,
+	coins []coinset.Coin) (*coinset.Selection, error) {
+
+	if b.Selector != nil {
+		return b.Selector.CoinSelect(targetValue, b.FeeRate, changeCost,
+			coins)
+	}
+
+	bnb := coinset.BranchAndBoundCoinSelector{LongTermFeeRate: b.FeeRate}
+	selection, err := bnb.CoinSelect(targetValue, b.FeeRate, changeCost,
+		coins)
+	if err != coinset.ErrCoinsNoSelectionAvailable {
+		return selection, err
+	}
+
+	knapsack := coinset.KnapsackCoinSelector{
+		MinChangeAmount: minChange,
+		Rand:            b.Rand,
+	}
+	return knapsack.CoinSelect(targetValue, b.FeeRate, changeCost, coins)
+}
+
+// Build returns an unsigned transaction paying the passed outputs from a
+// selection of the passed coins.
+//
+// The fee paid is the fee rate applied to the worst-case size of the signed
+// transaction.  When the selected coins exceed the outputs and the fee by more
+// than the dust threshold of a change output, the rest is paid to the change
+// address.  Otherwise it is added to the fee.
+//
+// An error is returned when any output is dust or any coin is passed more than
+// once, and ErrInsufficientFunds is returned when the coins are not enough to
+// pay for the outputs and the fee.
+func (b *Builder) Build(outputs []Output, coins []coinset.Coin) (*AuthoredTx, error) {
+	if len(outputs) == 0 {
+		return nil, ErrNoOutputs
+	}
+	if b.ChangeAddress == nil {
+		return nil, ErrNoChangeAddress
+	}
+
+	// The coins are matched to the inputs by their outpoints once the
+	// inputs are ordered, so each of them may only be passed once.
+	outPoints := make(map[wire.OutPoint]struct{}, len(coins))
+	for _, coin := range coins {
+		outPoint := wire.OutPoint{Hash: *coin.Hash(), Index: coin.Index()}
+		if _, ok := outPoints[outPoint]; ok {
+			str := fmt.Sprintf("coin %v is passed more than once",
+				outPoint)
+			return nil, errors.New(str)
+		}
+		outPoints[outPoint] = struct{}{}
+	}
+	dustRelayFeeRate := b.DustRelayFeeRate
+	if dustRelayFeeRate == 0 {
+		dustRelayFeeRate = txscript.DefaultDustRelayFeeRate
+	}
+
+	// Create the requested outputs, none of which may be dust.
+	txOuts := make([]*wire.TxOut, len(outputs))
+	var totalOutput btcutil.Amount
+	for i, output := range outputs {
+		pkScript, err := txscript.PayToAddrScript(output.Address)
+		if err != nil {
+			return nil, err
+		}
+		txOut := wire.NewTxOut(int64(output.Amount), pkScript)
+		if output.Amount <= 0 || output.Amount > btcutil.MaxSatoshi ||
+			txscript.IsDust(txOut, dustRelayFeeRate) {
+
+			str := fmt.Sprintf("output %d amount of %v to %v is dust "+
+				"or out of range", i, output.Amount, output.Address)
+			return nil, errors.New(str)
+		}
+		txOuts[i] = txOut
+		totalOutput += output.Amount
+	}
+
+	// Determine the cost and the dust threshold of a change output.
+	changeScript, err := txscript.PayToAddrScript(b.ChangeAddress)
+	if err != nil {
+		return nil, err
+	}
+	changeOutput := wire.NewTxOut(0, changeScript)
+	changeCost := b.FeeRate.FeeForVSize(int64(changeOutput.SerializeSize()))
+	minChange := txscript.GetDustThreshold(changeScript, dustRelayFeeRate)
+
+	// The coins need to pay for the outputs and for the part of the
+	// transaction other than the inputs.  The marker and flag bytes of
+	// the witness encoding are accounted for in case any of the coins
+	// need a witness to be spent.
+	fixedTx := wire.NewMsgTx(wire.TxVersion)
+	fixedTx.TxOut = txOuts
+	fixedWeight := int64(fixedTx.SerializeSizeStripped()*
+		btcutil.WitnessScaleFactor + 2)
+	targetValue := totalOutput + b.FeeRate.FeeForWeight(fixedWeight)
+
+	for attempt := 0; attempt < maxSelectionAttempts; attempt++ {
+		selection, err := b.selectCoins(targetValue, changeCost,
+			minChange, coins)
+		if err == coinset.ErrCoinsNoSelectionAvailable {
+			return nil, ErrInsufficientFunds
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		authoredTx, shortfall, err := b.newAuthoredTx(selection.Coins,
+			txOuts, changeScript, minChange)
+		if err != nil {
+			return nil, err
+		}
+		if authoredTx != nil {
+			return authoredTx, nil
+		}
+
+		// The exact size of the transaction needs a higher fee than
+		// the selection accounted for, so select again with a higher
+		// target.
+		targetValue += shortfall
+	}
+
+	return nil, ErrInsufficientFunds
+}
+
+// newAuthoredTx creates the unsigned transaction spending the passed coins to
+// the passed outputs, adding a change output when the change is not dust.
+// When the coins are not enough to pay the fee for the exact size of the
+// transaction, a nil transaction is returned along with the shortfall.
+func (b *Builder) newAuthoredTx(coins []coinset.Coin, txOuts []*wire.TxOut,
+	changeScript []byte, minChange btcutil.Amount) (*AuthoredTx,
+	btcutil.Amount, error) {
+
+	version := b.Version
+	if version == 0 {
+		version = wire.TxVersion
+	}
+	tx := coinset.NewMsgTxWithInputCoins(version, coins)
+	tx.LockTime = b.LockTime
+	if b.LockTime != 0 {
+		for _, txIn := range tx.TxIn {
+			txIn.Sequence = wire.MaxTxInSequenceNum - 1
+		}
+	}
+
+	authoredTx := &AuthoredTx{
+		Tx:              tx,
+		Coins:           coins,
+		PrevScripts:     make([][]byte, len(coins)),
+		PrevInputValues: make([]btcutil.Amount, len(coins)),
+		ChangeIndex:     -1,
+	}
+	spendSizes := make([]txscript.SpendSize, len(coins))
+	for i, coin := range coins {
+		var err error
+		spendSizes[i], err = coinset.CoinSpendSize(coin)
+		if err != nil {
+			return nil, 0, err
+		}
+		authoredTx.PrevScripts[i] = coin.PkScript()
+		authoredTx.PrevInputValues[i] = coin.Value()
+		authoredTx.TotalInput += coin.Value()
+	}
+	var totalOutput btcutil.Amount
+	for _, txOut := range txOuts {
+		tx.AddTxOut(wire.NewTxOut(txOut.Value, txOut.PkScript))
+		totalOutput += btcutil.Amount(txOut.Value)
+	}
+
+	// Pay the fee for the transaction without change, and make sure the
+	// coins cover it.
+	vsize, err := txscript.EstimateSignedTxVSize(tx, spendSizes)
+	if err != nil {
+		return nil, 0, err
+	}
+	fee := b.FeeRate.FeeForVSize(vsize)
+	excess := authoredTx.TotalInput - totalOutput - fee
+	if excess < 0 {
+		return nil, -excess, nil
+	}
+
+	// Add a change output when what remains after paying for it is not
+	// dust, and add the excess to the fee otherwise.
+	changeOutput := wire.NewTxOut(0, changeScript)
+	tx.AddTxOut(changeOutput)
+	vsize, err = txscript.EstimateSignedTxVSize(tx, spendSizes)
+	if err != nil {
+		return nil, 0, err
+	}
+	changeFee := b.FeeRate.FeeForVSize(vsize)
+	change := authoredTx.TotalInput - totalOutput - changeFee
+	if change > 0 && change >= minChange {
+		changeOutput.Value = int64(change)
+		authoredTx.ChangeIndex = len(tx.TxOut) - 1
+		totalOutput += change
+	} else {
+		tx.TxOut = tx.TxOut[:len(tx.TxOut)-1]
+	}
+	authoredTx.Fee = authoredTx.TotalInput - totalOutput
+
+	b.order(authoredTx)
+	return authoredTx, 0, nil
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2015-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txbuilder
+
+import (
+	"github.com/nbcorg/btcd/wire"
+	"github.com/nbcorg/btcutil/coinset"
+	"github.com/nbcorg/btcutil/txsort"
+)
+
+// order orders the inputs and outputs of the passed transaction as configured
+// and updates the details of the spent coins and the index of the change
+// output to match.
+func (b *Builder) order(authoredTx *AuthoredTx) {
+	tx := authoredTx.Tx
+	var changeOutput *wire.TxOut
+	if authoredTx.ChangeIndex >= 0 {
+		changeOutput = tx.TxOut[authoredTx.ChangeIndex]
+	}
+
+	switch b.Ordering {
+	case OrderRandom:
+		coinset.Shuffle(b.Rand, len(tx.TxIn), func(i, j int) {
+			tx.TxIn[i], tx.TxIn[j] = tx.TxIn[j], tx.TxIn[i]
+		})
+		coinset.Shuffle(b.Rand, len(tx.TxOut), func(i, j int) {
+			tx.TxOut[i], tx.TxOut[j] = tx.TxOut[j], tx.TxOut[i]
+		})
+
+	case OrderBIP69:
+		txsort.InPlaceSort(tx)
+
+	default:
+		return
+	}
+
+	// Build rejects duplicate coins, so the outpoints of the inputs are
+	// unique and the coins can be matched to the reordered inputs by them.
+	coinsByOutPoint := make(map[wire.OutPoint]coinset.Coin,
+		len(authoredTx.Coins))
+	for _, coin := range authoredTx.Coins {
+		outPoint := wire.OutPoint{Hash: *coin.Hash(), Index: coin.Index()}
+		coinsByOutPoint[outPoint] = coin
+	}
+	for i, txIn := range tx.TxIn {
+		coin := coinsByOutPoint[txIn.PreviousOutPoint]
+		authoredTx.Coins[i] = coin
+		authoredTx.PrevScripts[i] = coin.PkScript()
+		authoredTx.PrevInputValues[i] = coin.Value()
+	}
+
+	authoredTx.ChangeIndex = -1
+	for i, txOut := range tx.TxOut {
+		if changeOutput != nil && txOut == changeOutput {
+			authoredTx.ChangeIndex = i
+		}
+	}
+}
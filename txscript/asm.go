@@ -0,0 +1,265 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// asmToken is a token of a script in its human-readable form along with its
+// position, which is used when reporting errors.
+type asmToken struct {
+	text   string
+	quoted bool
+	line   int
+	column int
+}
+
+// asmError returns an Error of kind ErrInvalidAsm for the passed token.
+func asmError(tok asmToken, format string, args ...interface{}) Error {
+	str := fmt.Sprintf("line %d, column %d: %s", tok.line, tok.column,
+		fmt.Sprintf(format, args...))
+	return scriptError(ErrInvalidAsm, str)
+}
+
+// tokenizeAsm splits the passed text into whitespace separated tokens and
+// strings quoted with single or double quotes.
+func tokenizeAsm(asm string) ([]asmToken, error) {
+	var tokens []asmToken
+	line, column := 1, 0
+	var cur *asmToken
+	var quote rune
+	for _, r := range asm {
+		column++
+		switch {
+		case quote != 0 && r == quote:
+			tokens = append(tokens, *cur)
+			cur, quote = nil, 0
+
+		case quote != 0:
+			cur.text += string(r)
+
+		case r == '\'' || r == '"':
+			if cur != nil {
+				return nil, asmError(*cur, "unexpected quote "+
+					"in token %q", cur.text)
+			}
+			cur = &asmToken{quoted: true, line: line, column: column}
+			quote = r
+
+		case unicode.IsSpace(r):
+			if cur != nil {
+				tokens = append(tokens, *cur)
+				cur = nil
+			}
+
+		case cur == nil:
+			cur = &asmToken{text: string(r), line: line, column: column}
+
+		default:
+			cur.text += string(r)
+		}
+
+		if r == '\n' {
+			line, column = line+1, 0
+		}
+	}
+
+	if quote != 0 {
+		return nil, asmError(*cur, "unterminated quoted string")
+	}
+	if cur != nil {
+		tokens = append(tokens, *cur)
+	}
+	return tokens, nil
+}
+
+// asmOpcode returns the opcode the passed name refers to, with or without its
+// OP_ prefix.  Names without the prefix which are also hex digits, such as 10
+// for OP_10, are data instead.
+func asmOpcode(name string) (byte, bool) {
+	if opcode, ok := OpcodeByName[name]; ok {
+		return opcode, true
+	}
+	if strings.HasPrefix(name, "OP_") {
+		return 0, false
+	}
+	if _, isHex := asmHex(name); isHex {
+		return 0, false
+	}
+	opcode, ok := OpcodeByName["OP_"+name]
+	return opcode, ok
+}
+
+// asmNumber returns the number the passed token refers to, which is either a
+// small integer from 0 to 16 as DisasmString prints OP_0 through OP_16, or a
+// decimal number with an explicit sign such as -1.  Any other token of digits
+// is data, since DisasmString prints data pushes as hex digits.
+func asmNumber(text string) (int64, bool) {
+	if len(text) == 1 && text[0] >= '0' && text[0] <= '9' {
+		return int64(text[0] - '0'), true
+	}
+	if len(text) == 2 && text[0] == '1' && text[1] >= '0' && text[1] <= '6' {
+		return int64(10 + text[1] - '0'), true
+	}
+	if text[0] != '+' && text[0] != '-' {
+		return 0, false
+	}
+	num, err := strconv.ParseInt(text, 10, 64)
+	return num, err == nil
+}
+
+// asmHex returns the data the passed token of hex digits refers to, with or
+// without a 0x prefix.
+func asmHex(text string) ([]byte, bool) {
+	text = strings.TrimPrefix(text, "0x")
+	if text == "" {
+		return nil, false
+	}
+	data, err := hex.DecodeString(text)
+	return data, err == nil
+}
+
+// AssembleScript returns the script written in the passed human-readable form,
+// which is the inverse of DisasmString.  The text consists of the following
+// whitespace separated tokens:
+//
+//   - Opcode names, with or without their OP_ prefix, such as OP_DUP or DUP.
+//   - The numbers 0 to 16, which are pushed with their small integer
+//     opcodes, since DisasmString prints OP_0 through OP_16 as these numbers.
+//   - Decimal numbers with an explicit sign, such as -1, +1000 or -5, which
+//     are pushed as script numbers like ScriptBuilder.AddInt64 does.  The
+//     sign is what makes a token decimal, so any number other than 0 to 16
+//     must be written with one.
+//   - An even number of hex digits, with or without a 0x prefix, which are
+//     pushed as data.  Tokens such as 20 or 1000 are therefore the data 0x20
+//     and 0x1000 rather than numbers, just like DisasmString prints them.
+//   - Strings in single or double quotes, whose bytes are pushed as data.
+//
+// Data is pushed with the canonical opcode ScriptBuilder.AddData chooses, so
+// scripts built with a ScriptBuilder assemble back from their DisasmString
+// output to the same script, other than the pushes of the single bytes 0x11
+// through 0x16 described below.  Since DisasmString prints every data push as hex
+// digits alone, pushes which are not canonical assemble back to their
+// canonical form instead.  A push with a specific opcode can be written the
+// way the full disassembly of a script prints it, such as OP_DATA_2 0x0102 or
+// OP_PUSHDATA1 0x02 0x0102, which is written to the script as is.
+//
+// DisasmString prints OP_11 through OP_16 the same way as pushes of the single
+// bytes 0x11 through 0x16, as the reference implementation does.  The tokens
+// 11 to 16 are read as the opcodes, so those single bytes must be written with
+// their 0x prefix, such as 0x11, and the DisasmString output of a script which
+// pushes them does not assemble back to the same script.  The same goes for
+// the token 10, which is OP_10 rather than the push of 0x10, although that
+// push is not canonical since it is written to scripts as OP_16.
+//
+// An error of kind ErrInvalidAsm, which includes the line and column of the
+// offending token, is returned when the text is not a valid script.
+func AssembleScript(asm string) ([]byte, error) {
+	tokens, err := tokenizeAsm(asm)
+	if err != nil {
+		return nil, err
+	}
+
+	builder := NewScriptBuilder()
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+
+		// next returns the data of the hex token following the current
+		// one, which is part of an explicit push.
+		next := func() ([]byte, error) {
+			i++
+			if i >= len(tokens) || tokens[i].quoted {
+				return nil, asmError(tok, "missing data of %s",
+					tok.text)
+			}
+			if tokens[i].text == "0x" {
+				return nil, nil
+			}
+			data, ok := asmHex(tokens[i].text)
+			if !ok {
+				return nil, asmError(tokens[i], "invalid data "+
+					"%q of %s", tokens[i].text, tok.text)
+			}
+			return data, nil
+		}
+
+		opcode, isOpcode := asmOpcode(tok.text)
+		switch {
+		case tok.quoted:
+			builder.AddData([]byte(tok.text))
+
+		case isOpcode && opcode >= OP_DATA_1 && opcode <= OP_PUSHDATA4:
+			// Explicit pushes are written as they are, so only
+			// their lengths are checked.
+			push := []byte{opcode}
+			dataLen := int(opcode)
+			if opcode >= OP_PUSHDATA1 {
+				lenBytes, err := next()
+				if err != nil {
+					return nil, err
+				}
+				size := opcodeArray[opcode].length * -1
+				if len(lenBytes) > size {
+					return nil, asmError(tokens[i], "data "+
+						"length %q of %s is too large",
+						tokens[i].text, tok.text)
+				}
+
+				// The length is printed as a big-endian number
+				// while it is encoded as a little-endian one.
+				var buf [4]byte
+				copy(buf[4-len(lenBytes):], lenBytes)
+				length := binary.BigEndian.Uint32(buf[:])
+				binary.LittleEndian.PutUint32(buf[:], length)
+				push = append(push, buf[:size]...)
+				dataLen = int(length)
+			}
+			data, err := next()
+			if err != nil {
+				return nil, err
+			}
+
+			// The full disassembly prints empty data as 0x00.
+			if dataLen == 0 && bytes.Equal(data, []byte{0}) {
+				data = nil
+			}
+			if len(data) != dataLen {
+				return nil, asmError(tokens[i], "%s pushes %d "+
+					"bytes, but %d bytes are given",
+					tok.text, dataLen, len(data))
+			}
+			builder.script = append(builder.script, push...)
+			builder.script = append(builder.script, data...)
+
+		case isOpcode:
+			builder.AddOp(opcode)
+
+		default:
+			if num, ok := asmNumber(tok.text); ok {
+				builder.AddInt64(num)
+				break
+			}
+			data, ok := asmHex(tok.text)
+			if !ok {
+				return nil, asmError(tok, "unknown token %q",
+					tok.text)
+			}
+			builder.AddData(data)
+		}
+
+		if _, err := builder.Script(); err != nil {
+			return nil, asmError(tok, "%v", err)
+		}
+	}
+
+	return builder.Script()
+}
@@ -0,0 +1,230 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// disasmFull returns the full disassembly of the passed script, which names
+// the opcode of every data push along with its data.
+func disasmFull(t *testing.T, script []byte) string {
+	pops, err := parseScript(script)
+	if err != nil {
+		t.Fatalf("parseScript(%x): unexpected error: %v", script, err)
+	}
+	parts := make([]string, len(pops))
+	for i := range pops {
+		parts[i] = pops[i].print(false)
+	}
+	return strings.Join(parts, " ")
+}
+
+// checkAssemble ensures the passed text assembles to the wanted script.
+func checkAssemble(t *testing.T, name, text string, want []byte) {
+	got, err := AssembleScript(text)
+	if err != nil {
+		t.Errorf("%s: AssembleScript(%q): unexpected error: %v", name,
+			text, err)
+		return
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s: AssembleScript(%q): got %x, want %x", name, text,
+			got, want)
+	}
+}
+
+// TestAssembleScriptRoundTrip ensures scripts of every single byte data push
+// and of every opcode assemble back from their disassembly.  The one-line
+// disassembly of DisasmString does not include the opcode of data pushes, so
+// those assemble back to the canonical push of their data, while the full
+// disassembly assembles back to the exact script.  The pushes of the single
+// bytes 0x10 through 0x16 are printed as the tokens of OP_10 through OP_16,
+// and assemble back to those opcodes instead.
+func TestAssembleScriptRoundTrip(t *testing.T) {
+	for i := 0; i < 256; i++ {
+		data := []byte{byte(i)}
+		script := []byte{OP_DATA_1, byte(i)}
+		canonical, err := NewScriptBuilder().AddData(data).Script()
+		if err != nil {
+			t.Fatalf("AddData(%x): unexpected error: %v", data, err)
+		}
+
+		// want returns the script the one-line disassembly of the
+		// passed script assembles back to.
+		want := func(script []byte) []byte {
+			if i >= 0x10 && i <= 0x16 && script[0] == OP_DATA_1 {
+				return []byte{OP_10 + byte(i-0x10)}
+			}
+			return canonical
+		}
+
+		name := "OP_DATA_1 " + disasmFull(t, script)
+		disasm, err := DisasmString(script)
+		if err != nil {
+			t.Fatalf("%s: DisasmString: unexpected error: %v", name,
+				err)
+		}
+		checkAssemble(t, name, disasm, want(script))
+		checkAssemble(t, name, disasmFull(t, script), script)
+
+		disasm, err = DisasmString(canonical)
+		if err != nil {
+			t.Fatalf("%s: DisasmString: unexpected error: %v", name,
+				err)
+		}
+		checkAssemble(t, name, disasm, want(canonical))
+		checkAssemble(t, name, fmt.Sprintf("0x%02x", i), canonical)
+	}
+
+	for i := 0; i < 256; i++ {
+		op := &opcodeArray[i]
+		var script, data []byte
+		switch {
+		case op.length == 1:
+			script = []byte{op.value}
+
+		case op.length > 1:
+			data = bytes.Repeat([]byte{0xaa}, op.length-1)
+			script = append([]byte{op.value}, data...)
+
+		default:
+			data = []byte{0xaa, 0xbb}
+			script = append([]byte{op.value, 2}, make([]byte,
+				-op.length-1)...)
+			script = append(script, data...)
+		}
+
+		disasm, err := DisasmString(script)
+		if err != nil {
+			t.Fatalf("%s: DisasmString: unexpected error: %v",
+				op.name, err)
+		}
+		want := script
+		if op.length != 1 {
+			want, err = NewScriptBuilder().AddData(data).Script()
+			if err != nil {
+				t.Fatalf("%s: AddData: unexpected error: %v",
+					op.name, err)
+			}
+		}
+		checkAssemble(t, op.name, disasm, want)
+		checkAssemble(t, op.name, disasmFull(t, script), script)
+	}
+}
+
+// TestAssembleScriptNumbers ensures numbers pushed with AddInt64 assemble back
+// from their one-line disassembly, including those which are pushed as a
+// single byte of data, and from their decimal form with an explicit sign.  The
+// numbers 17 through 22 are pushed as the single bytes 0x11 through 0x16, whose
+// disassembly assembles back to OP_11 through OP_16 instead.
+func TestAssembleScriptNumbers(t *testing.T) {
+	for num := int64(-1000); num <= 1000; num++ {
+		script, err := NewScriptBuilder().AddInt64(num).
+			AddOp(OP_DROP).Script()
+		if err != nil {
+			t.Fatalf("AddInt64(%d): unexpected error: %v", num, err)
+		}
+		disasm, err := DisasmString(script)
+		if err != nil {
+			t.Fatalf("DisasmString(%x): unexpected error: %v", script,
+				err)
+		}
+		want := script
+		if num >= 17 && num <= 22 {
+			want = []byte{OP_11 + byte(num-17), OP_DROP}
+		}
+		checkAssemble(t, disasm, disasm, want)
+		checkAssemble(t, disasm, fmt.Sprintf("%+d DROP", num), script)
+	}
+}
+
+// TestAssembleScript ensures the various kinds of tokens assemble to the
+// expected scripts.
+func TestAssembleScript(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []byte
+	}{
+		{
+			name: "opcodes with and without prefix",
+			text: "DUP OP_HASH160 OP_10 NOP",
+			want: []byte{OP_DUP, OP_HASH160, OP_10, OP_NOP},
+		},
+		{
+			name: "numbers",
+			text: "0 9 10 16 -1 +17 +1000 -5",
+			want: []byte{OP_0, OP_9, OP_10, OP_16, OP_1NEGATE,
+				OP_DATA_1, 0x11, OP_DATA_2, 0xe8, 0x03, OP_DATA_1,
+				0x85},
+		},
+		{
+			name: "digits without a sign",
+			text: "1000 +1000 20 +20",
+			want: []byte{OP_DATA_2, 0x10, 0x00, OP_DATA_2, 0xe8, 0x03,
+				OP_DATA_1, 0x20, OP_DATA_1, 0x14},
+		},
+		{
+			name: "hex data",
+			text: "0x11 17 0x0102 00",
+			want: []byte{OP_DATA_1, 0x11, OP_DATA_1, 0x17,
+				OP_DATA_2, 0x01, 0x02, OP_0},
+		},
+		{
+			name: "quoted strings",
+			text: "'abc' \"x y\"",
+			want: []byte{OP_DATA_3, 'a', 'b', 'c', OP_DATA_3, 'x',
+				' ', 'y'},
+		},
+		{
+			name: "explicit pushes",
+			text: "OP_DATA_1 0x05 OP_PUSHDATA1 0x02 0xaabb " +
+				"OP_PUSHDATA2 0x0000 0x00",
+			want: []byte{OP_DATA_1, 0x05, OP_PUSHDATA1, 0x02, 0xaa,
+				0xbb, OP_PUSHDATA2, 0x00, 0x00},
+		},
+		{
+			name: "multiple lines",
+			text: "OP_DUP\n\tOP_CHECKSIG\n",
+			want: []byte{OP_DUP, OP_CHECKSIG},
+		},
+	}
+	for _, test := range tests {
+		checkAssemble(t, test.name, test.text, test.want)
+	}
+}
+
+// TestAssembleScriptErrors ensures invalid text is rejected with an error of
+// kind ErrInvalidAsm which reports the position of the offending token.
+func TestAssembleScriptErrors(t *testing.T) {
+	tests := []struct {
+		text string
+		pos  string
+	}{
+		{text: "OP_DUP\n  foo", pos: "line 2, column 3"},
+		{text: "OP_DUP 123", pos: "line 1, column 8"},
+		{text: "OP_DUP 'abc", pos: "line 1, column 8"},
+		{text: "ab'c'", pos: "line 1, column 1"},
+		{text: "OP_DATA_2 0x01", pos: "line 1, column 11"},
+		{text: "OP_PUSHDATA1", pos: "line 1, column 1"},
+		{text: "OP_UNKNOWN", pos: "line 1, column 1"},
+	}
+	for _, test := range tests {
+		_, err := AssembleScript(test.text)
+		if !IsErrorCode(err, ErrInvalidAsm) {
+			t.Errorf("AssembleScript(%q): got error %v, want kind "+
+				"%v", test.text, err, ErrInvalidAsm)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.pos) {
+			t.Errorf("AssembleScript(%q): error %q does not report "+
+				"%s", test.text, err, test.pos)
+		}
+	}
+}
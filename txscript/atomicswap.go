@@ -0,0 +1,349 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/nbcorg/btcd/btcec"
+	"github.com/nbcorg/btcd/wire"
+	"github.com/nbcorg/btcutil"
+	"github.com/nbcorg/btcutil/chaincfg"
+)
+
+// AtomicSwapSecretSize is the size of the secret of the atomic swap contracts
+// built by AtomicSwapContract.
+const AtomicSwapSecretSize = sha256.Size
+
+// AtomicSwapContract returns an atomic swap contract which pays to the owner of
+// the public key hashing to recipientHash160 once they reveal the secret
+// hashing to secretHash, or refunds to the owner of the public key hashing to
+// refundHash160 once the lock time is reached.  The secret hash is the SHA-256
+// hash of a secret of AtomicSwapSecretSize bytes.  The lock time is interpreted
+// as a block height below LockTimeThreshold and as a timestamp otherwise.
+//
+// The contract is recognized by ExtractAtomicSwapDataPushes.  Like any other
+// nonstandard script it must be paid to through a pay-to-script-hash or a
+// pay-to-witness-script-hash output, see AtomicSwapAddress.
+func AtomicSwapContract(recipientHash160, refundHash160 [20]byte,
+	secretHash [32]byte, lockTime uint32) ([]byte, error) {
+
+	builder := NewScriptBuilder()
+
+	// The redeem path requires the secret and a signature by the
+	// recipient.
+	builder.AddOp(OP_IF)
+	builder.AddOp(OP_SIZE).AddInt64(AtomicSwapSecretSize)
+	builder.AddOp(OP_EQUALVERIFY)
+	builder.AddOp(OP_SHA256).AddData(secretHash[:]).AddOp(OP_EQUALVERIFY)
+	builder.AddOp(OP_DUP).AddOp(OP_HASH160).AddData(recipientHash160[:])
+
+	// The refund path requires the lock time to be reached and a signature
+	// by the refund key.
+	builder.AddOp(OP_ELSE)
+	builder.AddInt64(int64(lockTime)).AddOp(OP_CHECKLOCKTIMEVERIFY)
+	builder.AddOp(OP_DROP)
+	builder.AddOp(OP_DUP).AddOp(OP_HASH160).AddData(refundHash160[:])
+	builder.AddOp(OP_ENDIF)
+
+	// Both paths end with checking the signature against the public key
+	// matching the hash of the path taken.
+	builder.AddOp(OP_EQUALVERIFY).AddOp(OP_CHECKSIG)
+
+	return builder.Script()
+}
+
+// AtomicSwapAddress returns the address paying to the passed atomic swap
+// contract on the passed network, which is a pay-to-witness-script-hash
+// address when witness is true and a pay-to-script-hash address otherwise.
+func AtomicSwapAddress(contract []byte, witness bool, net *chaincfg.Params) (btcutil.Address, error) {
+	if witness {
+		addr, err := WitnessScriptAddress(contract, net)
+		if err != nil {
+			return nil, err
+		}
+		return addr, nil
+	}
+	addr, err := btcutil.NewAddressScriptHash(contract, net)
+	if err != nil {
+		return nil, err
+	}
+	return addr, nil
+}
+
+// AtomicSwapOutput describes a transaction output paying to an atomic swap
+// contract, which is what redeem and refund transactions spend.
+type AtomicSwapOutput struct {
+	// Contract is the atomic swap contract the output pays to.
+	Contract []byte
+
+	// OutPoint identifies the output.
+	OutPoint wire.OutPoint
+
+	// Value is the value of the output.
+	Value btcutil.Amount
+
+	// Witness is whether the output is a pay-to-witness-script-hash output
+	// rather than a pay-to-script-hash output.
+	Witness bool
+}
+
+// dataPushes returns the data pushes of the contract of the output, or an error
+// of kind ErrUnsupportedScript when it is not an atomic swap contract.
+func (o *AtomicSwapOutput) dataPushes() (*AtomicSwapDataPushes, error) {
+	pushes, err := ExtractAtomicSwapDataPushes(0, o.Contract)
+	if err != nil {
+		return nil, err
+	}
+	if pushes == nil {
+		str := fmt.Sprintf("script %x is not an atomic swap contract",
+			o.Contract)
+		return nil, scriptError(ErrUnsupportedScript, str)
+	}
+	return pushes, nil
+}
+
+// spendSize returns the size of the data needed to spend the output with the
+// passed lengths of the stack items preceding the contract, which are the
+// signature, the public key, the secret when redeeming and the branch
+// selector.
+func (o *AtomicSwapOutput) spendSize(itemLens []int) SpendSize {
+	if o.Witness {
+		itemLens = append(itemLens, len(o.Contract))
+		return SpendSize{WitnessSize: witnessItemsSize(itemLens)}
+	}
+
+	// A single byte selector is pushed with OP_0 or OP_1.
+	sigScriptSize := canonicalDataSize(o.Contract)
+	for _, itemLen := range itemLens {
+		if itemLen <= 1 {
+			sigScriptSize++
+			continue
+		}
+		sigScriptSize += pushSize(itemLen)
+	}
+	return SpendSize{SigScriptSize: sigScriptSize}
+}
+
+// newSpendTx returns an unsigned transaction spending the output to payTo,
+// paying the fee for its signed size at feeRate out of the value of the output.
+func (o *AtomicSwapOutput) newSpendTx(payTo btcutil.Address, feeRate btcutil.FeeRate,
+	lockTime, sequence uint32, itemLens []int) (*wire.MsgTx, error) {
+
+	pkScript, err := PayToAddrScript(payTo)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.LockTime = lockTime
+	txIn := wire.NewTxIn(&o.OutPoint, nil, nil)
+	txIn.Sequence = sequence
+	tx.AddTxIn(txIn)
+	txOut := wire.NewTxOut(0, pkScript)
+	tx.AddTxOut(txOut)
+
+	spendSize := o.spendSize(itemLens)
+	vsize, err := EstimateSignedTxVSize(tx, []SpendSize{spendSize})
+	if err != nil {
+		return nil, err
+	}
+	fee := feeRate.FeeForVSize(vsize)
+	txOut.Value = int64(o.Value - fee)
+	if IsDust(txOut, DefaultDustRelayFeeRate) {
+		str := fmt.Sprintf("output value of %v is dust after paying "+
+			"a fee of %v", o.Value, fee)
+		return nil, scriptError(ErrDustOutput, str)
+	}
+	return tx, nil
+}
+
+// NewAtomicSwapRedeemTx returns an unsigned transaction redeeming the passed
+// atomic swap output by paying its value to payTo.  The fee is deducted from
+// the value of the output, and is calculated for the size of the transaction
+// once it is signed with SignAtomicSwapRedeem at the passed fee rate.  An
+// error of kind ErrDustOutput is returned when the remaining value is dust.
+func NewAtomicSwapRedeemTx(output *AtomicSwapOutput, payTo btcutil.Address,
+	feeRate btcutil.FeeRate) (*wire.MsgTx, error) {
+
+	pushes, err := output.dataPushes()
+	if err != nil {
+		return nil, err
+	}
+	itemLens := []int{maxSigLen, pubKeyLen, int(pushes.SecretSize), 1}
+	return output.newSpendTx(payTo, feeRate, 0, wire.MaxTxInSequenceNum,
+		itemLens)
+}
+
+// NewAtomicSwapRefundTx returns an unsigned transaction refunding the passed
+// atomic swap output by paying its value to payTo.  The lock time of the
+// transaction is the lock time of the contract, so it can't be mined before
+// the contract allows it.  See NewAtomicSwapRedeemTx for how the fee is paid.
+func NewAtomicSwapRefundTx(output *AtomicSwapOutput, payTo btcutil.Address,
+	feeRate btcutil.FeeRate) (*wire.MsgTx, error) {
+
+	pushes, err := output.dataPushes()
+	if err != nil {
+		return nil, err
+	}
+
+	// The lock time is only enforced when the input is not final.
+	itemLens := []int{maxSigLen, pubKeyLen, 0}
+	return output.newSpendTx(payTo, feeRate, uint32(pushes.LockTime),
+		wire.MaxTxInSequenceNum-1, itemLens)
+}
+
+// AtomicSwapSigner provides the private keys which sign the inputs spending
+// atomic swap outputs.
+type AtomicSwapSigner interface {
+	// AtomicSwapKey returns the private key whose compressed public key
+	// hashes to pubKeyHash.
+	AtomicSwapKey(pubKeyHash [20]byte) (*btcec.PrivateKey, error)
+}
+
+// AtomicSwapKeyClosure implements AtomicSwapSigner with a closure.
+type AtomicSwapKeyClosure func(pubKeyHash [20]byte) (*btcec.PrivateKey, error)
+
+// AtomicSwapKey implements AtomicSwapSigner by returning the result of calling
+// the closure.
+func (kc AtomicSwapKeyClosure) AtomicSwapKey(pubKeyHash [20]byte) (*btcec.PrivateKey, error) {
+	return kc(pubKeyHash)
+}
+
+// signAtomicSwap signs input idx of the transaction spending the passed atomic
+// swap output with the key of the passed public key hash, and sets its
+// signature script or witness to the signature and public key followed by the
+// passed items and the contract.  The signature commits to the contract and,
+// for witness outputs, to the value of the output as described by BIP0143.
+func signAtomicSwap(tx *wire.MsgTx, idx int, output *AtomicSwapOutput,
+	pubKeyHash [20]byte, signer AtomicSwapSigner, items [][]byte) error {
+
+	if idx < 0 || idx >= len(tx.TxIn) {
+		str := fmt.Sprintf("transaction input index %d is out of range "+
+			"for %d inputs", idx, len(tx.TxIn))
+		return scriptError(ErrInvalidIndex, str)
+	}
+
+	key, err := signer.AtomicSwapKey(pubKeyHash)
+	if err != nil {
+		return err
+	}
+	pubKey := key.PubKey().SerializeCompressed()
+	if !bytes.Equal(btcutil.Hash160(pubKey), pubKeyHash[:]) {
+		str := fmt.Sprintf("public key %x does not hash to %x", pubKey,
+			pubKeyHash)
+		return scriptError(ErrEqualVerify, str)
+	}
+
+	var sig []byte
+	if output.Witness {
+		sig, err = RawTxInWitnessSignature(tx, nil, idx,
+			int64(output.Value), output.Contract, SigHashAll, key)
+	} else {
+		sig, err = RawTxInSignature(tx, idx, output.Contract,
+			SigHashAll, key)
+	}
+	if err != nil {
+		return err
+	}
+
+	items = append([][]byte{sig, pubKey}, items...)
+	items = append(items, output.Contract)
+	txIn := tx.TxIn[idx]
+	if output.Witness {
+		txIn.SignatureScript = nil
+		txIn.Witness = wire.TxWitness(items)
+		return nil
+	}
+
+	builder := NewScriptBuilder()
+	for _, item := range items {
+		builder.AddData(item)
+	}
+	txIn.SignatureScript, err = builder.Script()
+	txIn.Witness = nil
+	return err
+}
+
+// SignAtomicSwapRedeem signs input idx of the passed transaction, which redeems
+// the passed atomic swap output, with the key of the recipient and reveals the
+// secret.  The transaction must not be modified afterwards, since the
+// signature commits to it.  An error of kind ErrEqualVerify is returned when
+// the secret does not match the secret hash of the contract.
+func SignAtomicSwapRedeem(tx *wire.MsgTx, idx int, output *AtomicSwapOutput,
+	secret []byte, signer AtomicSwapSigner) error {
+
+	pushes, err := output.dataPushes()
+	if err != nil {
+		return err
+	}
+	if int64(len(secret)) != pushes.SecretSize ||
+		sha256.Sum256(secret) != pushes.SecretHash {
+
+		str := fmt.Sprintf("secret does not match the secret hash %x of "+
+			"the contract", pushes.SecretHash)
+		return scriptError(ErrEqualVerify, str)
+	}
+	return signAtomicSwap(tx, idx, output, pushes.RecipientHash160, signer,
+		[][]byte{secret, {1}})
+}
+
+// SignAtomicSwapRefund signs input idx of the passed transaction, which refunds
+// the passed atomic swap output, with the refund key.  An error of kind
+// ErrUnsatisfiedLockTime is returned when the lock time of the transaction does
+// not satisfy the lock time of the contract, or when the input is final.
+func SignAtomicSwapRefund(tx *wire.MsgTx, idx int, output *AtomicSwapOutput,
+	signer AtomicSwapSigner) error {
+
+	pushes, err := output.dataPushes()
+	if err != nil {
+		return err
+	}
+
+	// Lock times are only comparable when they are both block heights or
+	// both timestamps.
+	txLockTime := int64(tx.LockTime)
+	if (txLockTime < LockTimeThreshold) != (pushes.LockTime < LockTimeThreshold) ||
+		txLockTime < pushes.LockTime {
+
+		str := fmt.Sprintf("transaction lock time %d does not satisfy "+
+			"the contract lock time %d", txLockTime, pushes.LockTime)
+		return scriptError(ErrUnsatisfiedLockTime, str)
+	}
+	if idx >= 0 && idx < len(tx.TxIn) &&
+		tx.TxIn[idx].Sequence == wire.MaxTxInSequenceNum {
+
+		str := fmt.Sprintf("transaction input %d is final, which "+
+			"disables its lock time", idx)
+		return scriptError(ErrUnsatisfiedLockTime, str)
+	}
+	return signAtomicSwap(tx, idx, output, pushes.RefundHash160, signer,
+		[][]byte{nil})
+}
+
+// ExtractAtomicSwapSecret returns the secret an input redeeming an atomic swap
+// output reveals, which is the item of its signature script or witness that
+// hashes to secretHash.  If the input does not reveal the secret,
+// ExtractAtomicSwapSecret returns (nil, nil).  Non-nil errors are returned for
+// unparsable signature scripts.
+func ExtractAtomicSwapSecret(txIn *wire.TxIn, secretHash [32]byte) ([]byte, error) {
+	items := [][]byte(txIn.Witness)
+	if len(txIn.SignatureScript) > 0 {
+		pushes, err := PushedData(txIn.SignatureScript)
+		if err != nil {
+			return nil, err
+		}
+		items = append(pushes, items...)
+	}
+
+	for _, item := range items {
+		if sha256.Sum256(item) == secretHash {
+			return item, nil
+		}
+	}
+	return nil, nil
+}
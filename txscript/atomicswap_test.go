@@ -0,0 +1,183 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/nbcorg/btcd/btcec"
+	"github.com/nbcorg/btcd/chaincfg/chainhash"
+	"github.com/nbcorg/btcd/wire"
+	"github.com/nbcorg/btcutil"
+	"github.com/nbcorg/btcutil/chaincfg"
+)
+
+// swapPrivKey returns a private key derived from the passed seed whose
+// compressed public key has the 0x03 prefix valid public keys require.
+func swapPrivKey(seed byte) *btcec.PrivateKey {
+	for i := byte(0); ; i++ {
+		b := sha256.Sum256([]byte{seed, i})
+		key, pubKey := btcec.PrivKeyFromBytes(btcec.S256(), b[:])
+		if btcutil.IsValidPubKey(pubKey.SerializeCompressed()) {
+			return key
+		}
+	}
+}
+
+// swapKeys returns an AtomicSwapSigner providing the passed keys.
+func swapKeys(keys ...*btcec.PrivateKey) AtomicSwapSigner {
+	return AtomicSwapKeyClosure(func(pubKeyHash [20]byte) (*btcec.PrivateKey, error) {
+		for _, key := range keys {
+			pubKey := key.PubKey().SerializeCompressed()
+			if bytes.Equal(btcutil.Hash160(pubKey), pubKeyHash[:]) {
+				return key, nil
+			}
+		}
+		return nil, errors.New("unknown key")
+	})
+}
+
+// swapPkScript returns the public key script paying to the passed contract.
+func swapPkScript(t *testing.T, contract []byte, witness bool) []byte {
+	addr, err := AtomicSwapAddress(contract, witness, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("AtomicSwapAddress: unexpected error: %v", err)
+	}
+	pkScript, err := PayToAddrScript(addr)
+	if err != nil {
+		t.Fatalf("PayToAddrScript: unexpected error: %v", err)
+	}
+	return pkScript
+}
+
+// TestAtomicSwap ensures redeem and refund transactions of atomic swap
+// contracts are signed so they execute successfully, for both
+// pay-to-script-hash and pay-to-witness-script-hash outputs.
+func TestAtomicSwap(t *testing.T) {
+	t.Parallel()
+
+	recipientKey, refundKey := swapPrivKey(1), swapPrivKey(2)
+	var recipientHash, refundHash [20]byte
+	copy(recipientHash[:], btcutil.Hash160(
+		recipientKey.PubKey().SerializeCompressed()))
+	copy(refundHash[:], btcutil.Hash160(
+		refundKey.PubKey().SerializeCompressed()))
+	secret := bytes.Repeat([]byte{0x09}, AtomicSwapSecretSize)
+	secretHash := sha256.Sum256(secret)
+	const lockTime = 600000
+
+	contract, err := AtomicSwapContract(recipientHash, refundHash,
+		secretHash, lockTime)
+	if err != nil {
+		t.Fatalf("AtomicSwapContract: unexpected error: %v", err)
+	}
+	payTo, err := btcutil.NewAddressPubKeyHash(recipientHash[:],
+		&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("NewAddressPubKeyHash: unexpected error: %v", err)
+	}
+	signer := swapKeys(recipientKey, refundKey)
+
+	// execute runs the scripts of the only input of tx, which spends the
+	// passed output.
+	execute := func(tx *wire.MsgTx, output *AtomicSwapOutput) error {
+		pkScript := swapPkScript(t, contract, output.Witness)
+		vm, err := NewEngine(pkScript, tx, 0, StandardVerifyFlags,
+			int64(output.Value), NewTxSigChecker(nil, nil))
+		if err != nil {
+			return err
+		}
+		return vm.Execute()
+	}
+
+	for _, witness := range []bool{false, true} {
+		output := &AtomicSwapOutput{
+			Contract: contract,
+			OutPoint: wire.OutPoint{Hash: chainhash.Hash{0x01}},
+			Value:    100000,
+			Witness:  witness,
+		}
+
+		redeemTx, err := NewAtomicSwapRedeemTx(output, payTo, 10000)
+		if err != nil {
+			t.Fatalf("NewAtomicSwapRedeemTx: unexpected error: %v",
+				err)
+		}
+		err = SignAtomicSwapRedeem(redeemTx, 0, output, secret[1:],
+			signer)
+		if !IsErrorCode(err, ErrEqualVerify) {
+			t.Fatalf("SignAtomicSwapRedeem: got error %v with the "+
+				"wrong secret, want %v", err, ErrEqualVerify)
+		}
+		err = SignAtomicSwapRedeem(redeemTx, 0, output, secret,
+			swapKeys(refundKey))
+		if err == nil {
+			t.Fatalf("SignAtomicSwapRedeem: signed without the " +
+				"recipient key")
+		}
+		err = SignAtomicSwapRedeem(redeemTx, 0, output, secret, signer)
+		if err != nil {
+			t.Fatalf("SignAtomicSwapRedeem: unexpected error: %v",
+				err)
+		}
+		if err := execute(redeemTx, output); err != nil {
+			t.Fatalf("witness %v: redeem failed: %v", witness, err)
+		}
+		got, err := ExtractAtomicSwapSecret(redeemTx.TxIn[0], secretHash)
+		if err != nil || !bytes.Equal(got, secret) {
+			t.Fatalf("ExtractAtomicSwapSecret: got %x, %v, want %x",
+				got, err, secret)
+		}
+
+		// The signature commits to the transaction.
+		redeemTx.TxOut[0].Value--
+		if err := execute(redeemTx, output); !IsErrorCode(err,
+			ErrNullFail) {
+
+			t.Fatalf("witness %v: modified redeem got error %v, "+
+				"want %v", witness, err, ErrNullFail)
+		}
+
+		refundTx, err := NewAtomicSwapRefundTx(output, payTo, 10000)
+		if err != nil {
+			t.Fatalf("NewAtomicSwapRefundTx: unexpected error: %v",
+				err)
+		}
+		if refundTx.LockTime != lockTime {
+			t.Fatalf("NewAtomicSwapRefundTx: lock time %d, want %d",
+				refundTx.LockTime, lockTime)
+		}
+		err = SignAtomicSwapRefund(refundTx, 0, output, signer)
+		if err != nil {
+			t.Fatalf("SignAtomicSwapRefund: unexpected error: %v",
+				err)
+		}
+		if err := execute(refundTx, output); err != nil {
+			t.Fatalf("witness %v: refund failed: %v", witness, err)
+		}
+		got, err = ExtractAtomicSwapSecret(refundTx.TxIn[0], secretHash)
+		if err != nil || got != nil {
+			t.Fatalf("ExtractAtomicSwapSecret: got %x, %v from a "+
+				"refund, want nil", got, err)
+		}
+
+		refundTx.LockTime = lockTime - 1
+		err = SignAtomicSwapRefund(refundTx, 0, output, signer)
+		if !IsErrorCode(err, ErrUnsatisfiedLockTime) {
+			t.Fatalf("SignAtomicSwapRefund: got error %v before the "+
+				"lock time, want %v", err, ErrUnsatisfiedLockTime)
+		}
+	}
+
+	output := &AtomicSwapOutput{Contract: contract, Value: 1000}
+	_, err = NewAtomicSwapRedeemTx(output, payTo, 10000)
+	if !IsErrorCode(err, ErrDustOutput) {
+		t.Fatalf("NewAtomicSwapRedeemTx: got error %v, want %v", err,
+			ErrDustOutput)
+	}
+}
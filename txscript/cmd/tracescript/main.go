@@ -0,0 +1,108 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// tracescript replays the scripts of a transaction input from its raw hex,
+// logging every opcode executed along with the stacks it leaves behind.
+//
+// Signatures are verified with a txscript.TxSigChecker, so the input is only
+// reported as ok when its signatures are valid.  Witness signatures commit to
+// the value of the output spent, so the amount is required for inputs with
+// witness data.
+//
+// Usage:
+//
+//	tracescript -tx <hex> -input <index> -pkscript <hex> [-amount <satoshi>]
+//	    [-flags standard|p2sh|none]
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/btcsuite/btclog"
+	"github.com/nbcorg/btcd/wire"
+	"github.com/nbcorg/btcutil/txscript"
+)
+
+// scriptFlags maps the accepted values of the flags option to script flags.
+var scriptFlags = map[string]txscript.ScriptFlags{
+	"standard": txscript.StandardVerifyFlags,
+	"p2sh":     txscript.ScriptBip16,
+	"none":     0,
+}
+
+func main() {
+	txHex := flag.String("tx", "", "raw transaction spending the input, "+
+		"in hex")
+	input := flag.Int("input", 0, "index of the input to trace")
+	pkScriptHex := flag.String("pkscript", "", "public key script of "+
+		"the output spent by the input, in hex")
+	amount := flag.Int64("amount", -1, "value of the output spent by "+
+		"the input, in satoshi, which is required for inputs with "+
+		"witness data")
+	flagsName := flag.String("flags", "standard", "script flags to "+
+		"execute with: standard, p2sh or none")
+	flag.Parse()
+
+	if err := run(*txHex, *input, *pkScriptHex, *amount, *flagsName); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run traces the input and returns an error when it fails to validate.
+func run(txHex string, input int, pkScriptHex string, amount int64,
+	flagsName string) error {
+
+	flags, ok := scriptFlags[flagsName]
+	if !ok {
+		return fmt.Errorf("unknown script flags %q", flagsName)
+	}
+	rawTx, err := hex.DecodeString(txHex)
+	if err != nil {
+		return fmt.Errorf("invalid transaction hex: %v", err)
+	}
+	var tx wire.MsgTx
+	if err := tx.Deserialize(bytes.NewReader(rawTx)); err != nil {
+		return fmt.Errorf("invalid transaction: %v", err)
+	}
+	pkScript, err := hex.DecodeString(pkScriptHex)
+	if err != nil {
+		return fmt.Errorf("invalid public key script hex: %v", err)
+	}
+	if input < 0 || input >= len(tx.TxIn) {
+		return fmt.Errorf("input %d: transaction has %d inputs", input,
+			len(tx.TxIn))
+	}
+
+	// Witness signatures can not be verified without the amount, so the
+	// input can not be reported as ok.
+	if amount < 0 {
+		if len(tx.TxIn[input].Witness) != 0 {
+			return fmt.Errorf("input %d: -amount is required to "+
+				"verify witness signatures", input)
+		}
+		amount = 0
+	}
+
+	logger := btclog.NewBackend(os.Stdout).Logger("TXSC")
+	logger.SetLevel(btclog.LevelTrace)
+	txscript.UseLogger(logger)
+
+	tracer, err := txscript.NewScriptTracer(pkScript, &tx, input, flags,
+		amount, txscript.NewTxSigChecker(nil, nil))
+	if err != nil {
+		return fmt.Errorf("input %d: %v", input, err)
+	}
+	tracer.Hook = txscript.LogTraceHook
+	if err := tracer.Run(); err != nil {
+		return fmt.Errorf("input %d failed: %v", input, err)
+	}
+
+	fmt.Printf("input %d: ok\n", input)
+	return nil
+}
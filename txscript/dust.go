@@ -0,0 +1,102 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript
+
+import (
+	"github.com/nbcorg/btcd/chaincfg/chainhash"
+	"github.com/nbcorg/btcd/wire"
+	"github.com/nbcorg/btcutil"
+)
+
+const (
+	// DefaultDustRelayFeeRate is the fee rate the dust threshold of an
+	// output is calculated with by default.  It matches the default dust
+	// relay fee of the reference implementation, which is three times its
+	// default minimum relay fee of 1000 sat/kvB.
+	DefaultDustRelayFeeRate btcutil.FeeRate = 3000
+
+	// pubKeyLen is the length of a serialized public key.  Only 33-byte
+	// compressed keys are valid in this chain, so spending a key never
+	// requires pushing the 65 bytes of an uncompressed key.
+	pubKeyLen = 33
+
+	// inputBaseSize is the size of a transaction input without its
+	// signature script, which is the outpoint and the sequence number.
+	inputBaseSize = chainhash.HashSize + 4 + 4
+
+	// dustSpendSize is the size of an input spending an output with a
+	// signature script that pushes a signature and a public key, as
+	// assumed by the reference implementation for all non-witness outputs.
+	// With only compressed keys valid this is exactly the size of an input
+	// spending a pay-to-pubkey-hash output.
+	dustSpendSize = inputBaseSize + 1 + 1 + maxSigLen + 1 + pubKeyLen
+
+	// dustWitnessSpendSize is the virtual size of an input spending a
+	// witness program with the same signature and public key in its
+	// witness as assumed by the reference implementation for all witness
+	// programs, regardless of their version.
+	dustWitnessSpendSize = inputBaseSize + 1 +
+		(1+maxSigLen+1+pubKeyLen)/btcutil.WitnessScaleFactor
+)
+
+// dustSpendSizeForScript returns the size, in virtual bytes, of the input that
+// is assumed to spend the passed public key script when calculating its dust
+// threshold.
+//
+// The sizes assumed by the reference implementation are used so an output is
+// never considered spendable when its relay policy considers it dust.  The
+// only exception are bare multi-signature outputs which require more than one
+// signature to spend, since the size of their inputs is known to be larger.
+func dustSpendSizeForScript(pkScript []byte) int {
+	if IsWitnessProgram(pkScript) {
+		return dustWitnessSpendSize
+	}
+
+	spendSize := dustSpendSize
+	if GetScriptClass(pkScript) == MultiSigTy {
+		_, numSigs, err := CalcMultiSigStats(pkScript)
+		if err != nil {
+			return spendSize
+		}
+
+		// The signature script of a multi-signature input pushes an
+		// extra dummy value due to an off-by-one bug in
+		// OP_CHECKMULTISIG followed by all of the signatures.
+		sigScriptLen := 1 + numSigs*(1+maxSigLen)
+		multiSigSpendSize := inputBaseSize +
+			wire.VarIntSerializeSize(uint64(sigScriptLen)) + sigScriptLen
+		if multiSigSpendSize > spendSize {
+			spendSize = multiSigSpendSize
+		}
+	}
+	return spendSize
+}
+
+// GetDustThreshold returns the smallest value an output paying to the passed
+// public key script can have without being considered dust at the passed fee
+// rate.  An output is dust when the fee to create it and spend it later on is
+// more than the output is worth, so the threshold is the fee for the size of
+// the output together with the size of the input that spends it.
+//
+// Outputs that are provably unspendable, such as null data outputs, are never
+// dust, so their threshold is zero.  The default fee rate to use is
+// DefaultDustRelayFeeRate.
+func GetDustThreshold(pkScript []byte, feeRate btcutil.FeeRate) btcutil.Amount {
+	if IsUnspendable(pkScript) {
+		return 0
+	}
+
+	outputSize := 8 + wire.VarIntSerializeSize(uint64(len(pkScript))) +
+		len(pkScript)
+	totalSize := outputSize + dustSpendSizeForScript(pkScript)
+	return feeRate.FeeForVSize(int64(totalSize))
+}
+
+// IsDust returns whether or not the passed transaction output is dust at the
+// passed fee rate.  See GetDustThreshold for details.
+func IsDust(txOut *wire.TxOut, feeRate btcutil.FeeRate) bool {
+	return btcutil.Amount(txOut.Value) < GetDustThreshold(txOut.PkScript,
+		feeRate)
+}
@@ -0,0 +1,785 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/nbcorg/btcd/wire"
+	"github.com/nbcorg/btcutil"
+)
+
+// scriptWrap describes how the script of a script test is paid to.
+type scriptWrap int
+
+const (
+	// wrapNone uses the script as the public key script.
+	wrapNone scriptWrap = iota
+
+	// wrapP2SH uses the script as the redeem script of a
+	// pay-to-script-hash output, which the signature script pushes last.
+	wrapP2SH
+
+	// wrapP2WSH uses the script as the witness script of a
+	// pay-to-witness-script-hash output, which is the last witness item.
+	wrapP2WSH
+
+	// wrapP2SHP2WSH uses the script as the witness script of a
+	// pay-to-witness-script-hash program nested in a pay-to-script-hash
+	// output.
+	wrapP2SHP2WSH
+)
+
+// testSigChecker is a SigChecker which accepts a signature when it is the
+// last byte of the public key, and records the checks it was asked for.
+type testSigChecker struct {
+	sigs        [][]byte
+	hashTypes   []SigHashType
+	scriptCodes [][]byte
+	witness     []bool
+}
+
+// CheckSig accepts sig when it is the last byte of pubKey.
+func (c *testSigChecker) CheckSig(sig, pubKey []byte, hashType SigHashType,
+	tx *wire.MsgTx, idx int, amount int64, scriptCode []byte,
+	witness bool) bool {
+
+	c.sigs = append(c.sigs, sig)
+	c.hashTypes = append(c.hashTypes, hashType)
+	c.scriptCodes = append(c.scriptCodes, scriptCode)
+	c.witness = append(c.witness, witness)
+	return len(pubKey) > 0 && bytes.Equal(sig, pubKey[len(pubKey)-1:])
+}
+
+// mustAssemble returns the script written in the passed text, failing the test
+// when it does not assemble.
+func mustAssemble(t *testing.T, text string) []byte {
+	script, err := AssembleScript(text)
+	if err != nil {
+		t.Fatalf("AssembleScript(%q): unexpected error: %v", text, err)
+	}
+	return script
+}
+
+// mustScript returns the script of the passed builder, failing the test when
+// it can not be built.
+func mustScript(t *testing.T, builder *ScriptBuilder) []byte {
+	script, err := builder.Script()
+	if err != nil {
+		t.Fatalf("Script: unexpected error: %v", err)
+	}
+	return script
+}
+
+// scriptTest is a script test vector, which spends the output paying to its
+// script with its signature script and witness.
+type scriptTest struct {
+	name     string
+	sig      string
+	script   string
+	witness  []string
+	wrap     scriptWrap
+	flags    ScriptFlags
+	version  int32
+	lockTime uint32
+	sequence uint32
+	valid    bool
+	err      ErrorCode
+}
+
+// run executes the test vector with the passed checker and returns the error
+// of the engine, which is nil when the scripts validate.
+func (test *scriptTest) run(t *testing.T, checker SigChecker) error {
+	sigScript := mustAssemble(t, test.sig)
+	script := mustAssemble(t, test.script)
+	var witness wire.TxWitness
+	for _, item := range test.witness {
+		data, err := hex.DecodeString(item)
+		if err != nil {
+			t.Fatalf("%s: invalid witness item %q", test.name, item)
+		}
+		witness = append(witness, data)
+	}
+
+	pkScript := script
+	switch test.wrap {
+	case wrapP2SH:
+		sigScript = append(sigScript, mustScript(t,
+			NewScriptBuilder().AddData(script))...)
+		pkScript = mustScript(t, NewScriptBuilder().AddOp(OP_HASH160).
+			AddData(btcutil.Hash160(script)).AddOp(OP_EQUAL))
+
+	case wrapP2WSH, wrapP2SHP2WSH:
+		witness = append(witness, script)
+		scriptHash := sha256.Sum256(script)
+		pkScript = mustScript(t, NewScriptBuilder().AddOp(OP_0).
+			AddData(scriptHash[:]))
+		if test.wrap == wrapP2SHP2WSH {
+			sigScript = append(sigScript, mustScript(t,
+				NewScriptBuilder().AddData(pkScript))...)
+			pkScript = mustScript(t, NewScriptBuilder().
+				AddOp(OP_HASH160).AddData(btcutil.Hash160(pkScript)).
+				AddOp(OP_EQUAL))
+		}
+	}
+
+	version := test.version
+	if version == 0 {
+		version = 2
+	}
+	tx := wire.NewMsgTx(version)
+	tx.LockTime = test.lockTime
+	tx.AddTxIn(&wire.TxIn{
+		SignatureScript: sigScript,
+		Witness:         witness,
+		Sequence:        test.sequence,
+	})
+	tx.AddTxOut(wire.NewTxOut(1000, []byte{OP_TRUE}))
+
+	vm, err := NewEngine(pkScript, tx, 0, test.flags, 1000, checker)
+	if err != nil {
+		return err
+	}
+	return vm.Execute()
+}
+
+// check ensures the passed error is the expected result of the test vector.
+func (test *scriptTest) check(t *testing.T, err error) {
+	if test.valid {
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+		return
+	}
+	if !IsErrorCode(err, test.err) {
+		t.Errorf("%s: got error %v, want %v", test.name, err, test.err)
+	}
+}
+
+// scriptTests are the script test vectors which do not check signatures.
+var scriptTests = []scriptTest{
+	{
+		name:   "push and equal",
+		sig:    "OP_1",
+		script: "OP_1 OP_EQUAL",
+		valid:  true,
+	},
+	{
+		name:   "arithmetic",
+		sig:    "OP_2 OP_3",
+		script: "OP_ADD OP_5 OP_EQUAL",
+		valid:  true,
+	},
+	{
+		name:   "false result",
+		sig:    "OP_0",
+		script: "",
+		err:    ErrEvalFalse,
+	},
+	{
+		name:   "empty stack",
+		script: "OP_1 OP_DROP",
+		err:    ErrEmptyStack,
+	},
+	{
+		name:   "equalverify",
+		script: "OP_2 OP_3 OP_EQUALVERIFY OP_1",
+		err:    ErrEqualVerify,
+	},
+	{
+		name:   "early return",
+		script: "OP_1 OP_RETURN",
+		err:    ErrEarlyReturn,
+	},
+	{
+		name:   "disabled opcode in unexecuted branch",
+		script: "OP_0 OP_IF OP_CAT OP_ENDIF OP_1",
+		err:    ErrDisabledOpcode,
+	},
+	{
+		name:   "reserved opcode in unexecuted branch",
+		script: "OP_0 OP_IF OP_RESERVED OP_ENDIF OP_1",
+		valid:  true,
+	},
+	{
+		name:   "reserved opcode",
+		script: "OP_1 OP_IF OP_RESERVED OP_ENDIF OP_1",
+		err:    ErrReservedOpcode,
+	},
+	{
+		name:   "always illegal opcode in unexecuted branch",
+		script: "OP_0 OP_IF OP_VERIF OP_ENDIF OP_1",
+		err:    ErrReservedOpcode,
+	},
+	{
+		name:   "conditional straddling the end of the script",
+		script: "OP_1 OP_IF OP_1",
+		err:    ErrUnbalancedConditional,
+	},
+	{
+		name:   "endif without if",
+		script: "OP_1 OP_ENDIF",
+		err:    ErrUnbalancedConditional,
+	},
+	{
+		name: "nested conditionals",
+		script: "OP_1 OP_IF OP_0 OP_IF OP_0 OP_ELSE OP_1 OP_ENDIF " +
+			"OP_ELSE OP_0 OP_ENDIF",
+		valid: true,
+	},
+	{
+		name:   "notif",
+		sig:    "OP_0",
+		script: "OP_NOTIF OP_1 OP_ELSE OP_0 OP_ENDIF",
+		valid:  true,
+	},
+	{
+		name: "rot",
+		sig:  "OP_1 OP_2 OP_3",
+		script: "OP_ROT OP_1 OP_EQUALVERIFY OP_3 OP_EQUALVERIFY " +
+			"OP_2 OP_EQUAL",
+		valid: true,
+	},
+	{
+		name: "pick and roll",
+		sig:  "OP_5 OP_6 OP_7",
+		script: "OP_2 OP_PICK OP_5 OP_EQUALVERIFY OP_2 OP_ROLL " +
+			"OP_5 OP_EQUALVERIFY OP_7 OP_EQUALVERIFY OP_6 OP_EQUAL",
+		valid: true,
+	},
+	{
+		name:   "alt stack does not persist",
+		sig:    "OP_1 OP_TOALTSTACK",
+		script: "OP_FROMALTSTACK",
+		err:    ErrInvalidStackOperation,
+	},
+	{
+		name: "sha256",
+		sig:  "'abc'",
+		script: "OP_SHA256 0xba7816bf8f01cfea414140de5dae2223b00361a3" +
+			"96177a9cb410ff61f20015ad OP_EQUAL",
+		valid: true,
+	},
+	{
+		name:   "within",
+		sig:    "OP_3",
+		script: "OP_2 OP_5 OP_WITHIN",
+		valid:  true,
+	},
+	{
+		name:   "size",
+		sig:    "'abc'",
+		script: "OP_SIZE OP_3 OP_EQUALVERIFY OP_DROP OP_1",
+		valid:  true,
+	},
+	{
+		name:   "non-minimal push",
+		script: "OP_DATA_1 0x05 OP_DROP OP_1",
+		valid:  true,
+	},
+	{
+		name:   "non-minimal push with minimal data",
+		script: "OP_DATA_1 0x05 OP_DROP OP_1",
+		flags:  ScriptVerifyMinimalData,
+		err:    ErrMinimalData,
+	},
+	{
+		name:   "upgradable nop",
+		script: "OP_NOP10 OP_1",
+		valid:  true,
+	},
+	{
+		name:   "discouraged upgradable nop",
+		script: "OP_NOP10 OP_1",
+		flags:  ScriptDiscourageUpgradableNops,
+		err:    ErrDiscourageUpgradableNOPs,
+	},
+
+	// Pay-to-script-hash.
+	{
+		name:   "p2sh",
+		sig:    "OP_2",
+		script: "OP_1ADD OP_3 OP_EQUAL",
+		wrap:   wrapP2SH,
+		flags:  ScriptBip16,
+		valid:  true,
+	},
+	{
+		name:   "p2sh redeem script fails",
+		sig:    "OP_3",
+		script: "OP_1ADD OP_3 OP_EQUAL",
+		wrap:   wrapP2SH,
+		flags:  ScriptBip16,
+		err:    ErrEvalFalse,
+	},
+	{
+		name:   "p2sh redeem script not run without bip16",
+		sig:    "OP_3",
+		script: "OP_1ADD OP_3 OP_EQUAL",
+		wrap:   wrapP2SH,
+		valid:  true,
+	},
+	{
+		name:   "p2sh signature script not push only",
+		sig:    "OP_2 OP_NOP",
+		script: "OP_1ADD OP_3 OP_EQUAL",
+		wrap:   wrapP2SH,
+		flags:  ScriptBip16,
+		err:    ErrNotPushOnly,
+	},
+	{
+		name:   "p2sh clean stack",
+		sig:    "OP_1 OP_2",
+		script: "OP_1ADD OP_3 OP_EQUAL",
+		wrap:   wrapP2SH,
+		flags:  ScriptBip16 | ScriptVerifyCleanStack,
+		err:    ErrCleanStack,
+	},
+
+	// Witness programs.
+	{
+		name:    "p2wsh",
+		script:  "OP_1ADD OP_3 OP_EQUAL",
+		witness: []string{"02"},
+		wrap:    wrapP2WSH,
+		flags:   ScriptBip16 | ScriptVerifyWitness,
+		valid:   true,
+	},
+	{
+		name:    "p2sh-p2wsh",
+		script:  "OP_1ADD OP_3 OP_EQUAL",
+		witness: []string{"02"},
+		wrap:    wrapP2SHP2WSH,
+		flags:   ScriptBip16 | ScriptVerifyWitness,
+		valid:   true,
+	},
+	{
+		name:    "p2wsh witness script fails",
+		script:  "OP_1ADD OP_3 OP_EQUAL",
+		witness: []string{"03"},
+		wrap:    wrapP2WSH,
+		flags:   ScriptBip16 | ScriptVerifyWitness,
+		err:     ErrEvalFalse,
+	},
+	{
+		name:    "p2wsh stack not clean",
+		script:  "OP_1ADD OP_3 OP_EQUAL",
+		witness: []string{"01", "02"},
+		wrap:    wrapP2WSH,
+		flags:   ScriptBip16 | ScriptVerifyWitness,
+		err:     ErrEvalFalse,
+	},
+	{
+		name:    "p2wsh with signature script",
+		sig:     "OP_1",
+		script:  "OP_1ADD OP_3 OP_EQUAL",
+		witness: []string{"02"},
+		wrap:    wrapP2WSH,
+		flags:   ScriptBip16 | ScriptVerifyWitness,
+		err:     ErrWitnessMalleated,
+	},
+	{
+		name: "p2wsh witness script hash mismatch",
+		script: "OP_0 0x00000000000000000000000000000000000000000000" +
+			"00000000000000000000",
+		witness: []string{"51"},
+		flags:   ScriptBip16 | ScriptVerifyWitness,
+		err:     ErrWitnessProgramMismatch,
+	},
+	{
+		name: "p2wsh empty witness",
+		script: "OP_0 0x00000000000000000000000000000000000000000000" +
+			"00000000000000000000",
+		flags: ScriptBip16 | ScriptVerifyWitness,
+		err:   ErrWitnessProgramEmpty,
+	},
+	{
+		name:    "unexpected witness",
+		script:  "OP_1",
+		witness: []string{"01"},
+		flags:   ScriptBip16 | ScriptVerifyWitness,
+		err:     ErrWitnessUnexpected,
+	},
+	{
+		name:    "minimal if not enforced",
+		script:  "OP_IF OP_1 OP_ELSE OP_0 OP_ENDIF",
+		witness: []string{"02"},
+		wrap:    wrapP2WSH,
+		flags:   ScriptBip16 | ScriptVerifyWitness,
+		valid:   true,
+	},
+	{
+		name:    "minimal if",
+		script:  "OP_IF OP_1 OP_ELSE OP_0 OP_ENDIF",
+		witness: []string{"02"},
+		wrap:    wrapP2WSH,
+		flags:   ScriptBip16 | ScriptVerifyWitness | ScriptVerifyMinimalIf,
+		err:     ErrMinimalIf,
+	},
+	{
+		name: "unknown witness version",
+		script: "OP_1 0x01010101010101010101010101010101010101010101" +
+			"01010101010101010101",
+		flags: ScriptBip16 | ScriptVerifyWitness,
+		valid: true,
+	},
+	{
+		name: "discouraged unknown witness version",
+		script: "OP_1 0x01010101010101010101010101010101010101010101" +
+			"01010101010101010101",
+		flags: ScriptBip16 | ScriptVerifyWitness |
+			ScriptVerifyDiscourageUpgradeableWitnessProgram,
+		err: ErrDiscourageUpgradableWitnessProgram,
+	},
+
+	// OP_CHECKLOCKTIMEVERIFY.
+	{
+		name:     "cltv",
+		script:   "+500 OP_CHECKLOCKTIMEVERIFY OP_DROP OP_1",
+		flags:    ScriptVerifyCheckLockTimeVerify,
+		lockTime: 500,
+		valid:    true,
+	},
+	{
+		name:     "cltv lock time not reached",
+		script:   "+500 OP_CHECKLOCKTIMEVERIFY OP_DROP OP_1",
+		flags:    ScriptVerifyCheckLockTimeVerify,
+		lockTime: 499,
+		err:      ErrUnsatisfiedLockTime,
+	},
+	{
+		name:     "cltv lock time of another type",
+		script:   "+500 OP_CHECKLOCKTIMEVERIFY OP_DROP OP_1",
+		flags:    ScriptVerifyCheckLockTimeVerify,
+		lockTime: LockTimeThreshold,
+		err:      ErrUnsatisfiedLockTime,
+	},
+	{
+		name:     "cltv finalized input",
+		script:   "+500 OP_CHECKLOCKTIMEVERIFY OP_DROP OP_1",
+		flags:    ScriptVerifyCheckLockTimeVerify,
+		lockTime: 500,
+		sequence: wire.MaxTxInSequenceNum,
+		err:      ErrUnsatisfiedLockTime,
+	},
+	{
+		name:   "cltv negative lock time",
+		script: "-1 OP_CHECKLOCKTIMEVERIFY OP_DROP OP_1",
+		flags:  ScriptVerifyCheckLockTimeVerify,
+		err:    ErrNegativeLockTime,
+	},
+	{
+		name:   "cltv as nop",
+		script: "+500 OP_CHECKLOCKTIMEVERIFY OP_DROP OP_1",
+		valid:  true,
+	},
+	{
+		name:   "cltv as discouraged nop",
+		script: "+500 OP_CHECKLOCKTIMEVERIFY OP_DROP OP_1",
+		flags:  ScriptDiscourageUpgradableNops,
+		err:    ErrDiscourageUpgradableNOPs,
+	},
+
+	// OP_CHECKSEQUENCEVERIFY.
+	{
+		name:     "csv",
+		script:   "OP_10 OP_CHECKSEQUENCEVERIFY OP_DROP OP_1",
+		flags:    ScriptVerifyCheckSequenceVerify,
+		sequence: 10,
+		valid:    true,
+	},
+	{
+		name:     "csv sequence not reached",
+		script:   "OP_10 OP_CHECKSEQUENCEVERIFY OP_DROP OP_1",
+		flags:    ScriptVerifyCheckSequenceVerify,
+		sequence: 9,
+		err:      ErrUnsatisfiedLockTime,
+	},
+	{
+		name:     "csv transaction version 1",
+		script:   "OP_10 OP_CHECKSEQUENCEVERIFY OP_DROP OP_1",
+		flags:    ScriptVerifyCheckSequenceVerify,
+		version:  1,
+		sequence: 10,
+		err:      ErrUnsatisfiedLockTime,
+	},
+	{
+		name:     "csv input with relative lock time disabled",
+		script:   "OP_10 OP_CHECKSEQUENCEVERIFY OP_DROP OP_1",
+		flags:    ScriptVerifyCheckSequenceVerify,
+		sequence: wire.SequenceLockTimeDisabled | 10,
+		err:      ErrUnsatisfiedLockTime,
+	},
+	{
+		name:     "csv with disable flag is a nop",
+		script:   "0x0000008000 OP_CHECKSEQUENCEVERIFY OP_DROP OP_1",
+		flags:    ScriptVerifyCheckSequenceVerify,
+		version:  1,
+		sequence: wire.MaxTxInSequenceNum,
+		valid:    true,
+	},
+	{
+		name:     "csv sequence of another type",
+		script:   "+4194314 OP_CHECKSEQUENCEVERIFY OP_DROP OP_1",
+		flags:    ScriptVerifyCheckSequenceVerify,
+		sequence: 10,
+		err:      ErrUnsatisfiedLockTime,
+	},
+	{
+		name:   "csv negative sequence",
+		script: "-1 OP_CHECKSEQUENCEVERIFY OP_DROP OP_1",
+		flags:  ScriptVerifyCheckSequenceVerify,
+		err:    ErrNegativeLockTime,
+	},
+}
+
+// TestScripts ensures the script test vectors execute with the expected
+// result.
+func TestScripts(t *testing.T) {
+	for i := range scriptTests {
+		test := &scriptTests[i]
+		test.check(t, test.run(t, nil))
+	}
+}
+
+// TestScriptSigChecks ensures OP_CHECKSIG, OP_CHECKMULTISIG and their verify
+// variants check the encoding of signatures and public keys as the flags
+// require, and verify the signatures through the SigChecker of the engine.
+func TestScriptSigChecks(t *testing.T) {
+	pkA := fmt.Sprintf("0x%x", testPubKey(0xa1))
+	pkB := fmt.Sprintf("0x%x", testPubKey(0xb2))
+	pkC := fmt.Sprintf("0x%x", testPubKey(0xc3))
+	evenPk := fmt.Sprintf("0x02%x", testPubKey(0xa1)[1:])
+	multiSig := strings.Join([]string{"OP_2", pkA, pkB, pkC,
+		"OP_3 OP_CHECKMULTISIG"}, " ")
+
+	tests := []struct {
+		scriptTest
+		checks int
+	}{
+		{scriptTest: scriptTest{
+			name:   "checksig",
+			sig:    "0xa101",
+			script: pkA + " OP_CHECKSIG",
+			valid:  true,
+		}, checks: 1},
+		{scriptTest: scriptTest{
+			name:   "checksig invalid signature",
+			sig:    "0xb201",
+			script: pkA + " OP_CHECKSIG",
+			err:    ErrEvalFalse,
+		}, checks: 1},
+		{scriptTest: scriptTest{
+			name:   "checksig invalid signature with null fail",
+			sig:    "0xb201",
+			script: pkA + " OP_CHECKSIG",
+			flags:  ScriptVerifyNullFail,
+			err:    ErrNullFail,
+		}, checks: 1},
+		{scriptTest: scriptTest{
+			name:   "checksig empty signature with null fail",
+			sig:    "OP_0",
+			script: pkA + " OP_CHECKSIG OP_NOT",
+			flags:  ScriptVerifyNullFail,
+			valid:  true,
+		}},
+		{scriptTest: scriptTest{
+			name:   "checksigverify",
+			sig:    "0xb201",
+			script: pkA + " OP_CHECKSIGVERIFY OP_1",
+			err:    ErrCheckSigVerify,
+		}, checks: 1},
+		{scriptTest: scriptTest{
+			name:   "checksig undefined hash type",
+			sig:    "0xa105",
+			script: pkA + " OP_CHECKSIG",
+			flags:  ScriptVerifyStrictEncoding,
+			err:    ErrInvalidSigHashType,
+		}},
+		{scriptTest: scriptTest{
+			name:   "checksig signature not der",
+			sig:    "0xa101",
+			script: pkA + " OP_CHECKSIG",
+			flags:  ScriptVerifyDERSignatures,
+			err:    ErrSigDER,
+		}},
+		{scriptTest: scriptTest{
+			name:   "checksig public key with even prefix",
+			sig:    "0x300602010102010101",
+			script: evenPk + " OP_CHECKSIG",
+			flags:  ScriptVerifyStrictEncoding,
+			err:    ErrPubKeyType,
+		}},
+		{scriptTest: scriptTest{
+			name:    "checksig public key with even prefix in witness",
+			script:  evenPk + " OP_CHECKSIG",
+			witness: []string{"a101"},
+			wrap:    wrapP2WSH,
+			flags: ScriptBip16 | ScriptVerifyWitness |
+				ScriptVerifyWitnessPubKeyType,
+			err: ErrWitnessPubKeyType,
+		}},
+		{scriptTest: scriptTest{
+			name:    "checksig in witness script",
+			script:  pkA + " OP_CHECKSIG",
+			witness: []string{"a101"},
+			wrap:    wrapP2WSH,
+			flags:   ScriptBip16 | ScriptVerifyWitness,
+			valid:   true,
+		}, checks: 1},
+		{scriptTest: scriptTest{
+			name:   "multisig",
+			sig:    "OP_0 0xa101 0xc301",
+			script: multiSig,
+			valid:  true,
+		}, checks: 3},
+		{scriptTest: scriptTest{
+			name:   "multisig signatures out of order",
+			sig:    "OP_0 0xc301 0xa101",
+			script: multiSig,
+			err:    ErrEvalFalse,
+		}, checks: 2},
+		{scriptTest: scriptTest{
+			name:   "multisig invalid signature with null fail",
+			sig:    "OP_0 0xa101 0xa101",
+			script: multiSig,
+			flags:  ScriptVerifyNullFail,
+			err:    ErrNullFail,
+		}, checks: 2},
+		{scriptTest: scriptTest{
+			name:   "multisig dummy",
+			sig:    "OP_1 0xa101 0xc301",
+			script: multiSig,
+			valid:  true,
+		}, checks: 3},
+		{scriptTest: scriptTest{
+			name:   "multisig strict dummy",
+			sig:    "OP_1 0xa101 0xc301",
+			script: multiSig,
+			flags:  ScriptStrictMultiSig,
+			err:    ErrSigNullDummy,
+		}},
+		{scriptTest: scriptTest{
+			name:   "multisig missing dummy",
+			sig:    "0xa101 0xc301",
+			script: multiSig,
+			err:    ErrInvalidStackOperation,
+		}},
+		{scriptTest: scriptTest{
+			name:   "checkmultisigverify",
+			sig:    "OP_0 0xc301 0xa101",
+			script: multiSig + "VERIFY OP_1",
+			err:    ErrCheckMultiSigVerify,
+		}, checks: 2},
+		{scriptTest: scriptTest{
+			name:   "multisig in p2sh",
+			sig:    "OP_0 0xa101 0xc301",
+			script: multiSig,
+			wrap:   wrapP2SH,
+			flags:  ScriptBip16 | ScriptStrictMultiSig,
+			valid:  true,
+		}, checks: 3},
+		{scriptTest: scriptTest{
+			name:    "multisig in witness script",
+			script:  multiSig,
+			witness: []string{"", "a101", "c301"},
+			wrap:    wrapP2WSH,
+			flags:   ScriptBip16 | ScriptVerifyWitness,
+			valid:   true,
+		}, checks: 3},
+	}
+	for i := range tests {
+		test := &tests[i]
+		checker := &testSigChecker{}
+		test.check(t, test.run(t, checker))
+		if len(checker.sigs) != test.checks {
+			t.Errorf("%s: checked %d signatures, want %d", test.name,
+				len(checker.sigs), test.checks)
+		}
+	}
+}
+
+// TestScriptSigCheckArgs ensures the SigChecker of the engine is passed the
+// script code signatures commit to, which starts after the last executed
+// OP_CODESEPARATOR, along with the hash type and whether the signature hash is
+// that of witness programs.
+func TestScriptSigCheckArgs(t *testing.T) {
+	pubKey := testPubKey(0xa1)
+	pkHash := btcutil.Hash160(pubKey)
+	pkHashScript, err := payToPubKeyHashScript(pkHash)
+	if err != nil {
+		t.Fatalf("payToPubKeyHashScript: unexpected error: %v", err)
+	}
+	pkA := fmt.Sprintf("0x%x", pubKey)
+	checkSigScript := mustAssemble(t, pkA+" OP_CHECKSIG")
+
+	tests := []struct {
+		scriptTest
+		scriptCode []byte
+		hashType   SigHashType
+		witness    bool
+	}{
+		{
+			scriptTest: scriptTest{
+				name:   "script code of public key script",
+				sig:    "0xa101",
+				script: pkA + " OP_CHECKSIG",
+				valid:  true,
+			},
+			scriptCode: checkSigScript,
+			hashType:   SigHashAll,
+		},
+		{
+			scriptTest: scriptTest{
+				name: "script code after code separator",
+				sig:  "0xa182",
+				script: "OP_1 OP_DROP OP_CODESEPARATOR " + pkA +
+					" OP_CHECKSIG",
+				valid: true,
+			},
+			scriptCode: checkSigScript,
+			hashType:   SigHashNone | SigHashAnyOneCanPay,
+		},
+		{
+			scriptTest: scriptTest{
+				name:    "script code of witness pubkey hash",
+				script:  fmt.Sprintf("OP_0 0x%x", pkHash),
+				witness: []string{"a103", hex.EncodeToString(pubKey)},
+				flags:   ScriptBip16 | ScriptVerifyWitness,
+				valid:   true,
+			},
+			scriptCode: pkHashScript,
+			hashType:   SigHashSingle,
+			witness:    true,
+		},
+	}
+	for i := range tests {
+		test := &tests[i]
+		checker := &testSigChecker{}
+		test.check(t, test.run(t, checker))
+		if len(checker.sigs) != 1 {
+			t.Errorf("%s: checked %d signatures, want 1", test.name,
+				len(checker.sigs))
+			continue
+		}
+		if !bytes.Equal(checker.scriptCodes[0], test.scriptCode) {
+			t.Errorf("%s: script code %x, want %x", test.name,
+				checker.scriptCodes[0], test.scriptCode)
+		}
+		if checker.hashTypes[0] != test.hashType {
+			t.Errorf("%s: hash type %v, want %v", test.name,
+				checker.hashTypes[0], test.hashType)
+		}
+		if checker.witness[0] != test.witness {
+			t.Errorf("%s: witness %v, want %v", test.name,
+				checker.witness[0], test.witness)
+		}
+	}
+}
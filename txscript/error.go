@@ -276,6 +276,28 @@ const (
 	// serialized in a compressed format.
 	ErrWitnessPubKeyType
 
+	// ErrUnsupportedScript is returned when a script is not of a form
+	// supported by the operation performed on it, such as estimating the
+	// size of the data needed to spend a non-standard script.
+	ErrUnsupportedScript
+
+	// ErrDustOutput is returned when a transaction being built would pay
+	// an output that is dust once the fee is deducted from its value.
+	ErrDustOutput
+
+	// ErrInvalidLockTime is returned when a lock time passed to a script
+	// template can not be enforced, such as a relative lock time that is
+	// out of range or has its disable flag set.
+	ErrInvalidLockTime
+
+	// ErrMissingPrevOut is returned when the output spent by a transaction
+	// input being validated can not be found.
+	ErrMissingPrevOut
+
+	// ErrInvalidAsm is returned from AssembleScript when the passed text
+	// is not a valid script in its human-readable form.
+	ErrInvalidAsm
+
 	// numErrorCodes is the maximum error code number used in tests.  This
 	// entry MUST be the last entry in the enum.
 	numErrorCodes
@@ -333,6 +355,11 @@ var errorCodeStrings = map[ErrorCode]string{
 	ErrMinimalIf:                          "ErrMinimalIf",
 	ErrWitnessPubKeyType:                  "ErrWitnessPubKeyType",
 	ErrDiscourageUpgradableWitnessProgram: "ErrDiscourageUpgradableWitnessProgram",
+	ErrUnsupportedScript:                  "ErrUnsupportedScript",
+	ErrDustOutput:                         "ErrDustOutput",
+	ErrInvalidLockTime:                    "ErrInvalidLockTime",
+	ErrMissingPrevOut:                     "ErrMissingPrevOut",
+	ErrInvalidAsm:                         "ErrInvalidAsm",
 }
 
 // String returns the ErrorCode as a human-readable name.
@@ -0,0 +1,833 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/nbcorg/btcd/chaincfg/chainhash"
+	"github.com/nbcorg/btcd/wire"
+	"github.com/nbcorg/btcutil"
+	"golang.org/x/crypto/ripemd160"
+)
+
+// execute performs the passed opcode, which is known not to be disabled or
+// always illegal, on the script engine.
+func (vm *Engine) execute(pop *parsedOpcode) error {
+	switch op := pop.opcode.value; {
+	// Push an empty array for OP_0 and the data of the data pushes.
+	case op <= OP_PUSHDATA4:
+		vm.dstack.PushByteArray(pop.data)
+		return nil
+
+	case op == OP_1NEGATE:
+		vm.dstack.PushInt(scriptNum(-1))
+		return nil
+
+	case op >= OP_1 && op <= OP_16:
+		vm.dstack.PushInt(scriptNum(asSmallInt(pop.opcode)))
+		return nil
+
+	case op == OP_RESERVED || op == OP_VER || op == OP_RESERVED1 ||
+		op == OP_RESERVED2:
+
+		str := fmt.Sprintf("attempt to execute reserved opcode %s",
+			pop.opcode.name)
+		return scriptError(ErrReservedOpcode, str)
+
+	case op == OP_NOP:
+		return nil
+
+	case op == OP_NOP1 || op >= OP_NOP4 && op <= OP_NOP10:
+		// Other NOP opcodes are reserved for future soft-fork
+		// upgrades, so discourage their use when requested.
+		if vm.hasFlag(ScriptDiscourageUpgradableNops) {
+			str := fmt.Sprintf("%s reserved for soft-fork upgrades",
+				pop.opcode.name)
+			return scriptError(ErrDiscourageUpgradableNOPs, str)
+		}
+		return nil
+
+	case op == OP_IF || op == OP_NOTIF:
+		return vm.opcodeIf(op == OP_NOTIF)
+
+	case op == OP_ELSE || op == OP_ENDIF:
+		if len(vm.condStack) == 0 {
+			str := fmt.Sprintf("encountered opcode %s with no "+
+				"matching opcode to begin conditional execution",
+				pop.opcode.name)
+			return scriptError(ErrUnbalancedConditional, str)
+		}
+
+		conditionalIdx := len(vm.condStack) - 1
+		if op == OP_ENDIF {
+			vm.condStack = vm.condStack[:conditionalIdx]
+			return nil
+		}
+		switch vm.condStack[conditionalIdx] {
+		case OpCondTrue:
+			vm.condStack[conditionalIdx] = OpCondFalse
+		case OpCondFalse:
+			vm.condStack[conditionalIdx] = OpCondTrue
+		case OpCondSkip:
+			// Value doesn't change in skip since it indicates this
+			// opcode is nested in a non-executed branch.
+		}
+		return nil
+
+	case op == OP_VERIFY:
+		return vm.verify(pop, ErrVerify)
+
+	case op == OP_RETURN:
+		return scriptError(ErrEarlyReturn, "script returned early")
+
+	case op == OP_CHECKLOCKTIMEVERIFY:
+		return vm.opcodeCheckLockTimeVerify(pop)
+
+	case op == OP_CHECKSEQUENCEVERIFY:
+		return vm.opcodeCheckSequenceVerify(pop)
+
+	case op == OP_TOALTSTACK:
+		so, err := vm.dstack.PopByteArray()
+		if err != nil {
+			return err
+		}
+		vm.astack.PushByteArray(so)
+		return nil
+
+	case op == OP_FROMALTSTACK:
+		so, err := vm.astack.PopByteArray()
+		if err != nil {
+			return err
+		}
+		vm.dstack.PushByteArray(so)
+		return nil
+
+	case op >= OP_2DROP && op <= OP_TUCK || op == OP_SIZE:
+		return vm.opcodeStack(op)
+
+	case op == OP_EQUAL || op == OP_EQUALVERIFY:
+		a, err := vm.dstack.PopByteArray()
+		if err != nil {
+			return err
+		}
+		b, err := vm.dstack.PopByteArray()
+		if err != nil {
+			return err
+		}
+		vm.dstack.PushBool(bytes.Equal(a, b))
+		if op == OP_EQUALVERIFY {
+			return vm.verify(pop, ErrEqualVerify)
+		}
+		return nil
+
+	case op >= OP_1ADD && op <= OP_0NOTEQUAL:
+		return vm.opcodeUnaryNum(op)
+
+	case op >= OP_ADD && op <= OP_MAX:
+		if err := vm.opcodeBinaryNum(op); err != nil {
+			return err
+		}
+		if op == OP_NUMEQUALVERIFY {
+			return vm.verify(pop, ErrNumEqualVerify)
+		}
+		return nil
+
+	case op == OP_WITHIN:
+		maxVal, err := vm.dstack.PopInt()
+		if err != nil {
+			return err
+		}
+		minVal, err := vm.dstack.PopInt()
+		if err != nil {
+			return err
+		}
+		x, err := vm.dstack.PopInt()
+		if err != nil {
+			return err
+		}
+		vm.dstack.PushBool(x >= minVal && x < maxVal)
+		return nil
+
+	case op >= OP_RIPEMD160 && op <= OP_HASH256:
+		return vm.opcodeHash(op)
+
+	case op == OP_CODESEPARATOR:
+		vm.lastCodeSep = vm.scriptOff
+		return nil
+
+	case op == OP_CHECKSIG || op == OP_CHECKSIGVERIFY:
+		if err := vm.opcodeCheckSig(); err != nil {
+			return err
+		}
+		if op == OP_CHECKSIGVERIFY {
+			return vm.verify(pop, ErrCheckSigVerify)
+		}
+		return nil
+
+	case op == OP_CHECKMULTISIG || op == OP_CHECKMULTISIGVERIFY:
+		if err := vm.opcodeCheckMultiSig(); err != nil {
+			return err
+		}
+		if op == OP_CHECKMULTISIGVERIFY {
+			return vm.verify(pop, ErrCheckMultiSigVerify)
+		}
+		return nil
+	}
+
+	str := fmt.Sprintf("attempt to execute invalid opcode %s",
+		pop.opcode.name)
+	return scriptError(ErrReservedOpcode, str)
+}
+
+// verify pops the top item of the data stack and returns an error of the
+// passed kind when it is false.  It is used by OP_VERIFY and the opcodes that
+// verify their result.
+func (vm *Engine) verify(pop *parsedOpcode, c ErrorCode) error {
+	verified, err := vm.dstack.PopBool()
+	if err != nil {
+		return err
+	}
+
+	if !verified {
+		str := fmt.Sprintf("%s failed", pop.opcode.name)
+		return scriptError(c, str)
+	}
+	return nil
+}
+
+// popIfBool enforces the "minimal if" policy during script execution if the
+// particular flag is set.  If so, in order to eliminate an additional source
+// of nuisance malleability, post-segwit for version 0 witness programs, we now
+// require the following: for OP_IF and OP_NOT_IF, the top stack item MUST
+// either be an empty byte slice, or [0x01]. Otherwise, the item at the top of
+// the stack will be popped and interpreted as a boolean.
+func (vm *Engine) popIfBool() (bool, error) {
+	// When not in witness execution mode, not executing a v0 witness
+	// program, or the minimal if flag isn't set pop the top stack item as
+	// a normal bool.
+	if !vm.isWitnessVersionActive(0) || !vm.hasFlag(ScriptVerifyMinimalIf) {
+		return vm.dstack.PopBool()
+	}
+
+	// At this point, a v0 witness program is being executed and the minimal
+	// if flag is set, so enforce additional constraints on the top stack
+	// item.
+	so, err := vm.dstack.PopByteArray()
+	if err != nil {
+		return false, err
+	}
+
+	// The top element MUST have a length of at least one.
+	if len(so) > 1 {
+		str := fmt.Sprintf("minimal if is active, top element MUST "+
+			"have a length of at least, instead length is %v",
+			len(so))
+		return false, scriptError(ErrMinimalIf, str)
+	}
+
+	// Additionally, if the length is one, then the value MUST be 0x01.
+	if len(so) == 1 && so[0] != 0x01 {
+		str := fmt.Sprintf("minimal if is active, top stack item MUST "+
+			"be an empty byte array or 0x01, is instead: %v",
+			so[0])
+		return false, scriptError(ErrMinimalIf, str)
+	}
+
+	return asBool(so), nil
+}
+
+// opcodeIf treats the top item on the data stack as a boolean and removes it.
+// An appropriate entry is added to the conditional stack depending on whether
+// the boolean is true, or false for OP_NOTIF, and whether this if is on an
+// executing branch in order to allow proper execution of further opcodes
+// depending on the conditional logic.  When the boolean is true, the first
+// branch will be executed (unless this opcode is nested in a non-executed
+// branch).
+//
+// Conditional stack transformation: [... x] -> [... x cond]
+func (vm *Engine) opcodeIf(notIf bool) error {
+	condVal := OpCondFalse
+	if vm.isBranchExecuting() {
+		ok, err := vm.popIfBool()
+		if err != nil {
+			return err
+		}
+
+		if ok != notIf {
+			condVal = OpCondTrue
+		}
+	} else {
+		condVal = OpCondSkip
+	}
+	vm.condStack = append(vm.condStack, condVal)
+	return nil
+}
+
+// verifyLockTime is a helper function used to validate locktimes.
+func verifyLockTime(txLockTime, threshold, lockTime int64) error {
+	// The lockTimes in both the script and transaction must be of the same
+	// type.
+	if !((txLockTime < threshold && lockTime < threshold) ||
+		(txLockTime >= threshold && lockTime >= threshold)) {
+		str := fmt.Sprintf("mismatched locktime types -- tx locktime "+
+			"%d, stack locktime %d", txLockTime, lockTime)
+		return scriptError(ErrUnsatisfiedLockTime, str)
+	}
+
+	if lockTime > txLockTime {
+		str := fmt.Sprintf("locktime requirement not satisfied -- "+
+			"locktime is greater than the transaction locktime: "+
+			"%d > %d", lockTime, txLockTime)
+		return scriptError(ErrUnsatisfiedLockTime, str)
+	}
+
+	return nil
+}
+
+// opcodeCheckLockTimeVerify compares the top item on the data stack to the
+// LockTime field of the transaction containing the script signature
+// validating if the transaction outputs are spendable yet.  If flag
+// ScriptVerifyCheckLockTimeVerify is not set, the code continues as if OP_NOP2
+// were executed.
+func (vm *Engine) opcodeCheckLockTimeVerify(pop *parsedOpcode) error {
+	// If the ScriptVerifyCheckLockTimeVerify script flag is not set, treat
+	// opcode as OP_NOP2 instead.
+	if !vm.hasFlag(ScriptVerifyCheckLockTimeVerify) {
+		if vm.hasFlag(ScriptDiscourageUpgradableNops) {
+			return scriptError(ErrDiscourageUpgradableNOPs,
+				"OP_NOP2 reserved for soft-fork upgrades")
+		}
+		return nil
+	}
+
+	// The current transaction locktime is a uint32 resulting in a maximum
+	// locktime of 2^32-1 (the year 2106).  However, scriptNums are signed
+	// and therefore a standard 4-byte scriptNum would only support up to a
+	// maximum of 2^31-1 (the year 2038).  Thus, a 5-byte scriptNum is used
+	// here since it will support up to 2^39-1 which allows dates beyond the
+	// current locktime limit.
+	//
+	// PeekByteArray is used here instead of PeekInt because we do not want
+	// to be limited to a 4-byte integer for reasons specified above.
+	so, err := vm.dstack.PeekByteArray(0)
+	if err != nil {
+		return err
+	}
+	lockTime, err := makeScriptNum(so, vm.dstack.verifyMinimalData, 5)
+	if err != nil {
+		return err
+	}
+
+	// In the rare event that the argument needs to be < 0 due to some
+	// arithmetic being done first, you can always use
+	// 0 OP_MAX OP_CHECKLOCKTIMEVERIFY.
+	if lockTime < 0 {
+		str := fmt.Sprintf("negative lock time: %d", lockTime)
+		return scriptError(ErrNegativeLockTime, str)
+	}
+
+	// The lock time field of a transaction is either a block height at
+	// which the transaction is finalized or a timestamp depending on if the
+	// value is before the txscript.LockTimeThreshold.  When it is under the
+	// threshold it is a block height.
+	err = verifyLockTime(int64(vm.tx.LockTime), LockTimeThreshold,
+		int64(lockTime))
+	if err != nil {
+		return err
+	}
+
+	// The lock time feature can also be disabled, thereby bypassing
+	// OP_CHECKLOCKTIMEVERIFY, if every transaction input has been finalized
+	// by setting its sequence to the maximum value
+	// (wire.MaxTxInSequenceNum).  This condition would result in the
+	// transaction being allowed into the blockchain making the opcode
+	// ineffective.
+	//
+	// This condition is prevented by enforcing that the input being used by
+	// the opcode is unlocked (its sequence number is less than the max
+	// value).  This is sufficient to prove correctness without having to
+	// check every input.
+	//
+	// NOTE: This implies that even if the transaction is not finalized due
+	// to another input being unlocked, the opcode execution will still fail
+	// when the input being used by the opcode is locked.
+	if vm.tx.TxIn[vm.txIdx].Sequence == wire.MaxTxInSequenceNum {
+		return scriptError(ErrUnsatisfiedLockTime,
+			"transaction input is finalized")
+	}
+
+	return nil
+}
+
+// opcodeCheckSequenceVerify compares the top item on the data stack to the
+// Sequence field of the input containing the script signature validating if
+// the output being spent is old enough.  If flag
+// ScriptVerifyCheckSequenceVerify is not set, the code continues as if OP_NOP3
+// were executed.
+func (vm *Engine) opcodeCheckSequenceVerify(pop *parsedOpcode) error {
+	// If the ScriptVerifyCheckSequenceVerify script flag is not set, treat
+	// opcode as OP_NOP3 instead.
+	if !vm.hasFlag(ScriptVerifyCheckSequenceVerify) {
+		if vm.hasFlag(ScriptDiscourageUpgradableNops) {
+			return scriptError(ErrDiscourageUpgradableNOPs,
+				"OP_NOP3 reserved for soft-fork upgrades")
+		}
+		return nil
+	}
+
+	// The current transaction sequence is a uint32 resulting in a maximum
+	// sequence of 2^32-1.  However, scriptNums are signed and therefore a
+	// standard 4-byte scriptNum would only support up to a maximum of
+	// 2^31-1.  Thus, a 5-byte scriptNum is used here since it will support
+	// up to 2^39-1 which allows sequences beyond the current sequence
+	// limit.
+	//
+	// PeekByteArray is used here instead of PeekInt because we do not want
+	// to be limited to a 4-byte integer for reasons specified above.
+	so, err := vm.dstack.PeekByteArray(0)
+	if err != nil {
+		return err
+	}
+	stackSequence, err := makeScriptNum(so, vm.dstack.verifyMinimalData, 5)
+	if err != nil {
+		return err
+	}
+
+	// In the rare event that the argument needs to be < 0 due to some
+	// arithmetic being done first, you can always use
+	// 0 OP_MAX OP_CHECKSEQUENCEVERIFY.
+	if stackSequence < 0 {
+		str := fmt.Sprintf("negative sequence: %d", stackSequence)
+		return scriptError(ErrNegativeLockTime, str)
+	}
+
+	sequence := int64(stackSequence)
+
+	// To provide for future soft-fork extensibility, if the
+	// operand has the disabled lock-time flag set,
+	// CHECKSEQUENCEVERIFY behaves as a NOP.
+	if sequence&int64(wire.SequenceLockTimeDisabled) != 0 {
+		return nil
+	}
+
+	// Transaction version numbers not high enough to trigger CSV rules must
+	// fail.
+	if vm.tx.Version < 2 {
+		str := fmt.Sprintf("invalid transaction version: %d",
+			vm.tx.Version)
+		return scriptError(ErrUnsatisfiedLockTime, str)
+	}
+
+	// Sequence numbers with their most significant bit set are not
+	// consensus constrained. Testing that the transaction's sequence
+	// number does not have this bit set prevents using this property
+	// to get around a CHECKSEQUENCEVERIFY check.
+	txSequence := int64(vm.tx.TxIn[vm.txIdx].Sequence)
+	if txSequence&int64(wire.SequenceLockTimeDisabled) != 0 {
+		str := fmt.Sprintf("transaction sequence has sequence "+
+			"locktime disabled bit set: 0x%x", txSequence)
+		return scriptError(ErrUnsatisfiedLockTime, str)
+	}
+
+	// Mask off non-consensus bits before doing comparisons.
+	lockTimeMask := int64(wire.SequenceLockTimeIsSeconds |
+		wire.SequenceLockTimeMask)
+	return verifyLockTime(txSequence&lockTimeMask,
+		wire.SequenceLockTimeIsSeconds, sequence&lockTimeMask)
+}
+
+// opcodeStack performs the passed opcode which rearranges or inspects the
+// items of the data stack.
+func (vm *Engine) opcodeStack(op byte) error {
+	switch op {
+	case OP_2DROP:
+		return vm.dstack.DropN(2)
+	case OP_2DUP:
+		return vm.dstack.DupN(2)
+	case OP_3DUP:
+		return vm.dstack.DupN(3)
+	case OP_2OVER:
+		return vm.dstack.OverN(2)
+	case OP_2ROT:
+		return vm.dstack.RotN(2)
+	case OP_2SWAP:
+		return vm.dstack.SwapN(2)
+	case OP_DROP:
+		return vm.dstack.DropN(1)
+	case OP_DUP:
+		return vm.dstack.DupN(1)
+	case OP_NIP:
+		return vm.dstack.NipN(1)
+	case OP_OVER:
+		return vm.dstack.OverN(1)
+	case OP_ROT:
+		return vm.dstack.RotN(1)
+	case OP_SWAP:
+		return vm.dstack.SwapN(1)
+	case OP_TUCK:
+		return vm.dstack.Tuck()
+
+	case OP_IFDUP:
+		// Duplicate the top item when it is true.
+		so, err := vm.dstack.PeekByteArray(0)
+		if err != nil {
+			return err
+		}
+		if asBool(so) {
+			vm.dstack.PushByteArray(so)
+		}
+		return nil
+
+	case OP_DEPTH:
+		vm.dstack.PushInt(scriptNum(vm.dstack.Depth()))
+		return nil
+
+	case OP_PICK, OP_ROLL:
+		val, err := vm.dstack.PopInt()
+		if err != nil {
+			return err
+		}
+		if op == OP_PICK {
+			return vm.dstack.PickN(val.Int32())
+		}
+		return vm.dstack.RollN(val.Int32())
+
+	case OP_SIZE:
+		so, err := vm.dstack.PeekByteArray(0)
+		if err != nil {
+			return err
+		}
+		vm.dstack.PushInt(scriptNum(len(so)))
+		return nil
+	}
+
+	str := fmt.Sprintf("opcode %s is not a stack operation",
+		opcodeArray[op].name)
+	return scriptError(ErrInternal, str)
+}
+
+// opcodeUnaryNum performs the passed numeric opcode which replaces the top
+// item of the data stack.
+func (vm *Engine) opcodeUnaryNum(op byte) error {
+	m, err := vm.dstack.PopInt()
+	if err != nil {
+		return err
+	}
+
+	switch op {
+	case OP_1ADD:
+		m++
+	case OP_1SUB:
+		m--
+	case OP_NEGATE:
+		m = -m
+	case OP_ABS:
+		if m < 0 {
+			m = -m
+		}
+	case OP_NOT:
+		if m == 0 {
+			m = 1
+		} else {
+			m = 0
+		}
+	case OP_0NOTEQUAL:
+		if m != 0 {
+			m = 1
+		}
+	}
+	vm.dstack.PushInt(m)
+	return nil
+}
+
+// opcodeBinaryNum performs the passed numeric opcode which replaces the top
+// two items of the data stack, where v0 is the top item and v1 the one below.
+func (vm *Engine) opcodeBinaryNum(op byte) error {
+	v0, err := vm.dstack.PopInt()
+	if err != nil {
+		return err
+	}
+	v1, err := vm.dstack.PopInt()
+	if err != nil {
+		return err
+	}
+
+	switch op {
+	case OP_ADD:
+		vm.dstack.PushInt(v1 + v0)
+	case OP_SUB:
+		vm.dstack.PushInt(v1 - v0)
+	case OP_BOOLAND:
+		vm.dstack.PushBool(v0 != 0 && v1 != 0)
+	case OP_BOOLOR:
+		vm.dstack.PushBool(v0 != 0 || v1 != 0)
+	case OP_NUMEQUAL, OP_NUMEQUALVERIFY:
+		vm.dstack.PushBool(v0 == v1)
+	case OP_NUMNOTEQUAL:
+		vm.dstack.PushBool(v0 != v1)
+	case OP_LESSTHAN:
+		vm.dstack.PushBool(v1 < v0)
+	case OP_GREATERTHAN:
+		vm.dstack.PushBool(v1 > v0)
+	case OP_LESSTHANOREQUAL:
+		vm.dstack.PushBool(v1 <= v0)
+	case OP_GREATERTHANOREQUAL:
+		vm.dstack.PushBool(v1 >= v0)
+	case OP_MIN:
+		if v1 < v0 {
+			v0 = v1
+		}
+		vm.dstack.PushInt(v0)
+	case OP_MAX:
+		if v1 > v0 {
+			v0 = v1
+		}
+		vm.dstack.PushInt(v0)
+	default:
+		str := fmt.Sprintf("opcode %s is not a numeric operation",
+			opcodeArray[op].name)
+		return scriptError(ErrInternal, str)
+	}
+	return nil
+}
+
+// calcHash calculates the hash of hasher over buf.
+func calcHash(buf []byte, hasher interface {
+	Write([]byte) (int, error)
+	Sum([]byte) []byte
+}) []byte {
+	hasher.Write(buf)
+	return hasher.Sum(nil)
+}
+
+// opcodeHash replaces the top item of the data stack with its hash by the
+// passed hashing opcode.  OP_HASH160 hashes with btcutil.Hash160, which is the
+// hash pay-to-pubkey-hash and pay-to-script-hash scripts commit to.
+func (vm *Engine) opcodeHash(op byte) error {
+	buf, err := vm.dstack.PopByteArray()
+	if err != nil {
+		return err
+	}
+
+	switch op {
+	case OP_RIPEMD160:
+		vm.dstack.PushByteArray(calcHash(buf, ripemd160.New()))
+	case OP_SHA1:
+		hash := sha1.Sum(buf)
+		vm.dstack.PushByteArray(hash[:])
+	case OP_SHA256:
+		hash := sha256.Sum256(buf)
+		vm.dstack.PushByteArray(hash[:])
+	case OP_HASH160:
+		vm.dstack.PushByteArray(btcutil.Hash160(buf))
+	case OP_HASH256:
+		vm.dstack.PushByteArray(chainhash.DoubleHashB(buf))
+	}
+	return nil
+}
+
+// opcodeCheckSig treats the top 2 items on the stack as a public key and a
+// signature and replaces them with a bool which indicates if the signature was
+// successfully verified by the SigChecker of the engine.
+//
+// The signature hash commits to the script since the most recent
+// OP_CODESEPARATOR, from which the signature itself is removed outside of
+// witness programs since there is no way for a signature to sign itself.
+//
+// Stack transformation: [... signature pubkey] -> [... bool]
+func (vm *Engine) opcodeCheckSig() error {
+	pkBytes, err := vm.dstack.PopByteArray()
+	if err != nil {
+		return err
+	}
+
+	fullSigBytes, err := vm.dstack.PopByteArray()
+	if err != nil {
+		return err
+	}
+
+	// The signature actually needs needs to be longer than this, but at
+	// least 1 byte is needed for the hash type below.  The full length is
+	// checked depending on the script flags and upon parsing the signature.
+	if len(fullSigBytes) < 1 {
+		vm.dstack.PushBool(false)
+		return nil
+	}
+
+	// Get script starting from the most recent OP_CODESEPARATOR.
+	subScript := vm.subScript()
+	if !vm.isWitnessVersionActive(0) {
+		subScript = removeOpcodeByData(subScript, fullSigBytes)
+	}
+
+	valid, err := vm.checkSig(fullSigBytes, pkBytes, subScript)
+	if err != nil {
+		return err
+	}
+
+	if !valid && vm.hasFlag(ScriptVerifyNullFail) {
+		str := "signature not empty on failed checksig"
+		return scriptError(ErrNullFail, str)
+	}
+
+	vm.dstack.PushBool(valid)
+	return nil
+}
+
+// opcodeCheckMultiSig treats the top item on the stack as an integer number of
+// public keys, followed by that many entries as raw data representing the
+// public keys, followed by the integer number of signatures, followed by that
+// many entries as raw data representing the signatures.
+//
+// Due to a bug in the original Satoshi client implementation, an additional
+// dummy argument is also required by the consensus rules, although it is not
+// used.  The dummy value SHOULD be an OP_0, although that is not required by
+// the consensus rules.  When the ScriptStrictMultiSig flag is set, it must be
+// OP_0.
+//
+// All of the aforementioned stack items are replaced with a bool which
+// indicates if the requisite number of signatures were successfully verified.
+//
+// Stack transformation:
+// [... dummy [sig ...] numsigs [pubkey ...] numpubkeys] -> [... bool]
+func (vm *Engine) opcodeCheckMultiSig() error {
+	numKeys, err := vm.dstack.PopInt()
+	if err != nil {
+		return err
+	}
+
+	numPubKeys := int(numKeys.Int32())
+	if numPubKeys < 0 {
+		str := fmt.Sprintf("number of pubkeys %d is negative",
+			numPubKeys)
+		return scriptError(ErrInvalidPubKeyCount, str)
+	}
+	if numPubKeys > MaxPubKeysPerMultiSig {
+		str := fmt.Sprintf("too many pubkeys: %d > %d",
+			numPubKeys, MaxPubKeysPerMultiSig)
+		return scriptError(ErrInvalidPubKeyCount, str)
+	}
+	vm.numOps += numPubKeys
+	if vm.numOps > MaxOpsPerScript {
+		str := fmt.Sprintf("exceeded max operation limit of %d",
+			MaxOpsPerScript)
+		return scriptError(ErrTooManyOperations, str)
+	}
+
+	pubKeys := make([][]byte, 0, numPubKeys)
+	for i := 0; i < numPubKeys; i++ {
+		pubKey, err := vm.dstack.PopByteArray()
+		if err != nil {
+			return err
+		}
+		pubKeys = append(pubKeys, pubKey)
+	}
+
+	numSigs, err := vm.dstack.PopInt()
+	if err != nil {
+		return err
+	}
+	numSignatures := int(numSigs.Int32())
+	if numSignatures < 0 {
+		str := fmt.Sprintf("number of signatures %d is negative",
+			numSignatures)
+		return scriptError(ErrInvalidSignatureCount, str)
+
+	}
+	if numSignatures > numPubKeys {
+		str := fmt.Sprintf("more signatures than pubkeys: %d > %d",
+			numSignatures, numPubKeys)
+		return scriptError(ErrInvalidSignatureCount, str)
+	}
+
+	signatures := make([][]byte, 0, numSignatures)
+	for i := 0; i < numSignatures; i++ {
+		signature, err := vm.dstack.PopByteArray()
+		if err != nil {
+			return err
+		}
+		signatures = append(signatures, signature)
+	}
+
+	// A bug in the original Satoshi client implementation means one more
+	// stack value than should be used must be popped.  Unfortunately, this
+	// buggy behavior is now part of the consensus and a hard fork would be
+	// required to fix it.
+	dummy, err := vm.dstack.PopByteArray()
+	if err != nil {
+		return err
+	}
+
+	// Since the dummy argument is otherwise not checked, it could be any
+	// value which unfortunately provides a source of malleability.  Thus,
+	// there is a script flag to force an error when the value is NOT 0.
+	if vm.hasFlag(ScriptStrictMultiSig) && len(dummy) != 0 {
+		str := fmt.Sprintf("multisig dummy argument has length %d "+
+			"instead of 0", len(dummy))
+		return scriptError(ErrSigNullDummy, str)
+	}
+
+	// Get script starting from the most recent OP_CODESEPARATOR.
+	script := vm.subScript()
+
+	// Remove the signature in pre version 0 segwit scripts since there is
+	// no way for a signature to sign itself.
+	if !vm.isWitnessVersionActive(0) {
+		for _, signature := range signatures {
+			script = removeOpcodeByData(script, signature)
+		}
+	}
+
+	success := true
+	numPubKeys++
+	pubKeyIdx := -1
+	signatureIdx := 0
+	for numSignatures > 0 {
+		// When there are more signatures than public keys remaining,
+		// there is no way to succeed since too many signatures are
+		// invalid, so exit early.
+		pubKeyIdx++
+		numPubKeys--
+		if numSignatures > numPubKeys {
+			success = false
+			break
+		}
+
+		signature := signatures[signatureIdx]
+		pubKey := pubKeys[pubKeyIdx]
+
+		// Skip to the next pubkey if signature is empty.
+		if len(signature) == 0 {
+			continue
+		}
+
+		valid, err := vm.checkSig(signature, pubKey, script)
+		if err != nil {
+			return err
+		}
+		if valid {
+			// PubKey verified, move on to the next signature.
+			signatureIdx++
+			numSignatures--
+		}
+	}
+
+	if !success && vm.hasFlag(ScriptVerifyNullFail) {
+		for _, signature := range signatures {
+			if len(signature) > 0 {
+				str := "not all signatures empty on failed " +
+					"checkmultisig"
+				return scriptError(ErrNullFail, str)
+			}
+		}
+	}
+
+	vm.dstack.PushBool(success)
+	return nil
+}
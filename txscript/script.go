@@ -51,59 +51,48 @@ func isSmallInt(op *opcode) bool {
 	return false
 }
 
-// isScriptHash returns true if the script passed is a pay-to-script-hash
-// transaction, false otherwise.
-func isScriptHash(pops []parsedOpcode) bool {
-	return len(pops) == 3 &&
-		pops[0].opcode.value == OP_HASH160 &&
-		pops[1].opcode.value == OP_DATA_20 &&
-		pops[2].opcode.value == OP_EQUAL
+// isScriptHashScript returns whether or not the passed script is a
+// pay-to-script-hash script.  It works on the raw script bytes, which always
+// parse when they match the template.
+func isScriptHashScript(script []byte) bool {
+	return len(script) == 23 &&
+		script[0] == OP_HASH160 &&
+		script[1] == OP_DATA_20 &&
+		script[22] == OP_EQUAL
 }
 
 // IsPayToScriptHash returns true if the script is in the standard
 // pay-to-script-hash (P2SH) format, false otherwise.
 func IsPayToScriptHash(script []byte) bool {
-	pops, err := parseScript(script)
-	if err != nil {
-		return false
-	}
-	return isScriptHash(pops)
+	return isScriptHashScript(script)
 }
 
-// isWitnessScriptHash returns true if the passed script is a
-// pay-to-witness-script-hash transaction, false otherwise.
-func isWitnessScriptHash(pops []parsedOpcode) bool {
-	return len(pops) == 2 &&
-		pops[0].opcode.value == OP_0 &&
-		pops[1].opcode.value == OP_DATA_32
+// isWitnessScriptHashScript returns whether or not the passed script is a
+// pay-to-witness-script-hash script.
+func isWitnessScriptHashScript(script []byte) bool {
+	return len(script) == 34 &&
+		script[0] == OP_0 &&
+		script[1] == OP_DATA_32
 }
 
 // IsPayToWitnessScriptHash returns true if the is in the standard
 // pay-to-witness-script-hash (P2WSH) format, false otherwise.
 func IsPayToWitnessScriptHash(script []byte) bool {
-	pops, err := parseScript(script)
-	if err != nil {
-		return false
-	}
-	return isWitnessScriptHash(pops)
+	return isWitnessScriptHashScript(script)
+}
+
+// isWitnessPubKeyHashScript returns whether or not the passed script is a
+// pay-to-witness-pubkey-hash script.
+func isWitnessPubKeyHashScript(script []byte) bool {
+	return len(script) == 22 &&
+		script[0] == OP_0 &&
+		script[1] == OP_DATA_20
 }
 
 // IsPayToWitnessPubKeyHash returns true if the is in the standard
 // pay-to-witness-pubkey-hash (P2WKH) format, false otherwise.
 func IsPayToWitnessPubKeyHash(script []byte) bool {
-	pops, err := parseScript(script)
-	if err != nil {
-		return false
-	}
-	return isWitnessPubKeyHash(pops)
-}
-
-// isWitnessPubKeyHash returns true if the passed script is a
-// pay-to-witness-pubkey-hash, and false otherwise.
-func isWitnessPubKeyHash(pops []parsedOpcode) bool {
-	return len(pops) == 2 &&
-		pops[0].opcode.value == OP_0 &&
-		pops[1].opcode.value == OP_DATA_20
+	return isWitnessPubKeyHashScript(script)
 }
 
 // IsWitnessProgram returns true if the passed script is a valid witness
@@ -111,84 +100,104 @@ func isWitnessPubKeyHash(pops []parsedOpcode) bool {
 // witness program must be a small integer (from 0-16), followed by 2-40 bytes
 // of pushed data.
 func IsWitnessProgram(script []byte) bool {
+	_, _, ok := extractWitnessProgramInfo(script)
+	return ok
+}
+
+// extractWitnessProgramInfo returns the version and the program of the passed
+// script and whether it is a witness program.  A witness program MUST adhere to
+// the following constraints: there must be exactly two opcodes (program
+// version and the program itself), the first opcode MUST be a small integer
+// (0-16), the push data MUST be canonical, and finally the size of the push
+// data must be between 2 and 40 bytes.
+func extractWitnessProgramInfo(script []byte) (int, []byte, bool) {
 	// The length of the script must be between 4 and 42 bytes. The
 	// smallest program is the witness version, followed by a data push of
 	// 2 bytes.  The largest allowed witness program has a data push of
 	// 40-bytes.
 	if len(script) < 4 || len(script) > 42 {
-		return false
+		return 0, nil, false
 	}
 
-	pops, err := parseScript(script)
-	if err != nil {
-		return false
+	tokenizer := MakeScriptTokenizer(script)
+	if !tokenizer.Next() || !isSmallInt(tokenizer.op) {
+		return 0, nil, false
 	}
+	version := asSmallInt(tokenizer.op)
 
-	return isWitnessProgram(pops)
-}
+	if !tokenizer.Next() || !canonicalPush(tokenizer.parsedOpcode()) {
+		return 0, nil, false
+	}
+	program := tokenizer.Data()
+	if len(program) < 2 || len(program) > 40 || !tokenizer.Done() {
+		return 0, nil, false
+	}
 
-// isWitnessProgram returns true if the passed script is a witness program, and
-// false otherwise. A witness program MUST adhere to the following constraints:
-// there must be exactly two pops (program version and the program itself), the
-// first opcode MUST be a small integer (0-16), the push data MUST be
-// canonical, and finally the size of the push data must be between 2 and 40
-// bytes.
-func isWitnessProgram(pops []parsedOpcode) bool {
-	return len(pops) == 2 &&
-		isSmallInt(pops[0].opcode) &&
-		canonicalPush(pops[1]) &&
-		(len(pops[1].data) >= 2 && len(pops[1].data) <= 40)
+	return version, program, true
 }
 
 // ExtractWitnessProgramInfo attempts to extract the witness program version,
 // as well as the witness program itself from the passed script.
 func ExtractWitnessProgramInfo(script []byte) (int, []byte, error) {
-	pops, err := parseScript(script)
-	if err != nil {
-		return 0, nil, err
+	witnessVersion, witnessProgram, ok := extractWitnessProgramInfo(script)
+	if ok {
+		return witnessVersion, witnessProgram, nil
 	}
 
-	// If at this point, the scripts doesn't resemble a witness program,
-	// then we'll exit early as there isn't a valid version or program to
-	// extract.
-	if !isWitnessProgram(pops) {
-		return 0, nil, fmt.Errorf("script is not a witness program, " +
-			"unable to extract version or witness program")
+	// Return the parse error of scripts which do not parse.
+	if err := checkScriptParses(script); err != nil {
+		return 0, nil, err
 	}
 
-	witnessVersion := asSmallInt(pops[0].opcode)
-	witnessProgram := pops[1].data
-
-	return witnessVersion, witnessProgram, nil
+	// If at this point, the scripts doesn't resemble a witness program,
+	// then there isn't a valid version or program to extract.
+	return 0, nil, fmt.Errorf("script is not a witness program, " +
+		"unable to extract version or witness program")
 }
 
-// isPushOnly returns true if the script only pushes data, false otherwise.
-func isPushOnly(pops []parsedOpcode) bool {
-	// NOTE: This function does NOT verify opcodes directly since it is
-	// internal and is only called with parsed opcodes for scripts that did
-	// not have any parse errors.  Thus, consensus is properly maintained.
-
-	for _, pop := range pops {
+// IsPushOnlyScript returns whether or not the passed script only pushes data.
+//
+// False will be returned when the script does not parse.
+func IsPushOnlyScript(script []byte) bool {
+	tokenizer := MakeScriptTokenizer(script)
+	for tokenizer.Next() {
 		// All opcodes up to OP_16 are data push instructions.
 		// NOTE: This does consider OP_RESERVED to be a data push
 		// instruction, but execution of OP_RESERVED will fail anyways
 		// and matches the behavior required by consensus.
-		if pop.opcode.value > OP_16 {
+		if tokenizer.Opcode() > OP_16 {
 			return false
 		}
 	}
-	return true
+	return tokenizer.Err() == nil
 }
 
-// IsPushOnlyScript returns whether or not the passed script only pushes data.
-//
-// False will be returned when the script does not parse.
-func IsPushOnlyScript(script []byte) bool {
-	pops, err := parseScript(script)
-	if err != nil {
-		return false
+// checkScriptParses returns an error if the passed script does not parse.
+func checkScriptParses(script []byte) error {
+	tokenizer := MakeScriptTokenizer(script)
+	for tokenizer.Next() {
+		// Nothing to do.
 	}
-	return isPushOnly(pops)
+	return tokenizer.Err()
+}
+
+// finalOpcodeData returns the data associated with the final opcode in the
+// passed script.  It will return nil if the script fails to parse.
+func finalOpcodeData(script []byte) []byte {
+	// Avoid unnecessary work.
+	if len(script) == 0 {
+		return nil
+	}
+
+	var data []byte
+	tokenizer := MakeScriptTokenizer(script)
+	for tokenizer.Next() {
+		data = tokenizer.Data()
+	}
+	if tokenizer.Err() != nil {
+		return nil
+	}
+	return data
 }
 
 // parseScriptTemplate is the same as parseScript but allows the passing of the
@@ -408,38 +417,36 @@ func asSmallInt(op *opcode) int {
 	return int(op.value - (OP_1 - 1))
 }
 
-// getSigOpCount is the implementation function for counting the number of
-// signature operations in the script provided by pops. If precise mode is
-// requested then we attempt to count the number of operations for a multisig
-// op. Otherwise we use the maximum.
-func getSigOpCount(pops []parsedOpcode, precise bool) int {
-	nSigs := 0
-	for i, pop := range pops {
-		switch pop.opcode.value {
-		case OP_CHECKSIG:
-			fallthrough
-		case OP_CHECKSIGVERIFY:
-			nSigs++
-		case OP_CHECKMULTISIG:
-			fallthrough
-		case OP_CHECKMULTISIGVERIFY:
+// countSigOps returns the number of signature operations in the passed script,
+// counting up to the point of a parse failure.  If precise mode is requested
+// then we attempt to count the number of operations for a multisig op.
+// Otherwise we use the maximum.
+func countSigOps(script []byte, precise bool) int {
+	numSigOps := 0
+	var prevOp *opcode
+	tokenizer := MakeScriptTokenizer(script)
+	for tokenizer.Next() {
+		switch tokenizer.Opcode() {
+		case OP_CHECKSIG, OP_CHECKSIGVERIFY:
+			numSigOps++
+
+		case OP_CHECKMULTISIG, OP_CHECKMULTISIGVERIFY:
 			// If we are being precise then look for familiar
 			// patterns for multisig, for now all we recognize is
 			// OP_1 - OP_16 to signify the number of pubkeys.
 			// Otherwise, we use the max of 20.
-			if precise && i > 0 &&
-				pops[i-1].opcode.value >= OP_1 &&
-				pops[i-1].opcode.value <= OP_16 {
-				nSigs += asSmallInt(pops[i-1].opcode)
+			if precise && prevOp != nil &&
+				prevOp.value >= OP_1 && prevOp.value <= OP_16 {
+
+				numSigOps += asSmallInt(prevOp)
 			} else {
-				nSigs += MaxPubKeysPerMultiSig
+				numSigOps += MaxPubKeysPerMultiSig
 			}
-		default:
-			// Not a sigop.
 		}
+		prevOp = tokenizer.op
 	}
 
-	return nSigs
+	return numSigOps
 }
 
 // GetSigOpCount provides a quick count of the number of signature operations
@@ -447,10 +454,7 @@ func getSigOpCount(pops []parsedOpcode, precise bool) int {
 // If the script fails to parse, then the count up to the point of failure is
 // returned.
 func GetSigOpCount(script []byte) int {
-	// Don't check error since parseScript returns the parsed-up-to-error
-	// list of pops.
-	pops, _ := parseScript(script)
-	return getSigOpCount(pops, false)
+	return countSigOps(script, false)
 }
 
 // GetPreciseSigOpCount returns the number of signature operations in
@@ -459,43 +463,38 @@ func GetSigOpCount(script []byte) int {
 // operations in the transaction.  If the script fails to parse, then the count
 // up to the point of failure is returned.
 func GetPreciseSigOpCount(scriptSig, scriptPubKey []byte, bip16 bool) int {
-	// Don't check error since parseScript returns the parsed-up-to-error
-	// list of pops.
-	pops, _ := parseScript(scriptPubKey)
-
-	// Treat non P2SH transactions as normal.
-	if !(bip16 && isScriptHash(pops)) {
-		return getSigOpCount(pops, true)
-	}
-
-	// The public key script is a pay-to-script-hash, so parse the signature
-	// script to get the final item.  Scripts that fail to fully parse count
-	// as 0 signature operations.
-	sigPops, err := parseScript(scriptSig)
-	if err != nil {
-		return 0
+	// Treat non P2SH transactions as normal.  Signature operations are
+	// counted up to the point of a parse failure.
+	if !(bip16 && isScriptHashScript(scriptPubKey)) {
+		return countSigOps(scriptPubKey, true)
+	}
+
+	// The public key script is a pay-to-script-hash, so tokenize the
+	// signature script to get the final item.  The signature script must
+	// only push data to the stack for P2SH to be a valid pair, so the
+	// signature operation count is 0 when that is not the case.  Scripts
+	// that fail to fully parse count as 0 signature operations as well.
+	var shScript []byte
+	tokenizer := MakeScriptTokenizer(scriptSig)
+	for tokenizer.Next() {
+		if tokenizer.Opcode() > OP_16 {
+			return 0
+		}
+		shScript = tokenizer.Data()
 	}
-
-	// The signature script must only push data to the stack for P2SH to be
-	// a valid pair, so the signature operation count is 0 when that is not
-	// the case.
-	if !isPushOnly(sigPops) || len(sigPops) == 0 {
+	if tokenizer.Err() != nil {
 		return 0
 	}
 
 	// The P2SH script is the last item the signature script pushes to the
 	// stack.  When the script is empty, there are no signature operations.
-	shScript := sigPops[len(sigPops)-1].data
 	if len(shScript) == 0 {
 		return 0
 	}
 
-	// Parse the P2SH script and don't check the error since parseScript
-	// returns the parsed-up-to-error list of pops and the consensus rules
-	// dictate signature operations are counted up to the first parse
-	// failure.
-	shPops, _ := parseScript(shScript)
-	return getSigOpCount(shPops, true)
+	// The consensus rules dictate signature operations are counted up to
+	// the first parse failure.
+	return countSigOps(shScript, true)
 }
 
 // GetWitnessSigOpCount returns the number of signature operations generated by
@@ -514,11 +513,7 @@ func GetWitnessSigOpCount(sigScript, pkScript []byte, witness wire.TxWitness) in
 	// Next, we'll check the sigScript to see if this is a nested p2sh
 	// witness program. This is a case wherein the sigScript is actually a
 	// datapush of a p2wsh witness program.
-	sigPops, err := parseScript(sigScript)
-	if err != nil {
-		return 0
-	}
-	if IsPayToScriptHash(pkScript) && isPushOnly(sigPops) &&
+	if IsPayToScriptHash(pkScript) && IsPushOnlyScript(sigScript) &&
 		IsWitnessProgram(sigScript[1:]) {
 		return getWitnessSigOps(sigScript[1:], witness)
 	}
@@ -533,10 +528,8 @@ func GetWitnessSigOpCount(sigScript, pkScript []byte, witness wire.TxWitness) in
 // extracted, then 0 is returned for the sig op count.
 func getWitnessSigOps(pkScript []byte, witness wire.TxWitness) int {
 	// Attempt to extract the witness program version.
-	witnessVersion, witnessProgram, err := ExtractWitnessProgramInfo(
-		pkScript,
-	)
-	if err != nil {
+	witnessVersion, witnessProgram, ok := extractWitnessProgramInfo(pkScript)
+	if !ok {
 		return 0
 	}
 
@@ -549,8 +542,7 @@ func getWitnessSigOps(pkScript []byte, witness wire.TxWitness) int {
 			len(witness) > 0:
 
 			witnessScript := witness[len(witness)-1]
-			pops, _ := parseScript(witnessScript)
-			return getSigOpCount(pops, true)
+			return countSigOps(witnessScript, true)
 		}
 	}
 
@@ -561,12 +553,12 @@ func getWitnessSigOps(pkScript []byte, witness wire.TxWitness) int {
 // guaranteed to fail at execution.  This allows inputs to be pruned instantly
 // when entering the UTXO set.
 func IsUnspendable(pkScript []byte) bool {
-	pops, err := parseScript(pkScript)
-	if err != nil {
+	if len(pkScript) > 0 && pkScript[0] == OP_RETURN {
 		return true
 	}
 
-	return len(pops) > 0 && pops[0].opcode.value == OP_RETURN
+	// Scripts which do not parse are unspendable as well.
+	return checkScriptParses(pkScript) != nil
 }
 
 // ConvertP2PKtoP2PKH converts pay to public key script to pay to public key hash script
@@ -0,0 +1,99 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript
+
+import (
+	"sync"
+
+	"github.com/nbcorg/btcd/chaincfg/chainhash"
+)
+
+// sigCacheKey identifies a signature which has been verified.  The signature
+// and public key are stored as strings so the key can be compared and used in
+// a map.
+type sigCacheKey struct {
+	sigHash chainhash.Hash
+	sig     string
+	pubKey  string
+}
+
+// SigCache implements an ECDSA signature verification cache with a randomized
+// entry eviction policy.  Only valid signatures are added to the cache.  The
+// benefit of a SigCache is two fold.  Firstly, it reduces the cost of
+// validating a block, since most of its signatures were already verified when
+// its transactions entered the mempool.  Secondly, it defends against a CPU
+// exhaustion attack where an attacker repeatedly sends transactions with
+// signatures that are costly to verify.
+//
+// A SigCache is meant to be shared by the TxSigChecker instances of a
+// verifier, such as the one returned by NewEngineVerifier, and is safe for
+// concurrent use by multiple goroutines.
+type SigCache struct {
+	sync.RWMutex
+	validSigs  map[sigCacheKey]struct{}
+	maxEntries uint
+}
+
+// NewSigCache creates and initializes a new instance of SigCache.  Its sole
+// parameter 'maxEntries' represents the maximum number of entries allowed to
+// exist in the SigCache at any particular moment.  Random entries are evicted
+// to make room for new entries that would cause the number of entries in the
+// cache to exceed the max.
+func NewSigCache(maxEntries uint) *SigCache {
+	return &SigCache{
+		validSigs:  make(map[sigCacheKey]struct{}, maxEntries),
+		maxEntries: maxEntries,
+	}
+}
+
+// Exists returns true if an existing entry of 'sig' over 'sigHash' for public
+// key 'pubKey' is found within the SigCache.  Otherwise, false is returned.
+//
+// NOTE: This function is safe for concurrent access.  Readers won't be blocked
+// unless there exists a writer, adding an entry to the SigCache.
+func (s *SigCache) Exists(sigHash chainhash.Hash, sig, pubKey []byte) bool {
+	key := sigCacheKey{sigHash, string(sig), string(pubKey)}
+
+	s.RLock()
+	_, ok := s.validSigs[key]
+	s.RUnlock()
+
+	return ok
+}
+
+// Add adds an entry for a signature over 'sigHash' under public key 'pubKey'
+// to the signature cache.  In the event that the SigCache is 'full', an
+// existing entry is randomly chosen to be evicted in order to make space for
+// the new entry.
+//
+// NOTE: This function is safe for concurrent access.  Writers will block
+// simultaneous readers until function execution has concluded.
+func (s *SigCache) Add(sigHash chainhash.Hash, sig, pubKey []byte) {
+	if s.maxEntries == 0 {
+		return
+	}
+	key := sigCacheKey{sigHash, string(sig), string(pubKey)}
+
+	s.Lock()
+	defer s.Unlock()
+
+	// If adding this new entry will put us over the max number of allowed
+	// entries, then evict an entry.
+	if _, ok := s.validSigs[key]; !ok && uint(len(s.validSigs)+1) > s.maxEntries {
+		// Remove a random entry from the map.  Relying on the random
+		// starting point of Go's map iteration.  It's worth noting that
+		// the random iteration starting point is not 100% guaranteed by
+		// the spec, however most Go compilers support it.  Ultimately,
+		// the iteration order isn't important here because in order to
+		// manipulate which items are evicted, an adversary would need to
+		// be able to execute preimage attacks on the hashing function in
+		// order to start eviction at a specific entry.
+		for evictKey := range s.validSigs {
+			delete(s.validSigs, evictKey)
+			break
+		}
+	}
+	s.validSigs[key] = struct{}{}
+}
@@ -0,0 +1,239 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/nbcorg/btcd/chaincfg/chainhash"
+	"github.com/nbcorg/btcd/wire"
+)
+
+// CalcSignatureHash computes the signature hash for the specified input of the
+// target transaction observing the desired signature hash type, committing to
+// the passed script code, with the original algorithm used before witness
+// programs.
+func CalcSignatureHash(script []byte, hashType SigHashType, tx *wire.MsgTx,
+	idx int) ([]byte, error) {
+
+	pops, err := parseScript(script)
+	if err != nil {
+		return nil, err
+	}
+	if idx < 0 || idx >= len(tx.TxIn) {
+		str := fmt.Sprintf("transaction input index %d is negative or "+
+			">= %d", idx, len(tx.TxIn))
+		return nil, scriptError(ErrInvalidIndex, str)
+	}
+
+	// The SigHashSingle signature type signs only the corresponding input
+	// and output (the output with the same index number as the input).
+	//
+	// Since transactions can have more inputs than outputs, this means it
+	// is improper to use SigHashSingle on input indices that don't have a
+	// corresponding output.
+	//
+	// A bug in the original Satoshi client implementation means specifying
+	// an index that is out of range results in a signature hash of 1 (as a
+	// uint256 little endian).  The original intent appeared to be to
+	// indicate failure, but unfortunately, it was never checked and thus is
+	// treated as the actual signature hash.  This buggy behavior is now
+	// part of the consensus and a hard fork would be required to fix it.
+	if hashType&sigHashMask == SigHashSingle && idx >= len(tx.TxOut) {
+		var hash chainhash.Hash
+		hash[0] = 0x01
+		return hash[:], nil
+	}
+
+	// Remove all instances of OP_CODESEPARATOR from the script.
+	script, err = unparseScript(removeOpcode(pops, OP_CODESEPARATOR))
+	if err != nil {
+		return nil, err
+	}
+
+	// Make a shallow copy of the transaction, zeroing out the script for
+	// all inputs that are not currently being processed.
+	txCopy := shallowCopyTx(tx)
+	for i := range txCopy.TxIn {
+		if i == idx {
+			txCopy.TxIn[idx].SignatureScript = script
+		} else {
+			txCopy.TxIn[i].SignatureScript = nil
+		}
+	}
+
+	switch hashType & sigHashMask {
+	case SigHashNone:
+		txCopy.TxOut = txCopy.TxOut[0:0] // Empty slice.
+		for i := range txCopy.TxIn {
+			if i != idx {
+				txCopy.TxIn[i].Sequence = 0
+			}
+		}
+
+	case SigHashSingle:
+		// Resize output array to up to and including requested index.
+		txCopy.TxOut = txCopy.TxOut[:idx+1]
+
+		// All but current output get zeroed out.
+		for i := 0; i < idx; i++ {
+			txCopy.TxOut[i].Value = -1
+			txCopy.TxOut[i].PkScript = nil
+		}
+
+		// Sequence on all other inputs is 0, too.
+		for i := range txCopy.TxIn {
+			if i != idx {
+				txCopy.TxIn[i].Sequence = 0
+			}
+		}
+
+	default:
+		// Consensus treats undefined hashtypes like normal SigHashAll
+		// for purposes of hash generation.
+		fallthrough
+	case SigHashOld:
+		fallthrough
+	case SigHashAll:
+		// Nothing special here.
+	}
+	if hashType&SigHashAnyOneCanPay != 0 {
+		txCopy.TxIn = txCopy.TxIn[idx : idx+1]
+	}
+
+	// The final hash is the double sha256 of both the serialized modified
+	// transaction and the hash type (encoded as a 4-byte little-endian
+	// value) appended.
+	wbuf := bytes.NewBuffer(make([]byte, 0, txCopy.SerializeSizeStripped()+4))
+	txCopy.SerializeNoWitness(wbuf)
+	binary.Write(wbuf, binary.LittleEndian, hashType)
+	return chainhash.DoubleHashB(wbuf.Bytes()), nil
+}
+
+// TxSigHashes houses the partial set of sighashes introduced within BIP0143.
+// This partial set of sighashes may be re-used within each input across a
+// transaction when validating all inputs.  As a result, validation complexity
+// for SigHashAll can be reduced by a polynomial factor.
+type TxSigHashes struct {
+	HashPrevOuts chainhash.Hash
+	HashSequence chainhash.Hash
+	HashOutputs  chainhash.Hash
+}
+
+// NewTxSigHashes computes, and returns the cached sighashes of the given
+// transaction.
+func NewTxSigHashes(tx *wire.MsgTx) *TxSigHashes {
+	var prevOuts, sequences, outputs bytes.Buffer
+	var buf [8]byte
+	for _, in := range tx.TxIn {
+		prevOuts.Write(in.PreviousOutPoint.Hash[:])
+		binary.LittleEndian.PutUint32(buf[:4], in.PreviousOutPoint.Index)
+		prevOuts.Write(buf[:4])
+
+		binary.LittleEndian.PutUint32(buf[:4], in.Sequence)
+		sequences.Write(buf[:4])
+	}
+	for _, out := range tx.TxOut {
+		wire.WriteTxOut(&outputs, 0, 0, out)
+	}
+
+	return &TxSigHashes{
+		HashPrevOuts: chainhash.DoubleHashH(prevOuts.Bytes()),
+		HashSequence: chainhash.DoubleHashH(sequences.Bytes()),
+		HashOutputs:  chainhash.DoubleHashH(outputs.Bytes()),
+	}
+}
+
+// CalcWitnessSigHash computes the signature hash for the specified input of
+// the target transaction observing the desired signature hash type, committing
+// to the passed script code and to the amount of the output the input spends,
+// as described by BIP0143.  The script code of a pay-to-witness-pubkey-hash
+// output is the pay-to-pubkey-hash script of its witness program.  The passed
+// sighashes, which may be nil, are those of the transaction.
+func CalcWitnessSigHash(script []byte, sigHashes *TxSigHashes,
+	hashType SigHashType, tx *wire.MsgTx, idx int, amt int64) ([]byte, error) {
+
+	if idx < 0 || idx >= len(tx.TxIn) {
+		str := fmt.Sprintf("transaction input index %d is negative or "+
+			">= %d", idx, len(tx.TxIn))
+		return nil, scriptError(ErrInvalidIndex, str)
+	}
+	if sigHashes == nil {
+		sigHashes = NewTxSigHashes(tx)
+	}
+
+	// We'll utilize this buffer throughout to incrementally calculate
+	// the signature hash for this transaction.
+	var sigHash bytes.Buffer
+	var buf [8]byte
+
+	// First write out, then encode the transaction's version number.
+	binary.LittleEndian.PutUint32(buf[:4], uint32(tx.Version))
+	sigHash.Write(buf[:4])
+
+	// Next write out the possibly pre-calculated hashes for the sequence
+	// numbers of all inputs, and the hashes of the previous outs for all
+	// outputs.
+	var zeroHash chainhash.Hash
+
+	// If anyone can pay isn't active, then we can use the cached
+	// hashPrevOuts, otherwise we just write zeroes for the prev outs.
+	if hashType&SigHashAnyOneCanPay == 0 {
+		sigHash.Write(sigHashes.HashPrevOuts[:])
+	} else {
+		sigHash.Write(zeroHash[:])
+	}
+
+	// If the sighash isn't anyone can pay, single, or none, the use the
+	// cached hash sequences, otherwise write all zeroes for the
+	// hashSequence.
+	if hashType&SigHashAnyOneCanPay == 0 &&
+		hashType&sigHashMask != SigHashSingle &&
+		hashType&sigHashMask != SigHashNone {
+		sigHash.Write(sigHashes.HashSequence[:])
+	} else {
+		sigHash.Write(zeroHash[:])
+	}
+
+	// Next, write the outpoint being spent.
+	txIn := tx.TxIn[idx]
+	sigHash.Write(txIn.PreviousOutPoint.Hash[:])
+	binary.LittleEndian.PutUint32(buf[:4], txIn.PreviousOutPoint.Index)
+	sigHash.Write(buf[:4])
+
+	// Next, write the script code along with the amount of the output
+	// and the sequence of the input.
+	wire.WriteVarBytes(&sigHash, 0, script)
+	binary.LittleEndian.PutUint64(buf[:], uint64(amt))
+	sigHash.Write(buf[:])
+	binary.LittleEndian.PutUint32(buf[:4], txIn.Sequence)
+	sigHash.Write(buf[:4])
+
+	// If the current signature mode isn't single, or none, then we can
+	// re-use the pre-generated hashoutputs sighash fragment.  Otherwise,
+	// we'll serialize and add only the target output index to the
+	// signature pre-image.
+	if hashType&sigHashMask != SigHashSingle &&
+		hashType&sigHashMask != SigHashNone {
+		sigHash.Write(sigHashes.HashOutputs[:])
+	} else if hashType&sigHashMask == SigHashSingle && idx < len(tx.TxOut) {
+		var b bytes.Buffer
+		wire.WriteTxOut(&b, 0, 0, tx.TxOut[idx])
+		sigHash.Write(chainhash.DoubleHashB(b.Bytes()))
+	} else {
+		sigHash.Write(zeroHash[:])
+	}
+
+	// Finally, write out the transaction's locktime, and the sig hash
+	// type.
+	binary.LittleEndian.PutUint32(buf[:4], tx.LockTime)
+	sigHash.Write(buf[:4])
+	binary.LittleEndian.PutUint32(buf[:4], uint32(hashType))
+	sigHash.Write(buf[:4])
+
+	return chainhash.DoubleHashB(sigHash.Bytes()), nil
+}
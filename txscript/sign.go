@@ -0,0 +1,51 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript
+
+import (
+	"github.com/nbcorg/btcd/btcec"
+	"github.com/nbcorg/btcd/wire"
+)
+
+// RawTxInSignature returns the serialized ECDSA signature for the input idx of
+// the given transaction, with hashType appended to it, committing to the passed
+// script code with the original signature hash algorithm.
+func RawTxInSignature(tx *wire.MsgTx, idx int, subScript []byte,
+	hashType SigHashType, key *btcec.PrivateKey) ([]byte, error) {
+
+	hash, err := CalcSignatureHash(subScript, hashType, tx, idx)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := key.Sign(hash)
+	if err != nil {
+		return nil, scriptError(ErrInternal, "cannot sign tx input: "+
+			err.Error())
+	}
+
+	return append(signature.Serialize(), byte(hashType)), nil
+}
+
+// RawTxInWitnessSignature returns the serialized ECDSA signature for the input
+// idx of the given transaction, with hashType appended to it, committing to the
+// passed script code and to the amount of the output the input spends as
+// described by BIP0143.  The passed sighashes may be nil.
+func RawTxInWitnessSignature(tx *wire.MsgTx, sigHashes *TxSigHashes, idx int,
+	amt int64, subScript []byte, hashType SigHashType,
+	key *btcec.PrivateKey) ([]byte, error) {
+
+	hash, err := CalcWitnessSigHash(subScript, sigHashes, hashType, tx,
+		idx, amt)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := key.Sign(hash)
+	if err != nil {
+		return nil, scriptError(ErrInternal, "cannot sign tx input: "+
+			err.Error())
+	}
+
+	return append(signature.Serialize(), byte(hashType)), nil
+}
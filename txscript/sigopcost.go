@@ -0,0 +1,90 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript
+
+import (
+	"fmt"
+
+	"github.com/nbcorg/btcutil"
+)
+
+// DefaultBytesPerSigOp is the default number of virtual bytes each unit of
+// signature operation cost is worth when calculating the sigop-adjusted
+// virtual size of a transaction.  It matches the default policy of the
+// reference implementation.
+const DefaultBytesPerSigOp = 20
+
+// GetSigOpCost returns the signature operation cost of the passed transaction
+// as defined by BIP 141.  Signature operations in legacy and pay-to-script-hash
+// scripts are scaled by btcutil.WitnessScaleFactor, while signature operations
+// in witness programs are counted once.
+//
+// The prevOutScripts are the public key scripts of the outputs spent by each
+// input, in input order.  Without them only the signature operations within
+// the transaction itself can be counted, so passing nil, as is required for a
+// coinbase, returns the legacy count only.  An error of kind ErrInvalidIndex
+// is returned when the number of scripts does not match the number of inputs.
+func GetSigOpCost(tx *btcutil.Tx, prevOutScripts [][]byte) (int, error) {
+	msgTx := tx.MsgTx()
+
+	// Legacy signature operations are counted in every signature script
+	// and public key script of the transaction itself.
+	numSigOps := 0
+	for _, txIn := range msgTx.TxIn {
+		numSigOps += GetSigOpCount(txIn.SignatureScript)
+	}
+	for _, txOut := range msgTx.TxOut {
+		numSigOps += GetSigOpCount(txOut.PkScript)
+	}
+	sigOpCost := numSigOps * btcutil.WitnessScaleFactor
+
+	if prevOutScripts == nil {
+		return sigOpCost, nil
+	}
+	if len(prevOutScripts) != len(msgTx.TxIn) {
+		str := fmt.Sprintf("transaction has %d inputs but %d previous "+
+			"output scripts were provided", len(msgTx.TxIn),
+			len(prevOutScripts))
+		return 0, scriptError(ErrInvalidIndex, str)
+	}
+
+	// Signature operations in the redeem scripts of pay-to-script-hash
+	// inputs and in witness programs depend on the spent outputs.
+	for i, txIn := range msgTx.TxIn {
+		pkScript := prevOutScripts[i]
+		if IsPayToScriptHash(pkScript) {
+			numSigOps := GetPreciseSigOpCount(txIn.SignatureScript,
+				pkScript, true)
+			sigOpCost += numSigOps * btcutil.WitnessScaleFactor
+		}
+
+		sigOpCost += GetWitnessSigOpCount(txIn.SignatureScript,
+			pkScript, txIn.Witness)
+	}
+
+	return sigOpCost, nil
+}
+
+// GetSigOpAdjustedVSize returns the virtual size of the passed transaction
+// adjusted for its signature operation cost, which is the size the mempool
+// policy of the reference implementation applies fee rates to.  Transactions
+// with an unusually high number of signature operations for their weight are
+// treated as if every unit of signature operation cost took up bytesPerSigOp
+// virtual bytes.  See GetSigOpCost for the meaning of prevOutScripts.
+func GetSigOpAdjustedVSize(tx *btcutil.Tx, prevOutScripts [][]byte,
+	bytesPerSigOp int) (int64, error) {
+
+	sigOpCost, err := GetSigOpCost(tx, prevOutScripts)
+	if err != nil {
+		return 0, err
+	}
+
+	weight := tx.Weight()
+	if sigOpWeight := int64(sigOpCost) * int64(bytesPerSigOp); sigOpWeight > weight {
+		weight = sigOpWeight
+	}
+	return (weight + btcutil.WitnessScaleFactor - 1) /
+		btcutil.WitnessScaleFactor, nil
+}
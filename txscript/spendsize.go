@@ -0,0 +1,281 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript
+
+import (
+	"fmt"
+
+	"github.com/nbcorg/btcd/wire"
+	"github.com/nbcorg/btcutil"
+)
+
+const (
+	// maxSigLen is the maximum length of a DER encoded ECDSA signature
+	// including the trailing hash type byte.  Since standard signatures
+	// must have a low S value, the S value is at most 32 bytes and only
+	// the R value can take up 33 bytes, which makes the signature at most
+	// 71 bytes before the hash type byte is appended.
+	maxSigLen = 72
+
+	// maxSchnorrSigLen is the maximum length of a schnorr signature used
+	// to spend a version 1 witness program through its key path, which is
+	// 64 bytes followed by an optional hash type byte.
+	maxSchnorrSigLen = 65
+
+	// witnessV1ProgramLen is the length of a version 1 witness program.
+	witnessV1ProgramLen = 32
+)
+
+// SpendSize describes the size of the data needed to spend an output once
+// all of the required signatures are provided.  Signatures are assumed to
+// have their maximum length, so the sizes are the worst case and the signed
+// transaction will never be larger than estimated.
+type SpendSize struct {
+	// SigScriptSize is the size in bytes of the signature script.
+	SigScriptSize int
+
+	// WitnessSize is the size in bytes of the serialized witness of the
+	// input including the number of witness items, or zero when the input
+	// has no witness.  Each of these bytes counts as a single weight unit.
+	WitnessSize int
+}
+
+// InputSize returns the size in bytes of the serialized input excluding its
+// witness, which is the outpoint, the signature script and the sequence
+// number.
+func (s SpendSize) InputSize() int {
+	return inputBaseSize + wire.VarIntSerializeSize(uint64(s.SigScriptSize)) +
+		s.SigScriptSize
+}
+
+// Weight returns the weight of the input as defined by BIP 141, which counts
+// every byte of the input scaled by btcutil.WitnessScaleFactor and every byte
+// of its witness once.
+func (s SpendSize) Weight() int64 {
+	return int64(s.InputSize()*btcutil.WitnessScaleFactor + s.WitnessSize)
+}
+
+// pushSize returns the number of bytes it takes a script to push data of the
+// passed length to the stack using the canonical push operation for it.
+func pushSize(dataLen int) int {
+	switch {
+	case dataLen == 0:
+		return 1
+	case dataLen < OP_PUSHDATA1:
+		return 1 + dataLen
+	case dataLen <= 0xff:
+		return 2 + dataLen
+	case dataLen <= 0xffff:
+		return 3 + dataLen
+	}
+	return 5 + dataLen
+}
+
+// witnessPubKeyHashItems are the lengths of the witness items needed to spend
+// a version 0 pay-to-witness-pubkey-hash output, which are a signature and the
+// public key.
+var witnessPubKeyHashItems = []int{maxSigLen, pubKeyLen}
+
+// witnessItemsSize returns the number of bytes the passed witness items take
+// up when serialized as the witness of an input.
+func witnessItemsSize(itemLens []int) int {
+	size := wire.VarIntSerializeSize(uint64(len(itemLens)))
+	for _, itemLen := range itemLens {
+		size += wire.VarIntSerializeSize(uint64(itemLen)) + itemLen
+	}
+	return size
+}
+
+// sigScriptItemsSize returns the number of bytes a signature script that
+// pushes the passed stack items takes up.
+func sigScriptItemsSize(itemLens []int) int {
+	size := 0
+	for _, itemLen := range itemLens {
+		size += pushSize(itemLen)
+	}
+	return size
+}
+
+// spendStackItems returns the lengths of the stack items needed to satisfy
+// the passed script, in the order they are pushed.  Only scripts which are
+// directly satisfied by signatures and public keys when executed are
+// supported, which are pay-to-pubkey, pay-to-pubkey-hash and multi-signature
+// scripts.
+func spendStackItems(script []byte) ([]int, error) {
+	switch class := GetScriptClass(script); class {
+	case PubKeyTy:
+		return []int{maxSigLen}, nil
+
+	case PubKeyHashTy:
+		return []int{maxSigLen, pubKeyLen}, nil
+
+	case MultiSigTy:
+		_, numSigs, err := CalcMultiSigStats(script)
+		if err != nil {
+			return nil, err
+		}
+
+		// OP_CHECKMULTISIG consumes an extra dummy item due to an
+		// off-by-one bug, which must be empty.
+		itemLens := make([]int, 1+numSigs)
+		for i := 1; i < len(itemLens); i++ {
+			itemLens[i] = maxSigLen
+		}
+		return itemLens, nil
+
+	default:
+		str := fmt.Sprintf("unable to estimate the size of the data "+
+			"needed to spend %s script %x", class, script)
+		return nil, scriptError(ErrUnsupportedScript, str)
+	}
+}
+
+// witnessScriptSpendSize returns the size of the witness needed to spend a
+// version 0 pay-to-witness-script-hash output with the passed witness script.
+// The witness is the stack items which satisfy the witness script followed by
+// the witness script itself.
+func witnessScriptSpendSize(witnessScript []byte) (int, error) {
+	if witnessScript == nil {
+		str := "a witness script is required to estimate the size of " +
+			"the data needed to spend a witness script hash"
+		return 0, scriptError(ErrUnsupportedScript, str)
+	}
+
+	itemLens, err := spendStackItems(witnessScript)
+	if err != nil {
+		return 0, err
+	}
+	itemLens = append(itemLens, len(witnessScript))
+	return witnessItemsSize(itemLens), nil
+}
+
+// EstimateSpendSize returns the worst-case size of the signature script and
+// witness needed to spend an output paying to the passed public key script.
+// Every script class other than non-standard and null data scripts is
+// supported, as well as the key path spend of version 1 witness programs.
+//
+// The redeem script is required to spend pay-to-script-hash outputs, and the
+// witness script is required to spend pay-to-witness-script-hash outputs,
+// including those nested in a pay-to-script-hash output.  They are otherwise
+// ignored, and neither is checked against the hash it is expected to match.
+// Redeem and witness scripts must themselves be pay-to-pubkey,
+// pay-to-pubkey-hash or multi-signature scripts, and redeem scripts may also
+// be version 0 witness programs.  An error of kind ErrUnsupportedScript is
+// returned for anything else.
+func EstimateSpendSize(pkScript, redeemScript, witnessScript []byte) (SpendSize, error) {
+	switch GetScriptClass(pkScript) {
+	case WitnessV0PubKeyHashTy:
+		witnessSize := witnessItemsSize(witnessPubKeyHashItems)
+		return SpendSize{WitnessSize: witnessSize}, nil
+
+	case WitnessV0ScriptHashTy:
+		witnessSize, err := witnessScriptSpendSize(witnessScript)
+		if err != nil {
+			return SpendSize{}, err
+		}
+		return SpendSize{WitnessSize: witnessSize}, nil
+
+	case ScriptHashTy:
+		if redeemScript == nil {
+			str := "a redeem script is required to estimate the " +
+				"size of the data needed to spend a script hash"
+			return SpendSize{}, scriptError(ErrUnsupportedScript, str)
+		}
+
+		// The signature script of a pay-to-script-hash input always
+		// ends with a push of the redeem script, and nested witness
+		// programs are spent by their witness.
+		redeemPushSize := canonicalDataSize(redeemScript)
+		switch GetScriptClass(redeemScript) {
+		case WitnessV0PubKeyHashTy:
+			return SpendSize{
+				SigScriptSize: redeemPushSize,
+				WitnessSize:   witnessItemsSize(witnessPubKeyHashItems),
+			}, nil
+
+		case WitnessV0ScriptHashTy:
+			witnessSize, err := witnessScriptSpendSize(witnessScript)
+			if err != nil {
+				return SpendSize{}, err
+			}
+			return SpendSize{
+				SigScriptSize: redeemPushSize,
+				WitnessSize:   witnessSize,
+			}, nil
+		}
+
+		itemLens, err := spendStackItems(redeemScript)
+		if err != nil {
+			return SpendSize{}, err
+		}
+		sigScriptSize := sigScriptItemsSize(itemLens) + redeemPushSize
+		return SpendSize{SigScriptSize: sigScriptSize}, nil
+
+	case PubKeyTy, PubKeyHashTy, MultiSigTy:
+		itemLens, err := spendStackItems(pkScript)
+		if err != nil {
+			return SpendSize{}, err
+		}
+		return SpendSize{SigScriptSize: sigScriptItemsSize(itemLens)}, nil
+	}
+
+	// Version 1 witness programs are spent through their key path with a
+	// single schnorr signature in the witness.
+	version, program, err := ExtractWitnessProgramInfo(pkScript)
+	if err == nil && version == 1 && len(program) == witnessV1ProgramLen {
+		witnessSize := witnessItemsSize([]int{maxSchnorrSigLen})
+		return SpendSize{WitnessSize: witnessSize}, nil
+	}
+
+	_, err = spendStackItems(pkScript)
+	return SpendSize{}, err
+}
+
+// EstimateSignedTxVSize returns the worst-case virtual size the passed
+// transaction will have once all of its inputs are signed, given the spend
+// size of each input in input order.  Any signature scripts and witnesses the
+// transaction already has are ignored in favor of the passed spend sizes.  An
+// error of kind ErrInvalidIndex is returned when the number of spend sizes
+// does not match the number of inputs.
+func EstimateSignedTxVSize(tx *wire.MsgTx, spendSizes []SpendSize) (int64, error) {
+	if len(spendSizes) != len(tx.TxIn) {
+		str := fmt.Sprintf("transaction has %d inputs but %d spend "+
+			"sizes were provided", len(tx.TxIn), len(spendSizes))
+		return 0, scriptError(ErrInvalidIndex, str)
+	}
+
+	// The version and lock time take up 4 bytes each.
+	baseSize := 8 + wire.VarIntSerializeSize(uint64(len(tx.TxIn))) +
+		wire.VarIntSerializeSize(uint64(len(tx.TxOut)))
+	for _, txOut := range tx.TxOut {
+		baseSize += txOut.SerializeSize()
+	}
+
+	hasWitness := false
+	for _, spendSize := range spendSizes {
+		baseSize += spendSize.InputSize()
+		if spendSize.WitnessSize > 0 {
+			hasWitness = true
+		}
+	}
+
+	// Once any input has a witness, the transaction carries the two byte
+	// marker and flag, and every input without a witness has an empty
+	// witness which takes up a single byte.
+	witnessSize := 0
+	if hasWitness {
+		witnessSize = 2
+		for _, spendSize := range spendSizes {
+			if spendSize.WitnessSize == 0 {
+				witnessSize++
+			}
+			witnessSize += spendSize.WitnessSize
+		}
+	}
+
+	weight := int64(baseSize*btcutil.WitnessScaleFactor + witnessSize)
+	return (weight + btcutil.WitnessScaleFactor - 1) /
+		btcutil.WitnessScaleFactor, nil
+}
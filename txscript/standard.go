@@ -83,96 +83,96 @@ func (t ScriptClass) String() string {
 	return scriptClassToName[t]
 }
 
-// isPubkey returns true if the script passed is a pay-to-pubkey transaction,
-// false otherwise.
-func isPubkey(pops []parsedOpcode) bool {
-	// Valid pubkeys are either 33 or 65 bytes.
-	return len(pops) == 2 &&
-		//(len(pops[0].data) == 33 || len(pops[0].data) == 65) &&
-		len(pops[0].data) == 33 && pops[0].data[0] == 0x03 &&
-		pops[1].opcode.value == OP_CHECKSIG
+// isPubKeyScript returns whether or not the passed script is a pay-to-pubkey
+// script.
+func isPubKeyScript(script []byte) bool {
+	tokenizer := MakeScriptTokenizer(script)
+	if !tokenizer.Next() || !btcutil.IsValidPubKey(tokenizer.Data()) {
+		return false
+	}
+	return tokenizer.Next() && tokenizer.Opcode() == OP_CHECKSIG &&
+		tokenizer.Done()
 }
 
-// isPubkeyHash returns true if the script passed is a pay-to-pubkey-hash
-// transaction, false otherwise.
-func isPubkeyHash(pops []parsedOpcode) bool {
-	return len(pops) == 5 &&
-		pops[0].opcode.value == OP_DUP &&
-		pops[1].opcode.value == OP_HASH160 &&
-		pops[2].opcode.value == OP_DATA_20 &&
-		pops[3].opcode.value == OP_EQUALVERIFY &&
-		pops[4].opcode.value == OP_CHECKSIG
-
+// isPubKeyHashScript returns whether or not the passed script is a
+// pay-to-pubkey-hash script.
+func isPubKeyHashScript(script []byte) bool {
+	return len(script) == 25 &&
+		script[0] == OP_DUP &&
+		script[1] == OP_HASH160 &&
+		script[2] == OP_DATA_20 &&
+		script[23] == OP_EQUALVERIFY &&
+		script[24] == OP_CHECKSIG
 }
 
-// isMultiSig returns true if the passed script is a multisig transaction, false
-// otherwise.
-func isMultiSig(pops []parsedOpcode) bool {
+// isMultiSigScript returns whether or not the passed script is a multisig
+// script whose number of public keys matches the number of keys it pushes.
+func isMultiSigScript(script []byte) bool {
 	// The absolute minimum is 1 pubkey:
 	// OP_0/OP_1-16 <pubkey> OP_1 OP_CHECKMULTISIG
-	l := len(pops)
-	if l < 4 {
-		return false
-	}
-	if !isSmallInt(pops[0].opcode) {
-		return false
-	}
-	if !isSmallInt(pops[l-2].opcode) {
+	tokenizer := MakeScriptTokenizer(script)
+	if !tokenizer.Next() || !isSmallInt(tokenizer.op) {
 		return false
 	}
-	if pops[l-1].opcode.value != OP_CHECKMULTISIG {
-		return false
+
+	// The public keys are followed by the number of them.
+	numPubKeys := 0
+	for {
+		if !tokenizer.Next() {
+			return false
+		}
+		if !btcutil.IsValidPubKey(tokenizer.Data()) {
+			break
+		}
+		numPubKeys++
 	}
+	if numPubKeys == 0 || !isSmallInt(tokenizer.op) ||
+		asSmallInt(tokenizer.op) != numPubKeys {
 
-	// Verify the number of pubkeys specified matches the actual number
-	// of pubkeys provided.
-	if l-2-1 != asSmallInt(pops[l-2].opcode) {
 		return false
 	}
 
-	for _, pop := range pops[1 : l-2] {
-		// Valid pubkeys are either 33 or 65 bytes.
-		if len(pop.data) != 33 || pop.data[0] != 0x03 /* && len(pop.data) != 65 */ {
-			return false
-		}
-	}
-	return true
+	return tokenizer.Next() && tokenizer.Opcode() == OP_CHECKMULTISIG &&
+		tokenizer.Done()
 }
 
-// isNullData returns true if the passed script is a null data transaction,
-// false otherwise.
-func isNullData(pops []parsedOpcode) bool {
-	// A nulldata transaction is either a single OP_RETURN or an
-	// OP_RETURN SMALLDATA (where SMALLDATA is a data push up to
-	// MaxDataCarrierSize bytes).
-	l := len(pops)
-	if l == 1 && pops[0].opcode.value == OP_RETURN {
+// isNullDataScript returns whether or not the passed script is a null data
+// script.
+func isNullDataScript(script []byte) bool {
+	// A nulldata script is either a single OP_RETURN or an OP_RETURN
+	// SMALLDATA (where SMALLDATA is a data push up to MaxDataCarrierSize
+	// bytes).
+	tokenizer := MakeScriptTokenizer(script)
+	if !tokenizer.Next() || tokenizer.Opcode() != OP_RETURN {
+		return false
+	}
+	if tokenizer.Done() {
 		return true
 	}
 
-	return l == 2 &&
-		pops[0].opcode.value == OP_RETURN &&
-		(isSmallInt(pops[1].opcode) || pops[1].opcode.value <=
-			OP_PUSHDATA4) &&
-		len(pops[1].data) <= MaxDataCarrierSize
+	return tokenizer.Next() &&
+		(isSmallInt(tokenizer.op) || tokenizer.Opcode() <= OP_PUSHDATA4) &&
+		len(tokenizer.Data()) <= MaxDataCarrierSize &&
+		tokenizer.Done()
 }
 
-// scriptType returns the type of the script being inspected from the known
-// standard types.
-func typeOfScript(pops []parsedOpcode) ScriptClass {
-	if isPubkey(pops) {
+// classifyScript returns the class of the passed script from the known
+// standard types.  Scripts which do not parse are NonStandardTy.
+func classifyScript(script []byte) ScriptClass {
+	switch {
+	case isPubKeyScript(script):
 		return PubKeyTy
-	} else if isPubkeyHash(pops) {
+	case isPubKeyHashScript(script):
 		return PubKeyHashTy
-	} else if isWitnessPubKeyHash(pops) {
+	case isWitnessPubKeyHashScript(script):
 		return WitnessV0PubKeyHashTy
-	} else if isScriptHash(pops) {
+	case isScriptHashScript(script):
 		return ScriptHashTy
-	} else if isWitnessScriptHash(pops) {
+	case isWitnessScriptHashScript(script):
 		return WitnessV0ScriptHashTy
-	} else if isMultiSig(pops) {
+	case isMultiSigScript(script):
 		return MultiSigTy
-	} else if isNullData(pops) {
+	case isNullDataScript(script):
 		return NullDataTy
 	}
 	return NonStandardTy
@@ -182,19 +182,15 @@ func typeOfScript(pops []parsedOpcode) ScriptClass {
 //
 // NonStandardTy will be returned when the script does not parse.
 func GetScriptClass(script []byte) ScriptClass {
-	pops, err := parseScript(script)
-	if err != nil {
-		return NonStandardTy
-	}
-	return typeOfScript(pops)
+	return classifyScript(script)
 }
 
 // expectedInputs returns the number of arguments required by a script.
 // If the script is of unknown type such that the number can not be determined
 // then -1 is returned. We are an internal function and thus assume that class
-// is the real class of pops (and we can thus assume things that were determined
-// while finding out the type).
-func expectedInputs(pops []parsedOpcode, class ScriptClass) int {
+// is the real class of the script (and we can thus assume things that were
+// determined while finding out the type).
+func expectedInputs(script []byte, class ScriptClass) int {
 	switch class {
 	case PubKeyTy:
 		return 1
@@ -216,12 +212,12 @@ func expectedInputs(pops []parsedOpcode, class ScriptClass) int {
 	case MultiSigTy:
 		// Standard multisig has a push a small number for the number
 		// of sigs and number of keys.  Check the first push instruction
-		// to see how many arguments are expected. typeOfScript already
-		// checked this so we know it'll be a small int.  Also, due to
-		// the original bitcoind bug where OP_CHECKMULTISIG pops an
-		// additional item from the stack, add an extra expected input
-		// for the extra push that is required to compensate.
-		return asSmallInt(pops[0].opcode) + 1
+		// to see how many arguments are expected. classifyScript
+		// already checked this so we know it'll be a small int.  Also,
+		// due to the original bitcoind bug where OP_CHECKMULTISIG pops
+		// an additional item from the stack, add an extra expected
+		// input for the extra push that is required to compensate.
+		return asSmallInt(&opcodeArray[script[0]]) + 1
 
 	case NullDataTy:
 		fallthrough
@@ -256,50 +252,56 @@ type ScriptInfo struct {
 func CalcScriptInfo(sigScript, pkScript []byte, witness wire.TxWitness,
 	bip16, segwit bool) (*ScriptInfo, error) {
 
-	sigPops, err := parseScript(sigScript)
-	if err != nil {
+	// Count the number of opcodes in the signature script while also
+	// ensuring it parses.  Since there is a check below to ensure the
+	// script is push only, this equates to the number of inputs to the
+	// public key script.
+	var numInputs int
+	tokenizer := MakeScriptTokenizer(sigScript)
+	for tokenizer.Next() {
+		numInputs++
+	}
+	if err := tokenizer.Err(); err != nil {
 		return nil, err
 	}
 
-	pkPops, err := parseScript(pkScript)
-	if err != nil {
+	if err := checkScriptParses(pkScript); err != nil {
 		return nil, err
 	}
 
 	// Push only sigScript makes little sense.
 	si := new(ScriptInfo)
-	si.PkScriptClass = typeOfScript(pkPops)
+	si.PkScriptClass = classifyScript(pkScript)
 
 	// Can't have a signature script that doesn't just push data.
-	if !isPushOnly(sigPops) {
+	if !IsPushOnlyScript(sigScript) {
 		return nil, scriptError(ErrNotPushOnly,
 			"signature script is not push only")
 	}
 
-	si.ExpectedInputs = expectedInputs(pkPops, si.PkScriptClass)
+	si.ExpectedInputs = expectedInputs(pkScript, si.PkScriptClass)
 
 	switch {
 	// Count sigops taking into account pay-to-script-hash.
 	case si.PkScriptClass == ScriptHashTy && bip16 && !segwit:
 		// The pay-to-hash-script is the final data push of the
 		// signature script.
-		script := sigPops[len(sigPops)-1].data
-		shPops, err := parseScript(script)
-		if err != nil {
+		script := finalOpcodeData(sigScript)
+		if err := checkScriptParses(script); err != nil {
 			return nil, err
 		}
 
-		shInputs := expectedInputs(shPops, typeOfScript(shPops))
+		shInputs := expectedInputs(script, classifyScript(script))
 		if shInputs == -1 {
 			si.ExpectedInputs = -1
 		} else {
 			si.ExpectedInputs += shInputs
 		}
-		si.SigOps = getSigOpCount(shPops, true)
+		si.SigOps = countSigOps(script, true)
 
 		// All entries pushed to stack (or are OP_RESERVED and exec
 		// will fail).
-		si.NumInputs = len(sigPops)
+		si.NumInputs = numInputs
 
 	// If segwit is active, and this is a regular p2wkh output, then we'll
 	// treat the script as a p2pkh output in essence.
@@ -310,13 +312,13 @@ func CalcScriptInfo(sigScript, pkScript []byte, witness wire.TxWitness,
 
 	// We'll attempt to detect the nested p2sh case so we can accurately
 	// count the signature operations involved.
-	case si.PkScriptClass == ScriptHashTy &&
+	case si.PkScriptClass == ScriptHashTy && len(sigScript) > 0 &&
 		IsWitnessProgram(sigScript[1:]) && bip16 && segwit:
 
 		// Extract the pushed witness program from the sigScript so we
 		// can determine the number of expected inputs.
-		pkPops, _ := parseScript(sigScript[1:])
-		shInputs := expectedInputs(pkPops, typeOfScript(pkPops))
+		program := sigScript[1:]
+		shInputs := expectedInputs(program, classifyScript(program))
 		if shInputs == -1 {
 			si.ExpectedInputs = -1
 		} else {
@@ -326,7 +328,7 @@ func CalcScriptInfo(sigScript, pkScript []byte, witness wire.TxWitness,
 		si.SigOps = GetWitnessSigOpCount(sigScript, pkScript, witness)
 
 		si.NumInputs = len(witness)
-		si.NumInputs += len(sigPops)
+		si.NumInputs += numInputs
 
 	// If segwit is active, and this is a p2wsh output, then we'll need to
 	// examine the witness script to generate accurate script info.
@@ -334,9 +336,9 @@ func CalcScriptInfo(sigScript, pkScript []byte, witness wire.TxWitness,
 		// The witness script is the final element of the witness
 		// stack.
 		witnessScript := witness[len(witness)-1]
-		pops, _ := parseScript(witnessScript)
 
-		shInputs := expectedInputs(pops, typeOfScript(pops))
+		shInputs := expectedInputs(witnessScript,
+			classifyScript(witnessScript))
 		if shInputs == -1 {
 			si.ExpectedInputs = -1
 		} else {
@@ -347,11 +349,11 @@ func CalcScriptInfo(sigScript, pkScript []byte, witness wire.TxWitness,
 		si.NumInputs = len(witness)
 
 	default:
-		si.SigOps = getSigOpCount(pkPops, true)
+		si.SigOps = countSigOps(pkScript, true)
 
 		// All entries pushed to stack (or are OP_RESERVED and exec
 		// will fail).
-		si.NumInputs = len(sigPops)
+		si.NumInputs = numInputs
 	}
 
 	return si, nil
@@ -526,14 +528,7 @@ func ExtractPkScriptAddrs(pkScript []byte, chainParams *chaincfg.Params) (Script
 	var addrs []btcutil.Address
 	var requiredSigs int
 
-	// No valid addresses or required signatures if the script doesn't
-	// parse.
-	pops, err := parseScript(pkScript)
-	if err != nil {
-		return NonStandardTy, nil, 0, err
-	}
-
-	scriptClass := typeOfScript(pops)
+	scriptClass := classifyScript(pkScript)
 	switch scriptClass {
 	case PubKeyHashTy:
 		// A pay-to-pubkey-hash script is of the form:
@@ -541,7 +536,7 @@ func ExtractPkScriptAddrs(pkScript []byte, chainParams *chaincfg.Params) (Script
 		// Therefore the pubkey hash is the 3rd item on the stack.
 		// Skip the pubkey hash if it's invalid for some reason.
 		requiredSigs = 1
-		addr, err := btcutil.NewAddressPubKeyHash(pops[2].data,
+		addr, err := btcutil.NewAddressPubKeyHash(pkScript[3:23],
 			chainParams)
 		if err == nil {
 			addrs = append(addrs, addr)
@@ -553,7 +548,7 @@ func ExtractPkScriptAddrs(pkScript []byte, chainParams *chaincfg.Params) (Script
 		// Therefore, the pubkey hash is the second item on the stack.
 		// Skip the pubkey hash if it's invalid for some reason.
 		requiredSigs = 1
-		addr, err := btcutil.NewAddressWitnessPubKeyHash(pops[1].data,
+		addr, err := btcutil.NewAddressWitnessPubKeyHash(pkScript[2:22],
 			chainParams)
 		if err == nil {
 			addrs = append(addrs, addr)
@@ -565,7 +560,7 @@ func ExtractPkScriptAddrs(pkScript []byte, chainParams *chaincfg.Params) (Script
 		// Therefore the pubkey is the first item on the stack.
 		// Skip the pubkey if it's invalid for some reason.
 		requiredSigs = 1
-		addr, err := btcutil.NewAddressPubKey(pops[0].data, chainParams)
+		addr, err := btcutil.NewAddressPubKey(pkScript[1:34], chainParams)
 		if err == nil {
 			addrs = append(addrs, addr)
 		}
@@ -576,7 +571,7 @@ func ExtractPkScriptAddrs(pkScript []byte, chainParams *chaincfg.Params) (Script
 		// Therefore the script hash is the 2nd item on the stack.
 		// Skip the script hash if it's invalid for some reason.
 		requiredSigs = 1
-		addr, err := btcutil.NewAddressScriptHashFromHash(pops[1].data,
+		addr, err := btcutil.NewAddressScriptHashFromHash(pkScript[2:22],
 			chainParams)
 		if err == nil {
 			addrs = append(addrs, addr)
@@ -588,7 +583,7 @@ func ExtractPkScriptAddrs(pkScript []byte, chainParams *chaincfg.Params) (Script
 		// Therefore, the script hash is the second item on the stack.
 		// Skip the script hash if it's invalid for some reason.
 		requiredSigs = 1
-		addr, err := btcutil.NewAddressWitnessScriptHash(pops[1].data,
+		addr, err := btcutil.NewAddressWitnessScriptHash(pkScript[2:34],
 			chainParams)
 		if err == nil {
 			addrs = append(addrs, addr)
@@ -598,15 +593,15 @@ func ExtractPkScriptAddrs(pkScript []byte, chainParams *chaincfg.Params) (Script
 		// A multi-signature script is of the form:
 		//  <numsigs> <pubkey> <pubkey> <pubkey>... <numpubkeys> OP_CHECKMULTISIG
 		// Therefore the number of required signatures is the 1st item
-		// on the stack and the number of public keys is the 2nd to last
-		// item on the stack.
-		requiredSigs = asSmallInt(pops[0].opcode)
-		numPubKeys := asSmallInt(pops[len(pops)-2].opcode)
+		// on the stack and the public keys are the data pushes which
+		// follow it.
+		tokenizer := MakeScriptTokenizer(pkScript)
+		tokenizer.Next()
+		requiredSigs = asSmallInt(tokenizer.op)
 
 		// Extract the public keys while skipping any that are invalid.
-		addrs = make([]btcutil.Address, 0, numPubKeys)
-		for i := 0; i < numPubKeys; i++ {
-			addr, err := btcutil.NewAddressPubKey(pops[i+1].data,
+		for tokenizer.Next() && tokenizer.Data() != nil {
+			addr, err := btcutil.NewAddressPubKey(tokenizer.Data(),
 				chainParams)
 			if err == nil {
 				addrs = append(addrs, addr)
@@ -619,7 +614,11 @@ func ExtractPkScriptAddrs(pkScript []byte, chainParams *chaincfg.Params) (Script
 
 	case NonStandardTy:
 		// Don't attempt to extract addresses or required signatures for
-		// nonstandard transactions.
+		// nonstandard transactions, but do report scripts which don't
+		// parse.
+		if err := checkScriptParses(pkScript); err != nil {
+			return NonStandardTy, nil, 0, err
+		}
 	}
 
 	return scriptClass, addrs, requiredSigs, nil
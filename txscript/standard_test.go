@@ -0,0 +1,490 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/nbcorg/btcd/wire"
+	"github.com/nbcorg/btcutil/chaincfg"
+)
+
+// parsedScriptClass returns the class of the passed script by parsing it into
+// opcodes first, the way GetScriptClass did before it used the tokenizer.  It
+// serves as the reference the tokenizer based classification is checked and
+// benchmarked against.
+func parsedScriptClass(script []byte) ScriptClass {
+	pops, err := parseScript(script)
+	if err != nil {
+		return NonStandardTy
+	}
+
+	isPubKey := func(data []byte) bool {
+		return len(data) == 33 && data[0] == 0x03
+	}
+	l := len(pops)
+	switch {
+	case l == 2 && isPubKey(pops[0].data) &&
+		pops[1].opcode.value == OP_CHECKSIG:
+		return PubKeyTy
+
+	case l == 5 && pops[0].opcode.value == OP_DUP &&
+		pops[1].opcode.value == OP_HASH160 &&
+		pops[2].opcode.value == OP_DATA_20 &&
+		pops[3].opcode.value == OP_EQUALVERIFY &&
+		pops[4].opcode.value == OP_CHECKSIG:
+		return PubKeyHashTy
+
+	case l == 2 && pops[0].opcode.value == OP_0 &&
+		pops[1].opcode.value == OP_DATA_20:
+		return WitnessV0PubKeyHashTy
+
+	case parsedIsScriptHash(pops):
+		return ScriptHashTy
+
+	case l == 2 && pops[0].opcode.value == OP_0 &&
+		pops[1].opcode.value == OP_DATA_32:
+		return WitnessV0ScriptHashTy
+	}
+
+	if l >= 4 && isSmallInt(pops[0].opcode) &&
+		isSmallInt(pops[l-2].opcode) &&
+		pops[l-1].opcode.value == OP_CHECKMULTISIG &&
+		l-3 == asSmallInt(pops[l-2].opcode) {
+
+		isMultiSig := true
+		for _, pop := range pops[1 : l-2] {
+			isMultiSig = isMultiSig && isPubKey(pop.data)
+		}
+		if isMultiSig {
+			return MultiSigTy
+		}
+	}
+
+	if l > 0 && pops[0].opcode.value == OP_RETURN && (l == 1 || l == 2 &&
+		(isSmallInt(pops[1].opcode) ||
+			pops[1].opcode.value <= OP_PUSHDATA4) &&
+		len(pops[1].data) <= MaxDataCarrierSize) {
+
+		return NullDataTy
+	}
+	return NonStandardTy
+}
+
+// parsedIsScriptHash returns whether the passed opcodes are a
+// pay-to-script-hash script.
+func parsedIsScriptHash(pops []parsedOpcode) bool {
+	return len(pops) == 3 &&
+		pops[0].opcode.value == OP_HASH160 &&
+		pops[1].opcode.value == OP_DATA_20 &&
+		pops[2].opcode.value == OP_EQUAL
+}
+
+// parsedIsPayToScriptHash returns whether the passed script is a
+// pay-to-script-hash script by parsing it into opcodes first.
+func parsedIsPayToScriptHash(script []byte) bool {
+	pops, err := parseScript(script)
+	return err == nil && parsedIsScriptHash(pops)
+}
+
+// parsedSigOpCount returns the number of signature operations in the passed
+// script by parsing it into opcodes first, counting up to the point of a parse
+// failure.
+func parsedSigOpCount(script []byte, precise bool) int {
+	pops, _ := parseScript(script)
+
+	numSigOps := 0
+	for i, pop := range pops {
+		switch pop.opcode.value {
+		case OP_CHECKSIG, OP_CHECKSIGVERIFY:
+			numSigOps++
+
+		case OP_CHECKMULTISIG, OP_CHECKMULTISIGVERIFY:
+			if precise && i > 0 &&
+				pops[i-1].opcode.value >= OP_1 &&
+				pops[i-1].opcode.value <= OP_16 {
+
+				numSigOps += asSmallInt(pops[i-1].opcode)
+			} else {
+				numSigOps += MaxPubKeysPerMultiSig
+			}
+		}
+	}
+	return numSigOps
+}
+
+// testPubKey returns a valid serialized public key derived from the passed
+// byte.
+func testPubKey(b byte) []byte {
+	pubKey := bytes.Repeat([]byte{b}, 33)
+	pubKey[0] = 0x03
+	return pubKey
+}
+
+// randomScript returns a script which is close to one of the standard script
+// templates, possibly corrupted so that it no longer matches the template or
+// no longer parses.
+func randomScript(r *rand.Rand) []byte {
+	builder := NewScriptBuilder()
+	switch r.Intn(8) {
+	case 0:
+		builder.AddData(testPubKey(1)).AddOp(OP_CHECKSIG)
+
+	case 1:
+		builder.AddOp(OP_DUP).AddOp(OP_HASH160).
+			AddData(make([]byte, 20)).AddOp(OP_EQUALVERIFY).
+			AddOp(OP_CHECKSIG)
+
+	case 2:
+		lens := []int{1, 2, 20, 32, 40, 41}
+		builder.AddInt64(int64(r.Intn(2) * 16)).
+			AddData(make([]byte, lens[r.Intn(len(lens))]))
+
+	case 3:
+		builder.AddOp(OP_HASH160).AddData(make([]byte, 20)).
+			AddOp(OP_EQUAL)
+
+	case 4:
+		builder.AddInt64(int64(r.Intn(3)))
+		numPubKeys := r.Intn(4)
+		for i := 0; i < numPubKeys; i++ {
+			builder.AddData(testPubKey(byte(i)))
+		}
+		builder.AddInt64(int64(r.Intn(4))).AddOp(OP_CHECKMULTISIG)
+
+	case 5:
+		builder.AddOp(OP_RETURN)
+		if r.Intn(2) == 0 {
+			builder.AddData(make([]byte, r.Intn(90)))
+		}
+
+	case 6:
+		script := make([]byte, r.Intn(50))
+		r.Read(script)
+		return script
+	}
+	script, _ := builder.Script()
+
+	for i := r.Intn(3); i > 0 && len(script) > 0; i-- {
+		switch r.Intn(3) {
+		case 0:
+			script[r.Intn(len(script))] = byte(r.Intn(256))
+		case 1:
+			script = script[:r.Intn(len(script))]
+		case 2:
+			script = append(script, byte(r.Intn(256)))
+		}
+	}
+	return script
+}
+
+// randomScripts returns the passed number of scripts from randomScript using a
+// fixed seed.
+func randomScripts(n int) [][]byte {
+	r := rand.New(rand.NewSource(1))
+	scripts := make([][]byte, n)
+	for i := range scripts {
+		scripts[i] = randomScript(r)
+	}
+	return scripts
+}
+
+// TestScriptTokenizerParity ensures the tokenizer yields the same opcodes and
+// errors as parseScript, and that the tokenizer based functions agree with
+// the ones which parse the script into opcodes first.
+func TestScriptTokenizerParity(t *testing.T) {
+	for _, script := range randomScripts(100000) {
+		pops, err := parseScript(script)
+		tokenizer := MakeScriptTokenizer(script)
+		var n int
+		for ; tokenizer.Next(); n++ {
+			if n >= len(pops) ||
+				tokenizer.Opcode() != pops[n].opcode.value ||
+				!bytes.Equal(tokenizer.Data(), pops[n].data) {
+
+				t.Fatalf("%x: opcode %d does not match", script, n)
+			}
+		}
+		if n != len(pops) {
+			t.Fatalf("%x: got %d opcodes, want %d", script, n,
+				len(pops))
+		}
+		tokErr := tokenizer.Err()
+		if (tokErr == nil) != (err == nil) ||
+			(err != nil && tokErr.Error() != err.Error()) {
+
+			t.Fatalf("%x: got error %v, want %v", script, tokErr, err)
+		}
+
+		if got, want := GetScriptClass(script),
+			parsedScriptClass(script); got != want {
+
+			t.Fatalf("%x: GetScriptClass: got %v, want %v", script,
+				got, want)
+		}
+		if got, want := IsPayToScriptHash(script),
+			parsedIsPayToScriptHash(script); got != want {
+
+			t.Fatalf("%x: IsPayToScriptHash: got %v, want %v",
+				script, got, want)
+		}
+		if got, want := GetSigOpCount(script),
+			parsedSigOpCount(script, false); got != want {
+
+			t.Fatalf("%x: GetSigOpCount: got %d, want %d", script,
+				got, want)
+		}
+	}
+}
+
+// TestScriptClassAllocs ensures classifying scripts which parse and counting
+// their signature operations does not allocate.  Only parse failures allocate,
+// to create their error.
+func TestScriptClassAllocs(t *testing.T) {
+	var scripts [][]byte
+	for _, script := range randomScripts(200) {
+		if checkScriptParses(script) == nil {
+			scripts = append(scripts, script)
+		}
+	}
+	allocs := testing.AllocsPerRun(10, func() {
+		for _, script := range scripts {
+			GetScriptClass(script)
+			IsPayToScriptHash(script)
+			GetSigOpCount(script)
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("got %v allocations, want 0", allocs)
+	}
+}
+
+// TestExtractPkScriptAddrs ensures the class, addresses and required
+// signatures are extracted from the standard scripts.
+func TestExtractPkScriptAddrs(t *testing.T) {
+	hash20 := bytes.Repeat([]byte{0x01}, 20)
+	hash32 := bytes.Repeat([]byte{0x02}, 32)
+	mustScript := func(builder *ScriptBuilder) []byte {
+		script, err := builder.Script()
+		if err != nil {
+			t.Fatalf("Script: unexpected error: %v", err)
+		}
+		return script
+	}
+
+	tests := []struct {
+		name     string
+		script   []byte
+		class    ScriptClass
+		numAddrs int
+		reqSigs  int
+	}{
+		{
+			name: "pubkey",
+			script: mustScript(NewScriptBuilder().
+				AddData(testPubKey(1)).AddOp(OP_CHECKSIG)),
+			class:    PubKeyTy,
+			numAddrs: 1,
+			reqSigs:  1,
+		},
+		{
+			name: "pubkey with an invalid prefix",
+			script: mustScript(NewScriptBuilder().
+				AddData(append([]byte{0x02}, hash32...)).
+				AddOp(OP_CHECKSIG)),
+			class: NonStandardTy,
+		},
+		{
+			name: "pubkey hash",
+			script: mustScript(NewScriptBuilder().AddOp(OP_DUP).
+				AddOp(OP_HASH160).AddData(hash20).
+				AddOp(OP_EQUALVERIFY).AddOp(OP_CHECKSIG)),
+			class:    PubKeyHashTy,
+			numAddrs: 1,
+			reqSigs:  1,
+		},
+		{
+			name: "witness pubkey hash",
+			script: mustScript(NewScriptBuilder().AddOp(OP_0).
+				AddData(hash20)),
+			class:    WitnessV0PubKeyHashTy,
+			numAddrs: 1,
+			reqSigs:  1,
+		},
+		{
+			name: "script hash",
+			script: mustScript(NewScriptBuilder().AddOp(OP_HASH160).
+				AddData(hash20).AddOp(OP_EQUAL)),
+			class:    ScriptHashTy,
+			numAddrs: 1,
+			reqSigs:  1,
+		},
+		{
+			name: "witness script hash",
+			script: mustScript(NewScriptBuilder().AddOp(OP_0).
+				AddData(hash32)),
+			class:    WitnessV0ScriptHashTy,
+			numAddrs: 1,
+			reqSigs:  1,
+		},
+		{
+			name: "2 of 3 multisig",
+			script: mustScript(NewScriptBuilder().AddOp(OP_2).
+				AddData(testPubKey(1)).AddData(testPubKey(2)).
+				AddData(testPubKey(3)).AddOp(OP_3).
+				AddOp(OP_CHECKMULTISIG)),
+			class:    MultiSigTy,
+			numAddrs: 3,
+			reqSigs:  2,
+		},
+		{
+			name: "null data",
+			script: mustScript(NewScriptBuilder().AddOp(OP_RETURN).
+				AddData(hash20)),
+			class: NullDataTy,
+		},
+	}
+	for _, test := range tests {
+		class, addrs, reqSigs, err := ExtractPkScriptAddrs(test.script,
+			&chaincfg.MainNetParams)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if class != test.class || len(addrs) != test.numAddrs ||
+			reqSigs != test.reqSigs {
+
+			t.Errorf("%s: got class %v, %d addresses and %d "+
+				"required signatures, want %v, %d and %d",
+				test.name, class, len(addrs), reqSigs, test.class,
+				test.numAddrs, test.reqSigs)
+		}
+	}
+
+	_, _, _, err := ExtractPkScriptAddrs([]byte{OP_DATA_2, 0x01},
+		&chaincfg.MainNetParams)
+	if !IsErrorCode(err, ErrMalformedPush) {
+		t.Fatalf("got error %v, want kind %v", err, ErrMalformedPush)
+	}
+}
+
+// TestCalcScriptInfo ensures the script info of pay-to-script-hash and
+// pay-to-witness-script-hash spends accounts for the redeem script, and that
+// signature scripts which are not push only are rejected.
+func TestCalcScriptInfo(t *testing.T) {
+	redeemScript, err := NewScriptBuilder().AddOp(OP_1).
+		AddData(testPubKey(1)).AddData(testPubKey(2)).AddOp(OP_2).
+		AddOp(OP_CHECKMULTISIG).Script()
+	if err != nil {
+		t.Fatalf("Script: unexpected error: %v", err)
+	}
+	p2shScript, err := payToScriptHashScript(make([]byte, 20))
+	if err != nil {
+		t.Fatalf("payToScriptHashScript: unexpected error: %v", err)
+	}
+	p2wshScript, err := payToWitnessScriptHashScript(make([]byte, 32))
+	if err != nil {
+		t.Fatalf("payToWitnessScriptHashScript: unexpected error: %v",
+			err)
+	}
+	sigScript, err := NewScriptBuilder().AddOp(OP_0).
+		AddData(make([]byte, 71)).AddData(redeemScript).Script()
+	if err != nil {
+		t.Fatalf("Script: unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		sigScript []byte
+		pkScript  []byte
+		witness   wire.TxWitness
+		want      ScriptInfo
+	}{
+		{
+			name:      "p2sh multisig",
+			sigScript: sigScript,
+			pkScript:  p2shScript,
+			want: ScriptInfo{
+				PkScriptClass:  ScriptHashTy,
+				NumInputs:      3,
+				ExpectedInputs: 3,
+				SigOps:         2,
+			},
+		},
+		{
+			name:     "p2wsh multisig",
+			pkScript: p2wshScript,
+			witness: wire.TxWitness{nil, make([]byte, 71),
+				redeemScript},
+			want: ScriptInfo{
+				PkScriptClass:  WitnessV0ScriptHashTy,
+				NumInputs:      3,
+				ExpectedInputs: 3,
+				SigOps:         2,
+			},
+		},
+	}
+	for _, test := range tests {
+		si, err := CalcScriptInfo(test.sigScript, test.pkScript,
+			test.witness, true, test.witness != nil)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if *si != test.want {
+			t.Errorf("%s: got %+v, want %+v", test.name, *si,
+				test.want)
+		}
+	}
+
+	_, err = CalcScriptInfo([]byte{OP_DUP}, p2shScript, nil, true, false)
+	if !IsErrorCode(err, ErrNotPushOnly) {
+		t.Fatalf("got error %v, want kind %v", err, ErrNotPushOnly)
+	}
+}
+
+// benchmarkScripts are the scripts the classification benchmarks run over.
+var benchmarkScripts = randomScripts(1000)
+
+// benchmarkParsedAndTokenized runs the passed functions over the benchmark
+// scripts as the parsed and tokenizer sub-benchmarks, reporting allocations.
+func benchmarkParsedAndTokenized(b *testing.B, parsed, tokenizer func([]byte)) {
+	run := func(f func([]byte)) func(*testing.B) {
+		return func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				f(benchmarkScripts[i%len(benchmarkScripts)])
+			}
+		}
+	}
+	b.Run("parsed", run(parsed))
+	b.Run("tokenizer", run(tokenizer))
+}
+
+// BenchmarkGetScriptClass benchmarks classifying scripts by parsing them into
+// opcodes against classifying them with the tokenizer.
+func BenchmarkGetScriptClass(b *testing.B) {
+	benchmarkParsedAndTokenized(b,
+		func(script []byte) { parsedScriptClass(script) },
+		func(script []byte) { GetScriptClass(script) })
+}
+
+// BenchmarkIsPayToScriptHash benchmarks detecting pay-to-script-hash scripts
+// by parsing them into opcodes against checking the raw script.
+func BenchmarkIsPayToScriptHash(b *testing.B) {
+	benchmarkParsedAndTokenized(b,
+		func(script []byte) { parsedIsPayToScriptHash(script) },
+		func(script []byte) { IsPayToScriptHash(script) })
+}
+
+// BenchmarkGetSigOpCount benchmarks counting signature operations by parsing
+// scripts into opcodes against counting them with the tokenizer.
+func BenchmarkGetSigOpCount(b *testing.B) {
+	benchmarkParsedAndTokenized(b,
+		func(script []byte) { parsedSigOpCount(script, false) },
+		func(script []byte) { GetSigOpCount(script) })
+}
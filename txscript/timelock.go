@@ -0,0 +1,307 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"github.com/nbcorg/btcd/wire"
+	"github.com/nbcorg/btcutil"
+	"github.com/nbcorg/btcutil/chaincfg"
+)
+
+// LockTimeToSequence returns the sequence number of an input enforcing the
+// passed relative lock time as defined by BIP 68, which is a number of blocks
+// or, when isSeconds is true, a number of seconds.  Relative lock times in
+// seconds have a granularity of 512 seconds, so they are rounded up to the
+// next multiple of it.  An error of kind ErrInvalidLockTime is returned when
+// the lock time is too large to be encoded.
+//
+// The result is also the number to use with OP_CHECKSEQUENCEVERIFY in order
+// to require the same relative lock time.
+func LockTimeToSequence(isSeconds bool, lockTime uint32) (uint32, error) {
+	value := uint64(lockTime)
+	flags := uint32(0)
+	if isSeconds {
+		const granularity = 1 << wire.SequenceLockTimeGranularity
+		value = (value + granularity - 1) / granularity
+		flags = wire.SequenceLockTimeIsSeconds
+	}
+	if value > wire.SequenceLockTimeMask {
+		unit := "blocks"
+		if isSeconds {
+			unit = "seconds"
+		}
+		str := fmt.Sprintf("relative lock time of %d %s exceeds the "+
+			"maximum that can be encoded", lockTime, unit)
+		return 0, scriptError(ErrInvalidLockTime, str)
+	}
+	return flags | uint32(value), nil
+}
+
+// SequenceToLockTime returns the relative lock time the passed sequence number
+// of an input enforces as defined by BIP 68, which is a number of blocks or,
+// when isSeconds is true, a number of seconds.  Enabled is false when the
+// sequence number has its disable flag set and enforces no relative lock
+// time at all.
+func SequenceToLockTime(sequence uint32) (isSeconds bool, lockTime uint32, enabled bool) {
+	if sequence&wire.SequenceLockTimeDisabled != 0 {
+		return false, 0, false
+	}
+	lockTime = sequence & wire.SequenceLockTimeMask
+	if sequence&wire.SequenceLockTimeIsSeconds != 0 {
+		return true, lockTime << wire.SequenceLockTimeGranularity, true
+	}
+	return false, lockTime, true
+}
+
+// WitnessScriptAddress returns the pay-to-witness-script-hash address paying
+// to the passed witness script on the passed network.
+func WitnessScriptAddress(witnessScript []byte, net *chaincfg.Params) (*btcutil.AddressWitnessScriptHash, error) {
+	hash := sha256.Sum256(witnessScript)
+	return btcutil.NewAddressWitnessScriptHash(hash[:], net)
+}
+
+// checkTimeLockKey returns an error of kind ErrPubKeyType unless the passed
+// public key is valid in this chain.  See btcutil.IsValidPubKey.
+func checkTimeLockKey(pubKey []byte) error {
+	if !btcutil.IsValidPubKey(pubKey) {
+		str := fmt.Sprintf("invalid public key %x", pubKey)
+		return scriptError(ErrPubKeyType, str)
+	}
+	return nil
+}
+
+// checkRelativeLockTime returns an error of kind ErrInvalidLockTime unless the
+// passed sequence number enforces a relative lock time.
+func checkRelativeLockTime(sequence uint32) error {
+	if sequence&wire.SequenceLockTimeDisabled != 0 {
+		str := fmt.Sprintf("sequence %#x has the relative lock time "+
+			"disable flag set", sequence)
+		return scriptError(ErrInvalidLockTime, str)
+	}
+	return nil
+}
+
+// AbsoluteTimeLockScript returns a witness script which can only be spent with
+// a signature by the passed public key once the passed lock time is reached.
+// The lock time is interpreted as a block height below LockTimeThreshold and
+// as a timestamp otherwise.  An error of kind ErrInvalidLockTime is returned
+// for a lock time of zero, which would not lock the output at all.  See
+// WitnessScriptAddress for the address of the script.
+//
+// The spending transaction must set its lock time to at least the passed one
+// and its input a sequence number below wire.MaxTxInSequenceNum, since
+// OP_CHECKLOCKTIMEVERIFY fails for inputs whose lock time is disabled.
+func AbsoluteTimeLockScript(pubKey []byte, lockTime uint32) ([]byte, error) {
+	if err := checkTimeLockKey(pubKey); err != nil {
+		return nil, err
+	}
+	if lockTime == 0 {
+		str := "absolute lock time must not be zero"
+		return nil, scriptError(ErrInvalidLockTime, str)
+	}
+	return NewScriptBuilder().AddInt64(int64(lockTime)).
+		AddOp(OP_CHECKLOCKTIMEVERIFY).AddOp(OP_DROP).
+		AddData(pubKey).AddOp(OP_CHECKSIG).Script()
+}
+
+// RelativeTimeLockScript returns a witness script which can only be spent with
+// a signature by the passed public key once the output has been confirmed for
+// the relative lock time the passed sequence number enforces.  See
+// LockTimeToSequence for how to encode the relative lock time and
+// WitnessScriptAddress for the address of the script.
+//
+// Relative lock times are only enforced for transactions with a version of 2
+// or higher, so OP_CHECKSEQUENCEVERIFY fails when the spending transaction has
+// a lower version.
+func RelativeTimeLockScript(pubKey []byte, sequence uint32) ([]byte, error) {
+	if err := checkTimeLockKey(pubKey); err != nil {
+		return nil, err
+	}
+	if err := checkRelativeLockTime(sequence); err != nil {
+		return nil, err
+	}
+	return NewScriptBuilder().AddInt64(int64(sequence)).
+		AddOp(OP_CHECKSEQUENCEVERIFY).AddOp(OP_DROP).
+		AddData(pubKey).AddOp(OP_CHECKSIG).Script()
+}
+
+// VaultScript returns a witness script with two spending paths.  The recovery
+// key can spend the output at any time, while the delayed key can only spend
+// it once the output has been confirmed for the relative lock time the passed
+// sequence number enforces.  This gives the holder of the recovery key time
+// to claw back funds spent by a compromised delayed key.
+//
+// The recovery path is taken by placing a 1 on top of the signature in the
+// witness, and the delayed path by placing an empty item there instead.  See
+// WitnessScriptAddress for the address of the script.  Just like for
+// RelativeTimeLockScript, the delayed path can only be taken by a transaction
+// with a version of 2 or higher.
+func VaultScript(recoveryKey, delayedKey []byte, sequence uint32) ([]byte, error) {
+	if err := checkTimeLockKey(recoveryKey); err != nil {
+		return nil, err
+	}
+	if err := checkTimeLockKey(delayedKey); err != nil {
+		return nil, err
+	}
+	if err := checkRelativeLockTime(sequence); err != nil {
+		return nil, err
+	}
+
+	builder := NewScriptBuilder()
+	builder.AddOp(OP_IF).AddData(recoveryKey)
+	builder.AddOp(OP_ELSE).AddInt64(int64(sequence))
+	builder.AddOp(OP_CHECKSEQUENCEVERIFY).AddOp(OP_DROP).AddData(delayedKey)
+	builder.AddOp(OP_ENDIF).AddOp(OP_CHECKSIG)
+	return builder.Script()
+}
+
+// TimeLockPath describes a path spending a timelocked script.
+type TimeLockPath struct {
+	// PubKey is the public key which must sign to take the path.
+	PubKey []byte
+
+	// LockTime is the absolute lock time the spending transaction must
+	// have, or zero when the path has no absolute lock time.
+	LockTime uint32
+
+	// Sequence is the sequence number the spending input must have to
+	// take the path.  It enforces the relative lock time of the path as
+	// defined by BIP 68, in which case the spending transaction must have
+	// a version of 2 or higher.  Paths with an absolute lock time use
+	// wire.MaxTxInSequenceNum-1 so the lock time is not disabled, and
+	// other paths use wire.MaxTxInSequenceNum.
+	Sequence uint32
+}
+
+// EarliestSpend returns the earliest block height and the earliest median time
+// past of the block preceding that block at which a transaction spending an
+// output through the path can be mined, given the height of the block which
+// confirmed the output and the median time past of the block preceding it.
+// The median time is the zero time when the path does not depend on it.
+func (p *TimeLockPath) EarliestSpend(confHeight int32, confMedianTime time.Time) (int32, time.Time) {
+	height, medianTime := confHeight, time.Time{}
+
+	// Transactions are final once their lock time is below the height of
+	// their block or the median time past preceding it.
+	if p.LockTime != 0 {
+		if p.LockTime < LockTimeThreshold {
+			if lockHeight := int32(p.LockTime) + 1; lockHeight > height {
+				height = lockHeight
+			}
+		} else {
+			medianTime = time.Unix(int64(p.LockTime)+1, 0)
+		}
+	}
+
+	// Relative lock times count from the block confirming the output, or
+	// from the median time past preceding it.
+	isSeconds, lockTime, enabled := SequenceToLockTime(p.Sequence)
+	switch {
+	case enabled && isSeconds:
+		relTime := confMedianTime.Add(time.Duration(lockTime) * time.Second)
+		if relTime.After(medianTime) {
+			medianTime = relTime
+		}
+	case enabled:
+		if relHeight := confHeight + int32(lockTime); relHeight > height {
+			height = relHeight
+		}
+	}
+
+	return height, medianTime
+}
+
+// scriptNumPush returns the number the passed opcode pushes and whether it is
+// a canonical push of a script number.
+func scriptNumPush(pop parsedOpcode) (int64, bool) {
+	if !canonicalPush(pop) {
+		return 0, false
+	}
+	if isSmallInt(pop.opcode) {
+		return int64(asSmallInt(pop.opcode)), true
+	}
+	if pop.opcode.value > OP_PUSHDATA4 {
+		return 0, false
+	}
+	num, err := makeScriptNum(pop.data, true, 5)
+	if err != nil {
+		return 0, false
+	}
+	return int64(num), true
+}
+
+// ExtractTimeLockPaths returns the spending paths of a witness script built by
+// AbsoluteTimeLockScript, RelativeTimeLockScript or VaultScript.  If the script
+// is not one of them, ExtractTimeLockPaths returns (nil, nil).  Non-nil errors
+// are returned for unparsable scripts.
+func ExtractTimeLockPaths(script []byte) ([]TimeLockPath, error) {
+	pops, err := parseScript(script)
+	if err != nil {
+		return nil, err
+	}
+
+	// isKey returns whether the opcode pushes a valid public key.
+	isKey := func(pop parsedOpcode) bool {
+		return pop.opcode.value == OP_DATA_33 &&
+			checkTimeLockKey(pop.data) == nil
+	}
+
+	// lockedKey matches a lock time enforced by the passed opcode followed
+	// by a check of the signature of a key, and returns the lock time and
+	// the key.
+	lockedKey := func(pops []parsedOpcode, lockOp byte) (uint32, []byte, bool) {
+		if len(pops) < 4 || pops[1].opcode.value != lockOp ||
+			pops[2].opcode.value != OP_DROP || !isKey(pops[3]) {
+
+			return 0, nil, false
+		}
+		num, ok := scriptNumPush(pops[0])
+		if !ok || num <= 0 || num > int64(^uint32(0)) {
+			return 0, nil, false
+		}
+		return uint32(num), pops[3].data, true
+	}
+
+	switch {
+	case len(pops) == 5 && pops[4].opcode.value == OP_CHECKSIG:
+		lockTime, pubKey, ok := lockedKey(pops, OP_CHECKLOCKTIMEVERIFY)
+		if ok {
+			return []TimeLockPath{{
+				PubKey:   pubKey,
+				LockTime: lockTime,
+				Sequence: wire.MaxTxInSequenceNum - 1,
+			}}, nil
+		}
+		sequence, pubKey, ok := lockedKey(pops, OP_CHECKSEQUENCEVERIFY)
+		if ok && checkRelativeLockTime(sequence) == nil {
+			return []TimeLockPath{{
+				PubKey:   pubKey,
+				Sequence: sequence,
+			}}, nil
+		}
+
+	case len(pops) == 9 && pops[0].opcode.value == OP_IF &&
+		isKey(pops[1]) && pops[2].opcode.value == OP_ELSE &&
+		pops[7].opcode.value == OP_ENDIF &&
+		pops[8].opcode.value == OP_CHECKSIG:
+
+		sequence, pubKey, ok := lockedKey(pops[3:7], OP_CHECKSEQUENCEVERIFY)
+		if ok && checkRelativeLockTime(sequence) == nil {
+			return []TimeLockPath{{
+				PubKey:   pops[1].data,
+				Sequence: wire.MaxTxInSequenceNum,
+			}, {
+				PubKey:   pubKey,
+				Sequence: sequence,
+			}}, nil
+		}
+	}
+
+	return nil, nil
+}
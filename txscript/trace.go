@@ -0,0 +1,223 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript
+
+import (
+	"fmt"
+
+	"github.com/nbcorg/btcd/wire"
+)
+
+// ScriptKind identifies which of the scripts involved in spending a
+// transaction input is running.
+type ScriptKind byte
+
+const (
+	// ScriptKindSig is the signature script of the input.
+	ScriptKindSig ScriptKind = iota
+
+	// ScriptKindPkScript is the public key script of the spent output.
+	ScriptKindPkScript
+
+	// ScriptKindRedeem is the redeem script of a pay-to-script-hash
+	// output, which is the last item the signature script pushes.
+	ScriptKindRedeem
+
+	// ScriptKindWitness is the witness script of a witness program, which
+	// is the last witness item for pay-to-witness-script-hash outputs, and
+	// the implied pay-to-pubkey-hash script for
+	// pay-to-witness-pubkey-hash outputs.
+	ScriptKindWitness
+)
+
+// scriptKindStrings is a map of script kinds back to their names.
+var scriptKindStrings = map[ScriptKind]string{
+	ScriptKindSig:      "sigScript",
+	ScriptKindPkScript: "pkScript",
+	ScriptKindRedeem:   "redeemScript",
+	ScriptKindWitness:  "witnessScript",
+}
+
+// String returns the ScriptKind as a human-readable name.
+func (k ScriptKind) String() string {
+	if s := scriptKindStrings[k]; s != "" {
+		return s
+	}
+	return fmt.Sprintf("Unknown ScriptKind (%d)", int(k))
+}
+
+// TraceStep describes an opcode executed by a ScriptTracer along with the
+// state of the engine it left behind.
+type TraceStep struct {
+	// ScriptIndex is the index of the running script, as accepted by
+	// Engine.DisasmScript.
+	ScriptIndex int
+
+	// Kind identifies the role of the running script.
+	Kind ScriptKind
+
+	// PC is the index of the opcode within the running script.
+	PC int
+
+	// Opcode is the value of the opcode.
+	Opcode byte
+
+	// Data is the data the opcode pushes, if any.
+	Data []byte
+
+	// Executed is whether the opcode was in an executing branch.  Opcodes
+	// in a branch which is not taken are skipped, apart from conditionals
+	// which still update the conditional stack.
+	Executed bool
+
+	// Stack and AltStack are the main and alt stacks after the opcode,
+	// from the bottom to the top.  After the last opcode of a script they
+	// are the stacks the next script starts with, since the alt stack
+	// does not persist and pay-to-script-hash and witness programs
+	// replace the main stack.
+	Stack    [][]byte
+	AltStack [][]byte
+
+	// CondStack is the conditional stack after the opcode, which holds
+	// one of OpCondTrue, OpCondFalse and OpCondSkip for every conditional
+	// enclosing the next opcode, from the outermost to the innermost.
+	CondStack []int
+
+	// Err is the error the opcode failed with, if any.
+	Err error
+
+	pop *parsedOpcode
+}
+
+// String returns the step in the same format as Engine.DisasmPC, which is the
+// index of the script and of the opcode followed by the disassembled opcode,
+// prefixed by the kind of the script.
+func (s *TraceStep) String() string {
+	return fmt.Sprintf("%s %02x:%04x: %s", s.Kind, s.ScriptIndex, s.PC,
+		s.pop.print(false))
+}
+
+// TraceHook is called with every step of a ScriptTracer, including the one
+// which fails.
+type TraceHook func(step *TraceStep)
+
+// LogTraceHook is a TraceHook which logs every step along with the stacks it
+// left behind through the package logger at the trace level.  See UseLogger.
+func LogTraceHook(step *TraceStep) {
+	log.Tracef("%v", newLogClosure(func() string {
+		if !step.Executed {
+			return fmt.Sprintf("stepping %v (skipped)", step)
+		}
+		return fmt.Sprintf("stepping %v", step)
+	}))
+	if step.Err != nil {
+		log.Tracef("%v failed: %v", step, step.Err)
+		return
+	}
+	log.Tracef("%v", newLogClosure(func() string {
+		str := "Stack:\n" + (&stack{stk: step.Stack}).String()
+		if len(step.AltStack) != 0 {
+			str += "AltStack:\n" +
+				(&stack{stk: step.AltStack}).String()
+		}
+		if len(step.CondStack) != 0 {
+			str += fmt.Sprintf("CondStack: %v\n", step.CondStack)
+		}
+		return str
+	}))
+}
+
+// ScriptTracer steps through the execution of the scripts which spend a
+// transaction input by an Engine, exposing the state of the engine after
+// every opcode.
+type ScriptTracer struct {
+	// Hook, when set, is called with every step taken.
+	Hook TraceHook
+
+	vm   *Engine
+	done bool
+	err  error
+}
+
+// NewScriptTracer returns a new ScriptTracer which executes the scripts of
+// input txIdx of the passed transaction against the public key script of the
+// output it spends.  The arguments are those of NewEngine, whose error is
+// returned when the engine can not be created.
+func NewScriptTracer(scriptPubKey []byte, tx *wire.MsgTx, txIdx int,
+	flags ScriptFlags, inputAmount int64,
+	checker SigChecker) (*ScriptTracer, error) {
+
+	vm, err := NewEngine(scriptPubKey, tx, txIdx, flags, inputAmount,
+		checker)
+	if err != nil {
+		return nil, err
+	}
+	return &ScriptTracer{vm: vm}, nil
+}
+
+// Engine returns the engine of the tracer, which must not be stepped other
+// than through the tracer.
+func (t *ScriptTracer) Engine() *Engine {
+	return t.vm
+}
+
+// Done returns whether the tracer has executed every opcode or failed.
+func (t *ScriptTracer) Done() bool {
+	return t.done
+}
+
+// Step executes the next opcode and returns the resulting step, calling the
+// hook of the tracer with it when set.  The error the opcode failed with is
+// returned as well, after which the tracer is done.  Nil is returned when the
+// tracer is already done.
+func (t *ScriptTracer) Step() (*TraceStep, error) {
+	if t.done {
+		return nil, t.err
+	}
+
+	vm := t.vm
+	scriptIdx, scriptOff, err := vm.curPC()
+	if err != nil {
+		t.done, t.err = true, err
+		return nil, err
+	}
+	pop := &vm.scripts[scriptIdx][scriptOff]
+	step := &TraceStep{
+		ScriptIndex: scriptIdx,
+		Kind:        vm.scriptKinds[scriptIdx],
+		PC:          scriptOff,
+		Opcode:      pop.opcode.value,
+		Data:        pop.data,
+		Executed:    vm.isBranchExecuting(),
+		pop:         pop,
+	}
+
+	t.done, step.Err = vm.Step()
+	if step.Err != nil {
+		t.done, t.err = true, step.Err
+	}
+	step.Stack = vm.GetStack()
+	step.AltStack = vm.GetAltStack()
+	step.CondStack = vm.GetCondStack()
+
+	if t.Hook != nil {
+		t.Hook(step)
+	}
+	return step, step.Err
+}
+
+// Run executes every remaining opcode, calling the hook of the tracer with
+// each of them, and returns nil when the scripts validate, as Engine.Execute.
+func (t *ScriptTracer) Run() error {
+	for !t.done {
+		if _, err := t.Step(); err != nil {
+			return err
+		}
+	}
+	if t.err != nil {
+		return t.err
+	}
+	return t.vm.CheckErrorCondition(true)
+}
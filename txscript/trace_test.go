@@ -0,0 +1,225 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btclog"
+	"github.com/nbcorg/btcd/wire"
+	"github.com/nbcorg/btcutil"
+)
+
+// traceTx returns a transaction with a single input spending an output with
+// the passed signature script and witness.
+func traceTx(sigScript []byte, witness wire.TxWitness) *wire.MsgTx {
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(&wire.TxIn{
+		SignatureScript: sigScript,
+		Witness:         witness,
+	})
+	tx.AddTxOut(wire.NewTxOut(1000, []byte{OP_TRUE}))
+	return tx
+}
+
+// traceInput traces the only input of tx, returning every step taken along
+// with the result of the tracer.
+func traceInput(t *testing.T, pkScript []byte, tx *wire.MsgTx,
+	flags ScriptFlags, checker SigChecker) ([]*TraceStep, error) {
+
+	tracer, err := NewScriptTracer(pkScript, tx, 0, flags, 1000, checker)
+	if err != nil {
+		t.Fatalf("NewScriptTracer: unexpected error: %v", err)
+	}
+	var steps []*TraceStep
+	tracer.Hook = func(step *TraceStep) {
+		steps = append(steps, step)
+	}
+	err = tracer.Run()
+	if !tracer.Done() {
+		t.Fatalf("Run: tracer is not done")
+	}
+	return steps, err
+}
+
+// TestTraceP2SH ensures the tracer executes the redeem script of a
+// pay-to-script-hash output with the rest of the signature script stack.
+func TestTraceP2SH(t *testing.T) {
+	redeemScript := mustScript(t, NewScriptBuilder().AddOp(OP_1ADD).
+		AddOp(OP_3).AddOp(OP_EQUAL))
+	pkScript := mustScript(t, NewScriptBuilder().AddOp(OP_HASH160).
+		AddData(btcutil.Hash160(redeemScript)).AddOp(OP_EQUAL))
+	sigScript := mustScript(t, NewScriptBuilder().AddOp(OP_2).
+		AddData(redeemScript))
+
+	steps, err := traceInput(t, pkScript, traceTx(sigScript, nil),
+		ScriptBip16, nil)
+	if err != nil {
+		t.Fatalf("Run: unexpected error: %v", err)
+	}
+
+	wantKinds := []ScriptKind{ScriptKindSig, ScriptKindSig,
+		ScriptKindPkScript, ScriptKindPkScript, ScriptKindPkScript,
+		ScriptKindRedeem, ScriptKindRedeem, ScriptKindRedeem}
+	if len(steps) != len(wantKinds) {
+		t.Fatalf("Run: took %d steps, want %d", len(steps),
+			len(wantKinds))
+	}
+	for i, step := range steps {
+		if step.Kind != wantKinds[i] {
+			t.Errorf("step %d: kind %v, want %v", i, step.Kind,
+				wantKinds[i])
+		}
+	}
+
+	// The last opcode of the public key script leaves the stack the
+	// redeem script starts with.
+	wantStack := [][]byte{{2}}
+	if got := steps[4].Stack; !reflect.DeepEqual(got, wantStack) {
+		t.Errorf("step 4: stack %x, want %x", got, wantStack)
+	}
+	if got := steps[5].Stack; !reflect.DeepEqual(got, [][]byte{{3}}) {
+		t.Errorf("step 5: stack %x, want [03]", got)
+	}
+}
+
+// TestTraceWitnessConditional ensures the tracer exposes the stacks and the
+// conditional stack after every opcode of a witness script, along with which
+// opcodes are in a branch which is not taken.
+func TestTraceWitnessConditional(t *testing.T) {
+	witnessScript := mustScript(t, NewScriptBuilder().AddOp(OP_IF).
+		AddOp(OP_2).AddOp(OP_ELSE).AddOp(OP_3).AddOp(OP_TOALTSTACK).
+		AddOp(OP_3).AddOp(OP_ENDIF).AddOp(OP_FROMALTSTACK).
+		AddOp(OP_EQUAL))
+	scriptHash := sha256.Sum256(witnessScript)
+	pkScript := mustScript(t, NewScriptBuilder().AddOp(OP_0).
+		AddData(scriptHash[:]))
+	tx := traceTx(nil, wire.TxWitness{nil, witnessScript})
+
+	steps, err := traceInput(t, pkScript, tx, ScriptBip16|
+		ScriptVerifyWitness|ScriptVerifyMinimalIf|ScriptVerifyCleanStack,
+		nil)
+	if err != nil {
+		t.Fatalf("Run: unexpected error: %v", err)
+	}
+
+	type wantStep struct {
+		kind      ScriptKind
+		opcode    byte
+		executed  bool
+		stack     [][]byte
+		altStack  [][]byte
+		condStack []int
+	}
+	want := []wantStep{
+		{ScriptKindPkScript, OP_0, true, [][]byte{nil}, nil, nil},
+		{ScriptKindPkScript, OP_DATA_32, true, [][]byte{nil}, nil, nil},
+		{ScriptKindWitness, OP_IF, true, nil, nil,
+			[]int{OpCondFalse}},
+		{ScriptKindWitness, OP_2, false, nil, nil,
+			[]int{OpCondFalse}},
+		{ScriptKindWitness, OP_ELSE, false, nil, nil,
+			[]int{OpCondTrue}},
+		{ScriptKindWitness, OP_3, true, [][]byte{{3}}, nil,
+			[]int{OpCondTrue}},
+		{ScriptKindWitness, OP_TOALTSTACK, true, nil, [][]byte{{3}},
+			[]int{OpCondTrue}},
+		{ScriptKindWitness, OP_3, true, [][]byte{{3}}, [][]byte{{3}},
+			[]int{OpCondTrue}},
+		{ScriptKindWitness, OP_ENDIF, true, [][]byte{{3}},
+			[][]byte{{3}}, nil},
+		{ScriptKindWitness, OP_FROMALTSTACK, true, [][]byte{{3}, {3}},
+			nil, nil},
+		{ScriptKindWitness, OP_EQUAL, true, [][]byte{{1}}, nil, nil},
+	}
+	if len(steps) != len(want) {
+		t.Fatalf("Run: took %d steps, want %d", len(steps), len(want))
+	}
+	for i, step := range steps {
+		w := want[i]
+		if step.Kind != w.kind || step.Opcode != w.opcode ||
+			step.Executed != w.executed {
+
+			t.Errorf("step %d: got %v executed %v, want %v %s "+
+				"executed %v", i, step, step.Executed, w.kind,
+				opcodeArray[w.opcode].name, w.executed)
+		}
+		if len(step.Stack) != len(w.stack) ||
+			len(step.Stack) != 0 && !reflect.DeepEqual(step.Stack, w.stack) {
+
+			t.Errorf("step %d: stack %x, want %x", i, step.Stack,
+				w.stack)
+		}
+		if len(step.AltStack) != len(w.altStack) ||
+			len(step.AltStack) != 0 &&
+				!reflect.DeepEqual(step.AltStack, w.altStack) {
+
+			t.Errorf("step %d: alt stack %x, want %x", i,
+				step.AltStack, w.altStack)
+		}
+		if len(step.CondStack) != len(w.condStack) ||
+			len(step.CondStack) != 0 &&
+				!reflect.DeepEqual(step.CondStack, w.condStack) {
+
+			t.Errorf("step %d: cond stack %v, want %v", i,
+				step.CondStack, w.condStack)
+		}
+	}
+}
+
+// TestTraceFailure ensures the tracer stops at the failing opcode, reports its
+// error through the hook, and logs it through LogTraceHook.
+func TestTraceFailure(t *testing.T) {
+	var buf bytes.Buffer
+	logger := btclog.NewBackend(&buf).Logger("TXSC")
+	logger.SetLevel(btclog.LevelTrace)
+	UseLogger(logger)
+	defer DisableLog()
+
+	pkScript := mustScript(t, NewScriptBuilder().AddOp(OP_2).AddOp(OP_3).
+		AddOp(OP_EQUALVERIFY).AddOp(OP_1))
+	tracer, err := NewScriptTracer(pkScript, traceTx(nil, nil), 0, 0, 0,
+		nil)
+	if err != nil {
+		t.Fatalf("NewScriptTracer: unexpected error: %v", err)
+	}
+	var steps []*TraceStep
+	tracer.Hook = func(step *TraceStep) {
+		steps = append(steps, step)
+		LogTraceHook(step)
+	}
+	err = tracer.Run()
+	if !IsErrorCode(err, ErrEqualVerify) {
+		t.Fatalf("Run: got error %v, want %v", err, ErrEqualVerify)
+	}
+	if len(steps) != 3 {
+		t.Fatalf("Run: took %d steps, want 3", len(steps))
+	}
+	last := steps[len(steps)-1]
+	if last.Opcode != OP_EQUALVERIFY || last.Err != err {
+		t.Fatalf("last step: got %v with error %v, want OP_EQUALVERIFY "+
+			"with error %v", last, last.Err, err)
+	}
+
+	// The tracer keeps returning the error once it failed.
+	if step, stepErr := tracer.Step(); step != nil || stepErr != err {
+		t.Fatalf("Step: got %v, %v after failure, want nil, %v", step,
+			stepErr, err)
+	}
+
+	logged := buf.String()
+	for _, s := range []string{"pkScript 01:0001: OP_3", "OP_EQUALVERIFY",
+		"failed"} {
+
+		if !strings.Contains(logged, s) {
+			t.Errorf("LogTraceHook: log does not contain %q:\n%s", s,
+				logged)
+		}
+	}
+}
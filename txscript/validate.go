@@ -0,0 +1,291 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/nbcorg/btcd/btcec"
+	"github.com/nbcorg/btcd/chaincfg/chainhash"
+	"github.com/nbcorg/btcd/wire"
+	"github.com/nbcorg/btcutil"
+)
+
+// PrevOutputFetcher looks up the outputs spent by the inputs of the
+// transactions being validated.
+type PrevOutputFetcher interface {
+	// FetchPrevOutput returns the output the passed outpoint refers to, or
+	// nil when it is unknown or already spent.
+	FetchPrevOutput(op wire.OutPoint) *wire.TxOut
+}
+
+// InputVerifier executes the scripts of a single transaction input.  The
+// verifier returned by NewEngineVerifier executes them with an Engine, while
+// other implementations may be plugged in through this interface.
+type InputVerifier interface {
+	// VerifyInput executes the signature script and witness of input idx
+	// of the passed transaction against the public key script of the
+	// output it spends with the passed flags, and returns an error when
+	// they do not validate.  Script failures should be returned as an
+	// Error so their ErrorCode can be reported.  The passed sighashes,
+	// which are shared by every input of the transaction, are nil when
+	// the transaction has no witness data.
+	VerifyInput(tx *wire.MsgTx, idx int, prevOut *wire.TxOut,
+		flags ScriptFlags, sigHashes *TxSigHashes) error
+}
+
+// TxSigChecker is a SigChecker which calculates signature hashes and verifies
+// ECDSA signatures, skipping the verification of signatures found in its
+// SigCache and adding those it verifies.
+type TxSigChecker struct {
+	sigCache  *SigCache
+	sigHashes *TxSigHashes
+}
+
+// NewTxSigChecker returns a TxSigChecker using the passed signature cache,
+// which may be nil to verify every signature.  The passed sighashes are those
+// of the transaction being verified and are calculated when first needed when
+// they are nil, in which case the checker must only be used for a single
+// transaction.
+func NewTxSigChecker(sigCache *SigCache, sigHashes *TxSigHashes) *TxSigChecker {
+	return &TxSigChecker{sigCache: sigCache, sigHashes: sigHashes}
+}
+
+// CheckSig returns whether sig is a valid signature by pubKey of the signature
+// hash of the passed input.
+//
+// This is part of the SigChecker interface.
+func (c *TxSigChecker) CheckSig(sig, pubKey []byte, hashType SigHashType,
+	tx *wire.MsgTx, idx int, amount int64, scriptCode []byte,
+	witness bool) bool {
+
+	var hash []byte
+	var err error
+	if witness {
+		if c.sigHashes == nil {
+			c.sigHashes = NewTxSigHashes(tx)
+		}
+		hash, err = CalcWitnessSigHash(scriptCode, c.sigHashes,
+			hashType, tx, idx, amount)
+	} else {
+		hash, err = CalcSignatureHash(scriptCode, hashType, tx, idx)
+	}
+	if err != nil {
+		return false
+	}
+
+	var sigHash chainhash.Hash
+	copy(sigHash[:], hash)
+	if c.sigCache != nil && c.sigCache.Exists(sigHash, sig, pubKey) {
+		return true
+	}
+
+	pk, err := btcec.ParsePubKey(pubKey, btcec.S256())
+	if err != nil {
+		return false
+	}
+	signature, err := btcec.ParseSignature(sig, btcec.S256())
+	if err != nil {
+		return false
+	}
+	if !signature.Verify(hash, pk) {
+		return false
+	}
+	if c.sigCache != nil {
+		c.sigCache.Add(sigHash, sig, pubKey)
+	}
+	return true
+}
+
+// engineVerifier is the InputVerifier returned by NewEngineVerifier.
+type engineVerifier struct {
+	sigCache *SigCache
+}
+
+// NewEngineVerifier returns an InputVerifier which executes the scripts of
+// inputs with an Engine, verifying their signatures with a TxSigChecker using
+// the passed signature cache, which may be nil.
+func NewEngineVerifier(sigCache *SigCache) InputVerifier {
+	return &engineVerifier{sigCache: sigCache}
+}
+
+// VerifyInput executes the scripts of the passed input with an Engine.
+//
+// This is part of the InputVerifier interface.
+func (v *engineVerifier) VerifyInput(tx *wire.MsgTx, idx int,
+	prevOut *wire.TxOut, flags ScriptFlags, sigHashes *TxSigHashes) error {
+
+	checker := NewTxSigChecker(v.sigCache, sigHashes)
+	vm, err := NewEngine(prevOut.PkScript, tx, idx, flags, prevOut.Value,
+		checker)
+	if err != nil {
+		return err
+	}
+	return vm.Execute()
+}
+
+// InputError describes a transaction input which failed validation.
+type InputError struct {
+	// TxHash is the hash of the transaction with the failing input.
+	TxHash chainhash.Hash
+
+	// InputIndex is the index of the failing input.
+	InputIndex int
+
+	// ErrorCode is the code of the failure, which is ErrInternal when the
+	// InputVerifier returned an error that is not an Error.
+	ErrorCode ErrorCode
+
+	// Err is the error the input failed with.
+	Err error
+}
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e *InputError) Error() string {
+	return fmt.Sprintf("failed to validate input %s:%d: %v", e.TxHash,
+		e.InputIndex, e.Err)
+}
+
+// newInputError returns an InputError for the passed failing input.
+func newInputError(tx *btcutil.Tx, idx int, err error) *InputError {
+	code := ErrInternal
+	if serr, ok := err.(Error); ok {
+		code = serr.ErrorCode
+	}
+	return &InputError{
+		TxHash:     *tx.Hash(),
+		InputIndex: idx,
+		ErrorCode:  code,
+		Err:        err,
+	}
+}
+
+// inputWork is a transaction input to validate along with the output it
+// spends.
+type inputWork struct {
+	tx        *btcutil.Tx
+	idx       int
+	prevOut   *wire.TxOut
+	sigHashes *TxSigHashes
+}
+
+// collectInputs appends the inputs of the passed transaction to work along
+// with the sighashes they share when the transaction has witness data.  An
+// InputError of kind ErrMissingPrevOut is returned for the first input
+// whose output can not be fetched.
+func collectInputs(work []inputWork, tx *btcutil.Tx,
+	fetcher PrevOutputFetcher) ([]inputWork, error) {
+
+	var sigHashes *TxSigHashes
+	if tx.MsgTx().HasWitness() {
+		sigHashes = NewTxSigHashes(tx.MsgTx())
+	}
+	for idx, txIn := range tx.MsgTx().TxIn {
+		prevOut := fetcher.FetchPrevOutput(txIn.PreviousOutPoint)
+		if prevOut == nil {
+			str := fmt.Sprintf("unable to find output %v spent by "+
+				"input %d", txIn.PreviousOutPoint, idx)
+			return nil, newInputError(tx, idx,
+				scriptError(ErrMissingPrevOut, str))
+		}
+		work = append(work, inputWork{tx: tx, idx: idx,
+			prevOut: prevOut, sigHashes: sigHashes})
+	}
+	return work, nil
+}
+
+// validateInputs verifies the passed inputs across a pool of worker
+// goroutines and returns an InputError for the first failure seen.
+func validateInputs(work []inputWork, flags ScriptFlags,
+	verifier InputVerifier) error {
+
+	numWorkers := runtime.NumCPU() * 3
+	if numWorkers > len(work) {
+		numWorkers = len(work)
+	}
+
+	workChan := make(chan inputWork)
+	resultChan := make(chan error)
+	quit := make(chan struct{})
+	defer close(quit)
+
+	for i := 0; i < numWorkers; i++ {
+		go func() {
+			for item := range workChan {
+				var result error
+				err := verifier.VerifyInput(item.tx.MsgTx(),
+					item.idx, item.prevOut, flags,
+					item.sigHashes)
+				if err != nil {
+					result = newInputError(item.tx, item.idx, err)
+				}
+				select {
+				case resultChan <- result:
+				case <-quit:
+					return
+				}
+			}
+		}()
+	}
+
+	// Feed the workers from a separate goroutine so results can be
+	// received while work is still being handed out.
+	go func() {
+		defer close(workChan)
+		for _, item := range work {
+			select {
+			case workChan <- item:
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	for range work {
+		if err := <-resultChan; err != nil {
+			log.Debugf("Script validation failed: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateTransactionScripts validates the scripts of every input of the
+// passed transaction in parallel, fetching the outputs they spend from the
+// passed fetcher and executing them with the passed verifier and flags.
+//
+// An error of type *InputError is returned for a failing input, identifying it
+// along with the ErrorCode of the failure.  Inputs spending outputs that can
+// not be fetched fail with ErrMissingPrevOut before any script is executed.
+// When several inputs fail, which of them is reported is unspecified.
+func ValidateTransactionScripts(tx *btcutil.Tx, fetcher PrevOutputFetcher,
+	flags ScriptFlags, verifier InputVerifier) error {
+
+	work, err := collectInputs(nil, tx, fetcher)
+	if err != nil {
+		return err
+	}
+	return validateInputs(work, flags, verifier)
+}
+
+// ValidateBlockScripts validates the scripts of every input of every
+// transaction of the passed block other than the coinbase in parallel.  The
+// fetcher must also provide the outputs created by earlier transactions of the
+// block.  Errors are reported as for ValidateTransactionScripts.
+func ValidateBlockScripts(block *btcutil.Block, fetcher PrevOutputFetcher,
+	flags ScriptFlags, verifier InputVerifier) error {
+
+	var work []inputWork
+	txns := block.Transactions()
+	for i := 1; i < len(txns); i++ {
+		var err error
+		work, err = collectInputs(work, txns[i], fetcher)
+		if err != nil {
+			return err
+		}
+	}
+	return validateInputs(work, flags, verifier)
+}
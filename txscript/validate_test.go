@@ -0,0 +1,301 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript
+
+import (
+	"errors"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nbcorg/btcd/btcec"
+	"github.com/nbcorg/btcd/chaincfg/chainhash"
+	"github.com/nbcorg/btcd/wire"
+	"github.com/nbcorg/btcutil"
+)
+
+// mapFetcher is a PrevOutputFetcher backed by a map.
+type mapFetcher map[wire.OutPoint]*wire.TxOut
+
+// FetchPrevOutput returns the output the passed outpoint refers to.
+func (m mapFetcher) FetchPrevOutput(op wire.OutPoint) *wire.TxOut {
+	return m[op]
+}
+
+// stubVerifier is an InputVerifier which fails the inputs found in its fail
+// map with the mapped error and counts its calls.
+type stubVerifier struct {
+	fail  map[int]error
+	delay time.Duration
+	calls int32
+}
+
+// VerifyInput fails the input when it is found in the fail map.
+func (v *stubVerifier) VerifyInput(tx *wire.MsgTx, idx int,
+	prevOut *wire.TxOut, flags ScriptFlags, sigHashes *TxSigHashes) error {
+
+	atomic.AddInt32(&v.calls, 1)
+	if err, ok := v.fail[idx]; ok {
+		return err
+	}
+	time.Sleep(v.delay)
+	return nil
+}
+
+// validateTx returns a transaction with the passed number of inputs along with
+// a fetcher providing the outputs they spend.
+func validateTx(numInputs int) (*btcutil.Tx, mapFetcher) {
+	tx := wire.NewMsgTx(2)
+	fetcher := make(mapFetcher)
+	for i := 0; i < numInputs; i++ {
+		op := wire.OutPoint{Hash: chainhash.Hash{0x01}, Index: uint32(i)}
+		tx.AddTxIn(&wire.TxIn{PreviousOutPoint: op})
+		fetcher[op] = wire.NewTxOut(1000, []byte{OP_TRUE})
+	}
+	tx.AddTxOut(wire.NewTxOut(1000, []byte{OP_TRUE}))
+	return btcutil.NewTx(tx), fetcher
+}
+
+// TestValidateTransactionScripts ensures the inputs of a transaction are all
+// verified and failures are reported with the index and code of the failing
+// input.
+func TestValidateTransactionScripts(t *testing.T) {
+	t.Parallel()
+
+	tx, fetcher := validateTx(100)
+	verifier := &stubVerifier{}
+	err := ValidateTransactionScripts(tx, fetcher, 0, verifier)
+	if err != nil {
+		t.Fatalf("ValidateTransactionScripts: unexpected error: %v", err)
+	}
+	if verifier.calls != 100 {
+		t.Fatalf("ValidateTransactionScripts: verified %d inputs, want "+
+			"100", verifier.calls)
+	}
+
+	tests := []struct {
+		name    string
+		fail    map[int]error
+		missing int
+		index   int
+		code    ErrorCode
+	}{
+		{
+			name:    "script failure",
+			fail:    map[int]error{57: scriptError(ErrEvalFalse, "")},
+			missing: -1,
+			index:   57,
+			code:    ErrEvalFalse,
+		},
+		{
+			name:    "other failure",
+			fail:    map[int]error{3: errors.New("failure")},
+			missing: -1,
+			index:   3,
+			code:    ErrInternal,
+		},
+		{
+			name:    "missing output",
+			fail:    map[int]error{0: scriptError(ErrEvalFalse, "")},
+			missing: 42,
+			index:   42,
+			code:    ErrMissingPrevOut,
+		},
+	}
+
+	for _, test := range tests {
+		tx, fetcher := validateTx(100)
+		if test.missing >= 0 {
+			op := tx.MsgTx().TxIn[test.missing].PreviousOutPoint
+			delete(fetcher, op)
+		}
+		verifier := &stubVerifier{fail: test.fail}
+		err := ValidateTransactionScripts(tx, fetcher, 0, verifier)
+		ierr, ok := err.(*InputError)
+		if !ok {
+			t.Errorf("%s: got error %v, want *InputError", test.name,
+				err)
+			continue
+		}
+		if ierr.TxHash != *tx.Hash() || ierr.InputIndex != test.index ||
+			ierr.ErrorCode != test.code {
+
+			t.Errorf("%s: got input %s:%d with code %v, want %s:%d "+
+				"with code %v", test.name, ierr.TxHash,
+				ierr.InputIndex, ierr.ErrorCode, tx.Hash(),
+				test.index, test.code)
+		}
+
+		// No script is executed when an output is missing.
+		if test.missing >= 0 && verifier.calls != 0 {
+			t.Errorf("%s: verified %d inputs, want 0", test.name,
+				verifier.calls)
+		}
+	}
+}
+
+// TestValidateScriptsNoLeak ensures the worker goroutines exit when
+// validation returns early on a failing input.
+func TestValidateScriptsNoLeak(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	tx, fetcher := validateTx(500)
+	verifier := &stubVerifier{
+		fail:  map[int]error{0: scriptError(ErrEvalFalse, "")},
+		delay: time.Millisecond,
+	}
+	err := ValidateTransactionScripts(tx, fetcher, 0, verifier)
+	if !IsErrorCode(err.(*InputError).Err, ErrEvalFalse) {
+		t.Fatalf("ValidateTransactionScripts: got error %v, want %v",
+			err, ErrEvalFalse)
+	}
+	if n := atomic.LoadInt32(&verifier.calls); n == 500 {
+		t.Fatalf("ValidateTransactionScripts: verified every input " +
+			"after a failure")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("ValidateTransactionScripts: %d goroutines "+
+				"still running, want %d", runtime.NumGoroutine(),
+				before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestValidateBlockScripts ensures the inputs of every transaction of a block
+// other than the coinbase are verified.
+func TestValidateBlockScripts(t *testing.T) {
+	t.Parallel()
+
+	coinbase := wire.NewMsgTx(1)
+	coinbase.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Index: wire.MaxPrevOutIndex},
+	})
+	tx1, _ := validateTx(3)
+	tx2, fetcher := validateTx(4)
+	block := btcutil.NewBlock(&wire.MsgBlock{
+		Transactions: []*wire.MsgTx{coinbase, tx1.MsgTx(), tx2.MsgTx()},
+	})
+
+	verifier := &stubVerifier{}
+	if err := ValidateBlockScripts(block, fetcher, 0, verifier); err != nil {
+		t.Fatalf("ValidateBlockScripts: unexpected error: %v", err)
+	}
+	if verifier.calls != 7 {
+		t.Fatalf("ValidateBlockScripts: verified %d inputs, want 7",
+			verifier.calls)
+	}
+
+	verifier = &stubVerifier{fail: map[int]error{
+		2: scriptError(ErrEqualVerify, ""),
+	}}
+	err := ValidateBlockScripts(block, fetcher, 0, verifier)
+	ierr, ok := err.(*InputError)
+	if !ok || ierr.ErrorCode != ErrEqualVerify || ierr.InputIndex != 2 {
+		t.Fatalf("ValidateBlockScripts: got error %v, want input 2 "+
+			"with code %v", err, ErrEqualVerify)
+	}
+}
+
+// cacheSig adds the passed signature, which includes its hash type byte, to
+// the passed signature cache as a valid signature of the passed hash.
+func cacheSig(sigCache *SigCache, hash, sig, pubKey []byte) {
+	var sigHash chainhash.Hash
+	copy(sigHash[:], hash)
+	sigCache.Add(sigHash, sig[:len(sig)-1], pubKey)
+}
+
+// TestEngineVerifier ensures the engine verifier verifies legacy and witness
+// signatures, fills the signature cache with them, and skips verifying the
+// signatures found in the cache.
+func TestEngineVerifier(t *testing.T) {
+	t.Parallel()
+
+	privKey, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatalf("NewPrivateKey: unexpected error: %v", err)
+	}
+	pubKey := privKey.PubKey().SerializeCompressed()
+	pkHash := btcutil.Hash160(pubKey)
+	p2pkh, err := payToPubKeyHashScript(pkHash)
+	if err != nil {
+		t.Fatalf("payToPubKeyHashScript: unexpected error: %v", err)
+	}
+	p2wpkh := mustScript(t, NewScriptBuilder().AddOp(OP_0).AddData(pkHash))
+
+	// Spend a legacy output with the first input and a witness output
+	// with the second one.
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Index: 0}})
+	tx.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Index: 1}})
+	tx.AddTxOut(wire.NewTxOut(1500, []byte{OP_TRUE}))
+	fetcher := mapFetcher{
+		tx.TxIn[0].PreviousOutPoint: wire.NewTxOut(1000, p2pkh),
+		tx.TxIn[1].PreviousOutPoint: wire.NewTxOut(2000, p2wpkh),
+	}
+
+	sign := func(hash []byte, err error) []byte {
+		if err != nil {
+			t.Fatalf("signature hash: unexpected error: %v", err)
+		}
+		sig, err := privKey.Sign(hash)
+		if err != nil {
+			t.Fatalf("Sign: unexpected error: %v", err)
+		}
+		return append(sig.Serialize(), byte(SigHashAll))
+	}
+	legacySig := sign(CalcSignatureHash(p2pkh, SigHashAll, tx, 0))
+	witnessSig := sign(CalcWitnessSigHash(p2pkh, nil, SigHashAll, tx, 1,
+		2000))
+	tx.TxIn[0].SignatureScript = mustScript(t, NewScriptBuilder().
+		AddData(legacySig).AddData(pubKey))
+	tx.TxIn[1].Witness = wire.TxWitness{witnessSig, pubKey}
+
+	flags := ScriptBip16 | ScriptVerifyWitness | ScriptVerifyCleanStack
+	sigCache := NewSigCache(10)
+	verifier := NewEngineVerifier(sigCache)
+	err = ValidateTransactionScripts(btcutil.NewTx(tx), fetcher, flags,
+		verifier)
+	if err != nil {
+		t.Fatalf("ValidateTransactionScripts: unexpected error: %v", err)
+	}
+	if len(sigCache.validSigs) != 2 {
+		t.Fatalf("ValidateTransactionScripts: cached %d signatures, "+
+			"want 2", len(sigCache.validSigs))
+	}
+
+	// A signature of another transaction fails unless it is cached for
+	// the signature hash of the input.
+	badTx := tx.Copy()
+	badTx.LockTime = 1
+	err = ValidateTransactionScripts(btcutil.NewTx(badTx), fetcher, flags,
+		verifier)
+	ierr, ok := err.(*InputError)
+	if !ok || ierr.ErrorCode != ErrEvalFalse {
+		t.Fatalf("ValidateTransactionScripts: got error %v, want %v",
+			err, ErrEvalFalse)
+	}
+
+	hash, err := CalcSignatureHash(p2pkh, SigHashAll, badTx, 0)
+	if err != nil {
+		t.Fatalf("CalcSignatureHash: unexpected error: %v", err)
+	}
+	cacheSig(sigCache, hash, legacySig, pubKey)
+	hash, err = CalcWitnessSigHash(p2pkh, nil, SigHashAll, badTx, 1, 2000)
+	if err != nil {
+		t.Fatalf("CalcWitnessSigHash: unexpected error: %v", err)
+	}
+	cacheSig(sigCache, hash, witnessSig, pubKey)
+	err = ValidateTransactionScripts(btcutil.NewTx(badTx), fetcher, flags,
+		verifier)
+	if err != nil {
+		t.Fatalf("ValidateTransactionScripts: unexpected error with "+
+			"cached signatures: %v", err)
+	}
+}
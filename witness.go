@@ -0,0 +1,248 @@
+// Copyright (c) 2016-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcutil
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+
+	"github.com/nbcorg/btcd/chaincfg/chainhash"
+	"github.com/nbcorg/btcd/wire"
+)
+
+const (
+	// CoinbaseWitnessDataLen is the required length of the only element
+	// within the coinbase's witness data if the coinbase transaction
+	// contains a witness commitment.
+	CoinbaseWitnessDataLen = 32
+
+	// CoinbaseWitnessPkScriptLength is the length of the public key script
+	// containing an OP_RETURN, the WitnessMagicBytes, and the witness
+	// commitment itself.  In order to be a valid candidate for the output
+	// containing the witness commitment, a public key script must be at
+	// least this long.
+	CoinbaseWitnessPkScriptLength = 38
+)
+
+// WitnessMagicBytes is the prefix marker within the public key script of a
+// coinbase output to indicate that this output holds the witness commitment
+// for a block.  It is an OP_RETURN followed by a 36 byte data push whose first
+// four bytes are 0xaa21a9ed.
+var WitnessMagicBytes = []byte{
+	0x6a, // OP_RETURN
+	0x24, // OP_DATA_36
+	0xaa,
+	0x21,
+	0xa9,
+	0xed,
+}
+
+// WitnessCommitmentError describes an error due to a block whose witness
+// commitment is missing, malformed, or does not match the witness data of the
+// transactions it commits to.
+type WitnessCommitmentError string
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e WitnessCommitmentError) Error() string {
+	return string(e)
+}
+
+// isCoinBaseTx determines whether or not a transaction is a coinbase.  A
+// coinbase is a special transaction created by miners that has no inputs.
+// This is represented in the block chain by a transaction with a single input
+// that has a previous output transaction index set to the maximum value along
+// with a zero hash.
+func isCoinBaseTx(msgTx *wire.MsgTx) bool {
+	// A coin base must only have one transaction input.
+	if len(msgTx.TxIn) != 1 {
+		return false
+	}
+
+	// The previous output of a coin base must have a max value index and
+	// a zero hash.
+	prevOut := &msgTx.TxIn[0].PreviousOutPoint
+	if prevOut.Index != math.MaxUint32 || prevOut.Hash != (chainhash.Hash{}) {
+		return false
+	}
+
+	return true
+}
+
+// ExtractWitnessCommitment attempts to locate, and return the witness
+// commitment for a block.  The witness commitment is of the form:
+// SHA256(witness root || witness nonce).  The function additionally returns a
+// boolean indicating if the witness root was located within any of the txOut's
+// in the passed transaction.  The witness commitment is stored as the data push
+// for an OP_RETURN with special magic bytes to aide in location.  When more
+// than one output carries the magic bytes, the last one is the commitment.
+func ExtractWitnessCommitment(tx *Tx) ([]byte, bool) {
+	// The witness commitment *must* be located within one of the coinbase
+	// transaction's outputs.
+	msgTx := tx.MsgTx()
+	if !isCoinBaseTx(msgTx) {
+		return nil, false
+	}
+
+	for i := len(msgTx.TxOut) - 1; i >= 0; i-- {
+		// The public key script that contains the witness commitment
+		// must shared a prefix with the WitnessMagicBytes, and be at
+		// least 38 bytes.
+		pkScript := msgTx.TxOut[i].PkScript
+		if len(pkScript) >= CoinbaseWitnessPkScriptLength &&
+			bytes.HasPrefix(pkScript, WitnessMagicBytes) {
+
+			// The witness commitment itself is a 32-byte hash
+			// directly after the WitnessMagicBytes.  The remaining
+			// bytes beyond the 38th byte currently have no
+			// consensus meaning.
+			start := len(WitnessMagicBytes)
+			end := CoinbaseWitnessPkScriptLength
+			return pkScript[start:end], true
+		}
+	}
+
+	return nil, false
+}
+
+// CalcWitnessCommitment returns the witness commitment for the passed witness
+// merkle root and coinbase witness nonce, which is the double sha256 of their
+// concatenation.
+func CalcWitnessCommitment(witnessMerkleRoot *chainhash.Hash, witnessNonce []byte) []byte {
+	var witnessPreimage [chainhash.HashSize * 2]byte
+	copy(witnessPreimage[:], witnessMerkleRoot[:])
+	copy(witnessPreimage[chainhash.HashSize:], witnessNonce)
+	return chainhash.DoubleHashB(witnessPreimage[:])
+}
+
+// ValidateWitnessCommitment validates the witness commitment (if any) found
+// within the coinbase transaction of the passed block.  A block without a
+// witness commitment is only valid when none of its transactions carry
+// witness data.  Any failure is returned as a WitnessCommitmentError.
+func ValidateWitnessCommitment(blk *Block) error {
+	// If the block doesn't have any transactions at all, then we won't be
+	// able to extract a commitment from the non-existent coinbase
+	// transaction.  So we exit early here.
+	transactions := blk.Transactions()
+	if len(transactions) == 0 {
+		str := "cannot validate witness commitment of block without " +
+			"transactions"
+		return WitnessCommitmentError(str)
+	}
+
+	coinbaseTx := transactions[0]
+	if len(coinbaseTx.MsgTx().TxIn) == 0 {
+		str := "coinbase transaction has no inputs"
+		return WitnessCommitmentError(str)
+	}
+
+	witnessCommitment, witnessFound := ExtractWitnessCommitment(coinbaseTx)
+
+	// If we can't find a witness commitment in any of the coinbase's
+	// outputs, then the block MUST NOT contain any transactions with
+	// witness data.
+	if !witnessFound {
+		for _, tx := range transactions {
+			if tx.HasWitness() {
+				str := fmt.Sprintf("block contains transaction %v "+
+					"with witness data, yet no witness "+
+					"commitment present", tx.Hash())
+				return WitnessCommitmentError(str)
+			}
+		}
+		return nil
+	}
+
+	// At this point the block contains a witness commitment, so the
+	// coinbase transaction MUST have exactly one witness element within
+	// its witness data and that element must be exactly
+	// CoinbaseWitnessDataLen bytes.
+	coinbaseWitness := coinbaseTx.MsgTx().TxIn[0].Witness
+	if len(coinbaseWitness) != 1 {
+		str := fmt.Sprintf("the coinbase transaction has %d items in "+
+			"its witness stack when only one is allowed",
+			len(coinbaseWitness))
+		return WitnessCommitmentError(str)
+	}
+	witnessNonce := coinbaseWitness[0]
+	if len(witnessNonce) != CoinbaseWitnessDataLen {
+		str := fmt.Sprintf("the coinbase transaction witness nonce "+
+			"has %d bytes when it must be %d bytes",
+			len(witnessNonce), CoinbaseWitnessDataLen)
+		return WitnessCommitmentError(str)
+	}
+
+	// Finally, with the preliminary checks out of the way, we can check if
+	// the extracted witnessCommitment is equal to:
+	// SHA256(witnessMerkleRoot || witnessNonce).  Where witnessNonce is the
+	// coinbase transaction's only witness item.
+	witnessMerkleRoot := CalcMerkleRoot(transactions, true)
+	computedCommitment := CalcWitnessCommitment(&witnessMerkleRoot,
+		witnessNonce)
+	if !bytes.Equal(computedCommitment, witnessCommitment) {
+		str := fmt.Sprintf("witness commitment does not match: "+
+			"computed %x, coinbase includes %x", computedCommitment,
+			witnessCommitment)
+		return WitnessCommitmentError(str)
+	}
+
+	return nil
+}
+
+// WitnessCommitmentScript returns the public key script for a coinbase output
+// carrying the passed 32-byte witness commitment.  The script is the
+// WitnessMagicBytes followed by the commitment itself.
+func WitnessCommitmentScript(witnessCommitment []byte) []byte {
+	pkScript := make([]byte, 0, CoinbaseWitnessPkScriptLength)
+	pkScript = append(pkScript, WitnessMagicBytes...)
+	pkScript = append(pkScript, witnessCommitment...)
+	return pkScript
+}
+
+// AddWitnessCommitment adds the witness commitment as an OP_RETURN output
+// within the passed coinbase transaction.  The commitment covers the witness
+// hashes of blockTxns, whose first entry is expected to be the coinbase and is
+// treated as the zero hash.  If the coinbase does not already carry a witness
+// nonce, a nonce of all zeroes is added to its only input.  The generated
+// commitment is returned.  An error of type WitnessCommitmentError is returned
+// when the coinbase has no inputs.
+//
+// NOTE: The coinbase is passed as a raw wire.MsgTx since adding the commitment
+// changes its serialization, so any Tx wrapping it must be created afterwards
+// in order to avoid caching a stale hash.
+func AddWitnessCommitment(coinbaseTx *wire.MsgTx, blockTxns []*Tx) ([]byte, error) {
+	if len(coinbaseTx.TxIn) == 0 {
+		str := "coinbase transaction has no inputs"
+		return nil, WitnessCommitmentError(str)
+	}
+
+	// The witness of the coinbase transaction MUST be exactly 32-bytes
+	// of all zeroes unless the caller has already chosen a nonce.
+	if len(coinbaseTx.TxIn[0].Witness) == 0 {
+		var witnessNonce [CoinbaseWitnessDataLen]byte
+		coinbaseTx.TxIn[0].Witness = wire.TxWitness{witnessNonce[:]}
+	}
+	witnessNonce := coinbaseTx.TxIn[0].Witness[0]
+
+	// Next, obtain the merkle root of a tree which consists of the wtxid
+	// of all transactions in the block.  The coinbase transaction will
+	// have a special wtxid of all zeroes.
+	witnessMerkleRoot := CalcMerkleRoot(blockTxns, true)
+
+	// The preimage to the witness commitment is:
+	// witnessRoot || coinbaseWitness
+	witnessCommitment := CalcWitnessCommitment(&witnessMerkleRoot,
+		witnessNonce)
+
+	// Finally, create the OP_RETURN carrying witness commitment output as
+	// an additional output within the coinbase.
+	commitmentOutput := &wire.TxOut{
+		Value:    0,
+		PkScript: WitnessCommitmentScript(witnessCommitment),
+	}
+	coinbaseTx.TxOut = append(coinbaseTx.TxOut, commitmentOutput)
+
+	return witnessCommitment, nil
+}